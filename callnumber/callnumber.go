@@ -0,0 +1,166 @@
+// Package callnumber orders library call numbers the way they are shelved, rather than as plain
+// strings, for both the Dewey Decimal and Library of Congress classification schemes.
+package callnumber
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type Scheme int
+
+const (
+	Unknown Scheme = iota
+	Dewey
+	LCC
+)
+
+var (
+	deweyPattern  = regexp.MustCompile(`^(\d{1,3})(?:\.(\d+))?\s*(.*)$`)
+	lccPattern    = regexp.MustCompile(`^([A-Z]{1,3})\s*(\d+)(?:\.(\d+))?\s*(.*)$`)
+	cutterPattern = regexp.MustCompile(`^([A-Z])(\d+)(.*)$`)
+
+	// openlibrary dewey numbers often contain prime marks showing where the number may be shortened
+	deweyMarks = strings.NewReplacer("/", "", "'", "")
+)
+
+// Detect reports which classification scheme a call number appears to belong to.
+func Detect(callNumber string) Scheme {
+	_, scheme := parse(callNumber)
+	return scheme
+}
+
+// Less reports whether call number a is shelved before call number b. Dewey numbers sort before
+// Library of Congress numbers, which sort before anything unrecognised.
+func Less(a, b string) bool {
+	return Compare(a, b) < 0
+}
+
+// Compare returns -1, 0 or 1 depending on whether call number a is shelved before, alongside or
+// after call number b.
+func Compare(a, b string) int {
+	tokensA, schemeA := parse(a)
+	tokensB, schemeB := parse(b)
+
+	if schemeA != schemeB {
+		return compareRank(schemeRank(schemeA), schemeRank(schemeB))
+	}
+
+	for i := 0; i < len(tokensA) && i < len(tokensB); i++ {
+		if c := tokensA[i].compare(tokensB[i]); c != 0 {
+			return c
+		}
+	}
+	return compareRank(len(tokensA), len(tokensB))
+}
+
+func schemeRank(scheme Scheme) int {
+	switch scheme {
+	case Dewey:
+		return 0
+	case LCC:
+		return 1
+	default:
+		return 2
+	}
+}
+
+type tokenKind int
+
+const (
+	classToken tokenKind = iota
+	numberToken
+	fractionToken
+	cutterToken
+	textToken
+)
+
+type token struct {
+	kind  tokenKind
+	text  string
+	value int64
+}
+
+func (t token) compare(other token) int {
+	if t.kind != other.kind {
+		return compareRank(int(t.kind), int(other.kind))
+	}
+
+	switch t.kind {
+	case numberToken:
+		switch {
+		case t.value < other.value:
+			return -1
+		case t.value > other.value:
+			return 1
+		default:
+			return 0
+		}
+	case fractionToken:
+		return strings.Compare(strings.TrimRight(t.text, "0"), strings.TrimRight(other.text, "0"))
+	case cutterToken:
+		// the digits of a cutter number are a decimal fraction, so C12 files before C2
+		if c := strings.Compare(t.text[:1], other.text[:1]); c != 0 {
+			return c
+		}
+		return strings.Compare(strings.TrimRight(t.text[1:], "0"), strings.TrimRight(other.text[1:], "0"))
+	default:
+		return strings.Compare(t.text, other.text)
+	}
+}
+
+func parse(callNumber string) ([]token, Scheme) {
+	normalized := strings.TrimSpace(callNumber)
+
+	if match := deweyPattern.FindStringSubmatch(deweyMarks.Replace(normalized)); match != nil {
+		tokens := []token{number(match[1]), {kind: fractionToken, text: match[2]}}
+		return append(tokens, parseRemainder(match[3])...), Dewey
+	}
+
+	if match := lccPattern.FindStringSubmatch(strings.ToUpper(normalized)); match != nil {
+		tokens := []token{{kind: classToken, text: match[1]}, number(match[2]), {kind: fractionToken, text: match[3]}}
+		return append(tokens, parseRemainder(match[4])...), LCC
+	}
+
+	return []token{{kind: textToken, text: strings.ToUpper(normalized)}}, Unknown
+}
+
+// parseRemainder splits the part of a call number following the class number into cutter numbers,
+// years and anything else.
+func parseRemainder(remainder string) []token {
+	tokens := []token{}
+	for _, field := range strings.FieldsFunc(remainder, func(r rune) bool { return r == ' ' || r == '.' }) {
+		if _, err := strconv.ParseInt(field, 10, 64); err == nil {
+			tokens = append(tokens, number(field))
+			continue
+		}
+
+		if match := cutterPattern.FindStringSubmatch(strings.ToUpper(field)); match != nil {
+			tokens = append(tokens, token{kind: cutterToken, text: match[1] + match[2]})
+			if match[3] != "" {
+				tokens = append(tokens, token{kind: textToken, text: match[3]})
+			}
+			continue
+		}
+
+		tokens = append(tokens, token{kind: textToken, text: strings.ToUpper(field)})
+	}
+	return tokens
+}
+
+func number(digits string) token {
+	value, _ := strconv.ParseInt(digits, 10, 64)
+	return token{kind: numberToken, text: digits, value: value}
+}
+
+func compareRank(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
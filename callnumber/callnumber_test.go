@@ -0,0 +1,64 @@
+package callnumber
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetect(t *testing.T) {
+	assert.Equal(t, Dewey, Detect("823.912"))
+	assert.Equal(t, Dewey, Detect("823/.912 T649h"))
+	assert.Equal(t, LCC, Detect("PR6039.O32 H6 1966"))
+	assert.Equal(t, LCC, Detect("qa76.73.G63"))
+	assert.Equal(t, Unknown, Detect("[Fic]"))
+}
+
+func TestSortDewey(t *testing.T) {
+	assertSorts(t, []string{
+		"5.1",
+		"20",
+		"100",
+		"823",
+		"823.9",
+		"823.91 A123",
+		"823/.912",
+		"823.912 T649h",
+		"823.912 T65",
+		"823.92",
+		"900",
+	})
+}
+
+func TestSortLCC(t *testing.T) {
+	assertSorts(t, []string{
+		"P35",
+		"PR9",
+		"PR45",
+		"PR6039.O32 H6 1966",
+		"PR6039.O32 H6 1988",
+		"PR6039.O32 L6",
+		"PR6039.O4",
+		"PS3545.I345",
+		"QA76.5",
+		"QA76.73.G63",
+		"QA76.73.G7",
+		"QA100",
+	})
+}
+
+func TestSortMixedSchemes(t *testing.T) {
+	assertSorts(t, []string{"823.912", "PR6039.O32", "[Fic]"})
+}
+
+func assertSorts(t *testing.T, expected []string) {
+	t.Helper()
+
+	shuffled := append([]string{}, expected...)
+	rand.New(rand.NewSource(1)).Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+
+	sort.SliceStable(shuffled, func(i, j int) bool { return Less(shuffled[i], shuffled[j]) })
+	assert.Equal(t, expected, shuffled)
+}
@@ -0,0 +1,171 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/arudzitis/addlib/db"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	authorCmd.AddCommand(authorListCmd)
+	authorCmd.AddCommand(authorShowCmd)
+	authorCmd.AddCommand(authorRenameCmd)
+	authorCmd.AddCommand(authorMergeCmd)
+	authorCmd.AddCommand(authorSortNameCmd)
+
+	rootCmd.AddCommand(authorCmd)
+}
+
+var authorCmd = &cobra.Command{
+	Use:   "author",
+	Short: "view and manage author records",
+}
+
+var authorListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "list all authors by sort name",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		runAuthorList()
+	},
+}
+
+func runAuthorList() {
+	authors, err := database.Authors()
+	cobra.CheckErr(err)
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "SORT NAME\tNAME\tBOOKS\tOLID")
+	for _, author := range authors {
+		fmt.Fprintf(writer, "%s\t%s\t%d\t%s\n", author.SortName(), author.Name, author.Books, author.OLID)
+	}
+	cobra.CheckErr(writer.Flush())
+}
+
+var authorShowCmd = &cobra.Command{
+	Use:   "show <olid>",
+	Short: "show an author's details and every book held by them",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runAuthorShow(args[0])
+	},
+}
+
+func runAuthorShow(ref string) {
+	author, err := database.FindAuthor(ref)
+	cobra.CheckErr(err)
+
+	books, err := database.FindBooks(db.BookFilter{AuthorID: &author.ID})
+	cobra.CheckErr(err)
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fields := []struct {
+		label string
+		value *string
+	}{
+		{"Personal name", author.PersonalName},
+		{"Born", author.BirthDate},
+		{"Died", author.DeathDate},
+		{"Wikidata", author.WikidataID},
+		{"VIAF", author.VIAFID},
+	}
+
+	fmt.Fprintf(writer, "Name:\t%s\n", author.Name)
+	fmt.Fprintf(writer, "Sort name:\t%s\n", author.SortName())
+	fmt.Fprintf(writer, "OLID:\t%s\n", author.OLID)
+	for _, field := range fields {
+		if field.value != nil {
+			fmt.Fprintf(writer, "%s:\t%s\n", field.label, *field.value)
+		}
+	}
+	if alternateNames := author.GetAlternateNames(); len(alternateNames) > 0 {
+		fmt.Fprintf(writer, "Also known as:\t%s\n", strings.Join(alternateNames, "; "))
+	}
+	cobra.CheckErr(writer.Flush())
+
+	if author.Bio != nil {
+		fmt.Printf("\n%s\n", *author.Bio)
+	}
+
+	fmt.Printf("\n%d books held:\n", len(books))
+	writer = tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, book := range books {
+		role := ""
+		for _, credit := range book.Credits {
+			if credit.AuthorID == author.ID {
+				role = roleAbbreviations[credit.Role]
+			}
+		}
+		fmt.Fprintf(writer, "  %s\t%s\t%s\n", book.Title, role, book.OLID)
+	}
+	cobra.CheckErr(writer.Flush())
+}
+
+var authorRenameCmd = &cobra.Command{
+	Use:   "rename <olid> <new name>",
+	Short: "rename a single author record",
+	Args:  cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		runAuthorRename(args[0], strings.Join(args[1:], " "))
+	},
+}
+
+func runAuthorRename(ref string, name string) {
+	author, err := database.FindAuthor(ref)
+	cobra.CheckErr(err)
+
+	rows, err := database.RenameAuthor(author.OLID, name)
+	cobra.CheckErr(err)
+
+	log.Printf("Updated %d rows!\n", rows)
+}
+
+var authorMergeCmd = &cobra.Command{
+	Use:   "merge <surviving olid> <duplicate olid>...",
+	Short: "merge duplicate author records into one, moving all their books to the survivor",
+	Args:  cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		runAuthorMerge(args[0], args[1:])
+	},
+}
+
+func runAuthorMerge(survivorRef string, duplicateRefs []string) {
+	survivor, err := database.FindAuthor(survivorRef)
+	cobra.CheckErr(err)
+
+	duplicateOLIDs := make([]string, len(duplicateRefs))
+	for i, ref := range duplicateRefs {
+		duplicate, err := database.FindAuthor(ref)
+		cobra.CheckErr(err)
+		duplicateOLIDs[i] = duplicate.OLID
+	}
+
+	moved, err := database.MergeAuthors(survivor.OLID, duplicateOLIDs)
+	cobra.CheckErr(err)
+
+	log.Printf("Merged %d authors into %s, moving %d books!\n", len(duplicateOLIDs), survivor.Name, moved)
+}
+
+var authorSortNameCmd = &cobra.Command{
+	Use:   "sort-name <olid> [<sort name>]",
+	Short: "override the name an author is sorted by; omit the name to restore the derived one",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runAuthorSortName(args[0], strings.Join(args[1:], " "))
+	},
+}
+
+func runAuthorSortName(ref string, sortName string) {
+	author, err := database.FindAuthor(ref)
+	cobra.CheckErr(err)
+
+	rows, err := database.UpdateAuthorSortName(author.OLID, sortName)
+	cobra.CheckErr(err)
+
+	log.Printf("Updated %d rows!\n", rows)
+}
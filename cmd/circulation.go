@@ -0,0 +1,147 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/arudzitis/addlib/db"
+	"github.com/arudzitis/addlib/openlibrary"
+	"github.com/spf13/cobra"
+)
+
+var (
+	loanPatron string
+	loanDue    string
+)
+
+func init() {
+	checkoutCmd.Flags().StringVarP(&loanPatron, "patron", "p", "", "barcode or id of the borrowing patron")
+	checkoutCmd.Flags().StringVar(&loanDue, "due", "", "date the copy is due back (YYYY-MM-DD); defaults to the loan period from today")
+	checkoutCmd.MarkFlagRequired("patron")
+
+	renewCmd.Flags().StringVar(&loanDue, "due", "", "new due date (YYYY-MM-DD); defaults to the loan period from today")
+
+	rootCmd.AddCommand(checkoutCmd)
+	rootCmd.AddCommand(checkinCmd)
+	rootCmd.AddCommand(renewCmd)
+}
+
+var checkoutCmd = &cobra.Command{
+	Use:   "checkout <accession|olid|isbn>",
+	Short: "lend a copy to a patron; given a book, its first available copy is lent",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runCheckout(args[0])
+	},
+}
+
+func runCheckout(ref string) {
+	patron, err := database.FindPatron(loanPatron)
+	cobra.CheckErr(err)
+
+	accession := ref
+	if _, err := database.FindCopy(ref); errors.Is(err, db.ErrNotFound) {
+		book := resolveBook(ref)
+		bookCopy, err := database.LendableCopy(openlibrary.Book{OLID: book.OLID}, *patron)
+		cobra.CheckErr(err)
+		accession = bookCopy.Accession
+	} else {
+		cobra.CheckErr(err)
+	}
+
+	loan, err := database.Checkout(accession, *patron, parseDue())
+	cobra.CheckErr(err)
+
+	log.Printf("Lent %s to %s, due %s\n", accession, patron.Name, loan.DueOn.Format(dateLayout))
+}
+
+var checkinCmd = &cobra.Command{
+	Use:   "checkin <accession|olid|isbn>",
+	Short: "record a copy on loan as returned",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runCheckin(args[0])
+	},
+}
+
+func runCheckin(ref string) {
+	accession := resolveLoanedCopy(ref)
+
+	loan, hold, err := database.Checkin(accession)
+	cobra.CheckErr(err)
+
+	overdue := ""
+	if loan.ReturnedAt.After(loan.DueOn.AddDate(0, 0, 1)) {
+		overdue = fmt.Sprintf(" (was due %s)", loan.DueOn.Format(dateLayout))
+	}
+	log.Printf("Returned %s from %s%s\n", accession, loan.Patron.Name, overdue)
+
+	anonymizeLoans(time.Now())
+
+	if hold != nil {
+		log.Printf("Keep %s for %s (%s) until %s\n", accession, hold.Patron.Name, hold.Patron.Barcode, hold.PickupBy.Format(dateLayout))
+	}
+}
+
+var renewCmd = &cobra.Command{
+	Use:   "renew <accession|olid|isbn>",
+	Short: "extend the loan of a copy",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runRenew(args[0])
+	},
+}
+
+func runRenew(ref string) {
+	accession := resolveLoanedCopy(ref)
+
+	loan, err := database.Renew(accession, parseDue())
+	cobra.CheckErr(err)
+
+	log.Printf("Renewed %s for %s, now due %s\n", accession, loan.Patron.Name, loan.DueOn.Format(dateLayout))
+}
+
+// resolveLoanedCopy finds the accession number of a copy on loan: the copy itself, or the single copy
+// of a book which is out.
+func resolveLoanedCopy(ref string) string {
+	_, err := database.FindCopy(ref)
+	if err == nil {
+		return ref
+	}
+	if !errors.Is(err, db.ErrNotFound) {
+		cobra.CheckErr(err)
+	}
+
+	book := resolveBook(ref)
+	copies, err := database.Copies(openlibrary.Book{OLID: book.OLID})
+	cobra.CheckErr(err)
+
+	out := []string{}
+	for _, bookCopy := range copies {
+		loan, err := database.OpenLoan(bookCopy.Accession)
+		cobra.CheckErr(err)
+		if loan != nil {
+			out = append(out, bookCopy.Accession)
+		}
+	}
+
+	switch len(out) {
+	case 0:
+		cobra.CheckErr(fmt.Sprintf("no copy of %s is on loan", book.Title))
+	case 1:
+	default:
+		cobra.CheckErr(fmt.Sprintf("%d copies of %s are on loan; give the accession number of one", len(out), book.Title))
+	}
+	return out[0]
+}
+
+func parseDue() *time.Time {
+	if loanDue == "" {
+		return nil
+	}
+	due, err := time.Parse(dateLayout, loanDue)
+	cobra.CheckErr(err)
+	return &due
+}
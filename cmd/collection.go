@@ -0,0 +1,173 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+
+	"github.com/arudzitis/addlib/db"
+	"github.com/arudzitis/addlib/openlibrary"
+	"github.com/spf13/cobra"
+)
+
+var collectionFileName string
+
+func init() {
+	collectionAddCmd.Flags().StringVarP(&collectionFileName, "file", "f", "", "file of accession numbers, olids or isbns to add, one per line")
+	collectionRemoveCmd.Flags().StringVarP(&collectionFileName, "file", "f", "", "file of accession numbers, olids or isbns to remove, one per line")
+
+	collectionCmd.AddCommand(collectionCreateCmd)
+	collectionCmd.AddCommand(collectionListCmd)
+	collectionCmd.AddCommand(collectionRenameCmd)
+	collectionCmd.AddCommand(collectionDeleteCmd)
+	collectionCmd.AddCommand(collectionAddCmd)
+	collectionCmd.AddCommand(collectionRemoveCmd)
+
+	rootCmd.AddCommand(collectionCmd)
+}
+
+var collectionCmd = &cobra.Command{
+	Use:   "collection",
+	Short: "manage named collections sharing the catalogue, such as an office library and a loan shelf",
+}
+
+var collectionCreateCmd = &cobra.Command{
+	Use:   "create <name>",
+	Short: "create an empty collection",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runCollectionCreate(args[0])
+	},
+}
+
+func runCollectionCreate(name string) {
+	collection, err := database.CreateCollection(name)
+	cobra.CheckErr(err)
+
+	log.Printf("Created collection %q with id %d!\n", collection.Name, collection.ID)
+}
+
+var collectionListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "list the collections with their number of books",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		runCollectionList()
+	},
+}
+
+func runCollectionList() {
+	collections, err := database.Collections()
+	cobra.CheckErr(err)
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "ID\tCOLLECTION\tBOOKS")
+	for _, collection := range collections {
+		fmt.Fprintf(writer, "%d\t%s\t%d\n", collection.ID, collection.Name, collection.Books)
+	}
+	cobra.CheckErr(writer.Flush())
+}
+
+var collectionRenameCmd = &cobra.Command{
+	Use:   "rename <collection> <new name>",
+	Short: "rename a collection",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		runCollectionRename(args[0], args[1])
+	},
+}
+
+func runCollectionRename(ref string, name string) {
+	collection, err := database.FindCollection(ref)
+	cobra.CheckErr(err)
+
+	rows, err := database.RenameCollection(*collection, name)
+	cobra.CheckErr(err)
+
+	log.Printf("Updated %d rows!\n", rows)
+}
+
+var collectionDeleteCmd = &cobra.Command{
+	Use:   "delete <collection>",
+	Short: "delete a collection, keeping its books and copies",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runCollectionDelete(args[0])
+	},
+}
+
+func runCollectionDelete(ref string) {
+	collection, err := database.FindCollection(ref)
+	cobra.CheckErr(err)
+
+	err = database.DeleteCollection(*collection)
+	cobra.CheckErr(err)
+
+	log.Printf("Deleted collection %q!\n", collection.Name)
+}
+
+var collectionAddCmd = &cobra.Command{
+	Use:   "add <collection> [<accession|olid|isbn>...]",
+	Short: "add copies or whole books to a collection",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runCollectionMembership(args[0], args[1:], true)
+	},
+}
+
+var collectionRemoveCmd = &cobra.Command{
+	Use:   "remove <collection> [<accession|olid|isbn>...]",
+	Short: "remove copies or whole books from a collection",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runCollectionMembership(args[0], args[1:], false)
+	},
+}
+
+// runCollectionMembership adds items to or removes them from a collection. Like move, each ref is
+// taken as a copy's accession number if one matches, and otherwise as a book.
+func runCollectionMembership(ref string, refs []string, add bool) {
+	collection, err := database.FindCollection(ref)
+	cobra.CheckErr(err)
+
+	refs = appendRefsFromFile(refs, collectionFileName)
+	if len(refs) == 0 {
+		cobra.CheckErr("nothing to do; pass barcodes as arguments or with --file")
+	}
+
+	changed := 0
+	for _, itemRef := range refs {
+		rows, err := updateMembership(*collection, itemRef, add)
+		if err != nil {
+			log.Printf("error updating %q; %v, skipping...", itemRef, err)
+			continue
+		}
+		if rows > 0 {
+			changed++
+		}
+	}
+
+	log.Printf("Updated %d of %d items in %s!\n", changed, len(refs), collection.Name)
+}
+
+func updateMembership(collection db.Collection, ref string, add bool) (int64, error) {
+	copyMembership := database.RemoveCopyFromCollection
+	bookMembership := database.RemoveBooksFromCollection
+	if add {
+		copyMembership = database.AddCopyToCollection
+		bookMembership = database.AddBooksToCollection
+	}
+
+	rows, err := copyMembership(collection, ref)
+	if !errors.Is(err, db.ErrNotFound) {
+		return rows, err
+	}
+
+	book, err := database.FindBook(ref)
+	if err != nil {
+		return 0, err
+	}
+	return bookMembership(collection, []openlibrary.Book{{OLID: book.OLID}})
+}
@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	configCmd.AddCommand(configGetCmd)
+	configCmd.AddCommand(configSetCmd)
+
+	rootCmd.AddCommand(configCmd)
+}
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "view and change settings stored in the database, such as the loan period",
+}
+
+var configGetCmd = &cobra.Command{
+	Use:   "get [<key>]",
+	Short: "show a setting, or every setting",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 1 {
+			runConfigGet(args[0])
+			return
+		}
+		runConfigList()
+	},
+}
+
+func runConfigGet(key string) {
+	value, err := database.Setting(key)
+	cobra.CheckErr(err)
+
+	fmt.Println(value)
+}
+
+func runConfigList() {
+	settings, err := database.Settings()
+	cobra.CheckErr(err)
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "KEY\tVALUE\tDESCRIPTION")
+	for _, setting := range settings {
+		value := setting.Value
+		if setting.IsDefault {
+			value += " (default)"
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\n", setting.Key, value, setting.Description)
+	}
+	cobra.CheckErr(writer.Flush())
+}
+
+var configSetCmd = &cobra.Command{
+	Use:   "set <key> <value>",
+	Short: `change a setting; an empty value ("") restores the default`,
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		runConfigSet(args[0], args[1])
+	},
+}
+
+func runConfigSet(key string, value string) {
+	err := database.SetSetting(key, value)
+	cobra.CheckErr(err)
+
+	log.Printf("Set %s!\n", key)
+}
@@ -0,0 +1,159 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/arudzitis/addlib/db"
+	"github.com/arudzitis/addlib/openlibrary"
+	"github.com/spf13/cobra"
+)
+
+const dateLayout = "2006-01-02"
+
+var (
+	copyAccession string
+	copyCondition string
+	copyAcquired  string
+	copySource    string
+	copyPrice     string
+	copyStatus    string
+)
+
+func init() {
+	copyAddCmd.Flags().StringVarP(&copyAccession, "accession", "a", "", "accession number or barcode; generated if not given")
+	copyAddCmd.Flags().StringVar(&copyCondition, "condition", "", "physical condition of the copy")
+	copyAddCmd.Flags().StringVar(&copyAcquired, "acquired", "", "date the copy was acquired (YYYY-MM-DD)")
+	copyAddCmd.Flags().StringVar(&copySource, "source", "", "where the copy was acquired from")
+	copyAddCmd.Flags().StringVar(&copyPrice, "price", "", "price paid for the copy, e.g. 12.50")
+	copyAddCmd.Flags().StringVar(&copyStatus, "status", db.CopyStatusAvailable, "status of the copy")
+
+	copyCmd.AddCommand(copyAddCmd)
+	copyCmd.AddCommand(copyListCmd)
+	copyCmd.AddCommand(copyRemoveCmd)
+
+	rootCmd.AddCommand(copyCmd)
+}
+
+var copyCmd = &cobra.Command{
+	Use:   "copy",
+	Short: "manage the physical copies held of each book",
+}
+
+var copyAddCmd = &cobra.Command{
+	Use:   "add <olid|isbn>",
+	Short: "record a new copy of a book",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runCopyAdd(args[0])
+	},
+}
+
+func runCopyAdd(ref string) {
+	book := resolveBook(ref)
+
+	bookCopy := db.Copy{
+		Accession: copyAccession,
+		Condition: copyCondition,
+		Source:    copySource,
+		Status:    copyStatus,
+	}
+
+	if copyAcquired != "" {
+		acquired, err := time.Parse(dateLayout, copyAcquired)
+		cobra.CheckErr(err)
+		bookCopy.AcquiredOn = &acquired
+	}
+
+	if copyPrice != "" {
+		cents, err := parsePrice(copyPrice)
+		cobra.CheckErr(err)
+		bookCopy.PriceCents = &cents
+	}
+
+	created, err := database.AddCopy(openlibrary.Book{OLID: book.OLID}, bookCopy)
+	cobra.CheckErr(err)
+
+	log.Printf("Added copy %s of %s\n", created.Accession, book.Title)
+}
+
+var copyListCmd = &cobra.Command{
+	Use:   "list <olid|isbn>",
+	Short: "list the copies held of a book",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runCopyList(args[0])
+	},
+}
+
+func runCopyList(ref string) {
+	book := resolveBook(ref)
+
+	copies, err := database.Copies(openlibrary.Book{OLID: book.OLID})
+	cobra.CheckErr(err)
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "ACCESSION\tSTATUS\tCONDITION\tACQUIRED\tSOURCE\tPRICE")
+	for _, bookCopy := range copies {
+		acquired := ""
+		if bookCopy.AcquiredOn != nil {
+			acquired = bookCopy.AcquiredOn.Format(dateLayout)
+		}
+		price := ""
+		if bookCopy.PriceCents != nil {
+			price = formatPrice(*bookCopy.PriceCents)
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%s\n", bookCopy.Accession, bookCopy.Status, bookCopy.Condition, acquired, bookCopy.Source, price)
+	}
+	cobra.CheckErr(writer.Flush())
+}
+
+var copyRemoveCmd = &cobra.Command{
+	Use:   "remove <accession>",
+	Short: "remove a copy by its accession number",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runCopyRemove(args[0])
+	},
+}
+
+func runCopyRemove(accession string) {
+	rows, err := database.RemoveCopy(accession)
+	cobra.CheckErr(err)
+
+	log.Printf("Removed %d copies!\n", rows)
+}
+
+// pricePattern matches a decimal amount of at most two decimal places, such as "12" or "12.50".
+var pricePattern = regexp.MustCompile(`^(\d+)(?:\.(\d{1,2}))?$`)
+
+// parsePrice converts a decimal amount such as "12.5" into cents.
+func parsePrice(price string) (int64, error) {
+	match := pricePattern.FindStringSubmatch(price)
+	if match == nil {
+		return 0, fmt.Errorf("invalid price %q; give an amount such as 12.50", price)
+	}
+	whole, fraction := match[1], match[2]
+	fraction += strings.Repeat("0", 2-len(fraction))
+
+	units, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid price %q: %w", price, err)
+	}
+	cents, err := strconv.ParseInt(fraction, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid price %q: %w", price, err)
+	}
+
+	return units*100 + cents, nil
+}
+
+func formatPrice(cents int64) string {
+	return fmt.Sprintf("%d.%02d", cents/100, cents%100)
+}
@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePrice(t *testing.T) {
+	for _, test := range []struct {
+		price string
+		cents int64
+		valid bool
+	}{
+		{"12", 1200, true},
+		{"12.5", 1250, true},
+		{"12.50", 1250, true},
+		{"0.07", 7, true},
+		{"-1.50", 0, false},
+		{"+1.50", 0, false},
+		{".50", 0, false},
+		{"12.", 0, false},
+		{"12.505", 0, false},
+		{"12.50abc", 0, false},
+		{"12,50", 0, false},
+		{"", 0, false},
+	} {
+		cents, err := parsePrice(test.price)
+		if !test.valid {
+			assert.Error(t, err, test.price)
+			continue
+		}
+		if assert.NoError(t, err, test.price) {
+			assert.Equal(t, test.cents, cents, test.price)
+		}
+	}
+}
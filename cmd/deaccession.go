@@ -0,0 +1,157 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/arudzitis/addlib/db"
+	"github.com/arudzitis/addlib/openlibrary"
+	"github.com/spf13/cobra"
+)
+
+var (
+	deaccessionReason   string
+	deaccessionNote     string
+	deaccessionDate     string
+	deaccessionFileName string
+
+	deaccessionFrom string
+	deaccessionTo   string
+)
+
+func init() {
+	deaccessionCmd.Flags().StringVarP(&deaccessionReason, "reason", "r", "", fmt.Sprintf("why the books left the collection: %s", strings.Join(db.DeaccessionReasons, ", ")))
+	deaccessionCmd.Flags().StringVarP(&deaccessionNote, "note", "n", "", "note to keep with the record, such as who the books went to")
+	deaccessionCmd.Flags().StringVar(&deaccessionDate, "date", "", "date the books left (YYYY-MM-DD); defaults to today")
+	deaccessionCmd.Flags().StringVarP(&deaccessionFileName, "file", "f", "", "file of olids or isbns to deaccession, one per line")
+	deaccessionCmd.MarkFlagRequired("reason")
+
+	deaccessionReportCmd.Flags().StringVar(&deaccessionFrom, "from", "", "first date to report on (YYYY-MM-DD)")
+	deaccessionReportCmd.Flags().StringVar(&deaccessionTo, "to", "", "last date to report on (YYYY-MM-DD)")
+
+	deaccessionCmd.AddCommand(deaccessionReportCmd)
+
+	rootCmd.AddCommand(deaccessionCmd)
+	rootCmd.AddCommand(restoreCmd)
+}
+
+var deaccessionCmd = &cobra.Command{
+	Use:   "deaccession [<olid|isbn>...]",
+	Short: "record books as having left the collection",
+	Long: `Record books as having left the collection, with the reason and date they left. Their records
+are kept but hidden from list and export, and may be brought back with restore.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		runDeaccession(args)
+	},
+}
+
+func runDeaccession(refs []string) {
+	deaccession := db.Deaccession{
+		Reason: deaccessionReason,
+		Note:   deaccessionNote,
+	}
+	if deaccessionDate != "" {
+		on, err := time.Parse(dateLayout, deaccessionDate)
+		cobra.CheckErr(err)
+		deaccession.On = on
+	}
+
+	refs = appendRefsFromFile(refs, deaccessionFileName)
+	if len(refs) == 0 {
+		cobra.CheckErr("nothing to deaccession; pass olids or isbns as arguments or with --file")
+	}
+
+	collection := scopeCollection()
+
+	var rows int64
+	for _, ref := range refs {
+		book := resolveBook(ref)
+		if collection != nil {
+			inCollection, err := database.InCollection(*collection, openlibrary.Book{OLID: book.OLID})
+			cobra.CheckErr(err)
+			if !inCollection {
+				cobra.CheckErr(fmt.Sprintf("%s is not in the collection %q", book.Title, collection.Name))
+			}
+		}
+
+		updated, err := database.DeaccessionBook(openlibrary.Book{OLID: book.OLID}, deaccession)
+		cobra.CheckErr(err)
+		rows += updated
+	}
+
+	log.Printf("Deaccessioned %d books!\n", rows)
+}
+
+var deaccessionReportCmd = &cobra.Command{
+	Use:   "report",
+	Short: "list the books deaccessioned within a date range",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		runDeaccessionReport()
+	},
+}
+
+func runDeaccessionReport() {
+	dateRange := parseDateRange(deaccessionFrom, deaccessionTo)
+
+	filter := db.BookFilter{Deaccessioned: &dateRange}
+	if collection := scopeCollection(); collection != nil {
+		filter.CollectionID = &collection.ID
+	}
+
+	books, err := database.FindBooks(filter)
+	cobra.CheckErr(err)
+
+	sort.SliceStable(books, func(i, j int) bool { return books[i].DeaccessionedOn.Before(*books[j].DeaccessionedOn) })
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "DATE\tREASON\tTITLE\tAUTHORS\tNOTE\tOLID")
+	for _, book := range books {
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%s\n", book.DeaccessionedOn.UTC().Format(dateLayout), book.DeaccessionReason, book.Title, authorNames(book), book.DeaccessionNote, book.OLID)
+	}
+	cobra.CheckErr(writer.Flush())
+}
+
+var restoreCmd = &cobra.Command{
+	Use:   "restore <olid|isbn>...",
+	Short: "bring deaccessioned books back into the collection",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runRestore(args)
+	},
+}
+
+func runRestore(refs []string) {
+	var rows int64
+	for _, ref := range refs {
+		book := resolveBook(ref)
+		updated, err := database.RestoreBook(openlibrary.Book{OLID: book.OLID})
+		cobra.CheckErr(err)
+		rows += updated
+	}
+
+	log.Printf("Restored %d books!\n", rows)
+}
+
+// parseDateRange reads the first and last dates of a range, either of which may be empty to leave
+// that end open.
+func parseDateRange(from string, to string) db.DateRange {
+	dateRange := db.DateRange{}
+	for _, bound := range []struct {
+		value  string
+		target **time.Time
+	}{{from, &dateRange.From}, {to, &dateRange.To}} {
+		if bound.value == "" {
+			continue
+		}
+		date, err := time.Parse(dateLayout, bound.value)
+		cobra.CheckErr(err)
+		*bound.target = &date
+	}
+	return dateRange
+}
@@ -0,0 +1,170 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	duplicatesAuto        bool
+	duplicatesInteractive bool
+)
+
+func init() {
+	duplicatesCmd.Flags().BoolVar(&duplicatesAuto, "auto", false, "merge every match which is not merely similar into its suggested survivor")
+	duplicatesCmd.Flags().BoolVarP(&duplicatesInteractive, "interactive", "i", false, "ask which record to keep for each match")
+
+	rootCmd.AddCommand(duplicatesCmd)
+}
+
+var duplicatesCmd = &cobra.Command{
+	Use:   "duplicates",
+	Short: "find books and authors recorded more than once, and optionally merge them",
+	Long: `Find books and authors recorded more than once, and optionally merge them.
+
+Books match when they share an isbn, in either its ten or thirteen digit form, are editions of the
+same work, or have the same authors and the same title once case, accents, punctuation, a leading
+article and any subtitle are ignored. Authors match when their names are the same once case, accents,
+punctuation and name order are ignored. Titles and names which differ only by a typo, books with the
+same title whose authors are missing or differ only by a typo, and editions of the same work, which
+may be translations or abridgements, are reported as similar.
+
+The first record of each match is the suggested survivor. --auto merges every match into it except
+those which are only similar, which are left to be checked with --interactive.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		runDuplicates()
+	},
+}
+
+func runDuplicates() {
+	if duplicatesAuto && duplicatesInteractive {
+		cobra.CheckErr("pass only one of --auto and --interactive")
+	}
+	input := bufio.NewReader(os.Stdin)
+
+	bookMatches, err := database.BookDuplicates()
+	cobra.CheckErr(err)
+
+	mergedBooks := 0
+	for _, match := range bookMatches {
+		heading := "Books matching on " + strings.Join(match.Reasons, ", ")
+		printDuplicateHeading(heading, match.Similar)
+
+		writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(writer, "#\tTITLE\tAUTHORS\tCOPIES\tOLID")
+		for i, book := range match.Books {
+			fmt.Fprintf(writer, "%d\t%s\t%s\t%d\t%s\n", i+1, book.Title, authorNames(book), len(book.Copies), book.OLID)
+		}
+		cobra.CheckErr(writer.Flush())
+
+		survivor, ok := chooseSurvivor(input, len(match.Books), match.Similar)
+		if survivor < 0 {
+			// quitting skips the authors too
+			log.Printf("Merged %d books!\n", mergedBooks)
+			return
+		}
+		if !ok {
+			continue
+		}
+
+		duplicateOLIDs := []string{}
+		for i, book := range match.Books {
+			if i != survivor {
+				duplicateOLIDs = append(duplicateOLIDs, book.OLID)
+			}
+		}
+		cobra.CheckErr(database.MergeBooks(match.Books[survivor].OLID, duplicateOLIDs))
+		log.Printf("Merged %d books into %s\n", len(duplicateOLIDs), match.Books[survivor].OLID)
+		mergedBooks += len(duplicateOLIDs)
+	}
+
+	// authors are matched after books are merged, since merging books can leave authors unused
+	authorMatches, err := database.AuthorDuplicates()
+	cobra.CheckErr(err)
+
+	mergedAuthors := 0
+	for _, match := range authorMatches {
+		printDuplicateHeading("Authors matching on name", match.Similar)
+
+		writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(writer, "#\tNAME\tSORT NAME\tBOOKS\tOLID")
+		for i, author := range match.Authors {
+			fmt.Fprintf(writer, "%d\t%s\t%s\t%d\t%s\n", i+1, author.Name, author.SortName(), author.Books, author.OLID)
+		}
+		cobra.CheckErr(writer.Flush())
+
+		survivor, ok := chooseSurvivor(input, len(match.Authors), match.Similar)
+		if survivor < 0 {
+			break
+		}
+		if !ok {
+			continue
+		}
+
+		duplicateOLIDs := []string{}
+		for i, author := range match.Authors {
+			if i != survivor {
+				duplicateOLIDs = append(duplicateOLIDs, author.OLID)
+			}
+		}
+		_, err := database.MergeAuthors(match.Authors[survivor].OLID, duplicateOLIDs)
+		cobra.CheckErr(err)
+		log.Printf("Merged %d authors into %s\n", len(duplicateOLIDs), match.Authors[survivor].Name)
+		mergedAuthors += len(duplicateOLIDs)
+	}
+
+	if len(bookMatches) == 0 && len(authorMatches) == 0 {
+		log.Printf("No duplicates found!\n")
+		return
+	}
+	if duplicatesAuto || duplicatesInteractive {
+		log.Printf("Merged %d books and %d authors!\n", mergedBooks, mergedAuthors)
+	}
+}
+
+func printDuplicateHeading(heading string, similar bool) {
+	if similar {
+		heading += " (similar, check before merging)"
+	}
+	fmt.Printf("\n%s:\n", heading)
+}
+
+// chooseSurvivor returns the index of the record a match should be merged into, and whether it
+// should be merged at all. With --auto that is the suggested survivor unless the match is only
+// similar; with --interactive the user is asked. It returns -1 if the user quits.
+func chooseSurvivor(input *bufio.Reader, records int, similar bool) (int, bool) {
+	if duplicatesAuto {
+		return 0, !similar
+	}
+	if !duplicatesInteractive {
+		return 0, false
+	}
+
+	for {
+		fmt.Printf("Keep which record, merging the others into it? [1-%d, s to skip, q to quit] (s): ", records)
+		line, err := input.ReadString('\n')
+		answer := strings.ToLower(strings.TrimSpace(line))
+		if err != nil && answer == "" {
+			fmt.Println()
+			return -1, false
+		}
+
+		switch answer {
+		case "", "s":
+			return 0, false
+		case "q":
+			return -1, false
+		}
+		if choice, err := strconv.Atoi(answer); err == nil && choice >= 1 && choice <= records {
+			return choice - 1, true
+		}
+	}
+}
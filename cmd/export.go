@@ -1,18 +1,26 @@
 package cmd
 
 import (
+	"encoding/csv"
 	"fmt"
+	"io"
+	"log"
 	"os"
 	"strings"
 
+	"github.com/arudzitis/addlib/db"
+	"github.com/arudzitis/addlib/openlibrary"
 	"github.com/spf13/cobra"
 )
 
 var outputFileName string
+var exportFormatName string
 
 func init() {
 	exportCmd.PersistentFlags().StringVarP(&outputFileName, "output", "o", "", "file to output to")
+	exportCmd.PersistentFlags().StringVarP(&exportFormatName, "format", "f", "csv", `"csv" or "citation" for a bibliography`)
 	exportCmd.MarkPersistentFlagRequired("output")
+	addFilterFlags(exportCmd)
 
 	rootCmd.AddCommand(exportCmd)
 }
@@ -26,25 +34,122 @@ var exportCmd = &cobra.Command{
 }
 
 func run() {
+	var writer func(io.Writer, []db.Book) error
+
+	switch exportFormatName {
+	case "csv":
+		writer = writeCSV
+	case "citation":
+		writer = writeCitations
+	default:
+		log.Fatalf("unsupported output format: %q", exportFormatName)
+	}
+
 	outputFile, err := os.Create(outputFileName)
 	cobra.CheckErr(err)
 	defer func() { _ = outputFile.Close() }()
 
-	books, err := database.AllBooks()
+	books, err := database.FindBooks(bookFilter())
 	cobra.CheckErr(err)
 
-	_, err = outputFile.Write([]byte("title,author,url\n"))
+	err = writer(outputFile, books)
 	cobra.CheckErr(err)
+}
+
+func writeCSV(output io.Writer, books []db.Book) error {
+	locationPaths, err := database.LocationPaths()
+	if err != nil {
+		return err
+	}
+
+	writer := csv.NewWriter(output)
+	err = writer.Write([]string{"title", "author", "url", "location", "tags", "call_number"})
+	if err != nil {
+		return err
+	}
 
 	for _, book := range books {
-		authorNames := make([]string, len(book.Authors))
-		for i, author := range book.Authors {
-			authorNames[i] = author.Name
+		err = writer.Write([]string{
+			book.Title,
+			authorNames(book),
+			fmt.Sprintf("https://openlibrary.org%s", book.OLID),
+			bookLocation(book, locationPaths),
+			tagNames(book),
+			book.ShelfMark(),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+func writeCitations(output io.Writer, books []db.Book) error {
+	for _, book := range books {
+		_, err := fmt.Fprintln(output, citation(book))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// citedRoles are the contributor roles named after the title of a citation, with their phrasing.
+var citedRoles = []struct {
+	role   string
+	phrase string
+}{
+	{openlibrary.RoleEditor, "Edited by"},
+	{openlibrary.RoleTranslator, "Translated by"},
+	{openlibrary.RoleIllustrator, "Illustrated by"},
+}
+
+// citation formats a bibliography entry for a book, such as "Homer. The Odyssey. Translated by E. V.
+// Rieu." When a book has no primary authors its editors lead the entry instead.
+func citation(book db.Book) string {
+	byRole := map[string][]string{}
+	for _, credit := range book.Credits {
+		byRole[credit.Role] = append(byRole[credit.Role], credit.Author.Name)
+	}
+
+	parts := []string{}
+	if authors := byRole[openlibrary.RoleAuthor]; len(authors) > 0 {
+		parts = append(parts, joinNames(authors))
+	} else if editors := byRole[openlibrary.RoleEditor]; len(editors) > 0 {
+		abbreviation := "ed."
+		if len(editors) > 1 {
+			abbreviation = "eds."
 		}
+		parts = append(parts, fmt.Sprintf("%s, %s", joinNames(editors), abbreviation))
+		delete(byRole, openlibrary.RoleEditor)
+	}
+
+	parts = append(parts, book.Title)
+
+	for _, cited := range citedRoles {
+		if names := byRole[cited.role]; len(names) > 0 {
+			parts = append(parts, fmt.Sprintf("%s %s", cited.phrase, joinNames(names)))
+		}
+	}
+
+	for i, part := range parts {
+		parts[i] = strings.TrimRight(part, ".") + "."
+	}
+	return strings.Join(parts, " ")
+}
 
-		_, err = outputFile.Write([]byte(fmt.Sprintf(`"%s","%s","https://openlibrary.org%s"`, book.Title, strings.Join(authorNames, ", "), book.OLID)))
-		cobra.CheckErr(err)
-		_, err = outputFile.Write([]byte("\n"))
-		cobra.CheckErr(err)
+// joinNames joins names as in running text: "A", "A and B", "A, B, and C".
+func joinNames(names []string) string {
+	switch len(names) {
+	case 0:
+		return ""
+	case 1:
+		return names[0]
+	case 2:
+		return names[0] + " and " + names[1]
+	default:
+		return strings.Join(names[:len(names)-1], ", ") + ", and " + names[len(names)-1]
 	}
 }
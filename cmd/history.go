@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(historyCmd)
+	rootCmd.AddCommand(undoCmd)
+}
+
+var historyCmd = &cobra.Command{
+	Use:   "history [<olid|accession|location id>]",
+	Short: "show the changes made to a record, or to the whole catalogue",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		key := ""
+		if len(args) == 1 {
+			key = args[0]
+		}
+		runHistory(key)
+	},
+}
+
+func runHistory(key string) {
+	changes, err := database.History(key)
+	cobra.CheckErr(err)
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "ID\tWHEN\tACTOR\tACTION\tENTITY\tKEY\tCHANGE")
+	for _, change := range changes {
+		fmt.Fprintf(writer, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			change.ID, change.CreatedAt.Local().Format(time.RFC3339), change.Actor, change.Action, change.Entity, change.EntityKey, change.Describe())
+	}
+	cobra.CheckErr(writer.Flush())
+}
+
+var undoCmd = &cobra.Command{
+	Use:   "undo <change id>",
+	Short: "revert a single change from the history",
+	Long: `Revert a single change from the history, recording the reversal as a new change.
+
+A change to a field is only reverted while the field still holds the value the change gave it.
+Books deleted outright, rather than deaccessioned, cannot be restored. Merges of books or authors
+cannot be undone either: the merged record is removed and the history does not record which of the
+survivor's copies, credits and details came from it, so the record must be added again and they
+moved back by hand.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		changeID, err := strconv.ParseInt(args[0], 10, 64)
+		cobra.CheckErr(err)
+		runUndo(changeID)
+	},
+}
+
+func runUndo(changeID int64) {
+	err := database.Undo(changeID)
+	cobra.CheckErr(err)
+
+	log.Printf("Undid change %d!\n", changeID)
+}
@@ -0,0 +1,182 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/arudzitis/addlib/db"
+	"github.com/arudzitis/addlib/openlibrary"
+	"github.com/spf13/cobra"
+)
+
+var (
+	holdPatron string
+	holdAll    bool
+)
+
+func init() {
+	holdPlaceCmd.Flags().StringVarP(&holdPatron, "patron", "p", "", "barcode or id of the patron to queue")
+	holdPlaceCmd.MarkFlagRequired("patron")
+
+	holdListCmd.Flags().StringVarP(&holdPatron, "patron", "p", "", "only list the holds of this patron")
+	holdListCmd.Flags().BoolVar(&holdAll, "all", false, "include fulfilled, cancelled and expired holds")
+
+	holdExpireCmd.Flags().StringVar(&overdueAsOf, "as-of", "", "date to expire holds as of (YYYY-MM-DD); defaults to today")
+
+	holdCmd.AddCommand(holdPlaceCmd)
+	holdCmd.AddCommand(holdCancelCmd)
+	holdCmd.AddCommand(holdListCmd)
+	holdCmd.AddCommand(holdExpireCmd)
+
+	rootCmd.AddCommand(holdCmd)
+}
+
+var holdCmd = &cobra.Command{
+	Use:   "hold",
+	Short: "queue patrons for books which are out on loan",
+}
+
+var holdPlaceCmd = &cobra.Command{
+	Use:   "place <olid|isbn>",
+	Short: "add a patron to the queue for a book",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runHoldPlace(args[0])
+	},
+}
+
+func runHoldPlace(ref string) {
+	book := resolveBook(ref)
+	patron, err := database.FindPatron(holdPatron)
+	cobra.CheckErr(err)
+
+	hold, err := database.PlaceHold(openlibrary.Book{OLID: book.OLID}, *patron)
+	cobra.CheckErr(err)
+
+	if hold.Status == db.HoldStatusReady {
+		log.Printf("Placed hold %d; %s is on the shelf, keep it for %s until %s\n", hold.ID, hold.Copy.Accession, patron.Name, hold.PickupBy.Format(dateLayout))
+		return
+	}
+	log.Printf("Placed hold %d for %s on %s\n", hold.ID, patron.Name, book.Title)
+}
+
+var holdCancelCmd = &cobra.Command{
+	Use:   "cancel <hold id>",
+	Short: "take a hold out of the queue",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runHoldCancel(args[0])
+	},
+}
+
+func runHoldCancel(ref string) {
+	id, err := strconv.ParseInt(ref, 10, 64)
+	cobra.CheckErr(err)
+
+	hold, err := database.CancelHold(id)
+	cobra.CheckErr(err)
+
+	log.Printf("Cancelled hold %d for %s on %s\n", hold.ID, hold.Patron.Name, hold.Book.Title)
+	reportReadyHolds(hold.BookID)
+}
+
+var holdListCmd = &cobra.Command{
+	Use:   "list [olid|isbn]",
+	Short: "list the holds queued, optionally for one book",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runHoldList(args)
+	},
+}
+
+func runHoldList(args []string) {
+	filter := db.HoldFilter{Active: !holdAll}
+	if len(args) == 1 {
+		book := resolveBook(args[0])
+		filter.BookID = &book.ID
+	}
+	if holdPatron != "" {
+		patron, err := database.FindPatron(holdPatron)
+		cobra.CheckErr(err)
+		filter.PatronID = &patron.ID
+	}
+
+	holds, err := database.Holds(filter)
+	cobra.CheckErr(err)
+
+	// positions count every waiting hold on the book, not only those listed
+	positions := map[int64]int{}
+	for _, hold := range holds {
+		if _, ok := positions[hold.ID]; ok || hold.Status != db.HoldStatusWaiting {
+			continue
+		}
+		queue, err := database.Holds(db.HoldFilter{BookID: &hold.BookID, Active: true})
+		cobra.CheckErr(err)
+		position := 0
+		for _, queued := range queue {
+			if queued.Status == db.HoldStatusWaiting {
+				position++
+				positions[queued.ID] = position
+			}
+		}
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "ID\tTITLE\tPATRON\tBARCODE\tPLACED\tSTATUS\tQUEUE\tCOPY\tPICKUP BY")
+	for _, hold := range holds {
+		queue, accession, pickupBy := "", "", ""
+		if position, ok := positions[hold.ID]; ok {
+			queue = strconv.Itoa(position)
+		}
+		if hold.Copy != nil {
+			accession = hold.Copy.Accession
+		}
+		if hold.PickupBy != nil && hold.Status == db.HoldStatusReady {
+			pickupBy = hold.PickupBy.Format(dateLayout)
+		}
+		fmt.Fprintf(writer, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", hold.ID, hold.Book.Title, hold.Patron.Name, hold.Patron.Barcode, hold.PlacedAt.Format(dateLayout), hold.Status, queue, accession, pickupBy)
+	}
+	cobra.CheckErr(writer.Flush())
+}
+
+var holdExpireCmd = &cobra.Command{
+	Use:   "expire",
+	Short: "expire holds not collected by their pickup date, passing their copies down the queue",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		runHoldExpire()
+	},
+}
+
+func runHoldExpire() {
+	expired, err := database.ExpireHolds(asOf())
+	cobra.CheckErr(err)
+
+	books := map[int64]bool{}
+	for _, hold := range expired {
+		log.Printf("Expired hold %d for %s on %s\n", hold.ID, hold.Patron.Name, hold.Book.Title)
+		books[hold.BookID] = true
+	}
+	for _, hold := range expired {
+		if books[hold.BookID] {
+			reportReadyHolds(hold.BookID)
+			books[hold.BookID] = false
+		}
+	}
+	log.Printf("Expired %d holds!\n", len(expired))
+}
+
+// reportReadyHolds lists the copies of a book kept for patrons, so they can be put aside.
+func reportReadyHolds(bookID int64) {
+	holds, err := database.Holds(db.HoldFilter{BookID: &bookID, Active: true})
+	cobra.CheckErr(err)
+
+	for _, hold := range holds {
+		if hold.Status == db.HoldStatusReady && hold.Copy != nil {
+			log.Printf("Keep %s for %s (%s) until %s\n", hold.Copy.Accession, hold.Patron.Name, hold.Patron.Barcode, hold.PickupBy.Format(dateLayout))
+		}
+	}
+}
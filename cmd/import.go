@@ -2,11 +2,13 @@ package cmd
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"regexp"
 
+	"github.com/arudzitis/addlib/db"
 	"github.com/arudzitis/addlib/openlibrary"
 	"github.com/spf13/cobra"
 )
@@ -14,11 +16,15 @@ import (
 var inputFileName string
 var inputFormatName string
 var exceptionFileName string
+var countCopies bool
+var importTags []string
 
 func init() {
 	importCmd.PersistentFlags().StringVarP(&inputFileName, "input", "i", "", "file to import from")
 	importCmd.PersistentFlags().StringVarP(&inputFormatName, "format", "f", "", `"isbn" or "olid" for openlibrary id`)
 	importCmd.PersistentFlags().StringVarP(&exceptionFileName, "exceptions", "e", "", "file to write lines which were not able to be imported")
+	importCmd.PersistentFlags().BoolVar(&countCopies, "count-copies", false, "record a copy for every line, so repeated isbns add further copies")
+	importCmd.PersistentFlags().StringArrayVarP(&importTags, "tag", "t", nil, "tag to apply to every imported book; may be repeated")
 	importCmd.MarkPersistentFlagRequired("input")
 	importCmd.MarkPersistentFlagRequired("format")
 
@@ -76,6 +82,16 @@ func handleIsbn(isbn string) error {
 		return err
 	}
 
+	if countCopies {
+		existingBook, err := database.FindBook(cleanedIsbn)
+		if err != nil && !errors.Is(err, db.ErrNotFound) {
+			return err
+		}
+		if existingBook != nil {
+			return finishImport(openlibrary.Book{OLID: existingBook.OLID, Title: existingBook.Title})
+		}
+	}
+
 	book, err := openlibrary.LookupByISBN(cleanedIsbn)
 	if err != nil {
 		return err
@@ -86,6 +102,42 @@ func handleIsbn(isbn string) error {
 		return err
 	}
 
+	return finishImport(*book)
+}
+
+// finishImport applies the per-book options of the import command to a book which has been saved.
+// With --collection, the new copy joins the collection when copies are counted, otherwise the book
+// does.
+func finishImport(book openlibrary.Book) error {
+	collection := scopeCollection()
+
+	if countCopies {
+		bookCopy, err := database.AddCopy(book, db.Copy{})
+		if err != nil {
+			return err
+		}
+		log.Printf("Added copy %s of %s.\n", bookCopy.Accession, book.Title)
+
+		if collection != nil {
+			_, err = database.AddCopyToCollection(*collection, bookCopy.Accession)
+			if err != nil {
+				return err
+			}
+		}
+	} else if collection != nil {
+		_, err := database.AddBooksToCollection(*collection, []openlibrary.Book{book})
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, tag := range importTags {
+		_, err := database.TagBooks(tag, []openlibrary.Book{book})
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
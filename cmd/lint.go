@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/arudzitis/addlib/db"
+	"github.com/spf13/cobra"
+)
+
+var (
+	lintFix    bool
+	lintRules  []string
+	lintFailOn string
+)
+
+func init() {
+	lintCmd.Flags().BoolVar(&lintFix, "fix", false, "correct the problems found by fixable rules")
+	lintCmd.Flags().StringSliceVar(&lintRules, "rule", nil, "only check these rules; may be repeated")
+	lintCmd.Flags().StringVar(&lintFailOn, "fail-on", db.SeverityWarning, `exit non-zero if problems of this severity or worse remain: "warning" or "error"`)
+
+	rules := []string{}
+	for _, rule := range db.LintRules {
+		fixable := ""
+		if rule.Fixable {
+			fixable = ", fixable"
+		}
+		rules = append(rules, fmt.Sprintf("  %s (%s%s): %s", rule.ID, rule.Severity, fixable, rule.Description))
+	}
+	lintCmd.Long = "Check the catalogue for problems, exiting non-zero if any of the --fail-on severity or worse remain.\n\nRules:\n" + strings.Join(rules, "\n")
+
+	rootCmd.AddCommand(lintCmd)
+}
+
+var lintCmd = &cobra.Command{
+	Use:   "lint",
+	Short: "check the catalogue for problems such as bad isbns, missing authors and untidy titles",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		runLint()
+	},
+}
+
+func runLint() {
+	if lintFailOn != db.SeverityWarning && lintFailOn != db.SeverityError {
+		log.Fatalf("unsupported severity: %q", lintFailOn)
+	}
+
+	problems, err := database.Lint(lintRules, lintFix)
+	cobra.CheckErr(err)
+
+	remaining, fixed, failing := 0, 0, 0
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "SEVERITY\tRULE\tRECORD\tPROBLEM")
+	for _, problem := range problems {
+		severity := problem.Severity
+		if problem.Fixed {
+			severity = "fixed"
+			fixed++
+		} else {
+			remaining++
+			if lintFailOn == db.SeverityWarning || problem.Severity == db.SeverityError {
+				failing++
+			}
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s %s\t%s\n", severity, problem.Rule, problem.Entity, problem.Key, problem.Message)
+	}
+	cobra.CheckErr(writer.Flush())
+
+	if lintFix {
+		log.Printf("Fixed %d problems!\n", fixed)
+	}
+	if failing > 0 {
+		cobra.CheckErr(fmt.Sprintf("%d problems remain", remaining))
+	}
+}
@@ -0,0 +1,193 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/arudzitis/addlib/callnumber"
+	"github.com/arudzitis/addlib/db"
+	"github.com/arudzitis/addlib/openlibrary"
+	"github.com/spf13/cobra"
+)
+
+var (
+	filterLocation string
+	filterTags     []string
+	filterAnyTags  []string
+	filterNotTags  []string
+
+	filterIncludeDeaccessioned bool
+
+	filterAddedSince   string
+	filterChangedSince string
+)
+
+var listSort string
+
+func init() {
+	addFilterFlags(listCmd)
+	listCmd.Flags().StringVarP(&listSort, "sort", "s", "", `order to list books in: "title" or "call-number"; defaults to the order they were added`)
+
+	rootCmd.AddCommand(listCmd)
+}
+
+// addFilterFlags registers the flags which narrow down the set of books a command works on.
+func addFilterFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&filterLocation, "location", "l", "", "only include books shelved at or within this location")
+	cmd.Flags().StringArrayVar(&filterTags, "tag", nil, "only include books with this tag; may be repeated, and books must have every tag")
+	cmd.Flags().StringArrayVar(&filterAnyTags, "any-tag", nil, "only include books with at least one of these tags; may be repeated")
+	cmd.Flags().StringArrayVar(&filterNotTags, "not-tag", nil, "exclude books with this tag; may be repeated")
+	cmd.Flags().StringVar(&filterAddedSince, "added-since", "", "only include books added on or after this date (YYYY-MM-DD) or time (RFC 3339)")
+	cmd.Flags().StringVar(&filterChangedSince, "changed-since", "", "only include books added or changed on or after this date (YYYY-MM-DD) or time (RFC 3339)")
+	cmd.Flags().BoolVar(&filterIncludeDeaccessioned, "include-deaccessioned", false, "include books which have left the collection")
+}
+
+// bookFilter builds a filter from the flags registered by addFilterFlags.
+func bookFilter() db.BookFilter {
+	filter := db.BookFilter{
+		Tags: db.TagFilter{
+			All:  filterTags,
+			Any:  filterAnyTags,
+			None: filterNotTags,
+		},
+		IncludeDeaccessioned: filterIncludeDeaccessioned,
+	}
+
+	if collection := scopeCollection(); collection != nil {
+		filter.CollectionID = &collection.ID
+	}
+
+	if filterLocation != "" {
+		location, err := database.FindLocation(filterLocation)
+		cobra.CheckErr(err)
+		filter.LocationID = &location.ID
+	}
+
+	if filterAddedSince != "" {
+		since := parseSince(filterAddedSince)
+		filter.AddedSince = &since
+	}
+
+	if filterChangedSince != "" {
+		since := parseSince(filterChangedSince)
+		filter.ChangedSince = &since
+	}
+
+	return filter
+}
+
+// parseSince reads a time given as a local date, or as a full timestamp such as the time of a
+// previous export, returning it in UTC.
+func parseSince(value string) time.Time {
+	since, err := time.Parse(time.RFC3339, value)
+	if err == nil {
+		return since.UTC()
+	}
+
+	since, err = time.ParseInLocation(dateLayout, value, time.Local)
+	cobra.CheckErr(err)
+	return since.UTC()
+}
+
+var listCmd = &cobra.Command{
+	Use:   "list",
+	Short: "list the books in the database",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		runList()
+	},
+}
+
+func runList() {
+	books, err := database.FindBooks(bookFilter())
+	cobra.CheckErr(err)
+
+	switch listSort {
+	case "":
+	case "title":
+		sort.SliceStable(books, func(i, j int) bool { return strings.ToLower(books[i].Title) < strings.ToLower(books[j].Title) })
+	case "call-number":
+		sortByCallNumber(books)
+	default:
+		log.Fatalf("unsupported sort order: %q", listSort)
+	}
+
+	locationPaths, err := database.LocationPaths()
+	cobra.CheckErr(err)
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "CALL NUMBER\tTITLE\tAUTHORS\tLOCATION\tTAGS\tOLID")
+	for _, book := range books {
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%s\n", book.ShelfMark(), book.Title, authorNames(book), bookLocation(book, locationPaths), tagNames(book), book.OLID)
+	}
+	cobra.CheckErr(writer.Flush())
+}
+
+// sortByCallNumber orders books as they would be shelved, with unclassified books last.
+func sortByCallNumber(books []db.Book) {
+	sort.SliceStable(books, func(i, j int) bool {
+		a, b := books[i].ShelfMark(), books[j].ShelfMark()
+		if a == "" || b == "" {
+			return b == "" && a != ""
+		}
+		return callnumber.Less(a, b)
+	})
+}
+
+// roleAbbreviations annotate contributors who are not primary authors.
+var roleAbbreviations = map[string]string{
+	openlibrary.RoleEditor:      "ed.",
+	openlibrary.RoleTranslator:  "trans.",
+	openlibrary.RoleIllustrator: "illus.",
+	openlibrary.RoleContributor: "contrib.",
+}
+
+// authorNames lists the authors of a book in order, marking editors, translators and other
+// contributors, e.g. "Homer, E. V. Rieu (trans.)".
+func authorNames(book db.Book) string {
+	names := make([]string, len(book.Credits))
+	for i, credit := range book.Credits {
+		names[i] = credit.Author.Name
+		if abbreviation, ok := roleAbbreviations[credit.Role]; ok {
+			names[i] = fmt.Sprintf("%s (%s)", credit.Author.Name, abbreviation)
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+func tagNames(book db.Book) string {
+	names := make([]string, len(book.Tags))
+	for i, tag := range book.Tags {
+		names[i] = tag.Name
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// bookLocation describes where a book is shelved: its own location if it has one, otherwise the
+// locations of its copies.
+func bookLocation(book db.Book, locationPaths map[int64]string) string {
+	if book.LocationID != nil {
+		return locationPaths[*book.LocationID]
+	}
+
+	seen := map[string]bool{}
+	paths := []string{}
+	for _, bookCopy := range book.Copies {
+		if bookCopy.LocationID == nil {
+			continue
+		}
+		path := locationPaths[*bookCopy.LocationID]
+		if !seen[path] {
+			seen[path] = true
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	return strings.Join(paths, "; ")
+}
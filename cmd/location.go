@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/arudzitis/addlib/db"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	locationCmd.AddCommand(locationTreeCmd)
+	locationCmd.AddCommand(locationAddCmd)
+	locationCmd.AddCommand(locationRemoveCmd)
+
+	rootCmd.AddCommand(locationCmd)
+}
+
+var locationCmd = &cobra.Command{
+	Use:   "location",
+	Short: "manage where books are shelved",
+}
+
+var locationTreeCmd = &cobra.Command{
+	Use:   "tree",
+	Short: "show all locations as a tree",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		runLocationTree()
+	},
+}
+
+func runLocationTree() {
+	locations, err := database.Locations()
+	cobra.CheckErr(err)
+
+	children := map[int64][]db.Location{}
+	roots := []db.Location{}
+	for _, location := range locations {
+		if location.ParentID == nil {
+			roots = append(roots, location)
+		} else {
+			children[*location.ParentID] = append(children[*location.ParentID], location)
+		}
+	}
+
+	var printLocations func([]db.Location, int)
+	printLocations = func(locations []db.Location, depth int) {
+		sort.Slice(locations, func(i, j int) bool { return locations[i].Name < locations[j].Name })
+		for _, location := range locations {
+			fmt.Printf("%s%s (%s, id %d)\n", strings.Repeat("  ", depth), location.Name, location.Kind, location.ID)
+			printLocations(children[location.ID], depth+1)
+		}
+	}
+	printLocations(roots, 0)
+}
+
+var locationAddCmd = &cobra.Command{
+	Use:   "add <building/room/bookcase/shelf>",
+	Short: "add a location, creating any missing parent locations",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runLocationAdd(args[0])
+	},
+}
+
+func runLocationAdd(path string) {
+	location, err := database.AddLocation(strings.Split(strings.Trim(path, db.LocationSeparator), db.LocationSeparator))
+	cobra.CheckErr(err)
+
+	log.Printf("Location %s is %s %d\n", path, location.Kind, location.ID)
+}
+
+var locationRemoveCmd = &cobra.Command{
+	Use:   "remove <location>",
+	Short: "remove an empty location",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runLocationRemove(args[0])
+	},
+}
+
+func runLocationRemove(ref string) {
+	location, err := database.FindLocation(ref)
+	cobra.CheckErr(err)
+
+	err = database.RemoveLocation(*location)
+	cobra.CheckErr(err)
+
+	log.Printf("Removed location %s\n", ref)
+}
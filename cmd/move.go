@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/arudzitis/addlib/db"
+	"github.com/arudzitis/addlib/openlibrary"
+	"github.com/spf13/cobra"
+)
+
+var moveDestination string
+var moveFileName string
+
+func init() {
+	moveCmd.Flags().StringVarP(&moveDestination, "to", "t", "", "location to move to, as a path, or an id such as #12")
+	moveCmd.Flags().StringVarP(&moveFileName, "file", "f", "", "file of scanned barcodes, olids or isbns to move, one per line")
+	moveCmd.MarkFlagRequired("to")
+
+	rootCmd.AddCommand(moveCmd)
+}
+
+var moveCmd = &cobra.Command{
+	Use:   "move [<accession|olid|isbn>...]",
+	Short: "move copies or books to a location",
+	Run: func(cmd *cobra.Command, args []string) {
+		runMove(args)
+	},
+}
+
+func runMove(refs []string) {
+	location, err := database.FindLocation(moveDestination)
+	cobra.CheckErr(err)
+
+	refs = appendRefsFromFile(refs, moveFileName)
+	if len(refs) == 0 {
+		cobra.CheckErr("nothing to move; pass barcodes as arguments or with --file")
+	}
+
+	moved := 0
+	for _, ref := range refs {
+		err := moveItem(ref, *location)
+		if err != nil {
+			log.Printf("error moving %q; %v, skipping...", ref, err)
+			continue
+		}
+		moved++
+	}
+
+	log.Printf("Moved %d of %d items to %s\n", moved, len(refs), moveDestination)
+}
+
+// moveItem moves the copy with the given accession number, or failing that the book it refers to.
+func moveItem(ref string, location db.Location) error {
+	rows, err := database.MoveCopy(ref, location)
+	if err != nil {
+		return err
+	}
+	if rows > 0 {
+		return nil
+	}
+
+	book, err := database.FindBook(ref)
+	if errors.Is(err, db.ErrNotFound) {
+		return fmt.Errorf("no copy or book matches %q", ref)
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = database.MoveBook(openlibrary.Book{OLID: book.OLID}, location)
+	return err
+}
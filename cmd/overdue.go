@@ -0,0 +1,237 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/arudzitis/addlib/db"
+	"github.com/arudzitis/addlib/notice"
+	"github.com/spf13/cobra"
+)
+
+// smtpPasswordVariable names the environment variable holding the SMTP password, which is kept out of
+// the database.
+const smtpPasswordVariable = "ADDLIB_SMTP_PASSWORD"
+
+var (
+	overdueAsOf string
+
+	noticeTemplateFileName string
+	noticeOutputDir        string
+	noticeSend             bool
+)
+
+func init() {
+	overdueCmd.Flags().StringVar(&overdueAsOf, "as-of", "", "date to report as of (YYYY-MM-DD); defaults to today")
+
+	noticesCmd.Flags().StringVar(&overdueAsOf, "as-of", "", "date to send notices as of (YYYY-MM-DD); defaults to today")
+	noticesCmd.Flags().StringVarP(&noticeTemplateFileName, "template", "t", "", "text/template file to render notices with; see \"notices template\" for the default")
+	noticesCmd.Flags().StringVarP(&noticeOutputDir, "out", "o", "", "directory to write a notice file per patron to, created if need be")
+	noticesCmd.Flags().BoolVar(&noticeSend, "send", false, "email notices through the configured SMTP server; the password, if needed, is read from $"+smtpPasswordVariable)
+
+	noticesCmd.AddCommand(noticesTemplateCmd)
+	noticesCmd.AddCommand(noticesLogCmd)
+
+	rootCmd.AddCommand(overdueCmd)
+	rootCmd.AddCommand(noticesCmd)
+}
+
+var overdueCmd = &cobra.Command{
+	Use:   "overdue",
+	Short: "list overdue loans by patron",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		runOverdue()
+	},
+}
+
+func runOverdue() {
+	overdue, err := database.OverdueLoans(asOf())
+	cobra.CheckErr(err)
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "PATRON\tBARCODE\tACCESSION\tTITLE\tDUE\tDAYS OVERDUE")
+	for _, group := range groupByPatron(overdue) {
+		for i, loan := range group {
+			name, barcode := "", ""
+			if i == 0 {
+				name, barcode = loan.Patron.Name, loan.Patron.Barcode
+			}
+			fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%d\n", name, barcode, loan.Copy.Accession, loan.Title, loan.DueOn.Format(dateLayout), loan.DaysOverdue)
+		}
+	}
+	cobra.CheckErr(writer.Flush())
+}
+
+var noticesCmd = &cobra.Command{
+	Use:   "notices",
+	Short: "render a reminder for each patron with overdue loans",
+	Long: `Render a reminder for each patron with overdue loans, from the default template or one given
+with --template. Notices are written to files with --out, or emailed with --send using the
+smtp_server, smtp_from and smtp_username settings; either way a record is kept of what was sent.
+Without either flag the notices are only printed.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		runNotices()
+	},
+}
+
+func runNotices() {
+	templateText := notice.DefaultTemplate
+	if noticeTemplateFileName != "" {
+		content, err := os.ReadFile(noticeTemplateFileName)
+		cobra.CheckErr(err)
+		templateText = string(content)
+	}
+	tmpl, err := notice.Parse(templateText)
+	cobra.CheckErr(err)
+
+	var mailer *notice.Mailer
+	if noticeSend {
+		mailer = configuredMailer()
+	}
+
+	if noticeOutputDir != "" {
+		cobra.CheckErr(os.MkdirAll(noticeOutputDir, 0o755))
+	}
+
+	date := asOf()
+	overdue, err := database.OverdueLoans(date)
+	cobra.CheckErr(err)
+
+	sent := 0
+	for _, group := range groupByPatron(overdue) {
+		patron := group[0].Patron
+		data := notice.Data{
+			Name:    patron.Name,
+			Barcode: patron.Barcode,
+			Contact: patron.Contact,
+			Date:    date,
+		}
+		loans := []db.Loan{}
+		for _, loan := range group {
+			data.Items = append(data.Items, notice.Item{
+				Title:       loan.Title,
+				Accession:   loan.Copy.Accession,
+				DueOn:       loan.DueOn,
+				DaysOverdue: loan.DaysOverdue,
+			})
+			loans = append(loans, loan.Loan)
+		}
+
+		message, err := notice.Render(tmpl, data)
+		cobra.CheckErr(err)
+
+		if noticeOutputDir == "" && mailer == nil {
+			fmt.Printf("To: %s <%s>\nSubject: %s\n\n%s\n", patron.Name, patron.Contact, message.Subject, message.Body)
+			continue
+		}
+
+		if noticeOutputDir != "" {
+			fileName := filepath.Join(noticeOutputDir, fmt.Sprintf("%s-%s.txt", patron.Barcode, date.Format(dateLayout)))
+			err = os.WriteFile(fileName, []byte(fmt.Sprintf("Subject: %s\n\n%s", message.Subject, message.Body)), 0o644)
+			cobra.CheckErr(err)
+			_, err = database.RecordNotice(patron, db.NoticeMethodFile, fileName, loans)
+			cobra.CheckErr(err)
+		}
+
+		if mailer != nil {
+			err = mailer.Send(patron.Contact, *message)
+			if err != nil {
+				log.Printf("error sending notice to %s; %v, skipping...", patron.Name, err)
+				continue
+			}
+			_, err = database.RecordNotice(patron, db.NoticeMethodEmail, patron.Contact, loans)
+			cobra.CheckErr(err)
+		}
+		sent++
+	}
+
+	if noticeOutputDir != "" || mailer != nil {
+		log.Printf("Sent %d notices!\n", sent)
+	}
+}
+
+// configuredMailer builds a mailer from the SMTP settings.
+func configuredMailer() *notice.Mailer {
+	mailer := &notice.Mailer{Password: os.Getenv(smtpPasswordVariable)}
+	for _, setting := range []struct {
+		key    string
+		target *string
+	}{
+		{db.SettingSMTPServer, &mailer.Server},
+		{db.SettingSMTPFrom, &mailer.From},
+		{db.SettingSMTPUsername, &mailer.Username},
+	} {
+		value, err := database.Setting(setting.key)
+		cobra.CheckErr(err)
+		*setting.target = value
+	}
+
+	if mailer.From == "" {
+		cobra.CheckErr(fmt.Sprintf("set the address to send from with: addlib config set %s <address>", db.SettingSMTPFrom))
+	}
+	return mailer
+}
+
+var noticesTemplateCmd = &cobra.Command{
+	Use:   "template",
+	Short: "print the default notice template, as a starting point for your own",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Print(notice.DefaultTemplate)
+	},
+}
+
+var noticesLogCmd = &cobra.Command{
+	Use:   "log",
+	Short: "list the notices which have been sent",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		runNoticesLog()
+	},
+}
+
+func runNoticesLog() {
+	notices, err := database.Notices()
+	cobra.CheckErr(err)
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "ID\tSENT\tPATRON\tMETHOD\tRECIPIENT\tITEMS")
+	for _, sent := range notices {
+		accessions := ""
+		for i, loan := range sent.Loans {
+			if i > 0 {
+				accessions += ", "
+			}
+			accessions += loan.Copy.Accession
+		}
+		fmt.Fprintf(writer, "%d\t%s\t%s\t%s\t%s\t%s\n", sent.ID, sent.SentAt.Local().Format(time.RFC3339), sent.Patron.Name, sent.Method, sent.Recipient, accessions)
+	}
+	cobra.CheckErr(writer.Flush())
+}
+
+// groupByPatron splits overdue loans, which are ordered by patron, into a group per patron.
+func groupByPatron(overdue []db.OverdueLoan) [][]db.OverdueLoan {
+	groups := [][]db.OverdueLoan{}
+	for i, loan := range overdue {
+		if i == 0 || loan.PatronID != overdue[i-1].PatronID {
+			groups = append(groups, nil)
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], loan)
+	}
+	return groups
+}
+
+func asOf() time.Time {
+	if overdueAsOf == "" {
+		return time.Now()
+	}
+	date, err := time.Parse(dateLayout, overdueAsOf)
+	cobra.CheckErr(err)
+	return date
+}
@@ -0,0 +1,179 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/arudzitis/addlib/db"
+	"github.com/spf13/cobra"
+)
+
+var (
+	patronBarcode string
+	patronName    string
+	patronContact string
+	patronStatus  string
+)
+
+func init() {
+	patronAddCmd.Flags().StringVarP(&patronBarcode, "barcode", "b", "", "barcode of the patron's card; generated if not given")
+	patronAddCmd.Flags().StringVar(&patronContact, "contact", "", "how to reach the patron, such as an email address")
+	patronAddCmd.Flags().StringVar(&patronStatus, "status", db.PatronStatusActive, fmt.Sprintf("status of the patron: %s", strings.Join(db.PatronStatuses, ", ")))
+
+	patronUpdateCmd.Flags().StringVar(&patronName, "name", "", "new name for the patron")
+	patronUpdateCmd.Flags().StringVar(&patronContact, "contact", "", "new contact details for the patron")
+	patronUpdateCmd.Flags().StringVar(&patronStatus, "status", "", fmt.Sprintf("new status for the patron: %s", strings.Join(db.PatronStatuses, ", ")))
+
+	patronCmd.AddCommand(patronAddCmd)
+	patronCmd.AddCommand(patronListCmd)
+	patronCmd.AddCommand(patronUpdateCmd)
+	patronCmd.AddCommand(patronImportCmd)
+
+	rootCmd.AddCommand(patronCmd)
+}
+
+var patronCmd = &cobra.Command{
+	Use:   "patron",
+	Short: "manage the patrons who may borrow copies",
+}
+
+var patronAddCmd = &cobra.Command{
+	Use:   "add <name>",
+	Short: "register a patron",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runPatronAdd(args[0])
+	},
+}
+
+func runPatronAdd(name string) {
+	patron, err := database.AddPatron(db.Patron{
+		Barcode: patronBarcode,
+		Name:    name,
+		Contact: patronContact,
+		Status:  patronStatus,
+	})
+	cobra.CheckErr(err)
+
+	log.Printf("Added patron %s with barcode %s\n", patron.Name, patron.Barcode)
+}
+
+var patronListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "list the patrons",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		runPatronList()
+	},
+}
+
+func runPatronList() {
+	patrons, err := database.Patrons()
+	cobra.CheckErr(err)
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "ID\tBARCODE\tNAME\tCONTACT\tSTATUS")
+	for _, patron := range patrons {
+		fmt.Fprintf(writer, "%d\t%s\t%s\t%s\t%s\n", patron.ID, patron.Barcode, patron.Name, patron.Contact, patron.Status)
+	}
+	cobra.CheckErr(writer.Flush())
+}
+
+var patronUpdateCmd = &cobra.Command{
+	Use:   "update <barcode|id>",
+	Short: "change a patron's name, contact details or status",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		columns := map[string]string{}
+		for flag, column := range map[string]string{"name": "name", "contact": "contact", "status": "status"} {
+			if cmd.Flags().Changed(flag) {
+				value, err := cmd.Flags().GetString(flag)
+				cobra.CheckErr(err)
+				columns[column] = value
+			}
+		}
+		runPatronUpdate(args[0], columns)
+	},
+}
+
+func runPatronUpdate(ref string, columns map[string]string) {
+	if len(columns) == 0 {
+		cobra.CheckErr("nothing to update; pass --name, --contact or --status")
+	}
+
+	patron, err := database.FindPatron(ref)
+	cobra.CheckErr(err)
+
+	rows, err := database.UpdatePatron(*patron, columns)
+	cobra.CheckErr(err)
+
+	log.Printf("Updated %d rows!\n", rows)
+}
+
+var patronImportCmd = &cobra.Command{
+	Use:   "import <file>",
+	Short: "register patrons from a csv file",
+	Long: `Register patrons from a csv file with a header row. The "name" column is required; "barcode",
+"contact" and "status" are optional, with barcodes generated and patrons active where they are
+missing.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runPatronImport(args[0])
+	},
+}
+
+func runPatronImport(fileName string) {
+	inputFile, err := os.Open(fileName)
+	cobra.CheckErr(err)
+	defer func() { _ = inputFile.Close() }()
+
+	reader := csv.NewReader(inputFile)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	cobra.CheckErr(err)
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["name"]; !ok {
+		cobra.CheckErr(fmt.Sprintf("%s has no name column", fileName))
+	}
+
+	field := func(record []string, name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	added := 0
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		cobra.CheckErr(err)
+
+		patron, err := database.AddPatron(db.Patron{
+			Barcode: field(record, "barcode"),
+			Name:    field(record, "name"),
+			Contact: field(record, "contact"),
+			Status:  field(record, "status"),
+		})
+		if err != nil {
+			log.Printf("error importing %q; %v, skipping...", strings.Join(record, ","), err)
+			continue
+		}
+		log.Printf("Added patron %s with barcode %s\n", patron.Name, patron.Barcode)
+		added++
+	}
+
+	log.Printf("Imported %d patrons!\n", added)
+}
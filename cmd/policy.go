@@ -0,0 +1,260 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/arudzitis/addlib/db"
+	"github.com/arudzitis/addlib/openlibrary"
+	"github.com/spf13/cobra"
+)
+
+// policyFlags maps the flags setting a loan policy to the columns they set.
+var policyFlags = map[string]string{
+	"name":            "name",
+	"loan-days":       "loan_days",
+	"max-renewals":    "max_renewals",
+	"max-items":       "max_items",
+	"non-circulating": "non_circulating",
+}
+
+var (
+	policyLoanDays       int64
+	policyMaxRenewals    int64
+	policyMaxItems       int64
+	policyNonCirculating bool
+)
+
+func init() {
+	policyCreateCmd.Flags().Int64Var(&policyLoanDays, "loan-days", 0, "days copies are lent for; defaults to the loan_period_days setting")
+	policyCreateCmd.Flags().Int64Var(&policyMaxRenewals, "max-renewals", 0, "times a loan may be renewed; defaults to the max_renewals setting")
+	policyCreateCmd.Flags().Int64Var(&policyMaxItems, "max-items", 0, "copies under this policy a patron may have out at once; unset, only the max_loans setting, which counts every loan, applies")
+	policyCreateCmd.Flags().BoolVar(&policyNonCirculating, "non-circulating", false, "copies may not be lent at all, such as reference books")
+
+	policyUpdateCmd.Flags().String("name", "", "new name for the policy")
+	policyUpdateCmd.Flags().String("loan-days", "", "days copies are lent for; empty to use the setting")
+	policyUpdateCmd.Flags().String("max-renewals", "", "times a loan may be renewed; empty to use the setting")
+	policyUpdateCmd.Flags().String("max-items", "", "copies under this policy a patron may have out at once; empty for only the max_loans setting")
+	policyUpdateCmd.Flags().String("non-circulating", "", "whether copies may not be lent at all (true or false)")
+
+	policyCmd.AddCommand(policyCreateCmd)
+	policyCmd.AddCommand(policyListCmd)
+	policyCmd.AddCommand(policyUpdateCmd)
+	policyCmd.AddCommand(policyDeleteCmd)
+	policyCmd.AddCommand(policyAttachCmd)
+	policyCmd.AddCommand(policyDetachCmd)
+	policyCmd.AddCommand(policyShowCmd)
+
+	rootCmd.AddCommand(policyCmd)
+}
+
+var policyCmd = &cobra.Command{
+	Use:   "policy",
+	Short: "manage loan policies for collections, tags and books, such as reference and short loan",
+}
+
+var policyCreateCmd = &cobra.Command{
+	Use:   "create <name>",
+	Short: "create a loan policy; limits not given fall back to the library-wide settings",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		policy := db.LoanPolicy{Name: args[0], NonCirculating: policyNonCirculating}
+		for flag, limit := range map[string]**int64{"loan-days": &policy.LoanDays, "max-renewals": &policy.MaxRenewals, "max-items": &policy.MaxItems} {
+			if cmd.Flags().Changed(flag) {
+				value, err := cmd.Flags().GetInt64(flag)
+				cobra.CheckErr(err)
+				*limit = &value
+			}
+		}
+		runPolicyCreate(policy)
+	},
+}
+
+func runPolicyCreate(policy db.LoanPolicy) {
+	created, err := database.CreatePolicy(policy)
+	cobra.CheckErr(err)
+
+	log.Printf("Created loan policy %q with id %d!\n", created.Name, created.ID)
+}
+
+var policyListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "list the loan policies and what they are attached to",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		runPolicyList()
+	},
+}
+
+func runPolicyList() {
+	policies, err := database.Policies()
+	cobra.CheckErr(err)
+	attachments, err := database.PolicyAttachments()
+	cobra.CheckErr(err)
+
+	attached := map[int64][]string{}
+	for _, attachment := range attachments {
+		attached[attachment.PolicyID] = append(attached[attachment.PolicyID], fmt.Sprintf("%s %q", attachment.Scope, attachment.Target))
+	}
+
+	limit := func(value *int64) string {
+		if value == nil {
+			return "-"
+		}
+		return strconv.FormatInt(*value, 10)
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "ID\tPOLICY\tLOAN DAYS\tMAX RENEWALS\tMAX ITEMS\tCIRCULATES\tATTACHED TO")
+	for _, policy := range policies {
+		circulates := "yes"
+		if policy.NonCirculating {
+			circulates = "no"
+		}
+		fmt.Fprintf(writer, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n", policy.ID, policy.Name, limit(policy.LoanDays), limit(policy.MaxRenewals), limit(policy.MaxItems), circulates, strings.Join(attached[policy.ID], ", "))
+	}
+	cobra.CheckErr(writer.Flush())
+}
+
+var policyUpdateCmd = &cobra.Command{
+	Use:   "update <policy>",
+	Short: "change the name, limits or circulation of a loan policy",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		columns := map[string]string{}
+		for flag, column := range policyFlags {
+			if cmd.Flags().Changed(flag) {
+				value, err := cmd.Flags().GetString(flag)
+				cobra.CheckErr(err)
+				columns[column] = value
+			}
+		}
+		runPolicyUpdate(args[0], columns)
+	},
+}
+
+func runPolicyUpdate(ref string, columns map[string]string) {
+	if len(columns) == 0 {
+		cobra.CheckErr("nothing to update; pass --name, --loan-days, --max-renewals, --max-items or --non-circulating")
+	}
+
+	policy, err := database.FindPolicy(ref)
+	cobra.CheckErr(err)
+
+	rows, err := database.UpdatePolicy(*policy, columns)
+	cobra.CheckErr(err)
+
+	log.Printf("Updated %d rows!\n", rows)
+}
+
+var policyDeleteCmd = &cobra.Command{
+	Use:   "delete <policy>",
+	Short: "delete a loan policy which is no longer attached to anything",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runPolicyDelete(args[0])
+	},
+}
+
+func runPolicyDelete(ref string) {
+	policy, err := database.FindPolicy(ref)
+	cobra.CheckErr(err)
+
+	err = database.DeletePolicy(*policy)
+	cobra.CheckErr(err)
+
+	log.Printf("Deleted loan policy %q!\n", policy.Name)
+}
+
+var policyAttachCmd = &cobra.Command{
+	Use:   "attach <policy> book|tag|collection <olid|isbn|tag|collection>",
+	Short: "attach a loan policy to a book, tag or collection, replacing any policy it had",
+	Long: "Attach a loan policy to a book, tag or collection. A copy is lent under the policy of its book " +
+		"if it has one, otherwise the strictest policy of the book's tags, otherwise the strictest policy " +
+		"of the collections the book or copy is in, otherwise the library-wide settings.",
+	Args: cobra.ExactArgs(3),
+	Run: func(cmd *cobra.Command, args []string) {
+		runPolicyAttach(args[0], args[1], args[2])
+	},
+}
+
+func runPolicyAttach(ref string, scope string, target string) {
+	policy, err := database.FindPolicy(ref)
+	cobra.CheckErr(err)
+
+	err = database.AttachPolicy(*policy, scope, policyTarget(scope, target))
+	cobra.CheckErr(err)
+
+	log.Printf("Attached loan policy %q to %s %s!\n", policy.Name, scope, target)
+}
+
+var policyDetachCmd = &cobra.Command{
+	Use:   "detach book|tag|collection <olid|isbn|tag|collection>",
+	Short: "detach the loan policy from a book, tag or collection",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		runPolicyDetach(args[0], args[1])
+	},
+}
+
+func runPolicyDetach(scope string, target string) {
+	err := database.DetachPolicy(scope, policyTarget(scope, target))
+	cobra.CheckErr(err)
+
+	log.Printf("Detached the loan policy from %s %s!\n", scope, target)
+}
+
+var policyShowCmd = &cobra.Command{
+	Use:   "show <accession|olid|isbn>",
+	Short: "show the loan policy a copy, or the copies of a book, are lent under",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runPolicyShow(args[0])
+	},
+}
+
+func runPolicyShow(ref string) {
+	policy, err := database.CopyPolicy(ref)
+	if errors.Is(err, db.ErrNotFound) {
+		book := resolveBook(ref)
+		policy, err = database.BookPolicy(openlibrary.Book{OLID: book.OLID})
+	}
+	cobra.CheckErr(err)
+
+	source := policy.Source
+	if source == "" {
+		source = "library-wide settings"
+	}
+	limit := func(value *int64) string {
+		if value == nil {
+			return "no limit"
+		}
+		return strconv.FormatInt(*value, 10)
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(writer, "Policy:\t%s\n", policy.Name)
+	fmt.Fprintf(writer, "From:\t%s\n", source)
+	if policy.NonCirculating {
+		fmt.Fprintf(writer, "Circulates:\tno\n")
+	} else {
+		fmt.Fprintf(writer, "Loan days:\t%d\n", *policy.LoanDays)
+		fmt.Fprintf(writer, "Max renewals:\t%s\n", limit(policy.MaxRenewals))
+		fmt.Fprintf(writer, "Max items:\t%s\n", limit(policy.MaxItems))
+	}
+	cobra.CheckErr(writer.Flush())
+}
+
+// policyTarget resolves the book a policy is attached to by olid or isbn; tags and collections are
+// named as they are.
+func policyTarget(scope string, target string) string {
+	if scope != db.PolicyScopeBook {
+		return target
+	}
+	return resolveBook(target).OLID
+}
@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"errors"
+	"log"
+
+	"github.com/arudzitis/addlib/db"
+	"github.com/arudzitis/addlib/openlibrary"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(refreshCmd)
+}
+
+var refreshCmd = &cobra.Command{
+	Use:   "refresh",
+	Short: "follow openlibrary merges, moving local records to the surviving openlibrary ids, and fill in missing publication and author details",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		runRefresh()
+	},
+}
+
+func runRefresh() {
+	authors, err := database.Authors()
+	cobra.CheckErr(err)
+
+	for _, author := range authors {
+		if openlibrary.IsLocalAuthorKey(author.OLID) {
+			continue
+		}
+
+		err := refreshAuthor(author.Author)
+		if err != nil {
+			log.Printf("error refreshing author %s; %v, skipping...", author.OLID, err)
+		}
+	}
+
+	books, err := database.FindBooks(db.BookFilter{IncludeDeaccessioned: true})
+	cobra.CheckErr(err)
+
+	for _, book := range books {
+		err := refreshBook(book)
+		if err != nil {
+			log.Printf("error refreshing book %s; %v, skipping...", book.OLID, err)
+		}
+	}
+}
+
+func refreshAuthor(author db.Author) error {
+	resolved, err := openlibrary.LookupAuthor(author.OLID)
+	if errors.Is(err, openlibrary.ErrDeleted) {
+		log.Printf("Author %s (%s) has been deleted from openlibrary.\n", author.OLID, author.Name)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if resolved.OLID != author.OLID {
+		log.Printf("Author %s (%s) is now %s.\n", author.OLID, author.Name, resolved.OLID)
+		err = database.RekeyAuthor(author.OLID, resolved.OLID)
+		if err != nil {
+			return err
+		}
+	}
+
+	// authors saved from an unresolved redirect have no name
+	if author.Name == "" && resolved.Name != "" {
+		_, err = database.RenameAuthor(resolved.OLID, resolved.Name)
+		if err != nil {
+			return err
+		}
+	}
+
+	// authors saved before their details were kept, or whose details openlibrary has since
+	// gained, are filled in
+	_, err = database.FillAuthorDetails([]openlibrary.Author{*resolved})
+	return err
+}
+
+func refreshBook(book db.Book) error {
+	key, err := openlibrary.ResolveKey(book.OLID)
+	if errors.Is(err, openlibrary.ErrDeleted) {
+		log.Printf("Book %s (%s) has been deleted from openlibrary.\n", book.OLID, book.Title)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if key != book.OLID {
+		log.Printf("Book %s (%s) is now %s.\n", book.OLID, book.Title, key)
+		return database.RekeyBook(book.OLID, key)
+	}
+
+	// books saved before publication details were kept are filled in
+	if book.PublishDate == nil {
+		edition, err := openlibrary.LookupEdition(key)
+		if err != nil {
+			return err
+		}
+		_, err = database.UpdatePublication(*edition)
+		return err
+	}
+
+	return nil
+}
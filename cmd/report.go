@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/arudzitis/addlib/db"
+	"github.com/arudzitis/addlib/openlibrary"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	addFilterFlags(reportUnclassifiedCmd)
+
+	addFilterFlags(reportQualityCmd)
+
+	reportCmd.AddCommand(reportUnclassifiedCmd)
+	reportCmd.AddCommand(reportQualityCmd)
+
+	rootCmd.AddCommand(reportCmd)
+}
+
+var reportCmd = &cobra.Command{
+	Use:   "report",
+	Short: "report on the state of the catalogue",
+}
+
+var reportUnclassifiedCmd = &cobra.Command{
+	Use:   "unclassified",
+	Short: "list books with no call number, Dewey or LCC classification",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		runReportUnclassified()
+	},
+}
+
+func runReportUnclassified() {
+	filter := bookFilter()
+	filter.Unclassified = true
+
+	books, err := database.FindBooks(filter)
+	cobra.CheckErr(err)
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "TITLE\tAUTHORS\tOLID")
+	for _, book := range books {
+		fmt.Fprintf(writer, "%s\t%s\t%s\n", book.Title, authorNames(book), book.OLID)
+	}
+	cobra.CheckErr(writer.Flush())
+
+	fmt.Printf("%d unclassified books\n", len(books))
+}
+
+var reportQualityCmd = &cobra.Command{
+	Use:   "quality",
+	Short: "list books whose openlibrary data needed guesswork: several or no works, or authors from the by statement",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		runReportQuality()
+	},
+}
+
+func runReportQuality() {
+	books, err := database.FindBooks(bookFilter())
+	cobra.CheckErr(err)
+
+	flagged := 0
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "ISSUE\tTITLE\tAUTHORS\tOLID")
+	for _, book := range books {
+		issues := qualityIssues(book)
+		for _, issue := range issues {
+			fmt.Fprintf(writer, "%s\t%s\t%s\t%s\n", issue, book.Title, authorNames(book), book.OLID)
+		}
+		if len(issues) > 0 {
+			flagged++
+		}
+	}
+	cobra.CheckErr(writer.Flush())
+
+	fmt.Printf("%d of %d books flagged\n", flagged, len(books))
+}
+
+// qualityIssues describes how the data for a book may be unreliable.
+func qualityIssues(book db.Book) []string {
+	issues := []string{}
+
+	// books saved before works were recorded have no author source, and no works to judge
+	if book.AuthorSource != "" {
+		switch len(book.Works) {
+		case 0:
+			issues = append(issues, "no work")
+		case 1:
+		default:
+			titles := make([]string, len(book.Works))
+			for i, work := range book.Works {
+				titles[i] = work.Title
+			}
+			issues = append(issues, fmt.Sprintf("%d works: %s", len(book.Works), strings.Join(titles, "; ")))
+		}
+	}
+
+	if book.AuthorSource == openlibrary.AuthorSourceByStatement {
+		issues = append(issues, "authors from by statement")
+	}
+	if len(book.Credits) == 0 {
+		issues = append(issues, "no authors")
+	}
+
+	return issues
+}
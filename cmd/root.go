@@ -1,7 +1,10 @@
 package cmd
 
 import (
+	"bufio"
 	"log"
+	"os"
+	"strings"
 
 	"github.com/arudzitis/addlib/db"
 	"github.com/spf13/cobra"
@@ -11,7 +14,9 @@ var (
 	databaseFile string
 	database     *db.DB
 
-	verbose bool
+	verbose        bool
+	actor          string
+	collectionName string
 
 	rootCmd = &cobra.Command{
 		Use:   "addlib",
@@ -28,13 +33,60 @@ func init() {
 
 	rootCmd.PersistentFlags().StringVarP(&databaseFile, "database", "d", "", "database file to use; will be created if it does not exist")
 	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "enable verbose output")
+	rootCmd.PersistentFlags().StringVar(&actor, "actor", "", "who to record as making changes; defaults to $USER")
+	rootCmd.PersistentFlags().StringVar(&collectionName, "collection", "", "collection to work within: imports join it, and list, export and deaccession only see its books")
 	rootCmd.MarkPersistentFlagRequired("database")
 }
 
+// resolveBook finds a book by openlibrary id or isbn, exiting if there is no such book.
+func resolveBook(ref string) *db.Book {
+	book, err := database.FindBook(ref)
+	cobra.CheckErr(err)
+	return book
+}
+
+// scopeCollection returns the collection given with --collection, or nil if none was given, exiting
+// if there is no such collection.
+func scopeCollection() *db.Collection {
+	if collectionName == "" {
+		return nil
+	}
+
+	collection, err := database.FindCollection(collectionName)
+	cobra.CheckErr(err)
+	return collection
+}
+
+// appendRefsFromFile adds the non-blank lines of a file, such as a list of scanned barcodes, to refs.
+// Nothing is added if fileName is empty.
+func appendRefsFromFile(refs []string, fileName string) []string {
+	if fileName == "" {
+		return refs
+	}
+
+	refFile, err := os.Open(fileName)
+	cobra.CheckErr(err)
+	defer func() { _ = refFile.Close() }()
+
+	scanner := bufio.NewScanner(refFile)
+	for scanner.Scan() {
+		if ref := strings.TrimSpace(scanner.Text()); ref != "" {
+			refs = append(refs, ref)
+		}
+	}
+	cobra.CheckErr(scanner.Err())
+
+	return refs
+}
+
 func initDatabase() {
 	var err error
 	database, err = db.OpenDatabase(databaseFile, verbose)
 	if err != nil {
 		log.Fatalf("%v", err)
 	}
+
+	if actor != "" {
+		database.SetActor(actor)
+	}
 }
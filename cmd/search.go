@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	addFilterFlags(searchCmd)
+
+	rootCmd.AddCommand(searchCmd)
+}
+
+var searchCmd = &cobra.Command{
+	Use:   "search <text>",
+	Short: "find books by title or author name",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runSearch(strings.Join(args, " "))
+	},
+}
+
+func runSearch(text string) {
+	filter := bookFilter()
+	filter.Query = text
+
+	books, err := database.FindBooks(filter)
+	cobra.CheckErr(err)
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "TITLE\tAUTHORS\tOLID")
+	for _, book := range books {
+		fmt.Fprintf(writer, "%s\t%s\t%s\n", book.Title, authorNames(book), book.OLID)
+	}
+	cobra.CheckErr(writer.Flush())
+}
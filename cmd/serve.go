@@ -0,0 +1,41 @@
+package cmd
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/arudzitis/addlib/server"
+	"github.com/spf13/cobra"
+)
+
+var (
+	serveAddress string
+	serveUI      bool
+)
+
+func init() {
+	serveCmd.Flags().StringVarP(&serveAddress, "address", "a", "localhost:8080", "address to listen on")
+	serveCmd.Flags().BoolVar(&serveUI, "ui", false, "also serve web pages for browsing, searching and editing the catalogue")
+
+	rootCmd.AddCommand(serveCmd)
+}
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "serve the catalogue as a JSON API for other tools, described at /api/openapi.json",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		runServe()
+	},
+}
+
+func runServe() {
+	handler := server.New(database, server.Options{UI: serveUI})
+
+	if serveUI {
+		log.Printf("Serving the catalogue at http://%s/ and its API at http://%s%s!\n", serveAddress, serveAddress, server.APIPrefix)
+	} else {
+		log.Printf("Serving the catalogue at http://%s%s!\n", serveAddress, server.APIPrefix)
+	}
+	cobra.CheckErr(http.ListenAndServe(serveAddress, handler))
+}
@@ -0,0 +1,126 @@
+package cmd
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"github.com/arudzitis/addlib/db"
+	"github.com/arudzitis/addlib/openlibrary"
+	"github.com/arudzitis/addlib/site"
+	"github.com/spf13/cobra"
+)
+
+var (
+	siteOut       string
+	siteTemplates string
+	siteCovers    string
+	siteTitle     string
+)
+
+func init() {
+	siteBuildCmd.Flags().StringVarP(&siteOut, "out", "o", "", "directory to write the site to")
+	siteBuildCmd.Flags().StringVar(&siteTemplates, "templates", "", "directory of templates replacing the default ones of the same name")
+	siteBuildCmd.Flags().StringVar(&siteCovers, "covers", "link", `"link" to openlibrary's covers, "download" them into the site, or "none"`)
+	siteBuildCmd.Flags().StringVar(&siteTitle, "title", "Library", "title of the site")
+	addFilterFlags(siteBuildCmd)
+	siteBuildCmd.MarkFlagRequired("out")
+
+	siteCmd.AddCommand(siteBuildCmd)
+	siteCmd.AddCommand(siteTemplatesCmd)
+
+	rootCmd.AddCommand(siteCmd)
+}
+
+var siteCmd = &cobra.Command{
+	Use:   "site",
+	Short: "publish the catalogue as a static website",
+}
+
+var siteBuildCmd = &cobra.Command{
+	Use:   "build",
+	Short: "write html pages for every book, author, subject and letter, with a search index",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		runSiteBuild()
+	},
+}
+
+func runSiteBuild() {
+	options := site.Options{Out: siteOut, Templates: siteTemplates}
+	switch siteCovers {
+	case "link", "none":
+	case "download":
+		options.FetchCover = openlibrary.FetchCover
+	default:
+		log.Fatalf("unsupported covers option: %q", siteCovers)
+	}
+
+	books, err := database.FindBooks(bookFilter())
+	cobra.CheckErr(err)
+
+	locationPaths, err := database.LocationPaths()
+	cobra.CheckErr(err)
+
+	catalogue := site.Catalogue{Title: siteTitle, Generated: time.Now()}
+	for _, book := range books {
+		siteBook := siteBookOf(book, locationPaths)
+		if siteCovers == "link" {
+			siteBook.Cover = openlibrary.CoverURL(book.OLID, openlibrary.CoverMedium)
+		}
+		catalogue.Books = append(catalogue.Books, siteBook)
+	}
+
+	pages, err := site.Build(catalogue, options)
+	cobra.CheckErr(err)
+
+	log.Printf("Wrote %d pages for %d books to %s!\n", pages, len(books), siteOut)
+}
+
+// siteBookOf describes a book as the site shows it. Withdrawn copies are not counted.
+func siteBookOf(book db.Book, locationPaths map[int64]string) site.Book {
+	siteBook := site.Book{
+		OLID:       book.OLID,
+		Title:      book.Title,
+		CallNumber: book.ShelfMark(),
+		Location:   bookLocation(book, locationPaths),
+	}
+
+	for _, credit := range book.Credits {
+		siteBook.Authors = append(siteBook.Authors, site.Credit{OLID: credit.Author.OLID, Name: credit.Author.Name, Role: credit.Role})
+	}
+	for _, subject := range book.Subjects {
+		siteBook.Subjects = append(siteBook.Subjects, subject.Name)
+	}
+	for _, isbns := range []*string{book.ISBN13, book.ISBN10} {
+		if isbns != nil && *isbns != "" {
+			siteBook.ISBNs = append(siteBook.ISBNs, strings.Split(*isbns, openlibrary.ISBNSeparator)...)
+		}
+	}
+	if book.PublishDate != nil {
+		siteBook.PublishDate = *book.PublishDate
+	}
+	if book.Languages != nil && *book.Languages != "" {
+		siteBook.Languages = strings.Split(*book.Languages, openlibrary.LanguageSeparator)
+	}
+	for _, bookCopy := range book.Copies {
+		if bookCopy.Status != db.CopyStatusWithdrawn {
+			siteBook.Copies++
+		}
+	}
+	return siteBook
+}
+
+var siteTemplatesCmd = &cobra.Command{
+	Use:   "templates <dir>",
+	Short: "write the default templates into a directory, to be edited and passed to build --templates",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runSiteTemplates(args[0])
+	},
+}
+
+func runSiteTemplates(dir string) {
+	cobra.CheckErr(site.WriteTemplates(dir))
+	log.Printf("Wrote the default templates to %s!\n", dir)
+}
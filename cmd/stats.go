@@ -0,0 +1,263 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/arudzitis/addlib/db"
+	"github.com/spf13/cobra"
+)
+
+var (
+	statsFrom       string
+	statsTo         string
+	statsTop        int
+	statsFormatName string
+)
+
+func init() {
+	statsCmd.Flags().StringVarP(&statsFormatName, "format", "f", "text", `"text", "json" or "csv"`)
+	statsCmd.Flags().IntVar(&statsTop, "top", 10, "number of authors, subjects and works to rank; 0 for all")
+	addFilterFlags(statsCmd)
+
+	statsCirculationCmd.Flags().StringVar(&statsFrom, "from", "", "first date of loans to count (YYYY-MM-DD)")
+	statsCirculationCmd.Flags().StringVar(&statsTo, "to", "", "last date of loans to count (YYYY-MM-DD)")
+	statsCirculationCmd.Flags().IntVar(&statsTop, "top", 10, "number of books, authors, tags and patrons to rank; 0 for all")
+
+	anonymizeCmd.Flags().StringVar(&overdueAsOf, "as-of", "", "date to anonymize loans as of (YYYY-MM-DD); defaults to today")
+
+	statsCmd.AddCommand(statsCirculationCmd)
+
+	rootCmd.AddCommand(statsCmd)
+	rootCmd.AddCommand(anonymizeCmd)
+}
+
+var statsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "summarise the catalogue: books, authors, works, decades, languages, subjects and missing data",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		runStats()
+	},
+}
+
+func runStats() {
+	var writer func(io.Writer, db.CatalogueStats) error
+
+	switch statsFormatName {
+	case "text":
+		writer = writeStatsText
+	case "json":
+		writer = writeStatsJSON
+	case "csv":
+		writer = writeStatsCSV
+	default:
+		log.Fatalf("unsupported output format: %q", statsFormatName)
+	}
+
+	stats, err := database.CatalogueStats(bookFilter())
+	cobra.CheckErr(err)
+
+	stats.TopAuthors = topCounts(stats.TopAuthors)
+	stats.Subjects = topCounts(stats.Subjects)
+	stats.MultiEditionWorks = topCounts(stats.MultiEditionWorks)
+
+	cobra.CheckErr(writer(os.Stdout, *stats))
+}
+
+func writeStatsText(output io.Writer, stats db.CatalogueStats) error {
+	writer := tabwriter.NewWriter(output, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(writer, "Books:\t%d\n", stats.Books)
+	fmt.Fprintf(writer, "Copies:\t%d\n", stats.Copies)
+	fmt.Fprintf(writer, "Authors:\t%d\n", stats.Authors)
+	fmt.Fprintf(writer, "Works:\t%d, %.2f editions each\n", stats.Works, stats.EditionsPerWork)
+	fmt.Fprintf(writer, "Missing ISBN:\t%d\n", len(stats.MissingISBN))
+	fmt.Fprintf(writer, "Missing authors:\t%d\n", len(stats.MissingAuthors))
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+
+	for _, section := range []struct {
+		heading string
+		counts  []db.BookCount
+	}{
+		{"TOP AUTHOR", stats.TopAuthors},
+		{"WORK WITH SEVERAL EDITIONS", stats.MultiEditionWorks},
+		{"DECADE", stats.Decades},
+		{"LANGUAGE", stats.Languages},
+		{"SUBJECT", stats.Subjects},
+	} {
+		fmt.Fprintln(output)
+		writer = tabwriter.NewWriter(output, 0, 4, 2, ' ', 0)
+		fmt.Fprintf(writer, "%s\tBOOKS\n", section.heading)
+		for _, count := range section.counts {
+			fmt.Fprintf(writer, "%s\t%d\n", count.Name, count.Books)
+		}
+		if err := writer.Flush(); err != nil {
+			return err
+		}
+	}
+
+	for _, section := range []struct {
+		heading string
+		books   []db.BookSummary
+	}{
+		{"MISSING ISBN", stats.MissingISBN},
+		{"MISSING AUTHORS", stats.MissingAuthors},
+	} {
+		if len(section.books) == 0 {
+			continue
+		}
+		fmt.Fprintln(output)
+		writer = tabwriter.NewWriter(output, 0, 4, 2, ' ', 0)
+		fmt.Fprintf(writer, "%s\tOLID\n", section.heading)
+		for _, book := range section.books {
+			fmt.Fprintf(writer, "%s\t%s\n", book.Title, book.OLID)
+		}
+		if err := writer.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeStatsJSON(output io.Writer, stats db.CatalogueStats) error {
+	encoder := json.NewEncoder(output)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(stats)
+}
+
+// writeStatsCSV writes the statistics as rows of section, key, name and value, to chart from.
+func writeStatsCSV(output io.Writer, stats db.CatalogueStats) error {
+	rows := [][]string{
+		{"section", "key", "name", "value"},
+		{"summary", "books", "", strconv.Itoa(stats.Books)},
+		{"summary", "copies", "", strconv.Itoa(stats.Copies)},
+		{"summary", "authors", "", strconv.Itoa(stats.Authors)},
+		{"summary", "works", "", strconv.Itoa(stats.Works)},
+		{"summary", "editions_per_work", "", strconv.FormatFloat(stats.EditionsPerWork, 'f', 2, 64)},
+		{"summary", "missing_isbn", "", strconv.Itoa(len(stats.MissingISBN))},
+		{"summary", "missing_authors", "", strconv.Itoa(len(stats.MissingAuthors))},
+	}
+	for _, section := range []struct {
+		name   string
+		counts []db.BookCount
+	}{
+		{"top_author", stats.TopAuthors},
+		{"multi_edition_work", stats.MultiEditionWorks},
+		{"decade", stats.Decades},
+		{"language", stats.Languages},
+		{"subject", stats.Subjects},
+	} {
+		for _, count := range section.counts {
+			rows = append(rows, []string{section.name, count.Key, count.Name, strconv.Itoa(count.Books)})
+		}
+	}
+	for _, section := range []struct {
+		name  string
+		books []db.BookSummary
+	}{
+		{"missing_isbn", stats.MissingISBN},
+		{"missing_authors", stats.MissingAuthors},
+	} {
+		for _, book := range section.books {
+			rows = append(rows, []string{section.name, book.OLID, book.Title, ""})
+		}
+	}
+
+	writer := csv.NewWriter(output)
+	err := writer.WriteAll(rows)
+	if err != nil {
+		return err
+	}
+	return writer.Error()
+}
+
+// topCounts keeps the first --top counts.
+func topCounts(counts []db.BookCount) []db.BookCount {
+	if statsTop > 0 && len(counts) > statsTop {
+		return counts[:statsTop]
+	}
+	return counts
+}
+
+var statsCirculationCmd = &cobra.Command{
+	Use:   "circulation",
+	Short: "count loans by book, author, subject, tag and patron, and list books never borrowed",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		runStatsCirculation()
+	},
+}
+
+func runStatsCirculation() {
+	anonymizeLoans(time.Now())
+
+	filter := db.CirculationFilter{Period: parseDateRange(statsFrom, statsTo)}
+	if collection := scopeCollection(); collection != nil {
+		filter.CollectionID = &collection.ID
+	}
+
+	stats, err := database.CirculationStats(filter)
+	cobra.CheckErr(err)
+
+	fmt.Printf("%d loans, %d returned, kept %.1f days on average\n", stats.Loans, stats.Returned, stats.AverageLoanDays)
+
+	for _, ranking := range []struct {
+		heading string
+		counts  []db.LoanCount
+	}{
+		{"BOOK", stats.Books},
+		{"AUTHOR", stats.Authors},
+		{"SUBJECT", stats.Subjects},
+		{"TAG", stats.Tags},
+		{"PATRON", stats.Patrons},
+	} {
+		counts := ranking.counts
+		if statsTop > 0 && len(counts) > statsTop {
+			counts = counts[:statsTop]
+		}
+
+		fmt.Println()
+		writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintf(writer, "%s\tKEY\tLOANS\n", ranking.heading)
+		for _, count := range counts {
+			fmt.Fprintf(writer, "%s\t%s\t%d\n", count.Name, count.Key, count.Loans)
+		}
+		cobra.CheckErr(writer.Flush())
+	}
+
+	fmt.Println()
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "NEVER BORROWED\tAUTHORS\tCOPIES\tOLID")
+	for _, book := range stats.NeverBorrowed {
+		fmt.Fprintf(writer, "%s\t%s\t%d\t%s\n", book.Title, authorNames(book), len(book.Copies), book.OLID)
+	}
+	cobra.CheckErr(writer.Flush())
+}
+
+var anonymizeCmd = &cobra.Command{
+	Use:   "anonymize",
+	Short: "forget who borrowed copies returned longer ago than the anonymize_loans_after_days setting",
+	Long: `Forget who borrowed copies returned longer ago than the anonymize_loans_after_days setting.
+This also happens whenever a copy is checked in and before circulation statistics are reported.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		anonymized := anonymizeLoans(asOf())
+		log.Printf("Anonymized %d loans!\n", anonymized)
+	},
+}
+
+// anonymizeLoans applies the anonymize_loans_after_days setting as of a date.
+func anonymizeLoans(date time.Time) int64 {
+	anonymized, err := database.AnonymizeLoans(date)
+	cobra.CheckErr(err)
+	return anonymized
+}
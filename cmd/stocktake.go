@@ -0,0 +1,180 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/arudzitis/addlib/db"
+	"github.com/spf13/cobra"
+)
+
+var (
+	stocktakeSession         string
+	stocktakeFileName        string
+	stocktakeUpdateLocations bool
+	stocktakeMarkMissing     bool
+)
+
+func init() {
+	stocktakeScanCmd.Flags().StringVar(&stocktakeSession, "session", "", "id of the stocktake to scan into; defaults to the only one open")
+	stocktakeScanCmd.Flags().StringVarP(&stocktakeFileName, "file", "f", "", "file of scanned barcodes, olids or isbns, one per line")
+
+	stocktakeFinishCmd.Flags().StringVar(&stocktakeSession, "session", "", "id of the stocktake to finish; defaults to the only one open")
+	stocktakeFinishCmd.Flags().BoolVar(&stocktakeUpdateLocations, "update-locations", false, "move the items found here but recorded elsewhere to this location, and make the copies found which were missing available")
+	stocktakeFinishCmd.Flags().BoolVar(&stocktakeMarkMissing, "mark-missing", false, "record the copies expected here but not found as missing")
+
+	stocktakeCmd.AddCommand(stocktakeStartCmd)
+	stocktakeCmd.AddCommand(stocktakeScanCmd)
+	stocktakeCmd.AddCommand(stocktakeFinishCmd)
+
+	rootCmd.AddCommand(stocktakeCmd)
+}
+
+var stocktakeCmd = &cobra.Command{
+	Use:   "stocktake",
+	Short: "check the items on the shelves of a location against the catalogue",
+}
+
+var stocktakeStartCmd = &cobra.Command{
+	Use:   "start <location>",
+	Short: "start a stocktake of a location and everything within it",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runStocktakeStart(args[0])
+	},
+}
+
+func runStocktakeStart(ref string) {
+	location, err := database.FindLocation(ref)
+	cobra.CheckErr(err)
+
+	stocktake, err := database.StartStocktake(*location)
+	cobra.CheckErr(err)
+
+	log.Printf("Started stocktake %d of %s!\n", stocktake.ID, ref)
+}
+
+var stocktakeScanCmd = &cobra.Command{
+	Use:   "scan [<accession|olid|isbn>...]",
+	Short: "record items found on the shelves; with no arguments or --file, reads scans from standard input as they are made",
+	Run: func(cmd *cobra.Command, args []string) {
+		runStocktakeScan(args)
+	},
+}
+
+func runStocktakeScan(refs []string) {
+	stocktake, err := database.OpenStocktake(stocktakeSession)
+	cobra.CheckErr(err)
+
+	refs = appendRefsFromFile(refs, stocktakeFileName)
+	if len(refs) > 0 {
+		for _, ref := range refs {
+			scanStocktakeItem(*stocktake, ref)
+		}
+		log.Printf("Scanned %d items!\n", len(refs))
+		return
+	}
+
+	scanned := 0
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		if ref := strings.TrimSpace(scanner.Text()); ref != "" {
+			scanStocktakeItem(*stocktake, ref)
+			scanned++
+		}
+	}
+	cobra.CheckErr(scanner.Err())
+	log.Printf("Scanned %d items!\n", scanned)
+}
+
+// scanStocktakeItem records a scan, saying what it matched so that unknown items can be put aside.
+func scanStocktakeItem(stocktake db.Stocktake, ref string) {
+	scan, err := database.ScanStocktake(stocktake, ref)
+	cobra.CheckErr(err)
+
+	if scan.Book == nil {
+		log.Printf("%s: unknown\n", ref)
+		return
+	}
+	log.Printf("%s: %s\n", ref, scan.Book.Title)
+}
+
+var stocktakeFinishCmd = &cobra.Command{
+	Use:   "finish",
+	Short: "finish a stocktake, reporting the items missing, misplaced and unknown",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		runStocktakeFinish()
+	},
+}
+
+func runStocktakeFinish() {
+	stocktake, err := database.OpenStocktake(stocktakeSession)
+	cobra.CheckErr(err)
+
+	report, err := database.FinishStocktake(*stocktake, db.StocktakeOptions{
+		UpdateLocations: stocktakeUpdateLocations,
+		MarkMissing:     stocktakeMarkMissing,
+	})
+	cobra.CheckErr(err)
+
+	paths, err := database.LocationPaths()
+	cobra.CheckErr(err)
+
+	fmt.Printf("Stocktake %d of %s: %d items seen, %d missing, %d misplaced, %d unknown\n",
+		report.Stocktake.ID, paths[report.Stocktake.LocationID], report.Seen, len(report.Missing), len(report.Misplaced), len(report.Unknown))
+
+	for _, section := range []struct {
+		heading string
+		items   []db.StocktakeItem
+	}{
+		{"MISSING", report.Missing},
+		{"MISPLACED", report.Misplaced},
+	} {
+		if len(section.items) == 0 {
+			continue
+		}
+
+		fmt.Println()
+		writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintf(writer, "%s\tACCESSION\tRECORDED AT\tSTATUS\tOLID\n", section.heading)
+		for _, item := range section.items {
+			accession, status, recordedAt := "", "", ""
+			if item.Copy != nil {
+				accession, status = item.Copy.Accession, item.Copy.Status
+			}
+			if item.OnLoan {
+				status = "on loan"
+			}
+			if item.LocationID != nil {
+				recordedAt = paths[*item.LocationID]
+			}
+			fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\n", item.Book.Title, accession, recordedAt, status, item.Book.OLID)
+		}
+		cobra.CheckErr(writer.Flush())
+	}
+
+	if len(report.Unknown) > 0 {
+		fmt.Printf("\nUNKNOWN\n")
+		for _, ref := range report.Unknown {
+			fmt.Println(ref)
+		}
+	}
+
+	if stocktakeUpdateLocations {
+		log.Printf("Updated %d misplaced items!\n", report.Updated)
+	}
+	if stocktakeMarkMissing {
+		marked := 0
+		for _, item := range report.Missing {
+			if item.Copy != nil {
+				marked++
+			}
+		}
+		log.Printf("Marked %d copies missing!\n", marked)
+	}
+}
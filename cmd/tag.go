@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+
+	"github.com/arudzitis/addlib/openlibrary"
+	"github.com/spf13/cobra"
+)
+
+var tagFileName string
+
+func init() {
+	tagAddCmd.Flags().StringVarP(&tagFileName, "file", "f", "", "file of olids or isbns to tag, one per line")
+	tagRemoveCmd.Flags().StringVarP(&tagFileName, "file", "f", "", "file of olids or isbns to untag, one per line")
+
+	tagCmd.AddCommand(tagAddCmd)
+	tagCmd.AddCommand(tagRemoveCmd)
+	tagCmd.AddCommand(tagListCmd)
+
+	rootCmd.AddCommand(tagCmd)
+}
+
+var tagCmd = &cobra.Command{
+	Use:   "tag",
+	Short: "manage local tags on books",
+}
+
+var tagAddCmd = &cobra.Command{
+	Use:   "add <tag> [<olid|isbn>...]",
+	Short: "tag one or more books",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runTagAdd(args[0], args[1:])
+	},
+}
+
+func runTagAdd(tag string, refs []string) {
+	rows, err := database.TagBooks(tag, resolveTagBooks(refs))
+	cobra.CheckErr(err)
+
+	log.Printf("Tagged %d books with %q!\n", rows, tag)
+}
+
+var tagRemoveCmd = &cobra.Command{
+	Use:   "remove <tag> [<olid|isbn>...]",
+	Short: "remove a tag from one or more books",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runTagRemove(args[0], args[1:])
+	},
+}
+
+func runTagRemove(tag string, refs []string) {
+	rows, err := database.UntagBooks(tag, resolveTagBooks(refs))
+	cobra.CheckErr(err)
+
+	log.Printf("Removed %q from %d books!\n", tag, rows)
+}
+
+func resolveTagBooks(refs []string) []openlibrary.Book {
+	refs = appendRefsFromFile(refs, tagFileName)
+	if len(refs) == 0 {
+		cobra.CheckErr("no books given; pass olids or isbns as arguments or with --file")
+	}
+
+	books := make([]openlibrary.Book, len(refs))
+	for i, ref := range refs {
+		books[i] = openlibrary.Book{OLID: resolveBook(ref).OLID}
+	}
+	return books
+}
+
+var tagListCmd = &cobra.Command{
+	Use:   "list [<olid|isbn>]",
+	Short: "list all tags, or the tags on a book",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 1 {
+			runTagListBook(args[0])
+		} else {
+			runTagList()
+		}
+	},
+}
+
+func runTagList() {
+	tags, err := database.Tags()
+	cobra.CheckErr(err)
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "TAG\tBOOKS")
+	for _, tag := range tags {
+		fmt.Fprintf(writer, "%s\t%d\n", tag.Name, tag.Books)
+	}
+	cobra.CheckErr(writer.Flush())
+}
+
+func runTagListBook(ref string) {
+	book := resolveBook(ref)
+
+	tags, err := database.BookTags(openlibrary.Book{OLID: book.OLID})
+	cobra.CheckErr(err)
+
+	for _, tag := range tags {
+		fmt.Println(tag.Name)
+	}
+}
@@ -13,19 +13,27 @@ var newAuthorName string
 var bookOlid string
 var newTitle string
 
+var newCallNumber string
+
 func init() {
-	authorCmd.PersistentFlags().StringVarP(&oldAuthorName, "old", "o", "", "previous name")
-	authorCmd.PersistentFlags().StringVarP(&newAuthorName, "new", "n", "", "new name")
-	authorCmd.MarkPersistentFlagRequired("old")
-	authorCmd.MarkPersistentFlagRequired("new")
+	updateAuthorCmd.PersistentFlags().StringVarP(&oldAuthorName, "old", "o", "", "previous name")
+	updateAuthorCmd.PersistentFlags().StringVarP(&newAuthorName, "new", "n", "", "new name")
+	updateAuthorCmd.MarkPersistentFlagRequired("old")
+	updateAuthorCmd.MarkPersistentFlagRequired("new")
+
+	updateTitleCmd.PersistentFlags().StringVarP(&bookOlid, "olid", "o", "", "previous name")
+	updateTitleCmd.PersistentFlags().StringVarP(&newTitle, "title", "t", "", "new title")
+	updateTitleCmd.MarkPersistentFlagRequired("olid")
+	updateTitleCmd.MarkPersistentFlagRequired("title")
 
-	titleCmd.PersistentFlags().StringVarP(&bookOlid, "olid", "o", "", "previous name")
-	titleCmd.PersistentFlags().StringVarP(&newTitle, "title", "t", "", "new title")
-	titleCmd.MarkPersistentFlagRequired("olid")
-	titleCmd.MarkPersistentFlagRequired("title")
+	updateCallNumberCmd.PersistentFlags().StringVarP(&bookOlid, "olid", "o", "", "openlibrary id of the book")
+	updateCallNumberCmd.PersistentFlags().StringVarP(&newCallNumber, "call-number", "c", "", "local call number; empty to clear it")
+	updateCallNumberCmd.MarkPersistentFlagRequired("olid")
+	updateCallNumberCmd.MarkPersistentFlagRequired("call-number")
 
-	updateCmd.AddCommand(authorCmd)
-	updateCmd.AddCommand(titleCmd)
+	updateCmd.AddCommand(updateAuthorCmd)
+	updateCmd.AddCommand(updateTitleCmd)
+	updateCmd.AddCommand(updateCallNumberCmd)
 
 	rootCmd.AddCommand(updateCmd)
 }
@@ -35,7 +43,7 @@ var updateCmd = &cobra.Command{
 	Short: "update individual records in the database",
 }
 
-var authorCmd = &cobra.Command{
+var updateAuthorCmd = &cobra.Command{
 	Use:   "author",
 	Short: "update individual author records in the database",
 	Run: func(cmd *cobra.Command, args []string) {
@@ -50,7 +58,7 @@ func runUpdateAuthor() {
 	log.Printf("Updated %d rows!\n", rows)
 }
 
-var titleCmd = &cobra.Command{
+var updateTitleCmd = &cobra.Command{
 	Use:   "title",
 	Short: "update individual book title records in the database",
 	Run: func(cmd *cobra.Command, args []string) {
@@ -64,3 +72,18 @@ func runUpdateTitle() {
 
 	log.Printf("Updated %d rows!\n", rows)
 }
+
+var updateCallNumberCmd = &cobra.Command{
+	Use:   "call-number",
+	Short: "set a local call number for a book, overriding its openlibrary classification",
+	Run: func(cmd *cobra.Command, args []string) {
+		runUpdateCallNumber()
+	},
+}
+
+func runUpdateCallNumber() {
+	rows, err := database.UpdateCallNumber(openlibrary.Book{OLID: bookOlid}, newCallNumber)
+	cobra.CheckErr(err)
+
+	log.Printf("Updated %d rows!\n", rows)
+}
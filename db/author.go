@@ -0,0 +1,411 @@
+package db
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/arudzitis/addlib/openlibrary"
+
+	"gorm.io/gorm"
+)
+
+const (
+	authorKeyPrefix        = "/authors/"
+	alternateNameSeparator = "\n"
+)
+
+// nameParticles are lowercase words which belong with the surname that follows them.
+var nameParticles = map[string]bool{
+	"da": true, "de": true, "del": true, "della": true, "der": true, "di": true, "du": true,
+	"la": true, "le": true, "van": true, "von": true, "ten": true, "ter": true,
+}
+
+// nameSuffixes follow a surname rather than forming part of it.
+var nameSuffixes = map[string]bool{
+	"jr": true, "sr": true, "ii": true, "iii": true, "iv": true, "phd": true,
+}
+
+// SortName is the name the author is filed under: the override if one has been set, otherwise a
+// "Last, First" form derived from their name.
+func (a Author) SortName() string {
+	if a.SortNameOverride != nil && *a.SortNameOverride != "" {
+		return *a.SortNameOverride
+	}
+	return deriveSortName(a.Name)
+}
+
+// GetAlternateNames returns the other names openlibrary knows the author by.
+func (a Author) GetAlternateNames() []string {
+	if a.AlternateNames == nil || *a.AlternateNames == "" {
+		return nil
+	}
+	return strings.Split(*a.AlternateNames, alternateNameSeparator)
+}
+
+// deriveSortName turns "Ursula K. Le Guin" into "Le Guin, Ursula K." and "Martin Luther King Jr."
+// into "King, Martin Luther, Jr.". Names which already contain a comma, or are a single word, are
+// left alone.
+func deriveSortName(name string) string {
+	name = strings.TrimSpace(name)
+	if strings.Contains(name, ",") {
+		return name
+	}
+
+	words := strings.Fields(name)
+	suffixes := []string{}
+	for len(words) > 1 && nameSuffixes[strings.ToLower(strings.Trim(words[len(words)-1], "."))] {
+		suffixes = append([]string{words[len(words)-1]}, suffixes...)
+		words = words[:len(words)-1]
+	}
+	if len(words) < 2 {
+		return name
+	}
+
+	surnameStart := len(words) - 1
+	for surnameStart > 1 && isNameParticle(words[surnameStart-1]) {
+		surnameStart--
+	}
+
+	sortName := strings.Join(words[surnameStart:], " ") + ", " + strings.Join(words[:surnameStart], " ")
+	if len(suffixes) > 0 {
+		sortName += ", " + strings.Join(suffixes, " ")
+	}
+	return sortName
+}
+
+func isNameParticle(word string) bool {
+	if nameParticles[strings.ToLower(word)] {
+		// particles are usually lowercase, but some surnames such as "Le Guin" keep them capitalised
+		return unicode.IsLower([]rune(word)[0]) || strings.EqualFold(word, "le")
+	}
+	return false
+}
+
+// FindAuthor looks up an author by openlibrary id, with or without the "/authors/" prefix.
+func (d DB) FindAuthor(ref string) (*Author, error) {
+	candidates := []string{ref}
+	if !strings.HasPrefix(ref, "/") && !openlibrary.IsLocalAuthorKey(ref) {
+		candidates = append(candidates, authorKeyPrefix+ref)
+	}
+	for _, olid := range candidates {
+		author, err := readAuthorTx(d.db, olid)
+		if err != nil {
+			return nil, fmt.Errorf("db: error reading author: %w", err)
+		}
+		if author != nil {
+			return author, nil
+		}
+	}
+	return nil, fmt.Errorf("db: no author matching %q: %w", ref, ErrNotFound)
+}
+
+// UpdateAuthorSortName overrides the sort name of the author with the given openlibrary id. An empty
+// sort name restores the derived one.
+func (d DB) UpdateAuthorSortName(olid string, sortName string) (int64, error) {
+	rows, err := d.updateColumn(EntityAuthor, olid, "sort_name", optional(sortName))
+	if err != nil {
+		return 0, fmt.Errorf("db: error updating author sort name: %w", err)
+	}
+	return rows, nil
+}
+
+// AuthorChanges are changes made to several fields of an author at once. Fields left nil are not
+// changed; an empty sort name restores the derived one.
+type AuthorChanges struct {
+	Name     *string
+	SortName *string
+}
+
+// UpdateAuthor makes the changes to the author with the given openlibrary id in a single
+// transaction, so that either all of them are made or none.
+func (d DB) UpdateAuthor(olid string, changes AuthorChanges) error {
+	err := d.db.Transaction(func(tx *gorm.DB) error {
+		if changes.Name != nil {
+			_, err := d.updateColumnTx(tx, EntityAuthor, olid, "name", *changes.Name)
+			if err != nil {
+				return err
+			}
+		}
+		if changes.SortName != nil {
+			_, err := d.updateColumnTx(tx, EntityAuthor, olid, "sort_name", optional(*changes.SortName))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("db: error updating author: %w", err)
+	}
+	return nil
+}
+
+func newAuthor(author openlibrary.Author) *Author {
+	return &Author{
+		OLID:           author.OLID,
+		Name:           author.Name,
+		PersonalName:   optional(author.PersonalName),
+		AlternateNames: optional(strings.Join(author.AlternateNames, alternateNameSeparator)),
+		BirthDate:      optional(author.BirthDate),
+		DeathDate:      optional(author.DeathDate),
+		Bio:            optional(string(author.Bio)),
+		WikidataID:     optional(author.RemoteIDs.Wikidata),
+		VIAFID:         optional(author.RemoteIDs.VIAF),
+	}
+}
+
+// fillAuthorTx fills in the details of a saved author which are still empty from its openlibrary
+// record, leaving details already saved, or corrected locally, alone. Each detail filled in is
+// recorded in the change log. It returns 1 if the author was updated.
+func (d DB) fillAuthorTx(tx *gorm.DB, existing *Author, author openlibrary.Author) (int64, error) {
+	details := newAuthor(author)
+	var rows int64
+	for _, field := range []struct {
+		column         string
+		saved, fetched *string
+	}{
+		{"personal_name", existing.PersonalName, details.PersonalName},
+		{"alternate_names", existing.AlternateNames, details.AlternateNames},
+		{"birth_date", existing.BirthDate, details.BirthDate},
+		{"death_date", existing.DeathDate, details.DeathDate},
+		{"bio", existing.Bio, details.Bio},
+		{"wikidata_id", existing.WikidataID, details.WikidataID},
+		{"viaf_id", existing.VIAFID, details.VIAFID},
+	} {
+		if field.saved != nil || field.fetched == nil {
+			continue
+		}
+		updated, err := d.updateColumnTx(tx, EntityAuthor, existing.OLID, field.column, *field.fetched)
+		if err != nil {
+			return 0, fmt.Errorf("db: error updating author details: %w", err)
+		}
+		if updated > 0 {
+			rows = 1
+		}
+	}
+	return rows, nil
+}
+
+// FillAuthorDetails fills in the details of the saved authors which are still empty from their
+// openlibrary records, returning the number of authors updated. Authors not saved are skipped.
+func (d DB) FillAuthorDetails(authors []openlibrary.Author) (int64, error) {
+	var rows int64
+	err := d.db.Transaction(func(tx *gorm.DB) error {
+		for _, author := range authors {
+			existing, err := readAuthorTx(tx, author.OLID)
+			if err != nil {
+				return err
+			}
+			if existing == nil {
+				continue
+			}
+
+			updated, err := d.fillAuthorTx(tx, existing, author)
+			if err != nil {
+				return err
+			}
+			rows += updated
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("db: error filling in author details: %w", err)
+	}
+	return rows, nil
+}
+
+// optional maps empty strings onto NULL.
+func optional(value string) *string {
+	if value == "" {
+		return nil
+	}
+	return &value
+}
+
+// AuthorSummary is an author along with the number of books crediting them.
+type AuthorSummary struct {
+	Author
+	Books int64
+}
+
+// AuthorFilter selects the authors listed by FindAuthors.
+type AuthorFilter struct {
+	// Query matches authors whose name contains this text.
+	Query string
+
+	// Held matches only authors credited with a book still held.
+	Held bool
+
+	// Limit, if positive, returns at most this many of the matching authors, after skipping Offset
+	// of them. CountAuthors ignores both.
+	Limit  int
+	Offset int
+}
+
+// Authors returns every author with their number of books still held, ordered by sort name.
+func (d DB) Authors() ([]AuthorSummary, error) {
+	return d.FindAuthors(AuthorFilter{})
+}
+
+// FindAuthors returns the authors matching a filter with their number of books still held, ordered
+// by sort name. Sort names are derived outside the database, so the authors on the page asked for
+// are chosen from the names of every matching author before the page itself is read.
+func (d DB) FindAuthors(filter AuthorFilter) ([]AuthorSummary, error) {
+	names := []Author{}
+	tx := d.authorQuery(filter).Select("id", "name", "sort_name").Find(&names)
+	if tx.Error != nil {
+		return nil, fmt.Errorf("db: error reading authors: %w", tx.Error)
+	}
+	sort.SliceStable(names, func(i, j int) bool {
+		return strings.ToLower(names[i].SortName()) < strings.ToLower(names[j].SortName())
+	})
+
+	if filter.Limit > 0 {
+		start, end := filter.Offset, filter.Offset+filter.Limit
+		if start > len(names) {
+			start = len(names)
+		}
+		if end > len(names) {
+			end = len(names)
+		}
+		names = names[start:end]
+	}
+	position := map[int64]int{}
+	ids := []int64{}
+	for i, author := range names {
+		position[author.ID] = i
+		ids = append(ids, author.ID)
+	}
+
+	query := d.authorQuery(filter)
+	if filter.Limit > 0 {
+		query = query.Where("authors.id IN ?", ids)
+	}
+	summaries := []AuthorSummary{}
+	tx = query.
+		Select("authors.*, COUNT(book_authors.book_id) AS books").
+		Joins("LEFT JOIN book_authors ON book_authors.author_id = authors.id AND book_authors.book_id IN (" + heldBooksQuery + ")").
+		Group("authors.id").
+		Scan(&summaries)
+	if tx.Error != nil {
+		return nil, fmt.Errorf("db: error reading authors: %w", tx.Error)
+	}
+
+	sort.Slice(summaries, func(i, j int) bool {
+		return position[summaries[i].ID] < position[summaries[j].ID]
+	})
+	return summaries, nil
+}
+
+// CountAuthors counts the authors matching a filter, ignoring its Limit and Offset.
+func (d DB) CountAuthors(filter AuthorFilter) (int64, error) {
+	var count int64
+	tx := d.authorQuery(filter).Count(&count)
+	if tx.Error != nil {
+		return 0, fmt.Errorf("db: error counting authors: %w", tx.Error)
+	}
+	return count, nil
+}
+
+// authorQuery selects the authors matching a filter.
+func (d DB) authorQuery(filter AuthorFilter) *gorm.DB {
+	query := d.db.Model(&Author{})
+	if filter.Query != "" {
+		query = query.Where(`authors.name LIKE ? ESCAPE '\'`, "%"+escapeLike(filter.Query)+"%")
+	}
+	if filter.Held {
+		query = query.Where("authors.id IN (SELECT author_id FROM book_authors WHERE book_id IN (" + heldBooksQuery + "))")
+	}
+	return query
+}
+
+// RenameAuthor changes the name of the single author with the given openlibrary id.
+func (d DB) RenameAuthor(olid string, name string) (int64, error) {
+	rows, err := d.updateColumn(EntityAuthor, olid, "name", name)
+	if err != nil {
+		return 0, fmt.Errorf("db: error renaming author: %w", err)
+	}
+	return rows, nil
+}
+
+// MergeAuthors folds duplicate author records into a surviving record: every book crediting a
+// duplicate is credited to the survivor instead, and the duplicates are removed. It returns the
+// number of books re-credited.
+func (d DB) MergeAuthors(survivorOLID string, duplicateOLIDs []string) (int64, error) {
+	var moved int64
+	err := d.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		moved, err = d.mergeAuthorsTx(tx, survivorOLID, duplicateOLIDs)
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("db: error merging authors: %w", err)
+	}
+	return moved, nil
+}
+
+func (d DB) mergeAuthorsTx(tx *gorm.DB, survivorOLID string, duplicateOLIDs []string) (int64, error) {
+	survivor, err := readAuthorTx(tx, survivorOLID)
+	if err != nil {
+		return 0, err
+	}
+	if survivor == nil {
+		return 0, fmt.Errorf("no author with olid %s: %w", survivorOLID, ErrNotFound)
+	}
+
+	var moved int64
+	for _, duplicateOLID := range duplicateOLIDs {
+		if duplicateOLID == survivorOLID {
+			continue
+		}
+
+		duplicate, err := readAuthorTx(tx, duplicateOLID)
+		if err != nil {
+			return 0, err
+		}
+		if duplicate == nil {
+			return 0, fmt.Errorf("no author with olid %s: %w", duplicateOLID, ErrNotFound)
+		}
+
+		credits := []BookAuthor{}
+		err = tx.Where("author_id = ?", duplicate.ID).Find(&credits).Error
+		if err != nil {
+			return 0, err
+		}
+
+		for _, credit := range credits {
+			var existing int64
+			err = tx.Model(&BookAuthor{}).Where("book_id = ? AND author_id = ?", credit.BookID, survivor.ID).Count(&existing).Error
+			if err != nil {
+				return 0, err
+			}
+
+			if existing > 0 {
+				// the survivor is already credited with this book, so the duplicate credit goes
+				err = tx.Where("book_id = ? AND author_id = ?", credit.BookID, duplicate.ID).Delete(&BookAuthor{}).Error
+			} else {
+				err = tx.Model(&BookAuthor{}).
+					Where("book_id = ? AND author_id = ?", credit.BookID, duplicate.ID).
+					Update("author_id", survivor.ID).Error
+				moved++
+			}
+			if err != nil {
+				return 0, err
+			}
+		}
+
+		err = tx.Delete(&Author{}, duplicate.ID).Error
+		if err != nil {
+			return 0, err
+		}
+
+		err = d.recordChangeTx(tx, Change{Action: ActionMerge, Entity: EntityAuthor, EntityKey: duplicateOLID, NewValue: &survivorOLID})
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return moved, nil
+}
@@ -0,0 +1,219 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/arudzitis/addlib/openlibrary"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeriveSortName(t *testing.T) {
+	testCases := map[string]string{
+		"J. R. R. Tolkien":       "Tolkien, J. R. R.",
+		"Ursula K. Le Guin":      "Le Guin, Ursula K.",
+		"Ludwig van Beethoven":   "van Beethoven, Ludwig",
+		"Martin Luther King Jr.": "King, Martin Luther, Jr.",
+		"Tolkien, J. R. R.":      "Tolkien, J. R. R.",
+		"Homer":                  "Homer",
+		"":                       "",
+	}
+
+	for name, expected := range testCases {
+		assert.Equal(t, expected, deriveSortName(name), name)
+	}
+}
+
+func TestAuthorDetails(t *testing.T) {
+	author := openlibrary.Author{
+		OLID:           "/authors/OL1A",
+		Name:           "Ursula K. Le Guin",
+		PersonalName:   "Ursula Kroeber Le Guin",
+		AlternateNames: []string{"Ursula Le Guin", "U. K. Le Guin"},
+		BirthDate:      "21 October 1929",
+		Bio:            "American author.",
+		RemoteIDs:      openlibrary.RemoteIDs{Wikidata: "Q181659"},
+	}
+
+	db := openTestDatabase(t)
+	defer db.Close()
+
+	err := db.InsertRecord(openlibrary.Book{OLID: "olid-booka", Title: "Book A", Authors: []openlibrary.Author{author}})
+	require.NoError(t, err)
+	err = db.InsertRecord(openlibrary.Book{OLID: "olid-bookb", Title: "Book B"})
+	require.NoError(t, err)
+
+	found, err := db.FindAuthor("OL1A")
+	require.NoError(t, err)
+	assert.Equal(t, "Ursula Kroeber Le Guin", *found.PersonalName)
+	assert.Equal(t, []string{"Ursula Le Guin", "U. K. Le Guin"}, found.GetAlternateNames())
+	assert.Equal(t, "21 October 1929", *found.BirthDate)
+	assert.Nil(t, found.DeathDate)
+	assert.Equal(t, "Q181659", *found.WikidataID)
+	assert.Nil(t, found.VIAFID)
+	assert.Equal(t, "Le Guin, Ursula K.", found.SortName())
+
+	_, err = db.FindAuthor("OL2A")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	books, err := db.FindBooks(BookFilter{AuthorID: &found.ID})
+	require.NoError(t, err)
+	require.Len(t, books, 1)
+	assert.Equal(t, "Book A", books[0].Title)
+
+	_, err = db.UpdateAuthorSortName(author.OLID, "LeGuin, Ursula")
+	require.NoError(t, err)
+	found, err = db.FindAuthor(author.OLID)
+	require.NoError(t, err)
+	assert.Equal(t, "LeGuin, Ursula", found.SortName())
+
+	_, err = db.UpdateAuthorSortName(author.OLID, "")
+	require.NoError(t, err)
+	found, err = db.FindAuthor(author.OLID)
+	require.NoError(t, err)
+	assert.Equal(t, "Le Guin, Ursula K.", found.SortName())
+}
+
+func TestFillAuthorDetails(t *testing.T) {
+	author := openlibrary.Author{OLID: "/authors/OL1A", Name: "Ursula K. Le Guin"}
+
+	db := openTestDatabase(t)
+	defer db.Close()
+
+	err := db.InsertRecord(openlibrary.Book{OLID: "olid-booka", Title: "Book A", Authors: []openlibrary.Author{author}})
+	require.NoError(t, err)
+
+	// a re-import of the same book, now that openlibrary knows when she was born
+	author.BirthDate = "21 October 1929"
+	err = db.InsertRecord(openlibrary.Book{OLID: "olid-booka", Title: "Book A", Authors: []openlibrary.Author{author}})
+	require.NoError(t, err)
+	found, err := db.FindAuthor(author.OLID)
+	require.NoError(t, err)
+	assert.Equal(t, "21 October 1929", *found.BirthDate)
+
+	// an import of another of her books
+	author.DeathDate = "22 January 2018"
+	author.BirthDate = "1929"
+	err = db.InsertRecord(openlibrary.Book{OLID: "olid-bookb", Title: "Book B", Authors: []openlibrary.Author{author}})
+	require.NoError(t, err)
+	found, err = db.FindAuthor(author.OLID)
+	require.NoError(t, err)
+	assert.Equal(t, "22 January 2018", *found.DeathDate)
+	assert.Equal(t, "21 October 1929", *found.BirthDate, "details already saved are kept")
+
+	// a refresh of the author
+	author.RemoteIDs = openlibrary.RemoteIDs{Wikidata: "Q181659"}
+	rows, err := db.FillAuthorDetails([]openlibrary.Author{author, {OLID: "/authors/OL9A", Name: "Unknown"}})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), rows)
+	found, err = db.FindAuthor(author.OLID)
+	require.NoError(t, err)
+	assert.Equal(t, "Q181659", *found.WikidataID)
+
+	// each detail filled in is recorded, and can be undone
+	changes, err := db.History(author.OLID)
+	require.NoError(t, err)
+	filled := []string{}
+	for _, change := range changes {
+		if change.Action == ActionUpdate {
+			filled = append(filled, change.Field+"="+strValue(change.NewValue))
+		}
+	}
+	assert.Equal(t, []string{"birth_date=21 October 1929", "death_date=22 January 2018", "wikidata_id=Q181659"}, filled)
+	require.NoError(t, db.Undo(changes[len(changes)-1].ID))
+	found, err = db.FindAuthor(author.OLID)
+	require.NoError(t, err)
+	assert.Nil(t, found.WikidataID)
+
+	rows, err = db.FillAuthorDetails([]openlibrary.Author{author})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), rows, "an undone detail is filled in again")
+	rows, err = db.FillAuthorDetails([]openlibrary.Author{author})
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), rows)
+}
+
+func TestFindAuthorsPage(t *testing.T) {
+	db := openTestDatabase(t)
+	defer db.Close()
+
+	authors := []openlibrary.Author{
+		{OLID: "/authors/OL1A", Name: "Ursula K. Le Guin"},
+		{OLID: "/authors/OL2A", Name: "Iain M. Banks"},
+		{OLID: "/authors/OL3A", Name: "Iain Banks"},
+		{OLID: "/authors/OL4A", Name: "Ann Leckie"},
+	}
+	require.NoError(t, db.InsertRecord(openlibrary.Book{OLID: "olid-booka", Title: "Book A", Authors: authors}))
+	require.NoError(t, db.InsertRecord(openlibrary.Book{OLID: "olid-bookb", Title: "Book B", Authors: authors[:1]}))
+	_, err := db.DeaccessionBook(openlibrary.Book{OLID: "olid-booka"}, Deaccession{Reason: DeaccessionLost})
+	require.NoError(t, err)
+
+	filter := AuthorFilter{Query: "an", Limit: 2, Offset: 1}
+	count, err := db.CountAuthors(filter)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), count)
+
+	found, err := db.FindAuthors(filter)
+	require.NoError(t, err)
+	require.Len(t, found, 2)
+	assert.Equal(t, "Banks, Iain M.", found[0].SortName())
+	assert.Equal(t, "Leckie, Ann", found[1].SortName())
+
+	count, err = db.CountAuthors(AuthorFilter{Query: "Iain_"})
+	require.NoError(t, err)
+	assert.Zero(t, count, "wildcards only match themselves")
+
+	found, err = db.FindAuthors(AuthorFilter{Held: true})
+	require.NoError(t, err)
+	require.Len(t, found, 1)
+	assert.Equal(t, "Ursula K. Le Guin", found[0].Name)
+	assert.Equal(t, int64(1), found[0].Books)
+}
+
+func TestRenameAndMergeAuthors(t *testing.T) {
+	tolkien := openlibrary.Author{OLID: "/authors/OL1A", Name: "J.R.R. Tolkien"}
+	duplicate := openlibrary.Author{OLID: "/authors/OL2A", Name: "J. R. R. Tolkien"}
+	namesake := openlibrary.Author{OLID: "/authors/OL3A", Name: "J.R.R. Tolkien"}
+	illustrator := openlibrary.Author{OLID: "/authors/OL4A", Name: "Alan Lee", Role: openlibrary.RoleIllustrator}
+
+	db := openTestDatabase(t)
+	defer db.Close()
+
+	books := []openlibrary.Book{
+		{OLID: "olid-booka", Title: "Book A", Authors: []openlibrary.Author{tolkien}},
+		{OLID: "olid-bookb", Title: "Book B", Authors: []openlibrary.Author{duplicate, illustrator}},
+		{OLID: "olid-bookc", Title: "Book C", Authors: []openlibrary.Author{tolkien, duplicate}},
+		{OLID: "olid-bookd", Title: "Book D", Authors: []openlibrary.Author{namesake}},
+	}
+	for _, book := range books {
+		require.NoError(t, db.InsertRecord(book))
+	}
+
+	rows, err := db.RenameAuthor(namesake.OLID, "John Ronald Tolkien")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), rows, "only the author with the olid is renamed")
+
+	moved, err := db.MergeAuthors(tolkien.OLID, []string{duplicate.OLID})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), moved)
+
+	_, err = db.FindAuthor(duplicate.OLID)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	_, err = db.MergeAuthors(tolkien.OLID, []string{"/authors/OL9A"})
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	summaries, err := db.Authors()
+	require.NoError(t, err)
+	require.Len(t, summaries, 3)
+	assert.Equal(t, "Lee, Alan", summaries[0].SortName())
+	assert.Equal(t, "J.R.R. Tolkien", summaries[1].Name)
+	assert.Equal(t, int64(3), summaries[1].Books)
+	assert.Equal(t, "John Ronald Tolkien", summaries[2].Name)
+	assert.Equal(t, int64(1), summaries[2].Books)
+
+	found, err := db.AllBooks()
+	require.NoError(t, err)
+	assert.Equal(t, []openlibrary.Author{tolkien, illustrator}, found[1].Authors)
+	assert.Equal(t, []openlibrary.Author{tolkien}, found[2].Authors)
+}
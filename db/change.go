@@ -0,0 +1,433 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/arudzitis/addlib/openlibrary"
+
+	"gorm.io/gorm"
+)
+
+// Actions recorded in the change log.
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+	ActionMerge  = "merge"
+)
+
+// Entities recorded in the change log.
+const (
+	EntityBook       = "book"
+	EntityAuthor     = "author"
+	EntityCopy       = "copy"
+	EntityLocation   = "location"
+	EntityCollection = "collection"
+	EntityPatron     = "patron"
+	EntityLoan       = "loan"
+	EntitySetting    = "setting"
+	EntityHold       = "hold"
+	EntityTag        = "tag"
+	EntityPolicy     = "policy"
+	EntityStocktake  = "stocktake"
+	EntityCredit     = "credit"
+)
+
+// FieldTag records a book gaining a tag, as its new value, or losing one, as its old value.
+const FieldTag = "tag"
+
+// entity describes where the records of a kind of entity live.
+type entity struct {
+	model     func() interface{}
+	keyColumn string
+	// columns which may be changed through updateColumnTx, and so reverted by Undo
+	columns map[string]bool
+}
+
+var entities = map[string]entity{
+	EntityBook: {
+		model:     func() interface{} { return &Book{} },
+		keyColumn: "olid",
+		columns:   map[string]bool{"olid": true, "title": true, "call_number": true, "location_id": true, "publish_date": true, "languages": true},
+	},
+	EntityAuthor: {
+		model:     func() interface{} { return &Author{} },
+		keyColumn: "olid",
+		columns: map[string]bool{
+			"olid": true, "name": true, "sort_name": true, "personal_name": true, "alternate_names": true,
+			"birth_date": true, "death_date": true, "bio": true, "wikidata_id": true, "viaf_id": true,
+		},
+	},
+	EntityCopy: {
+		model:     func() interface{} { return &Copy{} },
+		keyColumn: "accession",
+		columns:   map[string]bool{"status": true, "location_id": true},
+	},
+	EntityLocation: {
+		model:     func() interface{} { return &Location{} },
+		keyColumn: "id",
+		columns:   map[string]bool{},
+	},
+	EntityCollection: {
+		model:     func() interface{} { return &Collection{} },
+		keyColumn: "id",
+		columns:   map[string]bool{"name": true},
+	},
+	EntityPatron: {
+		model:     func() interface{} { return &Patron{} },
+		keyColumn: "barcode",
+		columns:   map[string]bool{"name": true, "contact": true, "status": true},
+	},
+	EntitySetting: {
+		model:     func() interface{} { return &Setting{} },
+		keyColumn: "key",
+		columns:   map[string]bool{"value": true},
+	},
+	EntityPolicy: {
+		model:     func() interface{} { return &LoanPolicy{} },
+		keyColumn: "id",
+		columns:   map[string]bool{"name": true, "loan_days": true, "max_renewals": true, "max_items": true, "non_circulating": true},
+	},
+	// credits are keyed by their book and author ids, as in "12/34", which no single column holds
+	EntityCredit: {
+		model:   func() interface{} { return &BookAuthor{} },
+		columns: map[string]bool{},
+	},
+}
+
+// History returns the changes made to the book, author, copy or location with the given key, or
+// every change if the key is empty, oldest first.
+func (d DB) History(key string) ([]Change, error) {
+	query := d.db.Order("id")
+	if key != "" {
+		query = query.Where("entity_key IN ?", []string{key, bookKeyPrefix + key, authorKeyPrefix + key})
+	}
+
+	changes := []Change{}
+	tx := query.Find(&changes)
+	if tx.Error != nil {
+		return nil, fmt.Errorf("db: error reading history: %w", tx.Error)
+	}
+	return changes, nil
+}
+
+// Undo reverts a single change, recording the reversal as a new change. Changes to a field are only
+// undone while the field still holds the value the change gave it. Merges cannot be undone: the
+// merged record is removed, and what it brought to the survivor is not told apart from the
+// survivor's own.
+func (d DB) Undo(changeID int64) error {
+	change := Change{}
+	tx := d.db.Limit(1).Find(&change, changeID)
+	if tx.Error != nil {
+		return fmt.Errorf("db: error reading change: %w", tx.Error)
+	}
+	if tx.RowsAffected != 1 {
+		return fmt.Errorf("db: no change %d: %w", changeID, ErrNotFound)
+	}
+
+	switch {
+	case change.Action == ActionUpdate && change.Field == FieldTag:
+		book := []openlibrary.Book{{OLID: change.EntityKey}}
+		var err error
+		if change.NewValue != nil {
+			_, err = d.UntagBooks(*change.NewValue, book)
+		} else if change.OldValue != nil {
+			_, err = d.TagBooks(*change.OldValue, book)
+		}
+		return err
+
+	case change.Action == ActionUpdate && change.Field == FieldCollection:
+		return d.undoMembership(change)
+
+	case change.Action == ActionUpdate && change.Field == FieldPolicy:
+		return d.undoAttachment(change)
+
+	case change.Action == ActionUpdate:
+		return d.undoUpdate(change)
+
+	case change.Action == ActionCreate && change.Entity == EntityBook:
+		_, err := d.DeaccessionBook(openlibrary.Book{OLID: change.EntityKey}, Deaccession{
+			Reason: DeaccessionWithdrawn,
+			Note:   fmt.Sprintf("undo of change %d", change.ID),
+		})
+		return err
+
+	case change.Action == ActionDelete && change.Entity == EntityBook:
+		// deaccessions record their reason; books deleted outright are gone
+		if change.NewValue == nil {
+			return fmt.Errorf("db: book %s was deleted rather than deaccessioned, and cannot be restored", change.EntityKey)
+		}
+		_, err := d.RestoreBook(openlibrary.Book{OLID: change.EntityKey})
+		return err
+
+	case change.Action == ActionRestore && change.Entity == EntityBook:
+		_, err := d.DeaccessionBook(openlibrary.Book{OLID: change.EntityKey}, Deaccession{
+			Reason: strValue(change.OldValue),
+			Note:   fmt.Sprintf("undo of change %d", change.ID),
+		})
+		return err
+
+	case change.Action == ActionMerge:
+		return fmt.Errorf("db: merge of %s %s into %s cannot be undone, since the copies, credits and details it moved are not recorded; add %s again and move them back",
+			change.Entity, change.EntityKey, strValue(change.NewValue), change.EntityKey)
+
+	case change.Action == ActionDelete && change.Entity == EntityCredit:
+		return d.restoreCredit(change)
+
+	case change.Action == ActionCreate && change.Entity == EntityCopy:
+		_, err := d.RemoveCopy(change.EntityKey)
+		return err
+
+	case change.Action == ActionCreate && change.Entity == EntityLocation:
+		id, err := strconv.ParseInt(change.EntityKey, 10, 64)
+		if err != nil {
+			return fmt.Errorf("db: invalid location id %q: %w", change.EntityKey, err)
+		}
+		return d.RemoveLocation(Location{ID: id, Name: strValue(change.NewValue)})
+
+	// collections and policies are found by id, as they may since have been renamed, and others
+	// given their old names
+	case change.Action == ActionCreate && change.Entity == EntityCollection:
+		collection := Collection{}
+		err := readCreatedTx(d.db, change, &collection)
+		if err != nil {
+			return err
+		}
+		return d.DeleteCollection(collection)
+
+	case change.Action == ActionCreate && change.Entity == EntityPolicy:
+		policy := LoanPolicy{}
+		err := readCreatedTx(d.db, change, &policy)
+		if err != nil {
+			return err
+		}
+		return d.DeletePolicy(policy)
+	}
+
+	return fmt.Errorf("db: %s of %s %s cannot be undone", change.Action, change.Entity, change.EntityKey)
+}
+
+func (d DB) undoUpdate(change Change) error {
+	e, ok := entities[change.Entity]
+	if !ok || !e.columns[change.Field] {
+		return fmt.Errorf("db: changes to %s %s cannot be undone", change.Entity, change.Field)
+	}
+
+	// a change to the key itself is found under its new value
+	key := change.EntityKey
+	if change.Field == e.keyColumn && change.NewValue != nil {
+		key = *change.NewValue
+	}
+
+	return d.db.Transaction(func(tx *gorm.DB) error {
+		current, found, err := readColumnTx(tx, change.Entity, key, change.Field)
+		if err != nil {
+			return fmt.Errorf("db: error reading %s %s: %w", change.Entity, change.Field, err)
+		}
+		if !found {
+			return fmt.Errorf("db: no %s %s: %w", change.Entity, key, ErrNotFound)
+		}
+		if strValue(current) != strValue(change.NewValue) {
+			return fmt.Errorf("db: %s of %s %s has changed since change %d", change.Field, change.Entity, key, change.ID)
+		}
+
+		var value interface{}
+		if change.OldValue != nil {
+			value = *change.OldValue
+		}
+		_, err = d.updateColumnTx(tx, change.Entity, key, change.Field, value)
+		if err != nil || change.Entity != EntityCopy || change.Field != "status" {
+			return err
+		}
+
+		// a copy made available again is kept for the oldest hold waiting on its book
+		bookCopy := Copy{}
+		err = tx.Where("accession = ?", key).First(&bookCopy).Error
+		if err != nil {
+			return err
+		}
+		_, err = d.reserveCopyTx(tx, bookCopy)
+		return err
+	})
+}
+
+// readCreatedTx reads the record created by a change to an entity keyed by its id.
+func readCreatedTx(tx *gorm.DB, change Change, record interface{}) error {
+	id, err := strconv.ParseInt(change.EntityKey, 10, 64)
+	if err != nil {
+		return fmt.Errorf("db: invalid %s id %q: %w", change.Entity, change.EntityKey, err)
+	}
+	result := tx.Limit(1).Find(record, id)
+	if result.Error != nil {
+		return fmt.Errorf("db: error reading %s: %w", change.Entity, result.Error)
+	}
+	if result.RowsAffected != 1 {
+		return fmt.Errorf("db: no %s %d: %w", change.Entity, id, ErrNotFound)
+	}
+	return nil
+}
+
+// restoreCredit recreates a credit deleted by the given change, in its old role and position.
+func (d DB) restoreCredit(change Change) error {
+	var credit BookAuthor
+	_, err := fmt.Sscanf(change.EntityKey, "%d/%d", &credit.BookID, &credit.AuthorID)
+	if err != nil {
+		return fmt.Errorf("db: invalid credit %q: %w", change.EntityKey, err)
+	}
+	position, role, _ := strings.Cut(strValue(change.OldValue), " ")
+	credit.Position, err = strconv.Atoi(position)
+	if err != nil {
+		return fmt.Errorf("db: invalid position of credit %s: %w", change.EntityKey, err)
+	}
+	credit.Role = role
+
+	return d.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Create(&credit).Error
+		if err != nil {
+			return fmt.Errorf("db: error restoring credit %s: %w", change.EntityKey, err)
+		}
+		return d.recordChangeTx(tx, Change{Action: ActionCreate, Entity: EntityCredit, EntityKey: change.EntityKey, NewValue: change.OldValue})
+	})
+}
+
+// creditEntityKey identifies a credit in the change log.
+func creditEntityKey(bookID int64, authorID int64) string {
+	return fmt.Sprintf("%d/%d", bookID, authorID)
+}
+
+// updateColumn sets a column of the entity with the given key in its own transaction, recording the
+// change.
+func (d DB) updateColumn(entityName string, key string, column string, value interface{}) (int64, error) {
+	var rows int64
+	err := d.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		rows, err = d.updateColumnTx(tx, entityName, key, column, value)
+		return err
+	})
+	return rows, err
+}
+
+// updateColumnTx sets a column of the entity with the given key, recording the change.
+func (d DB) updateColumnTx(tx *gorm.DB, entityName string, key string, column string, value interface{}) (int64, error) {
+	e := entities[entityName]
+	if !e.columns[column] {
+		return 0, fmt.Errorf("db: %s %s cannot be updated", entityName, column)
+	}
+
+	old, found, err := readColumnTx(tx, entityName, key, column)
+	if err != nil {
+		return 0, err
+	}
+	if !found {
+		return 0, nil
+	}
+
+	result := tx.Model(e.model()).Where(e.keyColumn+" = ?", key).Update(column, value)
+	if result.Error != nil {
+		return 0, result.Error
+	}
+
+	newValue := stringValue(value)
+	if (old == nil) == (newValue == nil) && strValue(old) == strValue(newValue) {
+		return result.RowsAffected, nil
+	}
+
+	err = d.recordChangeTx(tx, Change{
+		Action:    ActionUpdate,
+		Entity:    entityName,
+		EntityKey: key,
+		Field:     column,
+		OldValue:  old,
+		NewValue:  newValue,
+	})
+	return result.RowsAffected, err
+}
+
+func readColumnTx(tx *gorm.DB, entityName string, key string, column string) (*string, bool, error) {
+	e := entities[entityName]
+
+	values := []sql.NullString{}
+	result := tx.Model(e.model()).Where(e.keyColumn+" = ?", key).Limit(1).Pluck(column, &values)
+	if result.Error != nil {
+		return nil, false, result.Error
+	}
+	if len(values) != 1 {
+		return nil, false, nil
+	}
+	if !values[0].Valid {
+		return nil, true, nil
+	}
+	return &values[0].String, true, nil
+}
+
+// recordChangeTx appends a change, made by the database's actor, to the change log.
+func (d DB) recordChangeTx(tx *gorm.DB, change Change) error {
+	change.ID = 0
+	change.Actor = d.actor
+	err := tx.Create(&change).Error
+	if err != nil {
+		return fmt.Errorf("db: error recording change: %w", err)
+	}
+	return nil
+}
+
+// stringValue renders a column value for the change log.
+func stringValue(value interface{}) *string {
+	var result string
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case *string:
+		if v == nil {
+			return nil
+		}
+		result = *v
+	case *int64:
+		if v == nil {
+			return nil
+		}
+		result = strconv.FormatInt(*v, 10)
+	case string:
+		result = v
+	case int64:
+		result = strconv.FormatInt(v, 10)
+	default:
+		result = fmt.Sprint(v)
+	}
+	return &result
+}
+
+func strValue(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
+
+// Describe summarises a change for display, e.g. `title: "Old" -> "New"`.
+func (c Change) Describe() string {
+	quote := func(value *string) string {
+		if value == nil {
+			return "(none)"
+		}
+		return strconv.Quote(*value)
+	}
+
+	switch c.Action {
+	case ActionUpdate:
+		return fmt.Sprintf("%s: %s -> %s", c.Field, quote(c.OldValue), quote(c.NewValue))
+	case ActionMerge:
+		return fmt.Sprintf("merged into %s", strValue(c.NewValue))
+	default:
+		values := []string{}
+		for _, value := range []*string{c.OldValue, c.NewValue} {
+			if value != nil {
+				values = append(values, *value)
+			}
+		}
+		return strings.Join(values, " ")
+	}
+}
@@ -0,0 +1,133 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/arudzitis/addlib/openlibrary"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistory(t *testing.T) {
+	authorA := openlibrary.Author{OLID: "olid-authora", Name: "Author A"}
+	book := openlibrary.Book{OLID: "olid-booka", Title: "Book A", Authors: []openlibrary.Author{authorA}}
+
+	db := openTestDatabase(t)
+	defer db.Close()
+	db.SetActor("tester")
+
+	require.NoError(t, db.InsertRecord(book))
+	_, err := db.UpdateTitle(book, "Book B")
+	require.NoError(t, err)
+	_, err = db.UpdateTitle(book, "Book B")
+	require.NoError(t, err)
+	_, err = db.UpdateAuthorName("Author A", "Author C")
+	require.NoError(t, err)
+	_, err = db.TagBooks("reference", []openlibrary.Book{book})
+	require.NoError(t, err)
+	_, err = db.DeaccessionBook(book, Deaccession{Reason: DeaccessionLost})
+	require.NoError(t, err)
+
+	changes, err := db.History("")
+	require.NoError(t, err)
+	require.Len(t, changes, 6, "updates which change nothing are not recorded")
+
+	for _, change := range changes {
+		assert.Equal(t, "tester", change.Actor)
+		assert.False(t, change.CreatedAt.IsZero())
+	}
+
+	assert.Equal(t, ActionCreate, changes[0].Action)
+	assert.Equal(t, EntityBook, changes[0].Entity)
+	assert.Equal(t, ActionCreate, changes[1].Action)
+	assert.Equal(t, EntityAuthor, changes[1].Entity)
+	assert.Equal(t, `title: "Book A" -> "Book B"`, changes[2].Describe())
+	assert.Equal(t, `name: "Author A" -> "Author C"`, changes[3].Describe())
+	assert.Equal(t, `tag: (none) -> "reference"`, changes[4].Describe())
+	assert.Equal(t, ActionDelete, changes[5].Action)
+	assert.Equal(t, "Book B lost", changes[5].Describe())
+
+	bookChanges, err := db.History("olid-booka")
+	require.NoError(t, err)
+	assert.Len(t, bookChanges, 4)
+}
+
+func TestUndo(t *testing.T) {
+	book := openlibrary.Book{OLID: "olid-booka", Title: "Book A"}
+
+	db := openTestDatabase(t)
+	defer db.Close()
+
+	require.NoError(t, db.InsertRecord(book))
+	_, err := db.UpdateTitle(book, "Book B")
+	require.NoError(t, err)
+	_, err = db.UpdateCallNumber(book, "REF 1")
+	require.NoError(t, err)
+	_, err = db.TagBooks("reference", []openlibrary.Book{book})
+	require.NoError(t, err)
+	require.NoError(t, db.RekeyBook(book.OLID, "olid-bookb"))
+
+	changes, err := db.History("")
+	require.NoError(t, err)
+	require.Len(t, changes, 5)
+
+	for _, change := range []Change{changes[4], changes[1], changes[2], changes[3]} {
+		require.NoError(t, db.Undo(change.ID), change.Describe())
+	}
+
+	books, err := db.FindBooks(BookFilter{})
+	require.NoError(t, err)
+	require.Len(t, books, 1)
+	assert.Equal(t, "olid-booka", books[0].OLID)
+	assert.Equal(t, "Book A", books[0].Title)
+	assert.Nil(t, books[0].CallNumber)
+	assert.Empty(t, books[0].Tags)
+
+	_, err = db.UpdateTitle(book, "Book C")
+	require.NoError(t, err)
+	assert.Error(t, db.Undo(changes[1].ID), "a field changed again since cannot be undone")
+
+	assert.ErrorIs(t, db.Undo(1000), ErrNotFound)
+
+	require.NoError(t, db.Undo(changes[0].ID))
+	books, err = db.FindBooks(BookFilter{})
+	require.NoError(t, err)
+	assert.Empty(t, books, "undoing a creation deaccessions the book")
+
+	changes, err = db.History("olid-booka")
+	require.NoError(t, err)
+	deaccession := changes[len(changes)-1]
+	require.Equal(t, ActionDelete, deaccession.Action)
+
+	require.NoError(t, db.Undo(deaccession.ID))
+	books, err = db.FindBooks(BookFilter{})
+	require.NoError(t, err)
+	assert.Len(t, books, 1, "undoing a deaccession restores the book")
+
+	changes, err = db.History("olid-booka")
+	require.NoError(t, err)
+	restore := changes[len(changes)-1]
+	require.Equal(t, ActionRestore, restore.Action)
+
+	require.NoError(t, db.Undo(restore.ID))
+	deaccessioned, err := db.FindBooks(BookFilter{IncludeDeaccessioned: true})
+	require.NoError(t, err)
+	require.Len(t, deaccessioned, 1)
+	assert.NotNil(t, deaccessioned[0].DeaccessionedOn, "undoing a restore deaccessions the book again")
+	assert.Equal(t, DeaccessionWithdrawn, deaccessioned[0].DeaccessionReason)
+
+	_, err = db.DeleteBook(book)
+	require.NoError(t, err)
+	changes, err = db.History("olid-booka")
+	require.NoError(t, err)
+	assert.Error(t, db.Undo(changes[len(changes)-1].ID), "a book deleted outright cannot be restored")
+
+	require.NoError(t, db.InsertRecord(openlibrary.Book{OLID: "olid-bookb", Title: "Book B"}))
+	require.NoError(t, db.InsertRecord(openlibrary.Book{OLID: "olid-bookc", Title: "Book C"}))
+	require.NoError(t, db.MergeBooks("olid-bookb", []string{"olid-bookc"}))
+	changes, err = db.History("olid-bookc")
+	require.NoError(t, err)
+	merge := changes[len(changes)-1]
+	require.Equal(t, ActionMerge, merge.Action)
+	assert.ErrorContains(t, db.Undo(merge.ID), "cannot be undone", "merges are refused")
+}
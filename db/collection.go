@@ -0,0 +1,319 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/arudzitis/addlib/openlibrary"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// FieldCollection records a book or copy joining a collection, as its new value, or leaving one, as
+// its old value.
+const FieldCollection = "collection"
+
+// collectionBooksQuery selects the ids of the books in a collection, directly or through a copy; it
+// takes the collection id twice.
+const collectionBooksQuery = "SELECT book_id FROM book_collections WHERE collection_id = ? " +
+	"UNION SELECT copies.book_id FROM copies JOIN copy_collections ON copy_collections.copy_id = copies.id WHERE copy_collections.collection_id = ?"
+
+// CollectionCount is a collection along with the number of books still held in it.
+type CollectionCount struct {
+	Collection
+	Books int64
+}
+
+// CreateCollection creates a new, empty collection.
+func (d DB) CreateCollection(name string) (*Collection, error) {
+	if name == "" {
+		return nil, fmt.Errorf("db: a collection needs a name")
+	}
+
+	collection := &Collection{Name: name}
+	err := d.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Create(collection).Error
+		if err != nil {
+			return err
+		}
+
+		return d.recordChangeTx(tx, Change{
+			Action:    ActionCreate,
+			Entity:    EntityCollection,
+			EntityKey: strconv.FormatInt(collection.ID, 10),
+			NewValue:  &collection.Name,
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("db: error creating collection: %w", err)
+	}
+	return collection, nil
+}
+
+// Collections returns every collection with its number of books, ordered by name.
+func (d DB) Collections() ([]CollectionCount, error) {
+	counts := []CollectionCount{}
+	tx := d.db.Model(&Collection{}).
+		Select("collections.*, (SELECT COUNT(*) FROM books WHERE deaccessioned_on IS NULL AND (" +
+			"id IN (SELECT book_id FROM book_collections WHERE collection_id = collections.id) OR " +
+			"id IN (SELECT copies.book_id FROM copies JOIN copy_collections ON copy_collections.copy_id = copies.id WHERE copy_collections.collection_id = collections.id))) AS books").
+		Order("collections.name").
+		Scan(&counts)
+	if tx.Error != nil {
+		return nil, fmt.Errorf("db: error reading collections: %w", tx.Error)
+	}
+	return counts, nil
+}
+
+// FindCollection looks up a collection by its name or numeric id.
+func (d DB) FindCollection(ref string) (*Collection, error) {
+	collection := Collection{}
+	tx := d.db.Where("name = ?", ref).Limit(1).Find(&collection)
+	if tx.Error != nil {
+		return nil, fmt.Errorf("db: error reading collection: %w", tx.Error)
+	}
+	if tx.RowsAffected == 1 {
+		return &collection, nil
+	}
+
+	if id, err := strconv.ParseInt(ref, 10, 64); err == nil {
+		tx = d.db.Limit(1).Find(&collection, id)
+		if tx.Error != nil {
+			return nil, fmt.Errorf("db: error reading collection: %w", tx.Error)
+		}
+		if tx.RowsAffected == 1 {
+			return &collection, nil
+		}
+	}
+
+	return nil, fmt.Errorf("db: no collection %q: %w", ref, ErrNotFound)
+}
+
+// RenameCollection gives a collection a new name.
+func (d DB) RenameCollection(collection Collection, name string) (int64, error) {
+	if name == "" {
+		return 0, fmt.Errorf("db: a collection needs a name")
+	}
+
+	rows, err := d.updateColumn(EntityCollection, strconv.FormatInt(collection.ID, 10), "name", name)
+	if err != nil {
+		return 0, fmt.Errorf("db: error renaming collection: %w", err)
+	}
+	return rows, nil
+}
+
+// DeleteCollection removes a collection. Its books and copies are kept, and stay in any other
+// collections they belong to.
+func (d DB) DeleteCollection(collection Collection) error {
+	err := d.db.Transaction(func(tx *gorm.DB) error {
+		for _, membership := range []interface{}{&BookCollection{}, &CopyCollection{}} {
+			err := tx.Where("collection_id = ?", collection.ID).Delete(membership).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		// a policy attached to the collection is detached first, as DetachPolicy would
+		policy, err := attachedPolicyTx(tx, PolicyScopeCollection, collection.ID)
+		if err != nil {
+			return err
+		}
+		if policy != nil {
+			err = tx.Where("scope = ? AND target_id = ?", PolicyScopeCollection, collection.ID).Delete(&PolicyAssignment{}).Error
+			if err != nil {
+				return err
+			}
+			err = d.recordChangeTx(tx, Change{Action: ActionUpdate, Entity: PolicyScopeCollection, EntityKey: strconv.FormatInt(collection.ID, 10), Field: FieldPolicy, OldValue: &policy.Name})
+			if err != nil {
+				return err
+			}
+		}
+
+		result := tx.Delete(&Collection{}, collection.ID)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return fmt.Errorf("no collection %q: %w", collection.Name, ErrNotFound)
+		}
+
+		return d.recordChangeTx(tx, Change{
+			Action:    ActionDelete,
+			Entity:    EntityCollection,
+			EntityKey: strconv.FormatInt(collection.ID, 10),
+			OldValue:  &collection.Name,
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("db: error deleting collection: %w", err)
+	}
+	return nil
+}
+
+// AddBooksToCollection puts each of the books in a collection, returning the number which were not
+// already in it.
+func (d DB) AddBooksToCollection(collection Collection, books []openlibrary.Book) (int64, error) {
+	var added int64
+	err := d.db.Transaction(func(tx *gorm.DB) error {
+		for _, book := range books {
+			ormBook, err := readBookTx(tx, book.OLID)
+			if err != nil {
+				return err
+			}
+			if ormBook == nil {
+				return fmt.Errorf("no book with olid %s: %w", book.OLID, ErrNotFound)
+			}
+
+			result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&BookCollection{BookID: ormBook.ID, CollectionID: collection.ID})
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				continue
+			}
+			added++
+
+			err = d.recordChangeTx(tx, Change{Action: ActionUpdate, Entity: EntityBook, EntityKey: book.OLID, Field: FieldCollection, NewValue: &collection.Name})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("db: error adding books to collection: %w", err)
+	}
+	return added, nil
+}
+
+// RemoveBooksFromCollection takes each of the books out of a collection, returning the number which
+// were in it. Copies of the books which were added to the collection individually stay in it.
+func (d DB) RemoveBooksFromCollection(collection Collection, books []openlibrary.Book) (int64, error) {
+	var removed int64
+	err := d.db.Transaction(func(tx *gorm.DB) error {
+		for _, book := range books {
+			result := tx.Where("collection_id = ? AND book_id IN (SELECT id FROM books WHERE olid = ?)", collection.ID, book.OLID).
+				Delete(&BookCollection{})
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				continue
+			}
+			removed++
+
+			err := d.recordChangeTx(tx, Change{Action: ActionUpdate, Entity: EntityBook, EntityKey: book.OLID, Field: FieldCollection, OldValue: &collection.Name})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("db: error removing books from collection: %w", err)
+	}
+	return removed, nil
+}
+
+// AddCopyToCollection puts the copy with the given accession number in a collection. It returns 0
+// if the copy is already in the collection, and ErrNotFound if there is no such copy.
+func (d DB) AddCopyToCollection(collection Collection, accession string) (int64, error) {
+	var rows int64
+	err := d.db.Transaction(func(tx *gorm.DB) error {
+		bookCopy, err := readCopyTx(tx, accession)
+		if err != nil {
+			return err
+		}
+
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&CopyCollection{CopyID: bookCopy.ID, CollectionID: collection.ID})
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		rows = result.RowsAffected
+
+		return d.recordChangeTx(tx, Change{Action: ActionUpdate, Entity: EntityCopy, EntityKey: accession, Field: FieldCollection, NewValue: &collection.Name})
+	})
+	if err != nil {
+		return 0, fmt.Errorf("db: error adding copy to collection: %w", err)
+	}
+	return rows, nil
+}
+
+// RemoveCopyFromCollection takes the copy with the given accession number out of a collection. It
+// returns 0 if the copy is not in the collection, and ErrNotFound if there is no such copy.
+func (d DB) RemoveCopyFromCollection(collection Collection, accession string) (int64, error) {
+	var rows int64
+	err := d.db.Transaction(func(tx *gorm.DB) error {
+		bookCopy, err := readCopyTx(tx, accession)
+		if err != nil {
+			return err
+		}
+
+		result := tx.Where("collection_id = ? AND copy_id = ?", collection.ID, bookCopy.ID).Delete(&CopyCollection{})
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		rows = result.RowsAffected
+
+		return d.recordChangeTx(tx, Change{Action: ActionUpdate, Entity: EntityCopy, EntityKey: accession, Field: FieldCollection, OldValue: &collection.Name})
+	})
+	if err != nil {
+		return 0, fmt.Errorf("db: error removing copy from collection: %w", err)
+	}
+	return rows, nil
+}
+
+func readCopyTx(tx *gorm.DB, accession string) (*Copy, error) {
+	bookCopy := Copy{}
+	result := tx.Where("accession = ?", accession).Limit(1).Find(&bookCopy)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, fmt.Errorf("no copy %q: %w", accession, ErrNotFound)
+	}
+	return &bookCopy, nil
+}
+
+// InCollection reports whether a book is in a collection, directly or through any of its copies.
+func (d DB) InCollection(collection Collection, book openlibrary.Book) (bool, error) {
+	var count int64
+	tx := d.db.Model(&Book{}).
+		Where("olid = ?", book.OLID).
+		Where("id IN ("+collectionBooksQuery+")", collection.ID, collection.ID).
+		Count(&count)
+	if tx.Error != nil {
+		return false, fmt.Errorf("db: error reading collection: %w", tx.Error)
+	}
+	return count > 0, nil
+}
+
+// undoMembership reverses a book or copy joining or leaving a collection.
+func (d DB) undoMembership(change Change) error {
+	name := change.NewValue
+	if name == nil {
+		name = change.OldValue
+	}
+	collection, err := d.FindCollection(strValue(name))
+	if errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("db: collection %q no longer exists: %w", strValue(name), err)
+	}
+	if err != nil {
+		return err
+	}
+
+	joined := change.NewValue != nil
+	switch {
+	case change.Entity == EntityBook && joined:
+		_, err = d.RemoveBooksFromCollection(*collection, []openlibrary.Book{{OLID: change.EntityKey}})
+	case change.Entity == EntityBook:
+		_, err = d.AddBooksToCollection(*collection, []openlibrary.Book{{OLID: change.EntityKey}})
+	case joined:
+		_, err = d.RemoveCopyFromCollection(*collection, change.EntityKey)
+	default:
+		_, err = d.AddCopyToCollection(*collection, change.EntityKey)
+	}
+	return err
+}
@@ -0,0 +1,141 @@
+package db
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/arudzitis/addlib/openlibrary"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollections(t *testing.T) {
+	bookA := openlibrary.Book{OLID: "olid-booka", Title: "Book A"}
+	bookB := openlibrary.Book{OLID: "olid-bookb", Title: "Book B"}
+	bookC := openlibrary.Book{OLID: "olid-bookc", Title: "Book C"}
+
+	db := openTestDatabase(t)
+	defer db.Close()
+
+	for _, book := range []openlibrary.Book{bookA, bookB, bookC} {
+		require.NoError(t, db.InsertRecord(book))
+	}
+
+	office, err := db.CreateCollection("Office")
+	require.NoError(t, err)
+	shelf, err := db.CreateCollection("Loan shelf")
+	require.NoError(t, err)
+
+	_, err = db.CreateCollection("Office")
+	assert.Error(t, err, "collection names must be unique")
+
+	rows, err := db.AddBooksToCollection(*office, []openlibrary.Book{bookA, bookB})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), rows)
+
+	rows, err = db.AddBooksToCollection(*office, []openlibrary.Book{bookA})
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), rows)
+
+	bookCopy, err := db.AddCopy(bookC, Copy{})
+	require.NoError(t, err)
+	rows, err = db.AddCopyToCollection(*shelf, bookCopy.Accession)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), rows)
+	_, err = db.AddBooksToCollection(*shelf, []openlibrary.Book{bookB})
+	require.NoError(t, err)
+
+	titles := func(collection Collection) []string {
+		books, err := db.FindBooks(BookFilter{CollectionID: &collection.ID})
+		require.NoError(t, err)
+		result := []string{}
+		for _, book := range books {
+			result = append(result, book.Title)
+		}
+		return result
+	}
+
+	assert.Equal(t, []string{"Book A", "Book B"}, titles(*office))
+	assert.Equal(t, []string{"Book B", "Book C"}, titles(*shelf))
+
+	inShelf, err := db.InCollection(*shelf, bookC)
+	require.NoError(t, err)
+	assert.True(t, inShelf, "a book is in a collection through its copies")
+	inOffice, err := db.InCollection(*office, bookC)
+	require.NoError(t, err)
+	assert.False(t, inOffice)
+
+	_, err = db.RenameCollection(*office, "Office library")
+	require.NoError(t, err)
+	found, err := db.FindCollection("Office library")
+	require.NoError(t, err)
+	assert.Equal(t, office.ID, found.ID)
+	_, err = db.FindCollection("Office")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	collections, err := db.Collections()
+	require.NoError(t, err)
+	require.Len(t, collections, 2)
+	assert.Equal(t, "Loan shelf", collections[0].Name)
+	assert.Equal(t, int64(2), collections[0].Books)
+	assert.Equal(t, "Office library", collections[1].Name)
+	assert.Equal(t, int64(2), collections[1].Books)
+
+	// merging keeps the duplicate's collections
+	require.NoError(t, db.MergeBooks(bookC.OLID, []string{bookA.OLID}))
+	assert.Equal(t, []string{"Book B", "Book C"}, titles(*found))
+
+	rows, err = db.RemoveBooksFromCollection(*shelf, []openlibrary.Book{bookB, bookC})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), rows, "book C is only in the shelf through its copy")
+	assert.Equal(t, []string{"Book C"}, titles(*shelf))
+
+	rows, err = db.RemoveCopyFromCollection(*shelf, bookCopy.Accession)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), rows)
+	assert.Empty(t, titles(*shelf))
+
+	changes, err := db.History(bookCopy.Accession)
+	require.NoError(t, err)
+	require.NoError(t, db.Undo(changes[len(changes)-1].ID))
+	assert.Equal(t, []string{"Book C"}, titles(*shelf))
+
+	policy, err := db.CreatePolicy(LoanPolicy{Name: "short loan", LoanDays: int64Ptr(7)})
+	require.NoError(t, err)
+	require.NoError(t, db.AttachPolicy(*policy, PolicyScopeCollection, shelf.Name))
+	require.NoError(t, db.DeleteCollection(*shelf))
+	_, err = db.FindCollection("Loan shelf")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// its policy is detached, and the detachment recorded
+	attachments, err := db.PolicyAttachments()
+	require.NoError(t, err)
+	assert.Empty(t, attachments)
+	changes, err = db.History(strconv.FormatInt(shelf.ID, 10))
+	require.NoError(t, err)
+	require.Len(t, changes, 4)
+	assert.Equal(t, FieldPolicy, changes[2].Field)
+	assert.Equal(t, "short loan", strValue(changes[2].OldValue))
+	assert.Nil(t, changes[2].NewValue)
+	assert.Equal(t, ActionDelete, changes[3].Action)
+
+	books, err := db.FindBooks(BookFilter{})
+	require.NoError(t, err)
+	assert.Len(t, books, 2, "deleting a collection keeps its books")
+
+	// undoing a creation removes the collection created, even once another has taken its name
+	_, err = db.RenameCollection(*office, "Study")
+	require.NoError(t, err)
+	newOffice, err := db.CreateCollection("Office")
+	require.NoError(t, err)
+	changes, err = db.History(strconv.FormatInt(office.ID, 10))
+	require.NoError(t, err)
+	require.Equal(t, ActionCreate, changes[0].Action)
+	require.NoError(t, db.Undo(changes[0].ID))
+
+	_, err = db.FindCollection("Study")
+	assert.ErrorIs(t, err, ErrNotFound)
+	found, err = db.FindCollection("Office")
+	require.NoError(t, err)
+	assert.Equal(t, newOffice.ID, found.ID)
+}
@@ -0,0 +1,157 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/arudzitis/addlib/openlibrary"
+
+	"gorm.io/gorm"
+)
+
+const accessionFormat = "C%06d"
+
+// AddCopy records a new physical copy of an existing book. If the copy has no accession number, one
+// is generated from the next copy id, skipping any already given to a copy by hand. An available copy
+// is kept for the oldest hold waiting on the book.
+func (d DB) AddCopy(book openlibrary.Book, bookCopy Copy) (*Copy, error) {
+	ormBook, err := d.readBook(book.OLID)
+	if err != nil {
+		return nil, err
+	}
+	if ormBook == nil {
+		return nil, fmt.Errorf("db: no book with olid %s: %w", book.OLID, ErrNotFound)
+	}
+
+	bookCopy.ID = 0
+	bookCopy.BookID = ormBook.ID
+	if bookCopy.Status == "" {
+		bookCopy.Status = CopyStatusAvailable
+	}
+
+	err = d.db.Transaction(func(tx *gorm.DB) error {
+		if bookCopy.Accession == "" {
+			var err error
+			bookCopy.Accession, err = generateKeyTx(tx, &Copy{}, "accession", accessionFormat)
+			if err != nil {
+				return err
+			}
+		}
+		err := tx.Create(&bookCopy).Error
+		if err != nil {
+			return err
+		}
+
+		err = d.recordChangeTx(tx, Change{Action: ActionCreate, Entity: EntityCopy, EntityKey: bookCopy.Accession, NewValue: &book.OLID})
+		if err != nil {
+			return err
+		}
+
+		_, err = d.reserveCopyTx(tx, bookCopy)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("db: error creating copy: %w", err)
+	}
+
+	return &bookCopy, nil
+}
+
+// generateKeyTx generates a key for a new record from the next id, formatted with format, skipping
+// ids whose key was already given to a record by hand.
+func generateKeyTx(tx *gorm.DB, model interface{}, column string, format string) (string, error) {
+	var lastID int64
+	err := tx.Model(model).Select("COALESCE(MAX(id), 0)").Scan(&lastID).Error
+	if err != nil {
+		return "", err
+	}
+
+	for next := lastID + 1; ; next++ {
+		key := fmt.Sprintf(format, next)
+		var used int64
+		err = tx.Model(model).Where(column+" = ?", key).Count(&used).Error
+		if err != nil {
+			return "", err
+		}
+		if used == 0 {
+			return key, nil
+		}
+	}
+}
+
+// Copies returns all the copies held of a book, in the order they were added.
+func (d DB) Copies(book openlibrary.Book) ([]Copy, error) {
+	copies := []Copy{}
+	tx := d.db.Joins("JOIN books ON books.id = copies.book_id").
+		Where("books.olid = ?", book.OLID).
+		Order("copies.id").
+		Find(&copies)
+	if tx.Error != nil {
+		return nil, fmt.Errorf("db: error reading copies: %w", tx.Error)
+	}
+	return copies, nil
+}
+
+// RemoveCopy deletes the copy with the given accession number. Copies which have been lent cannot be
+// removed. A hold the copy was kept for goes back to waiting, and is given another copy of the book
+// if one is on the shelf.
+func (d DB) RemoveCopy(accession string) (int64, error) {
+	var rows int64
+	err := d.db.Transaction(func(tx *gorm.DB) error {
+		olids := []string{}
+		err := tx.Model(&Book{}).Joins("JOIN copies ON copies.book_id = books.id").Where("copies.accession = ?", accession).Pluck("books.olid", &olids).Error
+		if err != nil || len(olids) == 0 {
+			return err
+		}
+
+		onLoan, err := readOpenLoanTx(tx, accession)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		if onLoan != nil {
+			return fmt.Errorf("copy %s is out on loan to %s", accession, onLoan.Patron.Barcode)
+		}
+
+		// the loan history, and the scrubbing of it once anonymized, goes by the copy's accession
+		var lent int64
+		err = tx.Model(&Loan{}).Where("copy_id IN (SELECT id FROM copies WHERE accession = ?)", accession).Count(&lent).Error
+		if err != nil {
+			return err
+		}
+		if lent > 0 {
+			return fmt.Errorf("copy %s has been lent, and is kept for its loan history", accession)
+		}
+
+		bookCopy := Copy{}
+		err = tx.Where("accession = ?", accession).First(&bookCopy).Error
+		if err != nil {
+			return err
+		}
+		released, err := d.releaseCopyTx(tx, bookCopy.ID)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Where("copy_id IN (SELECT id FROM copies WHERE accession = ?)", accession).Delete(&CopyCollection{}).Error
+		if err != nil {
+			return err
+		}
+
+		result := tx.Where("accession = ?", accession).Delete(&Copy{})
+		if result.Error != nil {
+			return result.Error
+		}
+		rows = result.RowsAffected
+
+		err = d.recordChangeTx(tx, Change{Action: ActionDelete, Entity: EntityCopy, EntityKey: accession, OldValue: &olids[0]})
+		if err != nil || !released {
+			return err
+		}
+		_, err = d.reserveFreeCopyTx(tx, bookCopy.BookID)
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("db: error deleting copy: %w", err)
+	}
+	return rows, nil
+}
@@ -0,0 +1,64 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/arudzitis/addlib/openlibrary"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCopies(t *testing.T) {
+	book := openlibrary.Book{
+		OLID:    "olid-booka",
+		Title:   "Book A",
+		Authors: []openlibrary.Author{},
+	}
+
+	db := openTestDatabase(t)
+	defer db.Close()
+
+	err := db.InsertRecord(book)
+	require.NoError(t, err)
+
+	first, err := db.AddCopy(book, Copy{})
+	require.NoError(t, err)
+	assert.Equal(t, "C000001", first.Accession)
+	assert.Equal(t, CopyStatusAvailable, first.Status)
+
+	second, err := db.AddCopy(book, Copy{Accession: "BARCODE-2", Condition: "worn"})
+	require.NoError(t, err)
+	assert.Equal(t, "BARCODE-2", second.Accession)
+
+	// accessions given by hand in the generated form are skipped
+	_, err = db.AddCopy(book, Copy{Accession: "C000004"})
+	require.NoError(t, err)
+	generated, err := db.AddCopy(book, Copy{})
+	require.NoError(t, err)
+	assert.Equal(t, "C000005", generated.Accession)
+	for _, accession := range []string{"C000004", "C000005"} {
+		_, err = db.RemoveCopy(accession)
+		require.NoError(t, err)
+	}
+
+	_, err = db.AddCopy(book, Copy{Accession: "BARCODE-2"})
+	assert.Error(t, err, "accession numbers must be unique")
+
+	_, err = db.AddCopy(openlibrary.Book{OLID: "olid-missing"}, Copy{})
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	copies, err := db.Copies(book)
+	require.NoError(t, err)
+	require.Len(t, copies, 2)
+	assert.Equal(t, "C000001", copies[0].Accession)
+	assert.Equal(t, "worn", copies[1].Condition)
+
+	rows, err := db.RemoveCopy("C000001")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), rows)
+
+	copies, err = db.Copies(book)
+	require.NoError(t, err)
+	require.Len(t, copies, 1)
+	assert.Equal(t, "BARCODE-2", copies[0].Accession)
+}
@@ -5,18 +5,32 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/arudzitis/addlib/openlibrary"
 
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"gorm.io/gorm/logger"
 )
 
 const updateBookOverrideTitle = "UPDATE books SET override_title = ? WHERE olid = ?;"
 
+const (
+	bookKeyPrefix = "/books/"
+	isbnSeparator = openlibrary.ISBNSeparator
+)
+
+// ErrNotFound is returned when a lookup by a user supplied reference matches nothing.
+var ErrNotFound = errors.New("db: record not found")
+
 type DB struct {
 	db *gorm.DB
+
+	// actor is recorded as the author of every change
+	actor string
 }
 
 func OpenDatabase(databasePath string, verbose bool) (*DB, error) {
@@ -38,7 +52,20 @@ func OpenDatabase(databasePath string, verbose bool) (*DB, error) {
 		return nil, fmt.Errorf("db: error opening database: %w", err)
 	}
 
-	return &DB{db: db}, nil
+	return &DB{db: db, actor: defaultActor()}, nil
+}
+
+// SetActor sets who is recorded as making changes through this database; it defaults to the
+// current user.
+func (d *DB) SetActor(actor string) {
+	d.actor = actor
+}
+
+func defaultActor() string {
+	if user := os.Getenv("USER"); user != "" {
+		return user
+	}
+	return "unknown"
 }
 
 func (d DB) Close() error {
@@ -50,13 +77,63 @@ func (d DB) Close() error {
 }
 
 func (d DB) Migrate() error {
-	err := d.db.AutoMigrate(&Book{}, &Author{}, &BookAuthor{})
+	err := d.db.AutoMigrate(&Book{}, &Author{}, &BookAuthor{}, &Copy{}, &Location{}, &Tag{}, &BookTag{}, &Work{}, &BookWork{}, &Change{}, &Collection{}, &BookCollection{}, &CopyCollection{},
+		&Patron{}, &Loan{}, &Setting{}, &Notice{}, &NoticeLoan{}, &Hold{},
+		&LoanPolicy{}, &PolicyAssignment{}, &Subject{}, &BookSubject{}, &Stocktake{}, &StocktakeScan{})
 	if err != nil {
 		return fmt.Errorf("db: error updating database file: %w", err)
 	}
+
+	err = d.backfillTimestamps()
+	if err != nil {
+		return fmt.Errorf("db: error backfilling timestamps: %w", err)
+	}
+
+	err = d.backfillPositions()
+	if err != nil {
+		return fmt.Errorf("db: error backfilling credit positions: %w", err)
+	}
 	return nil
 }
 
+// backfillTimestamps fills in the creation and modification times of records saved before they were
+// kept. They are taken from the change log where it covers a record, otherwise the records are
+// taken to have been created now.
+func (d DB) backfillTimestamps() error {
+	now := d.db.NowFunc()
+	return d.db.Transaction(func(tx *gorm.DB) error {
+		for _, table := range []struct {
+			name   string
+			entity string
+		}{{"books", EntityBook}, {"authors", EntityAuthor}} {
+			err := tx.Exec(fmt.Sprintf("UPDATE %[1]s SET created_at = COALESCE((SELECT MIN(created_at) FROM changes WHERE entity = ? AND entity_key = %[1]s.olid), ?) WHERE created_at IS NULL", table.name), table.entity, now).Error
+			if err != nil {
+				return err
+			}
+
+			err = tx.Exec(fmt.Sprintf("UPDATE %[1]s SET updated_at = COALESCE((SELECT MAX(created_at) FROM changes WHERE entity = ? AND entity_key = %[1]s.olid), created_at) WHERE updated_at IS NULL", table.name), table.entity).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		// a credit is as old as the book it is on
+		err := tx.Exec("UPDATE book_authors SET created_at = (SELECT created_at FROM books WHERE books.id = book_authors.book_id) WHERE created_at IS NULL").Error
+		if err != nil {
+			return err
+		}
+		return tx.Exec("UPDATE book_authors SET updated_at = created_at WHERE updated_at IS NULL").Error
+	})
+}
+
+// backfillPositions orders the credits of books saved before the order of their authors was kept,
+// which all have the position 0, by when they were saved.
+func (d DB) backfillPositions() error {
+	return d.db.Exec("UPDATE book_authors SET position = " +
+		"(SELECT COUNT(*) FROM book_authors AS earlier WHERE earlier.book_id = book_authors.book_id AND earlier.rowid < book_authors.rowid) " +
+		"WHERE book_id IN (SELECT book_id FROM book_authors GROUP BY book_id HAVING COUNT(*) > 1 AND MAX(position) = 0)").Error
+}
+
 func (d DB) InsertRecord(book openlibrary.Book) error {
 	// check if book exists
 	existingBook, err := d.readBook(book.OLID)
@@ -64,92 +141,225 @@ func (d DB) InsertRecord(book openlibrary.Book) error {
 		return err
 	}
 	if existingBook != nil {
+		// a fresh record may still know more about the book's authors
+		_, err = d.FillAuthorDetails(book.Authors)
+		if err != nil {
+			return err
+		}
+		if existingBook.DeaccessionedOn != nil {
+			log.Printf("Book %s already saved, but was deaccessioned; restore it to bring it back.\n", book.Title)
+			return nil
+		}
 		log.Printf("Book %s already saved.\n", book.Title)
 		return nil
 	}
 
-	// prepare the object for insertion
-	ormAuthors := []Author{}
-	for _, author := range book.Authors {
-		ormAuthor, err := d.readAuthor(author.OLID)
+	ormBook := &Book{
+		ISBN10: book.GetIsbn10(),
+		ISBN13: book.GetIsbn13(),
+		OLID:   book.OLID,
+		Title:  book.Title,
+		Dewey:  book.GetDeweyDecimalClass(),
+		LCC:    book.GetLCClassifications(),
+
+		AuthorSource: book.AuthorSource,
+
+		PublishDate: optional(book.PublishDate),
+		Languages:   book.GetLanguages(),
+	}
+
+	err = d.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Create(&ormBook).Error
+		if err != nil {
+			return fmt.Errorf("db: error creating book: %w", err)
+		}
+
+		err = d.recordChangeTx(tx, Change{Action: ActionCreate, Entity: EntityBook, EntityKey: book.OLID, NewValue: &book.Title})
+		if err != nil {
+			return err
+		}
+
+		err = addSubjectsTx(tx, *ormBook, book.Subjects)
 		if err != nil {
 			return err
 		}
 
-		if ormAuthor == nil {
-			ormAuthor = &Author{
-				OLID: author.OLID,
-				Name: author.Name,
+		for _, work := range book.Works {
+			ormWork := Work{OLID: work.Key, Title: work.Title}
+			err = tx.Where(Work{OLID: work.Key}).FirstOrCreate(&ormWork).Error
+			if err != nil {
+				return fmt.Errorf("db: error creating work: %w", err)
+			}
+
+			err = tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&BookWork{BookID: ormBook.ID, WorkID: ormWork.ID}).Error
+			if err != nil {
+				return fmt.Errorf("db: error linking work: %w", err)
 			}
 		}
 
-		ormAuthors = append(ormAuthors, *ormAuthor)
-	}
+		// credit each author once, in the order openlibrary lists them
+		credited := map[int64]bool{}
+		for _, author := range book.Authors {
+			ormAuthor, err := readAuthorTx(tx, author.OLID)
+			if err != nil {
+				return err
+			}
 
-	ormBook := &Book{
-		ISBN10:  book.GetIsbn10(),
-		ISBN13:  book.GetIsbn13(),
-		OLID:    book.OLID,
-		Authors: ormAuthors,
-		Title:   book.Title,
-	}
+			if ormAuthor == nil {
+				ormAuthor = newAuthor(author)
+				err = tx.Create(ormAuthor).Error
+				if err != nil {
+					return fmt.Errorf("db: error creating author: %w", err)
+				}
+
+				err = d.recordChangeTx(tx, Change{Action: ActionCreate, Entity: EntityAuthor, EntityKey: author.OLID, NewValue: &author.Name})
+				if err != nil {
+					return err
+				}
+			} else {
+				_, err = d.fillAuthorTx(tx, ormAuthor, author)
+				if err != nil {
+					return err
+				}
+			}
 
-	tx := d.db.Create(&ormBook)
-	if tx.Error != nil {
-		return fmt.Errorf("db: error creating book: %w", tx.Error)
-	}
-	tx = d.db.Save(&ormBook)
-	if tx.Error != nil {
-		return fmt.Errorf("db: error saving book: %w", tx.Error)
+			if credited[ormAuthor.ID] {
+				continue
+			}
+			credited[ormAuthor.ID] = true
+
+			err = tx.Create(&BookAuthor{
+				BookID:   ormBook.ID,
+				AuthorID: ormAuthor.ID,
+				Role:     author.Role,
+				Position: len(credited) - 1,
+			}).Error
+			if err != nil {
+				return fmt.Errorf("db: error crediting author: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
 	return nil
 }
 
 func (d DB) UpdateTitle(book openlibrary.Book, title string) (int64, error) {
-	tx := d.db.Model(&Book{}).Where("olid = ?", book.OLID).Update("title", title)
-	if tx.Error != nil {
-		return 0, fmt.Errorf("db: error updating book title: %w", tx.Error)
+	rows, err := d.updateColumn(EntityBook, book.OLID, "title", title)
+	if err != nil {
+		return 0, fmt.Errorf("db: error updating book title: %w", err)
 	}
 	book.Title = title
 
-	return tx.RowsAffected, nil
+	return rows, nil
+}
+
+// UpdateCallNumber sets the local call number of a book, which takes precedence over its Dewey and
+// Library of Congress classifications. An empty call number clears it.
+func (d DB) UpdateCallNumber(book openlibrary.Book, callNumber string) (int64, error) {
+	rows, err := d.updateColumn(EntityBook, book.OLID, "call_number", optional(callNumber))
+	if err != nil {
+		return 0, fmt.Errorf("db: error updating book call number: %w", err)
+	}
+	return rows, nil
+}
+
+// BookChanges are changes made to several fields of a book at once. Fields left nil are not
+// changed; an empty call number clears it.
+type BookChanges struct {
+	Title      *string
+	CallNumber *string
+}
+
+// UpdateBook makes the changes to a book in a single transaction, so that either all of them are
+// made or none.
+func (d DB) UpdateBook(book openlibrary.Book, changes BookChanges) error {
+	err := d.db.Transaction(func(tx *gorm.DB) error {
+		if changes.Title != nil {
+			_, err := d.updateColumnTx(tx, EntityBook, book.OLID, "title", *changes.Title)
+			if err != nil {
+				return err
+			}
+		}
+		if changes.CallNumber != nil {
+			_, err := d.updateColumnTx(tx, EntityBook, book.OLID, "call_number", optional(*changes.CallNumber))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("db: error updating book: %w", err)
+	}
+	return nil
 }
 
 func (d DB) UpdateAuthorName(oldName string, newName string) (int64, error) {
-	tx := d.db.Model(&Author{}).Where("name = ?", oldName).Update("name", newName)
-	if tx.Error != nil {
-		return 0, fmt.Errorf("db: error updating author name: %w", tx.Error)
+	var rows int64
+	err := d.db.Transaction(func(tx *gorm.DB) error {
+		olids := []string{}
+		err := tx.Model(&Author{}).Where("name = ?", oldName).Pluck("olid", &olids).Error
+		if err != nil {
+			return err
+		}
+
+		for _, olid := range olids {
+			updated, err := d.updateColumnTx(tx, EntityAuthor, olid, "name", newName)
+			if err != nil {
+				return err
+			}
+			rows += updated
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("db: error updating author name: %w", err)
 	}
-	return tx.RowsAffected, nil
+	return rows, nil
 }
 
+// DeleteBook removes a book's record outright. Books which have left the collection should usually
+// be deaccessioned instead, which keeps their record and can be undone.
 func (d DB) DeleteBook(book openlibrary.Book) (int64, error) {
-	tx := d.db.Model(&Book{}).Where("olid = ?", book.OLID).Delete(&Book{})
-	if tx.Error != nil {
-		return 0, fmt.Errorf("db: error deleting book: %w", tx.Error)
+	var rows int64
+	err := d.db.Transaction(func(tx *gorm.DB) error {
+		existing, err := readBookTx(tx, book.OLID)
+		if err != nil || existing == nil {
+			return err
+		}
+
+		result := tx.Delete(&Book{}, existing.ID)
+		if result.Error != nil {
+			return result.Error
+		}
+		rows = result.RowsAffected
+
+		return d.recordChangeTx(tx, Change{Action: ActionDelete, Entity: EntityBook, EntityKey: book.OLID, OldValue: &existing.Title})
+	})
+	if err != nil {
+		return 0, fmt.Errorf("db: error deleting book: %w", err)
 	}
-	return tx.RowsAffected, nil
+	return rows, nil
 }
 
 func (d DB) AllBooks() ([]openlibrary.Book, error) {
-	ormBooks := []Book{}
-	tx := d.db.Model(&Book{}).Preload("Authors").Find(&ormBooks)
-	if tx.Error != nil {
-		return nil, fmt.Errorf("db: error reading all books: %w", tx.Error)
+	ormBooks, err := d.FindBooks(BookFilter{})
+	if err != nil {
+		return nil, fmt.Errorf("db: error reading all books: %w", err)
 	}
 
 	books := []openlibrary.Book{}
 	for _, ormBook := range ormBooks {
-		ormAuthors, err := d.readAuthors(&ormBook)
-		if err != nil {
-			return nil, err
-		}
 		authors := []openlibrary.Author{}
-		for _, ormAuthor := range ormAuthors {
+		for _, credit := range ormBook.Credits {
 			author := openlibrary.Author{
-				Name: ormAuthor.Name,
-				OLID: ormAuthor.OLID,
+				Name: credit.Author.Name,
+				OLID: credit.Author.OLID,
+				Role: credit.Role,
 			}
 			authors = append(authors, author)
 		}
@@ -158,6 +368,12 @@ func (d DB) AllBooks() ([]openlibrary.Book, error) {
 			Title:   ormBook.Title,
 			Authors: authors,
 			OLID:    ormBook.OLID,
+
+			AuthorSource: ormBook.AuthorSource,
+		}
+
+		for _, work := range ormBook.Works {
+			book.Works = append(book.Works, openlibrary.Work{Key: work.OLID, Title: work.Title})
 		}
 
 		if ormBook.ISBN10 != nil {
@@ -168,15 +384,282 @@ func (d DB) AllBooks() ([]openlibrary.Book, error) {
 			book.SetIsbn13(*ormBook.ISBN13)
 		}
 
+		if ormBook.Dewey != nil {
+			book.SetDeweyDecimalClass(*ormBook.Dewey)
+		}
+
+		if ormBook.LCC != nil {
+			book.SetLCClassifications(*ormBook.LCC)
+		}
+
+		if ormBook.PublishDate != nil {
+			book.PublishDate = *ormBook.PublishDate
+		}
+
+		if ormBook.Languages != nil {
+			book.SetLanguages(*ormBook.Languages)
+		}
+
+		for _, subject := range ormBook.Subjects {
+			book.Subjects = append(book.Subjects, subject.Name)
+		}
+
 		books = append(books, book)
 	}
 
 	return books, nil
 }
 
+// BookFilter restricts the books returned by FindBooks. The zero value matches every book.
+type BookFilter struct {
+	// OLID matches only the book with this openlibrary id.
+	OLID string
+
+	// LocationID matches books shelved at, or with a copy shelved at, this location or any
+	// location within it.
+	LocationID *int64
+
+	// Query matches books whose title or any author's name contains this text.
+	Query string
+
+	Tags TagFilter
+
+	// Subject matches books with the subject of this name.
+	Subject string
+
+	// Unclassified matches books with no local call number, Dewey number or LCC number.
+	Unclassified bool
+
+	// AuthorID matches books crediting this author in any role.
+	AuthorID *int64
+
+	// CollectionID matches books in this collection, or with a copy in it.
+	CollectionID *int64
+
+	// IncludeDeaccessioned matches books which have been deaccessioned as well as those still held.
+	IncludeDeaccessioned bool
+
+	// Deaccessioned, if set, matches only books deaccessioned within the range.
+	Deaccessioned *DateRange
+
+	// AddedSince matches books added to the catalogue at or after this time.
+	AddedSince *time.Time
+
+	// ChangedSince matches books which have been added or changed at or after this time, including
+	// changes to their authors, tags and copies.
+	ChangedSince *time.Time
+
+	// Limit, if positive, returns at most this many of the matching books, after skipping Offset of
+	// them. CountBooks ignores both.
+	Limit  int
+	Offset int
+}
+
+// day returns the date of t as a UTC midnight; dates are kept this way so that they compare
+// consistently.
+func day(t time.Time) time.Time {
+	year, month, date := t.Date()
+	return time.Date(year, month, date, 0, 0, 0, 0, time.UTC)
+}
+
+// DateRange covers the days from From to To inclusive, given as UTC midnights. Either end may be left
+// open.
+type DateRange struct {
+	From *time.Time
+	To   *time.Time
+}
+
+// TagFilter matches books by their tags. A book must have every tag in All, at least one tag in
+// Any if it is not empty, and none of the tags in None.
+type TagFilter struct {
+	All  []string
+	Any  []string
+	None []string
+}
+
+// changedBooksClause matches books whose own record, credits or authors have been updated since a
+// time, or which have changes logged against them or their copies since then. Times are compared
+// through julianday, as they are stored with the offset of the zone they were recorded in.
+const changedBooksClause = "julianday(books.updated_at) >= julianday(?) OR " +
+	"books.id IN (SELECT book_authors.book_id FROM book_authors JOIN authors ON authors.id = book_authors.author_id WHERE julianday(book_authors.updated_at) >= julianday(?) OR julianday(authors.updated_at) >= julianday(?)) OR " +
+	"books.olid IN (SELECT entity_key FROM changes WHERE entity = ? AND julianday(created_at) >= julianday(?)) OR " +
+	"books.id IN (SELECT copies.book_id FROM copies JOIN changes ON changes.entity_key = copies.accession WHERE changes.entity = ? AND julianday(changes.created_at) >= julianday(?))"
+
+// FindBooks returns the books matching a filter, with their authors and copies.
+func (d DB) FindBooks(filter BookFilter) ([]Book, error) {
+	query, err := d.bookQuery(filter)
+	if err != nil {
+		return nil, err
+	}
+	query = query.
+		Preload("Credits", func(tx *gorm.DB) *gorm.DB { return tx.Order("position") }).
+		Preload("Credits.Author").
+		Preload("Copies").
+		Preload("Tags").
+		Preload("Subjects").
+		Preload("Works").
+		Order("books.id")
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit).Offset(filter.Offset)
+	}
+
+	books := []Book{}
+	tx := query.Find(&books)
+	if tx.Error != nil {
+		return nil, fmt.Errorf("db: error finding books: %w", tx.Error)
+	}
+
+	for i := range books {
+		books[i].Authors = make([]Author, len(books[i].Credits))
+		for j, credit := range books[i].Credits {
+			books[i].Authors[j] = credit.Author
+		}
+	}
+	return books, nil
+}
+
+// CountBooks counts the books matching a filter, ignoring its Limit and Offset.
+func (d DB) CountBooks(filter BookFilter) (int64, error) {
+	query, err := d.bookQuery(filter)
+	if err != nil {
+		return 0, err
+	}
+
+	var count int64
+	tx := query.Count(&count)
+	if tx.Error != nil {
+		return 0, fmt.Errorf("db: error counting books: %w", tx.Error)
+	}
+	return count, nil
+}
+
+// bookQuery selects the books matching a filter.
+func (d DB) bookQuery(filter BookFilter) (*gorm.DB, error) {
+	query := d.db.Model(&Book{})
+
+	if filter.OLID != "" {
+		query = query.Where("books.olid = ?", filter.OLID)
+	}
+
+	if filter.Deaccessioned != nil {
+		query = query.Where("books.deaccessioned_on IS NOT NULL")
+		if filter.Deaccessioned.From != nil {
+			query = query.Where("books.deaccessioned_on >= ?", *filter.Deaccessioned.From)
+		}
+		if filter.Deaccessioned.To != nil {
+			query = query.Where("books.deaccessioned_on < ?", filter.Deaccessioned.To.AddDate(0, 0, 1))
+		}
+	} else if !filter.IncludeDeaccessioned {
+		query = query.Where("books.deaccessioned_on IS NULL")
+	}
+
+	if filter.AddedSince != nil {
+		query = query.Where("julianday(books.created_at) >= julianday(?)", filter.AddedSince.UTC())
+	}
+
+	if filter.ChangedSince != nil {
+		since := filter.ChangedSince.UTC()
+		query = query.Where(changedBooksClause, since, since, since, EntityBook, since, EntityCopy, since)
+	}
+
+	if filter.LocationID != nil {
+		subtree, err := d.locationSubtree(*filter.LocationID)
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where("books.location_id IN ? OR books.id IN (SELECT book_id FROM copies WHERE location_id IN ?)", subtree, subtree)
+	}
+
+	if filter.Query != "" {
+		pattern := "%" + escapeLike(filter.Query) + "%"
+		query = query.Where(`books.title LIKE ? ESCAPE '\' OR books.id IN (SELECT book_authors.book_id FROM book_authors JOIN authors ON authors.id = book_authors.author_id WHERE authors.name LIKE ? ESCAPE '\')`, pattern, pattern)
+	}
+
+	if filter.CollectionID != nil {
+		query = query.Where("books.id IN ("+collectionBooksQuery+")", *filter.CollectionID, *filter.CollectionID)
+	}
+
+	if filter.AuthorID != nil {
+		query = query.Where("books.id IN (SELECT book_id FROM book_authors WHERE author_id = ?)", *filter.AuthorID)
+	}
+
+	if filter.Subject != "" {
+		query = query.Where("books.id IN ("+subjectBooksQuery+")", filter.Subject)
+	}
+
+	if filter.Unclassified {
+		for _, column := range []string{"books.call_number", "books.dewey", "books.lcc"} {
+			query = query.Where(fmt.Sprintf("COALESCE(%s, '') = ''", column))
+		}
+	}
+
+	for _, tag := range filter.Tags.All {
+		query = query.Where("books.id IN ("+taggedBooksQuery+" = ?)", tag)
+	}
+	if len(filter.Tags.Any) > 0 {
+		query = query.Where("books.id IN ("+taggedBooksQuery+" IN ?)", filter.Tags.Any)
+	}
+	if len(filter.Tags.None) > 0 {
+		query = query.Where("books.id NOT IN ("+taggedBooksQuery+" IN ?)", filter.Tags.None)
+	}
+
+	return query, nil
+}
+
+// FindBook looks up a book by its openlibrary id, with or without the "/books/" prefix, or by any of
+// its ISBNs.
+func (d DB) FindBook(ref string) (*Book, error) {
+	candidates := []string{ref}
+	if !strings.HasPrefix(ref, "/") {
+		candidates = append(candidates, bookKeyPrefix+ref)
+	}
+	for _, olid := range candidates {
+		book, err := d.readBook(olid)
+		if err != nil {
+			return nil, err
+		}
+		if book != nil {
+			return book, nil
+		}
+	}
+
+	book := Book{}
+	tx := d.db.Where(isbnMatchClause, isbnMatchArgs(ref)...).Limit(1).Find(&book)
+	if tx.Error != nil {
+		return nil, fmt.Errorf("db: error finding book: %w", tx.Error)
+	}
+	if tx.RowsAffected != 1 {
+		return nil, fmt.Errorf("db: no book matching %q: %w", ref, ErrNotFound)
+	}
+
+	return &book, nil
+}
+
+// isbnMatchClause matches a single isbn within either of the comma separated isbn columns.
+const isbnMatchClause = `(isbn13 = ? OR isbn13 LIKE ? ESCAPE '\' OR isbn13 LIKE ? ESCAPE '\' OR isbn13 LIKE ? ESCAPE '\' OR ` +
+	`isbn10 = ? OR isbn10 LIKE ? ESCAPE '\' OR isbn10 LIKE ? ESCAPE '\' OR isbn10 LIKE ? ESCAPE '\')`
+
+func isbnMatchArgs(isbn string) []interface{} {
+	escaped := escapeLike(isbn)
+	patterns := []interface{}{isbn, escaped + isbnSeparator + "%", "%" + isbnSeparator + escaped, "%" + isbnSeparator + escaped + isbnSeparator + "%"}
+	return append(patterns, patterns...)
+}
+
+// likeEscaper escapes the wildcards of a LIKE pattern, for clauses with ESCAPE '\'.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// escapeLike makes user input match only itself within a LIKE pattern.
+func escapeLike(value string) string {
+	return likeEscaper.Replace(value)
+}
+
 func (d DB) readBook(olid string) (*Book, error) {
+	return readBookTx(d.db, olid)
+}
+
+func readBookTx(db *gorm.DB, olid string) (*Book, error) {
 	book := Book{}
-	tx := d.db.Find(&book, "olid = ?", olid)
+	tx := db.Find(&book, "olid = ?", olid)
 	if tx.Error != nil {
 		if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
 			return nil, nil
@@ -190,9 +673,9 @@ func (d DB) readBook(olid string) (*Book, error) {
 	return &book, nil
 }
 
-func (d DB) readAuthor(olid string) (*Author, error) {
+func readAuthorTx(db *gorm.DB, olid string) (*Author, error) {
 	author := Author{}
-	tx := d.db.Find(&author, "olid = ?", olid)
+	tx := db.Find(&author, "olid = ?", olid)
 	if tx.Error != nil {
 		if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
 			return nil, nil
@@ -205,12 +688,3 @@ func (d DB) readAuthor(olid string) (*Author, error) {
 
 	return &author, nil
 }
-
-func (d DB) readAuthors(book *Book) ([]Author, error) {
-	authors := []Author{}
-	err := d.db.Model(book).Association("Authors").Find(&authors)
-	if err != nil {
-		return nil, fmt.Errorf("db: error reading authors for book: %w", err)
-	}
-	return authors, nil
-}
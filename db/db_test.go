@@ -1,9 +1,11 @@
 package db
 
 import (
+	"fmt"
 	"io/ioutil"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/arudzitis/addlib/openlibrary"
 	"github.com/stretchr/testify/assert"
@@ -92,6 +94,74 @@ func TestInsertRecord(t *testing.T) {
 
 }
 
+func TestAuthorRoles(t *testing.T) {
+	authorA := openlibrary.Author{OLID: "olid-authora", Name: "Author A"}
+	authorB := openlibrary.Author{OLID: "olid-authorb", Name: "Author B"}
+	translator := openlibrary.Author{OLID: "olid-authorc", Name: "Author C", Role: openlibrary.RoleTranslator}
+
+	db := openTestDatabase(t)
+	defer db.Close()
+
+	err := db.InsertRecord(openlibrary.Book{OLID: "olid-booka", Title: "Book A", Authors: []openlibrary.Author{authorA}})
+	require.NoError(t, err)
+
+	book := openlibrary.Book{
+		OLID:    "olid-bookb",
+		Title:   "Book B",
+		Authors: []openlibrary.Author{translator, authorB, authorA, authorB},
+	}
+	err = db.InsertRecord(book)
+	require.NoError(t, err)
+
+	books, err := db.AllBooks()
+	require.NoError(t, err)
+	require.Len(t, books, 2)
+	assert.Equal(t, []openlibrary.Author{translator, authorB, authorA}, books[1].Authors, "authors keep their order and role, and are credited once")
+
+	found, err := db.FindBooks(BookFilter{})
+	require.NoError(t, err)
+	require.Len(t, found, 2)
+	require.Len(t, found[1].Authors, 3)
+	assert.Equal(t, "Author C", found[1].Authors[0].Name)
+	assert.Equal(t, openlibrary.RoleTranslator, found[1].Credits[0].Role)
+
+	// credits saved before their order was kept are ordered as they were saved
+	require.NoError(t, db.db.Exec("UPDATE book_authors SET position = 0").Error)
+	require.NoError(t, db.Migrate())
+	positions := []int{}
+	require.NoError(t, db.db.Model(&BookAuthor{}).Where("book_id = ?", found[1].ID).Order("rowid").Pluck("position", &positions).Error)
+	assert.Equal(t, []int{0, 1, 2}, positions)
+}
+
+func TestWorks(t *testing.T) {
+	omnibus := openlibrary.Book{
+		OLID:         "olid-booka",
+		Title:        "Book A",
+		Authors:      []openlibrary.Author{},
+		Works:        []openlibrary.Work{{Key: "olid-worka", Title: "Work A"}, {Key: "olid-workb", Title: "Work B"}},
+		AuthorSource: openlibrary.AuthorSourceWork,
+	}
+	single := openlibrary.Book{
+		OLID:         "olid-bookb",
+		Title:        "Book B",
+		Authors:      []openlibrary.Author{},
+		Works:        []openlibrary.Work{{Key: "olid-workb", Title: "Work B"}},
+		AuthorSource: openlibrary.AuthorSourceEdition,
+	}
+
+	db := openTestDatabase(t)
+	defer db.Close()
+
+	require.NoError(t, db.InsertRecord(omnibus))
+	require.NoError(t, db.InsertRecord(single))
+
+	books, err := db.AllBooks()
+	require.NoError(t, err)
+	require.Len(t, books, 2)
+	assert.Equal(t, omnibus, books[0])
+	assert.Equal(t, single, books[1])
+}
+
 func TestUpdateAuthorName(t *testing.T) {
 	authorA := openlibrary.Author{
 		OLID: "olid-authora",
@@ -150,6 +220,34 @@ func TestUpdateTitle(t *testing.T) {
 	assert.Equal(t, "Book B", books[0].Title)
 }
 
+func TestUpdateBook(t *testing.T) {
+	book := openlibrary.Book{OLID: "olid-booka", Title: "Book A"}
+
+	db := openTestDatabase(t)
+	defer db.Close()
+
+	require.NoError(t, db.InsertRecord(book))
+
+	title, callNumber := "Book A: Revised", "REF 1"
+	require.NoError(t, db.UpdateBook(book, BookChanges{Title: &title, CallNumber: &callNumber}))
+
+	found, err := db.FindBook(book.OLID)
+	require.NoError(t, err)
+	assert.Equal(t, title, found.Title)
+	assert.Equal(t, callNumber, *found.CallNumber)
+
+	empty := ""
+	require.NoError(t, db.UpdateBook(book, BookChanges{CallNumber: &empty}))
+	found, err = db.FindBook(book.OLID)
+	require.NoError(t, err)
+	assert.Equal(t, title, found.Title, "fields left out are not changed")
+	assert.Nil(t, found.CallNumber)
+
+	changes, err := db.History(book.OLID)
+	require.NoError(t, err)
+	assert.Len(t, changes, 4)
+}
+
 func TestDeleteBook(t *testing.T) {
 	authorA := openlibrary.Author{
 		OLID: "olid-authora",
@@ -189,6 +287,297 @@ func TestDeleteBook(t *testing.T) {
 	assert.Equal(t, "Author A", books[0].Authors[0].Name)
 }
 
+func TestDeaccessionBook(t *testing.T) {
+	authorA := openlibrary.Author{
+		OLID: "olid-authora",
+		Name: "Author A",
+	}
+
+	bookA := openlibrary.Book{
+		OLID:    "olid-booka",
+		Title:   "Book A",
+		Authors: []openlibrary.Author{authorA},
+	}
+
+	bookB := openlibrary.Book{
+		OLID:    "olid-bookb",
+		Title:   "Book B",
+		Authors: []openlibrary.Author{authorA},
+	}
+
+	db := openTestDatabase(t)
+	defer db.Close()
+
+	err := db.InsertRecord(bookA)
+	require.NoError(t, err)
+
+	err = db.InsertRecord(bookB)
+	require.NoError(t, err)
+
+	_, err = db.TagBooks("fiction", []openlibrary.Book{bookA, bookB})
+	require.NoError(t, err)
+
+	on := time.Date(2022, time.March, 4, 0, 0, 0, 0, time.UTC)
+	rows, err := db.DeaccessionBook(bookA, Deaccession{Reason: DeaccessionDonated, Note: "to the school fete", On: on})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), rows)
+
+	_, err = db.DeaccessionBook(bookA, Deaccession{Reason: DeaccessionLost})
+	assert.Error(t, err, "a book can only be deaccessioned once")
+	_, err = db.DeaccessionBook(bookB, Deaccession{Reason: "misplaced"})
+	assert.Error(t, err)
+
+	books, err := db.AllBooks()
+	require.NoError(t, err)
+
+	require.Equal(t, 1, len(books))
+	assert.Equal(t, "Book B", books[0].Title)
+	assert.Equal(t, "Author A", books[0].Authors[0].Name)
+
+	tags, err := db.Tags()
+	require.NoError(t, err)
+	assert.Equal(t, []TagCount{{Name: "fiction", Books: 1}}, tags)
+
+	all, err := db.FindBooks(BookFilter{IncludeDeaccessioned: true})
+	require.NoError(t, err)
+	assert.Len(t, all, 2)
+
+	day := func(d int) *time.Time {
+		date := time.Date(2022, time.March, d, 0, 0, 0, 0, time.UTC)
+		return &date
+	}
+	deaccessioned, err := db.FindBooks(BookFilter{Deaccessioned: &DateRange{From: day(4), To: day(4)}})
+	require.NoError(t, err)
+	require.Len(t, deaccessioned, 1)
+	assert.Equal(t, "Book A", deaccessioned[0].Title)
+	assert.Equal(t, on, deaccessioned[0].DeaccessionedOn.UTC())
+	assert.Equal(t, DeaccessionDonated, deaccessioned[0].DeaccessionReason)
+	assert.Equal(t, "to the school fete", deaccessioned[0].DeaccessionNote)
+
+	deaccessioned, err = db.FindBooks(BookFilter{Deaccessioned: &DateRange{From: day(5)}})
+	require.NoError(t, err)
+	assert.Empty(t, deaccessioned)
+
+	rows, err = db.RestoreBook(bookA)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), rows)
+
+	books, err = db.AllBooks()
+	require.NoError(t, err)
+	assert.Len(t, books, 2)
+
+	restored, err := db.FindBook(bookA.OLID)
+	require.NoError(t, err)
+	assert.Nil(t, restored.DeaccessionedOn)
+	assert.Empty(t, restored.DeaccessionReason)
+
+	_, err = db.RestoreBook(bookA)
+	assert.Error(t, err, "only deaccessioned books can be restored")
+
+	changes, err := db.History(bookA.OLID)
+	require.NoError(t, err)
+	assert.Equal(t, "donated Book A", changes[len(changes)-1].Describe())
+}
+
+func TestFindBook(t *testing.T) {
+	book := openlibrary.Book{
+		OLID:    "/books/OL1M",
+		Title:   "Book A",
+		Isbn10:  []string{"0345391802"},
+		Isbn13:  []string{"9780345391803", "9780345391810"},
+		Authors: []openlibrary.Author{},
+	}
+
+	db := openTestDatabase(t)
+	defer db.Close()
+
+	err := db.InsertRecord(book)
+	require.NoError(t, err)
+
+	for _, ref := range []string{"/books/OL1M", "OL1M", "0345391802", "9780345391803", "9780345391810"} {
+		found, err := db.FindBook(ref)
+		require.NoError(t, err, ref)
+		assert.Equal(t, "Book A", found.Title, ref)
+	}
+
+	_, err = db.FindBook("978034539180")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// wildcards only match themselves
+	for _, ref := range []string{"%", "_780345391810", "97803453918%"} {
+		_, err = db.FindBook(ref)
+		assert.ErrorIs(t, err, ErrNotFound, ref)
+	}
+}
+
+func TestFindBooksPage(t *testing.T) {
+	db := openTestDatabase(t)
+	defer db.Close()
+
+	for i, title := range []string{"Book A", "Book B", "Book C", "Other D", "Book E"} {
+		require.NoError(t, db.InsertRecord(openlibrary.Book{OLID: fmt.Sprintf("olid-book%d", i), Title: title}))
+	}
+
+	filter := BookFilter{Query: "Book", Limit: 2, Offset: 2}
+	count, err := db.CountBooks(filter)
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), count)
+
+	books, err := db.FindBooks(filter)
+	require.NoError(t, err)
+	require.Len(t, books, 2)
+	assert.Equal(t, "Book C", books[0].Title)
+	assert.Equal(t, "Book E", books[1].Title)
+
+	filter.Offset = 4
+	books, err = db.FindBooks(filter)
+	require.NoError(t, err)
+	assert.Empty(t, books)
+
+	for _, query := range []string{"%", "Book_"} {
+		count, err = db.CountBooks(BookFilter{Query: query})
+		require.NoError(t, err)
+		assert.Zero(t, count, "wildcards in %q only match themselves", query)
+	}
+}
+
+func TestClassification(t *testing.T) {
+	dewey := openlibrary.Book{
+		OLID:              "olid-booka",
+		Title:             "Book A",
+		Authors:           []openlibrary.Author{},
+		DeweyDecimalClass: []string{"823.912", "823/.91"},
+	}
+	lcc := openlibrary.Book{
+		OLID:              "olid-bookb",
+		Title:             "Book B",
+		Authors:           []openlibrary.Author{},
+		LCClassifications: []string{"PR6039.O32 H6 1966"},
+	}
+	unclassified := openlibrary.Book{
+		OLID:    "olid-bookc",
+		Title:   "Book C",
+		Authors: []openlibrary.Author{},
+	}
+
+	db := openTestDatabase(t)
+	defer db.Close()
+
+	for _, book := range []openlibrary.Book{dewey, lcc, unclassified} {
+		require.NoError(t, db.InsertRecord(book))
+	}
+
+	books, err := db.AllBooks()
+	require.NoError(t, err)
+	require.Len(t, books, 3)
+	assert.Equal(t, dewey, books[0])
+	assert.Equal(t, lcc, books[1])
+
+	found, err := db.FindBooks(BookFilter{Unclassified: true})
+	require.NoError(t, err)
+	require.Len(t, found, 1)
+	assert.Equal(t, "Book C", found[0].Title)
+	assert.Equal(t, "", found[0].ShelfMark())
+
+	rows, err := db.UpdateCallNumber(unclassified, "REF 001")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), rows)
+
+	_, err = db.UpdateCallNumber(dewey, "FIC TOL")
+	require.NoError(t, err)
+
+	found, err = db.FindBooks(BookFilter{})
+	require.NoError(t, err)
+	require.Len(t, found, 3)
+	assert.Equal(t, "FIC TOL", found[0].ShelfMark())
+	assert.Equal(t, "PR6039.O32 H6 1966", found[1].ShelfMark())
+	assert.Equal(t, "REF 001", found[2].ShelfMark())
+
+	_, err = db.UpdateCallNumber(dewey, "")
+	require.NoError(t, err)
+
+	found, err = db.FindBooks(BookFilter{})
+	require.NoError(t, err)
+	assert.Equal(t, "823.912", found[0].ShelfMark())
+}
+
+func TestTimestamps(t *testing.T) {
+	authorA := openlibrary.Author{OLID: "olid-authora", Name: "Author A"}
+	bookA := openlibrary.Book{OLID: "olid-booka", Title: "Book A", Authors: []openlibrary.Author{authorA}}
+	bookB := openlibrary.Book{OLID: "olid-bookb", Title: "Book B"}
+	bookC := openlibrary.Book{OLID: "olid-bookc", Title: "Book C"}
+
+	db := openTestDatabase(t)
+	defer db.Close()
+
+	clock := time.Date(2023, time.January, 1, 12, 0, 0, 0, time.Local)
+	db.db.Config.NowFunc = func() time.Time { return clock }
+
+	require.NoError(t, db.InsertRecord(bookA))
+	clock = clock.AddDate(0, 1, 0)
+	require.NoError(t, db.InsertRecord(bookB))
+	require.NoError(t, db.InsertRecord(bookC))
+
+	titles := func(filter BookFilter) []string {
+		books, err := db.FindBooks(filter)
+		require.NoError(t, err)
+		result := []string{}
+		for _, book := range books {
+			result = append(result, book.Title)
+		}
+		return result
+	}
+
+	february := time.Date(2023, time.February, 1, 0, 0, 0, 0, time.Local)
+	assert.Equal(t, []string{"Book B", "Book C"}, titles(BookFilter{AddedSince: &february}))
+
+	march := february.AddDate(0, 1, 0)
+	assert.Empty(t, titles(BookFilter{ChangedSince: &march}))
+
+	clock = march
+	_, err := db.UpdateTitle(bookB, "Book B2")
+	require.NoError(t, err)
+	_, err = db.RenameAuthor(authorA.OLID, "Author A2")
+	require.NoError(t, err)
+	_, err = db.TagBooks("new", []openlibrary.Book{bookC})
+	require.NoError(t, err)
+
+	assert.Empty(t, titles(BookFilter{AddedSince: &march}))
+	assert.Equal(t, []string{"Book A", "Book B2", "Book C"}, titles(BookFilter{ChangedSince: &march}))
+
+	book, err := db.FindBook(bookB.OLID)
+	require.NoError(t, err)
+	assert.True(t, book.CreatedAt.Equal(february.Add(12*time.Hour)))
+	assert.True(t, book.UpdatedAt.Equal(march))
+
+	// records saved before timestamps were kept are backfilled from the change log, or failing that
+	// as of the migration
+	require.NoError(t, db.db.Exec("UPDATE books SET created_at = NULL, updated_at = NULL").Error)
+	require.NoError(t, db.db.Exec("UPDATE book_authors SET created_at = NULL, updated_at = NULL").Error)
+	require.NoError(t, db.db.Exec("DELETE FROM changes WHERE entity_key = ?", bookC.OLID).Error)
+	clock = march.AddDate(0, 1, 0)
+	require.NoError(t, db.Migrate())
+
+	books, err := db.FindBooks(BookFilter{})
+	require.NoError(t, err)
+	require.Len(t, books, 3)
+	assert.True(t, books[0].CreatedAt.Equal(time.Date(2023, time.January, 1, 12, 0, 0, 0, time.Local)))
+	assert.True(t, books[0].Credits[0].CreatedAt.Equal(books[0].CreatedAt))
+	assert.True(t, books[1].UpdatedAt.Equal(march))
+	assert.True(t, books[2].CreatedAt.Equal(clock))
+	assert.True(t, books[2].UpdatedAt.Equal(clock))
+
+	// times are compared as instants, whatever zone they were recorded or given in
+	clock = time.Date(2023, time.June, 1, 9, 0, 0, 0, time.FixedZone("AEST", 10*60*60))
+	require.NoError(t, db.InsertRecord(openlibrary.Book{OLID: "olid-bookd", Title: "Book D"}))
+	june := time.Date(2023, time.June, 1, 0, 0, 0, 0, time.UTC)
+	assert.Empty(t, titles(BookFilter{AddedSince: &june}))
+	assert.Empty(t, titles(BookFilter{ChangedSince: &june}))
+	may := time.Date(2023, time.May, 31, 22, 0, 0, 0, time.UTC)
+	assert.Equal(t, []string{"Book D"}, titles(BookFilter{AddedSince: &may}))
+	assert.Equal(t, []string{"Book D"}, titles(BookFilter{ChangedSince: &may}))
+}
+
 func openTestDatabase(t *testing.T) *DB {
 	t.Helper()
 
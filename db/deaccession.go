@@ -0,0 +1,119 @@
+package db
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/arudzitis/addlib/openlibrary"
+
+	"gorm.io/gorm"
+)
+
+// ActionRestore records a deaccessioned book being brought back into the collection.
+const ActionRestore = "restore"
+
+// heldBooksQuery selects the ids of the books which have not been deaccessioned.
+const heldBooksQuery = "SELECT id FROM books WHERE deaccessioned_on IS NULL"
+
+// Deaccession describes a book leaving the collection.
+type Deaccession struct {
+	// Reason is one of the DeaccessionReasons.
+	Reason string
+	Note   string
+	// On is the day the book left; today if it is zero.
+	On time.Time
+}
+
+// DeaccessionBook marks a book as having left the collection. The record is kept, but hidden from
+// FindBooks unless asked for, and may be brought back with RestoreBook. Holds on the book still in
+// the queue are cancelled.
+func (d DB) DeaccessionBook(book openlibrary.Book, deaccession Deaccession) (int64, error) {
+	if !validDeaccessionReason(deaccession.Reason) {
+		return 0, fmt.Errorf("db: unknown deaccession reason %q", deaccession.Reason)
+	}
+	if deaccession.On.IsZero() {
+		deaccession.On = time.Now()
+	}
+	deaccession.On = day(deaccession.On)
+
+	var rows int64
+	err := d.db.Transaction(func(tx *gorm.DB) error {
+		existing, err := readBookTx(tx, book.OLID)
+		if err != nil || existing == nil {
+			return err
+		}
+		if existing.DeaccessionedOn != nil {
+			return fmt.Errorf("book %q was already deaccessioned on %s", existing.Title, existing.DeaccessionedOn.Format("2006-01-02"))
+		}
+
+		var onLoan int64
+		err = tx.Model(&Copy{}).Where("book_id = ? AND id IN ("+openLoansQuery+")", existing.ID).Count(&onLoan).Error
+		if err != nil {
+			return err
+		}
+		if onLoan > 0 {
+			return fmt.Errorf("book %q has %d copies out on loan", existing.Title, onLoan)
+		}
+
+		result := tx.Model(existing).Updates(map[string]interface{}{
+			"deaccessioned_on":   deaccession.On,
+			"deaccession_reason": deaccession.Reason,
+			"deaccession_note":   deaccession.Note,
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+		rows = result.RowsAffected
+
+		_, err = d.cancelBookHoldsTx(tx, existing.ID)
+		if err != nil {
+			return err
+		}
+
+		return d.recordChangeTx(tx, Change{Action: ActionDelete, Entity: EntityBook, EntityKey: existing.OLID, OldValue: &existing.Title, NewValue: &deaccession.Reason})
+	})
+	if err != nil {
+		return 0, fmt.Errorf("db: error deaccessioning book: %w", err)
+	}
+	return rows, nil
+}
+
+// RestoreBook brings a deaccessioned book back into the collection.
+func (d DB) RestoreBook(book openlibrary.Book) (int64, error) {
+	var rows int64
+	err := d.db.Transaction(func(tx *gorm.DB) error {
+		existing, err := readBookTx(tx, book.OLID)
+		if err != nil || existing == nil {
+			return err
+		}
+		if existing.DeaccessionedOn == nil {
+			return fmt.Errorf("book %q has not been deaccessioned", existing.Title)
+		}
+		reason := existing.DeaccessionReason
+
+		result := tx.Model(existing).Updates(map[string]interface{}{
+			"deaccessioned_on":   nil,
+			"deaccession_reason": "",
+			"deaccession_note":   "",
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+		rows = result.RowsAffected
+
+		return d.recordChangeTx(tx, Change{Action: ActionRestore, Entity: EntityBook, EntityKey: existing.OLID, OldValue: &reason, NewValue: &existing.Title})
+	})
+	if err != nil {
+		return 0, fmt.Errorf("db: error restoring book: %w", err)
+	}
+	return rows, nil
+}
+
+func validDeaccessionReason(reason string) bool {
+	for _, valid := range DeaccessionReasons {
+		if reason == valid {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,418 @@
+package db
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/arudzitis/addlib/isbn"
+	"github.com/arudzitis/addlib/openlibrary"
+)
+
+// BookDuplicates are books which appear to be the same book recorded more than once. The first book
+// is the suggested survivor of a merge: the one with the most copies, then the one added first.
+type BookDuplicates struct {
+	// Reasons say why the books were matched, such as "isbn 9780261102361" or "work /works/OL27448W".
+	Reasons []string
+
+	// Similar is set when some of the books were matched only as editions of the same work, or by
+	// nearly the same title or authors, so the match needs checking before the books are merged.
+	Similar bool
+
+	Books []Book
+}
+
+// AuthorDuplicates are authors which appear to be the same person recorded more than once. The first
+// author is the suggested survivor of a merge: one with an openlibrary record, then the one with the
+// most books, then the one added first.
+type AuthorDuplicates struct {
+	// Similar is set when the names are only nearly the same, rather than the same once case,
+	// accents, punctuation and name order are ignored.
+	Similar bool
+
+	Authors []AuthorSummary
+}
+
+// BookDuplicates finds the books still held which share an isbn in either form, are editions of the
+// same work, or have the same or nearly the same title and authors. A book with no authors recorded
+// may match a book with any.
+func (d DB) BookDuplicates() ([]BookDuplicates, error) {
+	books, err := d.FindBooks(BookFilter{})
+	if err != nil {
+		return nil, err
+	}
+
+	groups := newDuplicateSet(len(books))
+	byISBN, byWork := map[string][]int{}, map[string][]int{}
+	titles, credits := make([]string, len(books)), make([]string, len(books))
+	for i, book := range books {
+		for _, isbns := range []*string{book.ISBN13, book.ISBN10} {
+			if isbns == nil {
+				continue
+			}
+			for _, value := range strings.Split(*isbns, isbnSeparator) {
+				key := isbn.Key(value)
+				if key != "" && !containsIndex(byISBN[key], i) {
+					byISBN[key] = append(byISBN[key], i)
+				}
+			}
+		}
+		for _, work := range book.Works {
+			byWork[work.OLID] = append(byWork[work.OLID], i)
+		}
+		titles[i], credits[i] = titleKey(book.Title), creditKey(book)
+	}
+
+	// firm matches are joined first, so that groups they make are not marked similar by a weaker
+	// match found between the same books
+	type match struct {
+		a, b   int
+		reason string
+	}
+	similarTitles := []match{}
+	for _, key := range sortedKeys(byISBN) {
+		groups.join(byISBN[key], "isbn "+key, false)
+	}
+	for a := 0; a < len(books); a++ {
+		if titles[a] == "" {
+			continue
+		}
+		for b := a + 1; b < len(books); b++ {
+			reason, similar := "title", false
+			if titles[a] != titles[b] {
+				if !nearlyEqual(titles[a], titles[b]) {
+					continue
+				}
+				reason, similar = "similar title", true
+			}
+
+			switch {
+			case credits[a] == credits[b]:
+			case credits[a] == "" || credits[b] == "":
+				reason, similar = reason+", missing authors", true
+			case nearlyEqual(credits[a], credits[b]):
+				reason, similar = reason+", similar authors", true
+			default:
+				continue
+			}
+			if similar {
+				similarTitles = append(similarTitles, match{a, b, reason})
+			} else {
+				groups.join([]int{a, b}, reason, false)
+			}
+		}
+	}
+	// editions of one work can be translations, abridgements or other distinct books
+	for _, key := range sortedKeys(byWork) {
+		groups.join(byWork[key], "work "+key, true)
+	}
+	for _, m := range similarTitles {
+		groups.join([]int{m.a, m.b}, m.reason, true)
+	}
+
+	duplicates := []BookDuplicates{}
+	for _, group := range groups.groups() {
+		duplicate := BookDuplicates{Reasons: group.reasons, Similar: group.similar}
+		for _, i := range group.members {
+			duplicate.Books = append(duplicate.Books, books[i])
+		}
+		sort.SliceStable(duplicate.Books, func(i, j int) bool {
+			a, b := duplicate.Books[i], duplicate.Books[j]
+			if len(a.Copies) != len(b.Copies) {
+				return len(a.Copies) > len(b.Copies)
+			}
+			return a.ID < b.ID
+		})
+		duplicates = append(duplicates, duplicate)
+	}
+	return duplicates, nil
+}
+
+// AuthorDuplicates finds the authors whose names are the same, or nearly the same, once case,
+// accents, punctuation and name order are ignored.
+func (d DB) AuthorDuplicates() ([]AuthorDuplicates, error) {
+	authors, err := d.Authors()
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, len(authors))
+	for i, author := range authors {
+		keys[i] = nameKey(author.SortName())
+	}
+
+	groups := newDuplicateSet(len(authors))
+	for a := 0; a < len(authors); a++ {
+		if keys[a] == "" {
+			continue
+		}
+		for b := a + 1; b < len(authors); b++ {
+			if keys[a] == keys[b] {
+				groups.join([]int{a, b}, "name", false)
+			} else if nearlyEqual(keys[a], keys[b]) {
+				groups.join([]int{a, b}, "similar name", true)
+			}
+		}
+	}
+
+	duplicates := []AuthorDuplicates{}
+	for _, group := range groups.groups() {
+		duplicate := AuthorDuplicates{Similar: group.similar}
+		for _, i := range group.members {
+			duplicate.Authors = append(duplicate.Authors, authors[i])
+		}
+		sort.SliceStable(duplicate.Authors, func(i, j int) bool {
+			a, b := duplicate.Authors[i], duplicate.Authors[j]
+			if openlibrary.IsLocalAuthorKey(a.OLID) != openlibrary.IsLocalAuthorKey(b.OLID) {
+				return !openlibrary.IsLocalAuthorKey(a.OLID)
+			}
+			if a.Books != b.Books {
+				return a.Books > b.Books
+			}
+			return a.ID < b.ID
+		})
+		duplicates = append(duplicates, duplicate)
+	}
+	return duplicates, nil
+}
+
+// duplicateSet joins records into groups of duplicates, remembering why they were joined.
+type duplicateSet struct {
+	parent  []int
+	reasons map[int][]string
+	similar map[int]bool
+}
+
+type duplicateGroup struct {
+	members []int
+	reasons []string
+	similar bool
+}
+
+func newDuplicateSet(size int) *duplicateSet {
+	parent := make([]int, size)
+	for i := range parent {
+		parent[i] = i
+	}
+	return &duplicateSet{parent: parent, reasons: map[int][]string{}, similar: map[int]bool{}}
+}
+
+func (s *duplicateSet) find(i int) int {
+	for s.parent[i] != i {
+		s.parent[i] = s.parent[s.parent[i]]
+		i = s.parent[i]
+	}
+	return i
+}
+
+// join puts the records in one group. A similar match is only recorded if the records were not
+// already grouped by a firmer one.
+func (s *duplicateSet) join(members []int, reason string, similar bool) {
+	if len(members) < 2 {
+		return
+	}
+
+	root := s.find(members[0])
+	joined := false
+	for _, member := range members[1:] {
+		other := s.find(member)
+		if other == root {
+			continue
+		}
+		joined = true
+		s.parent[other] = root
+		for _, otherReason := range s.reasons[other] {
+			s.addReason(root, otherReason)
+		}
+		s.similar[root] = s.similar[root] || s.similar[other]
+		delete(s.reasons, other)
+		delete(s.similar, other)
+	}
+	if similar && !joined {
+		return
+	}
+
+	s.similar[root] = s.similar[root] || similar
+	s.addReason(root, reason)
+}
+
+func (s *duplicateSet) addReason(root int, reason string) {
+	for _, existing := range s.reasons[root] {
+		if existing == reason {
+			return
+		}
+	}
+	s.reasons[root] = append(s.reasons[root], reason)
+}
+
+// groups returns the groups of more than one record, in the order of their first member.
+func (s *duplicateSet) groups() []duplicateGroup {
+	byRoot := map[int]*duplicateGroup{}
+	groups := []*duplicateGroup{}
+	for i := range s.parent {
+		root := s.find(i)
+		group, ok := byRoot[root]
+		if !ok {
+			group = &duplicateGroup{reasons: s.reasons[root], similar: s.similar[root]}
+			byRoot[root] = group
+			groups = append(groups, group)
+		}
+		group.members = append(group.members, i)
+	}
+
+	result := []duplicateGroup{}
+	for _, group := range groups {
+		if len(group.members) > 1 {
+			result = append(result, *group)
+		}
+	}
+	return result
+}
+
+// creditKey identifies the primary authors of a book, or all its contributors if it has no primary
+// author, regardless of their order or how their names are written.
+func creditKey(book Book) string {
+	names := []string{}
+	for _, credit := range book.Credits {
+		if credit.Role == openlibrary.RoleAuthor {
+			names = append(names, nameKey(credit.Author.SortName()))
+		}
+	}
+	if len(names) == 0 {
+		for _, credit := range book.Credits {
+			names = append(names, nameKey(credit.Author.SortName()))
+		}
+	}
+	sort.Strings(names)
+	return strings.Join(names, "|")
+}
+
+// leadingArticles are dropped from the start of titles before they are compared.
+var leadingArticles = map[string]bool{"a": true, "an": true, "the": true}
+
+// titleKey normalizes a title for comparison: the subtitle, a leading article, case, accents and
+// punctuation are ignored.
+func titleKey(title string) string {
+	title, _, _ = strings.Cut(title, ":")
+	words := strings.Fields(foldText(title))
+	if len(words) > 1 && leadingArticles[words[0]] {
+		words = words[1:]
+	}
+	return strings.Join(words, " ")
+}
+
+// nameKey normalizes a name for comparison, ignoring case, accents, spacing and punctuation.
+func nameKey(name string) string {
+	return strings.ReplaceAll(foldText(name), " ", "")
+}
+
+// diacritics maps accented latin letters onto the letters they are written without.
+var diacritics = map[rune]string{}
+
+func init() {
+	for base, accented := range map[string]string{
+		"a": "àáâãäåāăą", "c": "çćĉċč", "d": "ďđð", "e": "èéêëēĕėęě", "g": "ĝğġģ", "h": "ĥħ",
+		"i": "ìíîïĩīĭįı", "j": "ĵ", "k": "ķ", "l": "ĺļľŀł", "n": "ñńņňŉ", "o": "òóôõöøōŏő",
+		"r": "ŕŗř", "s": "śŝşšș", "t": "ţťŧț", "u": "ùúûüũūŭůűų", "w": "ŵ", "y": "ýÿŷ", "z": "źżž",
+		"ss": "ß", "ae": "æ", "oe": "œ", "th": "þ",
+	} {
+		for _, r := range accented {
+			diacritics[r] = base
+		}
+	}
+}
+
+// foldText lowercases text, strips accents and turns everything but letters and digits into single
+// spaces.
+func foldText(text string) string {
+	var folded strings.Builder
+	space := true
+	for _, r := range strings.ToLower(text) {
+		switch {
+		case diacritics[r] != "":
+			folded.WriteString(diacritics[r])
+			space = false
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			folded.WriteRune(r)
+			space = false
+		case unicode.Is(unicode.Mn, r):
+			// combining accents
+		case !space:
+			folded.WriteRune(' ')
+			space = true
+		}
+	}
+	return strings.TrimSpace(folded.String())
+}
+
+// nearlyEqual reports whether two normalized keys differ by no more than a typo or two: one edit in
+// up to ten characters and another for every ten after that. Keys shorter than five characters must
+// match exactly.
+func nearlyEqual(a string, b string) bool {
+	ra, rb := []rune(a), []rune(b)
+	shorter := len(ra)
+	if len(rb) < shorter {
+		shorter = len(rb)
+	}
+	if shorter < 5 {
+		return a == b
+	}
+
+	allowed := 1 + (shorter-1)/10
+	if len(ra)-len(rb) > allowed || len(rb)-len(ra) > allowed {
+		return false
+	}
+	return editDistance(ra, rb) <= allowed
+}
+
+// editDistance counts the insertions, deletions, substitutions and transpositions of adjacent
+// characters which turn a into b.
+func editDistance(a []rune, b []rune) int {
+	rows := make([][]int, len(a)+1)
+	for i := range rows {
+		rows[i] = make([]int, len(b)+1)
+		rows[i][0] = i
+	}
+	for j := range rows[0] {
+		rows[0][j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			best := rows[i-1][j-1] + cost
+			if rows[i-1][j]+1 < best {
+				best = rows[i-1][j] + 1
+			}
+			if rows[i][j-1]+1 < best {
+				best = rows[i][j-1] + 1
+			}
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] && rows[i-2][j-2]+1 < best {
+				best = rows[i-2][j-2] + 1
+			}
+			rows[i][j] = best
+		}
+	}
+	return rows[len(a)][len(b)]
+}
+
+func containsIndex(indexes []int, i int) bool {
+	for _, index := range indexes {
+		if index == i {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys(m map[string][]int) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
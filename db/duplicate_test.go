@@ -0,0 +1,113 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/arudzitis/addlib/openlibrary"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBookDuplicates(t *testing.T) {
+	tolkien := openlibrary.Author{OLID: "olid-tolkien", Name: "J.R.R. Tolkien"}
+	herbert := openlibrary.Author{OLID: "olid-herbert", Name: "Frank Herbert"}
+	work := openlibrary.Work{Key: "olid-dune", Title: "Dune"}
+
+	db := openTestDatabase(t)
+	defer db.Close()
+
+	for _, book := range []openlibrary.Book{
+		// the same isbn in its ten and thirteen digit forms
+		{OLID: "olid-hobbit1", Title: "The Hobbit", Isbn10: []string{"0261102362"}, Authors: []openlibrary.Author{tolkien}},
+		{OLID: "olid-hobbit2", Title: "Hobbit", Isbn13: []string{"978-0-261-10236-1"}},
+		// the same title ignoring the article, subtitle and accents
+		{OLID: "olid-silm1", Title: "The Silmarillion", Authors: []openlibrary.Author{tolkien}},
+		{OLID: "olid-silm2", Title: "Silmarillión: a history", Authors: []openlibrary.Author{tolkien}},
+		// nearly the same title
+		{OLID: "olid-tales1", Title: "Unfinished Tales", Authors: []openlibrary.Author{tolkien}},
+		{OLID: "olid-tales2", Title: "Unfinshed Tales", Authors: []openlibrary.Author{tolkien}},
+		// the same work
+		{OLID: "olid-dune1", Title: "Dune", Authors: []openlibrary.Author{herbert}, Works: []openlibrary.Work{work}},
+		{OLID: "olid-dune2", Title: "Dune (40th anniversary)", Works: []openlibrary.Work{work}},
+		// similar titles by different authors are not duplicates
+		{OLID: "olid-dune3", Title: "Dune", Authors: []openlibrary.Author{tolkien}},
+		// the same title with a misspelled author
+		{OLID: "olid-towers1", Title: "The Two Towers", Authors: []openlibrary.Author{tolkien}},
+		{OLID: "olid-towers2", Title: "The Two Towers", Authors: []openlibrary.Author{{OLID: "olid-tolkein", Name: "J.R.R. Tolkein"}}},
+		// the same title with no author recorded
+		{OLID: "olid-sign1", Title: "The Sign of Four", Authors: []openlibrary.Author{{OLID: "olid-doyle", Name: "Arthur Conan Doyle"}}},
+		{OLID: "olid-sign2", Title: "Sign of Four"},
+	} {
+		require.NoError(t, db.InsertRecord(book))
+	}
+	_, err := db.AddCopy(openlibrary.Book{OLID: "olid-hobbit2"}, Copy{Accession: "A1"})
+	require.NoError(t, err)
+
+	duplicates, err := db.BookDuplicates()
+	require.NoError(t, err)
+	require.Len(t, duplicates, 6)
+
+	olids := func(duplicate BookDuplicates) []string {
+		result := []string{}
+		for _, book := range duplicate.Books {
+			result = append(result, book.OLID)
+		}
+		return result
+	}
+
+	// the book with copies is suggested as the survivor
+	assert.Equal(t, []string{"olid-hobbit2", "olid-hobbit1"}, olids(duplicates[0]))
+	assert.Equal(t, []string{"isbn 9780261102361"}, duplicates[0].Reasons)
+	assert.False(t, duplicates[0].Similar)
+
+	assert.Equal(t, []string{"olid-silm1", "olid-silm2"}, olids(duplicates[1]))
+	assert.Equal(t, []string{"title"}, duplicates[1].Reasons)
+	assert.False(t, duplicates[1].Similar)
+
+	assert.Equal(t, []string{"olid-tales1", "olid-tales2"}, olids(duplicates[2]))
+	assert.Equal(t, []string{"similar title"}, duplicates[2].Reasons)
+	assert.True(t, duplicates[2].Similar)
+
+	assert.Equal(t, []string{"olid-dune1", "olid-dune2"}, olids(duplicates[3]))
+	assert.Equal(t, []string{"work olid-dune"}, duplicates[3].Reasons)
+	assert.True(t, duplicates[3].Similar)
+
+	assert.Equal(t, []string{"olid-towers1", "olid-towers2"}, olids(duplicates[4]))
+	assert.Equal(t, []string{"title, similar authors"}, duplicates[4].Reasons)
+	assert.True(t, duplicates[4].Similar)
+
+	assert.Equal(t, []string{"olid-sign1", "olid-sign2"}, olids(duplicates[5]))
+	assert.Equal(t, []string{"title, missing authors"}, duplicates[5].Reasons)
+	assert.True(t, duplicates[5].Similar)
+}
+
+func TestAuthorDuplicates(t *testing.T) {
+	db := openTestDatabase(t)
+	defer db.Close()
+
+	for i, author := range []openlibrary.Author{
+		{OLID: openlibrary.LocalAuthorKey("Gabriel Garcia Marquez"), Name: "Gabriel Garcia Marquez"},
+		{OLID: "olid-marquez", Name: "Gabriel García Márquez"},
+		{OLID: "olid-tolkien1", Name: "J. R. R. Tolkien"},
+		{OLID: "olid-tolkien2", Name: "Tolkein, J.R.R."},
+		{OLID: "olid-lewis", Name: "C. S. Lewis"},
+		{OLID: "olid-forester", Name: "C. S. Forester"},
+	} {
+		book := openlibrary.Book{OLID: "olid-book" + string(rune('a'+i)), Title: "Book", Authors: []openlibrary.Author{author}}
+		require.NoError(t, db.InsertRecord(book))
+	}
+
+	duplicates, err := db.AuthorDuplicates()
+	require.NoError(t, err)
+	require.Len(t, duplicates, 2)
+
+	// the author with an openlibrary record is suggested as the survivor
+	require.Len(t, duplicates[0].Authors, 2)
+	assert.Equal(t, "olid-marquez", duplicates[0].Authors[0].OLID)
+	assert.False(t, duplicates[0].Similar)
+
+	require.Len(t, duplicates[1].Authors, 2)
+	assert.Equal(t, "olid-tolkien1", duplicates[1].Authors[0].OLID)
+	assert.Equal(t, "olid-tolkien2", duplicates[1].Authors[1].OLID)
+	assert.True(t, duplicates[1].Similar)
+}
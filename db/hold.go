@@ -0,0 +1,362 @@
+package db
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/arudzitis/addlib/openlibrary"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// FieldHoldStatus records a hold moving through the queue, with its old and new status.
+const FieldHoldStatus = "status"
+
+// reservedCopiesQuery selects the ids of the copies kept for patrons whose holds are ready.
+const reservedCopiesQuery = "SELECT copy_id FROM holds WHERE status = '" + HoldStatusReady + "' AND copy_id IS NOT NULL"
+
+// activeHoldStatuses are the statuses of holds still in the queue.
+var activeHoldStatuses = []string{HoldStatusWaiting, HoldStatusReady}
+
+// HoldFilter restricts the holds returned by Holds. The zero value matches every hold.
+type HoldFilter struct {
+	BookID   *int64
+	PatronID *int64
+	// Active matches only holds which are waiting or ready.
+	Active bool
+}
+
+// PlaceHold queues a patron for a book. If a copy is on the shelf it is reserved for them straight
+// away.
+func (d DB) PlaceHold(book openlibrary.Book, patron Patron) (*Hold, error) {
+	hold := &Hold{}
+	err := d.db.Transaction(func(tx *gorm.DB) error {
+		if patron.Status != PatronStatusActive {
+			return fmt.Errorf("patron %s is %s", patron.Barcode, patron.Status)
+		}
+
+		ormBook, err := readBookTx(tx, book.OLID)
+		if err != nil {
+			return err
+		}
+		if ormBook == nil {
+			return fmt.Errorf("no book with olid %s: %w", book.OLID, ErrNotFound)
+		}
+		if ormBook.DeaccessionedOn != nil {
+			return fmt.Errorf("%s has been deaccessioned", ormBook.Title)
+		}
+
+		var existing int64
+		err = tx.Model(&Hold{}).Where("book_id = ? AND patron_id = ? AND status IN ?", ormBook.ID, patron.ID, activeHoldStatuses).Count(&existing).Error
+		if err != nil {
+			return err
+		}
+		if existing > 0 {
+			return fmt.Errorf("patron %s already has a hold on %s", patron.Barcode, ormBook.Title)
+		}
+
+		hold = &Hold{
+			BookID:   ormBook.ID,
+			PatronID: patron.ID,
+			PlacedAt: tx.NowFunc(),
+			Status:   HoldStatusWaiting,
+		}
+		err = tx.Omit(clause.Associations).Create(hold).Error
+		if err != nil {
+			return err
+		}
+
+		err = d.recordChangeTx(tx, Change{Action: ActionCreate, Entity: EntityHold, EntityKey: strconv.FormatInt(hold.ID, 10), NewValue: &patron.Barcode})
+		if err != nil {
+			return err
+		}
+
+		_, err = d.reserveFreeCopyTx(tx, ormBook.ID)
+		if err != nil {
+			return err
+		}
+
+		return tx.Preload(clause.Associations).First(hold, hold.ID).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("db: error placing hold: %w", err)
+	}
+	return hold, nil
+}
+
+// CancelHold takes a hold out of the queue. If a copy was reserved for it, the copy passes to the
+// next patron waiting.
+func (d DB) CancelHold(id int64) (*Hold, error) {
+	var hold *Hold
+	err := d.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		hold, err = readHoldTx(tx, id)
+		if err != nil {
+			return err
+		}
+		if !hold.active() {
+			return fmt.Errorf("hold %d is already %s", id, hold.Status)
+		}
+
+		_, err = d.closeHoldTx(tx, hold, HoldStatusCancelled)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("db: error cancelling hold: %w", err)
+	}
+	return hold, nil
+}
+
+// ExpireHolds closes the ready holds whose patrons did not collect their copy by the pickup date,
+// passing each copy on to the next patron waiting. It returns the expired holds.
+func (d DB) ExpireHolds(asOf time.Time) ([]Hold, error) {
+	expired := []Hold{}
+	err := d.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Preload(clause.Associations).
+			Where("status = ? AND pickup_by < ?", HoldStatusReady, day(asOf)).
+			Order("id").
+			Find(&expired).Error
+		if err != nil {
+			return err
+		}
+
+		for i := range expired {
+			_, err = d.closeHoldTx(tx, &expired[i], HoldStatusExpired)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("db: error expiring holds: %w", err)
+	}
+	return expired, nil
+}
+
+// Holds returns the holds matching a filter, with their books, patrons and reserved copies, in queue
+// order for each book.
+func (d DB) Holds(filter HoldFilter) ([]Hold, error) {
+	query := d.db.Preload(clause.Associations).Order("book_id").Order("id")
+	if filter.BookID != nil {
+		query = query.Where("book_id = ?", *filter.BookID)
+	}
+	if filter.PatronID != nil {
+		query = query.Where("patron_id = ?", *filter.PatronID)
+	}
+	if filter.Active {
+		query = query.Where("status IN ?", activeHoldStatuses)
+	}
+
+	holds := []Hold{}
+	tx := query.Find(&holds)
+	if tx.Error != nil {
+		return nil, fmt.Errorf("db: error reading holds: %w", tx.Error)
+	}
+	return holds, nil
+}
+
+// reserveCopyTx keeps a copy which has become free for the oldest hold waiting on its book, making
+// that hold ready. It returns the hold, or nil if nobody is waiting or the copy is not free after all.
+func (d DB) reserveCopyTx(tx *gorm.DB, bookCopy Copy) (*Hold, error) {
+	if bookCopy.Status != CopyStatusAvailable {
+		return nil, nil
+	}
+
+	var busy int64
+	err := tx.Model(&Copy{}).
+		Where("id = ? AND (id IN ("+openLoansQuery+") OR id IN ("+reservedCopiesQuery+"))", bookCopy.ID).
+		Count(&busy).Error
+	if err != nil || busy > 0 {
+		return nil, err
+	}
+
+	hold := Hold{}
+	result := tx.Where("book_id = ? AND status = ?", bookCopy.BookID, HoldStatusWaiting).Order("id").Limit(1).Find(&hold)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return nil, result.Error
+	}
+
+	days, err := d.HoldPickupDays()
+	if err != nil {
+		return nil, err
+	}
+	pickupBy := day(tx.NowFunc()).AddDate(0, 0, days)
+
+	err = tx.Model(&hold).Updates(map[string]interface{}{
+		"status":    HoldStatusReady,
+		"copy_id":   bookCopy.ID,
+		"pickup_by": pickupBy,
+	}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	err = d.recordHoldStatusTx(tx, hold.ID, HoldStatusWaiting, HoldStatusReady)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Preload(clause.Associations).First(&hold, hold.ID).Error
+	if err != nil {
+		return nil, err
+	}
+	return &hold, nil
+}
+
+// reserveFreeCopyTx keeps the first copy of a book which is on the shelf, neither on loan nor kept for
+// somebody else, for the oldest hold waiting on the book. It returns the hold, or nil if there is no
+// such copy or nobody is waiting.
+func (d DB) reserveFreeCopyTx(tx *gorm.DB, bookID int64) (*Hold, error) {
+	bookCopy := Copy{}
+	result := tx.Where("book_id = ? AND status = ?", bookID, CopyStatusAvailable).
+		Where("id NOT IN (" + openLoansQuery + ") AND id NOT IN (" + reservedCopiesQuery + ")").
+		Order("id").Limit(1).Find(&bookCopy)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return nil, result.Error
+	}
+	return d.reserveCopyTx(tx, bookCopy)
+}
+
+// releaseCopyTx puts the ready hold a copy is kept for back to waiting, for a copy which is leaving
+// the collection. It reports whether there was such a hold.
+func (d DB) releaseCopyTx(tx *gorm.DB, copyID int64) (bool, error) {
+	hold := Hold{}
+	result := tx.Where("copy_id = ? AND status = ?", copyID, HoldStatusReady).Limit(1).Find(&hold)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return false, result.Error
+	}
+
+	err := tx.Model(&Hold{ID: hold.ID}).Updates(map[string]interface{}{"status": HoldStatusWaiting, "copy_id": nil, "pickup_by": nil}).Error
+	if err != nil {
+		return false, err
+	}
+	return true, d.recordHoldStatusTx(tx, hold.ID, HoldStatusReady, HoldStatusWaiting)
+}
+
+// cancelBookHoldsTx cancels the holds still in the queue for a book which is leaving the collection.
+// The waiting holds are cancelled first, so that the copies kept for ready holds are not passed on.
+func (d DB) cancelBookHoldsTx(tx *gorm.DB, bookID int64) (int64, error) {
+	var cancelled int64
+	for _, status := range []string{HoldStatusWaiting, HoldStatusReady} {
+		holds := []Hold{}
+		err := tx.Where("book_id = ? AND status = ?", bookID, status).Order("id").Find(&holds).Error
+		if err != nil {
+			return 0, err
+		}
+		for i := range holds {
+			_, err = d.closeHoldTx(tx, &holds[i], HoldStatusCancelled)
+			if err != nil {
+				return 0, err
+			}
+			cancelled++
+		}
+	}
+	return cancelled, nil
+}
+
+// closeHoldTx takes a hold out of the queue with the given status. A copy reserved for the hold is
+// passed on to the next patron waiting, whose hold is returned.
+func (d DB) closeHoldTx(tx *gorm.DB, hold *Hold, status string) (*Hold, error) {
+	oldStatus := hold.Status
+	reservedID := hold.CopyID
+
+	closedAt := tx.NowFunc()
+	if status != HoldStatusFulfilled {
+		hold.CopyID = nil
+		hold.Copy = nil
+	}
+	// the preloaded associations are left out so that they cannot write back the reserved copy
+	err := tx.Model(&Hold{ID: hold.ID}).Updates(map[string]interface{}{"status": status, "closed_at": closedAt, "copy_id": hold.CopyID}).Error
+	if err != nil {
+		return nil, err
+	}
+	hold.Status = status
+	hold.ClosedAt = &closedAt
+
+	err = d.recordHoldStatusTx(tx, hold.ID, oldStatus, status)
+	if err != nil {
+		return nil, err
+	}
+
+	if oldStatus != HoldStatusReady || reservedID == nil || status == HoldStatusFulfilled {
+		return nil, nil
+	}
+
+	bookCopy := Copy{}
+	err = tx.First(&bookCopy, *reservedID).Error
+	if err != nil {
+		return nil, err
+	}
+	return d.reserveCopyTx(tx, bookCopy)
+}
+
+func (d DB) recordHoldStatusTx(tx *gorm.DB, id int64, oldStatus string, newStatus string) error {
+	return d.recordChangeTx(tx, Change{
+		Action:    ActionUpdate,
+		Entity:    EntityHold,
+		EntityKey: strconv.FormatInt(id, 10),
+		Field:     FieldHoldStatus,
+		OldValue:  &oldStatus,
+		NewValue:  &newStatus,
+	})
+}
+
+// fulfilHoldsTx closes the hold of a patron borrowing a copy of a book. It fails if the copy is
+// reserved for somebody else.
+func (d DB) fulfilHoldsTx(tx *gorm.DB, bookCopy Copy, patron Patron) error {
+	reserved := Hold{}
+	result := tx.Preload("Patron").Where("copy_id = ? AND status = ?", bookCopy.ID, HoldStatusReady).Limit(1).Find(&reserved)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 1 && reserved.PatronID != patron.ID {
+		return fmt.Errorf("copy %s is reserved for %s until %s: %w", bookCopy.Accession, reserved.Patron.Barcode, reserved.PickupBy.Format("2006-01-02"), ErrUnavailable)
+	}
+
+	holds := []Hold{}
+	err := tx.Where("book_id = ? AND patron_id = ? AND status IN ?", bookCopy.BookID, patron.ID, activeHoldStatuses).Find(&holds).Error
+	if err != nil {
+		return err
+	}
+	for i := range holds {
+		kept := holds[i].CopyID
+		holds[i].CopyID = &bookCopy.ID
+		_, err = d.closeHoldTx(tx, &holds[i], HoldStatusFulfilled)
+		if err != nil {
+			return err
+		}
+
+		// a patron borrowing a different copy to the one kept for them frees the kept copy
+		if kept != nil && *kept != bookCopy.ID {
+			keptCopy := Copy{}
+			err = tx.First(&keptCopy, *kept).Error
+			if err != nil {
+				return err
+			}
+			_, err = d.reserveCopyTx(tx, keptCopy)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func readHoldTx(tx *gorm.DB, id int64) (*Hold, error) {
+	hold := Hold{}
+	result := tx.Preload(clause.Associations).Limit(1).Find(&hold, id)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, fmt.Errorf("no hold %d: %w", id, ErrNotFound)
+	}
+	return &hold, nil
+}
+
+func (h Hold) active() bool {
+	return h.Status == HoldStatusWaiting || h.Status == HoldStatusReady
+}
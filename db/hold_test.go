@@ -0,0 +1,196 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/arudzitis/addlib/openlibrary"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHolds(t *testing.T) {
+	book := openlibrary.Book{OLID: "olid-booka", Title: "Book A"}
+
+	db := openTestDatabase(t)
+	defer db.Close()
+
+	clock := time.Date(2023, time.May, 1, 15, 0, 0, 0, time.UTC)
+	db.db.Config.NowFunc = func() time.Time { return clock }
+
+	require.NoError(t, db.InsertRecord(book))
+	bookCopy, err := db.AddCopy(book, Copy{})
+	require.NoError(t, err)
+
+	patrons := []*Patron{}
+	for _, name := range []string{"Ada", "Charles", "Grace"} {
+		patron, err := db.AddPatron(Patron{Name: name})
+		require.NoError(t, err)
+		patrons = append(patrons, patron)
+	}
+	ada, charles, grace := *patrons[0], *patrons[1], *patrons[2]
+
+	_, err = db.Checkout(bookCopy.Accession, ada, nil)
+	require.NoError(t, err)
+
+	charlesHold, err := db.PlaceHold(book, charles)
+	require.NoError(t, err)
+	assert.Equal(t, HoldStatusWaiting, charlesHold.Status)
+	graceHold, err := db.PlaceHold(book, grace)
+	require.NoError(t, err)
+
+	_, err = db.PlaceHold(book, grace)
+	assert.Error(t, err, "a patron can only queue once for a book")
+
+	_, err = db.Renew(bookCopy.Accession, nil)
+	assert.Error(t, err, "loans cannot be renewed while others wait")
+
+	// the returned copy is kept for the first in the queue
+	_, hold, err := db.Checkin(bookCopy.Accession)
+	require.NoError(t, err)
+	require.NotNil(t, hold)
+	assert.Equal(t, charlesHold.ID, hold.ID)
+	assert.Equal(t, HoldStatusReady, hold.Status)
+	assert.Equal(t, "Charles", hold.Patron.Name)
+	assert.Equal(t, bookCopy.Accession, hold.Copy.Accession)
+	assert.Equal(t, time.Date(2023, time.May, 8, 0, 0, 0, 0, time.UTC), hold.PickupBy.UTC())
+
+	_, err = db.Checkout(bookCopy.Accession, grace, nil)
+	assert.ErrorIs(t, err, ErrUnavailable, "a kept copy is only lent to the patron it is kept for")
+	_, err = db.LendableCopy(book, grace)
+	assert.ErrorIs(t, err, ErrUnavailable)
+
+	// uncollected holds expire and the copy passes down the queue
+	expired, err := db.ExpireHolds(clock.AddDate(0, 0, 7))
+	require.NoError(t, err)
+	assert.Empty(t, expired, "holds may be collected on the pickup date")
+
+	clock = clock.AddDate(0, 0, 8)
+	expired, err = db.ExpireHolds(clock)
+	require.NoError(t, err)
+	require.Len(t, expired, 1)
+	assert.Equal(t, charlesHold.ID, expired[0].ID)
+	assert.Nil(t, expired[0].CopyID, "expired holds no longer keep a copy")
+
+	holds, err := db.Holds(HoldFilter{PatronID: &charles.ID})
+	require.NoError(t, err)
+	require.Len(t, holds, 1)
+	assert.Equal(t, HoldStatusExpired, holds[0].Status)
+	assert.Nil(t, holds[0].CopyID)
+
+	holds, err = db.Holds(HoldFilter{Active: true})
+	require.NoError(t, err)
+	require.Len(t, holds, 1)
+	assert.Equal(t, graceHold.ID, holds[0].ID)
+	assert.Equal(t, HoldStatusReady, holds[0].Status)
+
+	lendable, err := db.LendableCopy(book, grace)
+	require.NoError(t, err)
+	assert.Equal(t, bookCopy.Accession, lendable.Accession)
+
+	_, err = db.Checkout(bookCopy.Accession, grace, nil)
+	require.NoError(t, err)
+
+	holds, err = db.Holds(HoldFilter{PatronID: &grace.ID})
+	require.NoError(t, err)
+	require.Len(t, holds, 1)
+	assert.Equal(t, HoldStatusFulfilled, holds[0].Status)
+	require.NotNil(t, holds[0].ClosedAt)
+
+	// a hold placed while a copy is on the shelf is ready at once, and cancelling it frees the copy
+	_, _, err = db.Checkin(bookCopy.Accession)
+	require.NoError(t, err)
+	adaHold, err := db.PlaceHold(book, ada)
+	require.NoError(t, err)
+	assert.Equal(t, HoldStatusReady, adaHold.Status)
+	charlesHold, err = db.PlaceHold(book, charles)
+	require.NoError(t, err)
+	assert.Equal(t, HoldStatusWaiting, charlesHold.Status)
+
+	_, err = db.CancelHold(adaHold.ID)
+	require.NoError(t, err)
+	_, err = db.CancelHold(adaHold.ID)
+	assert.Error(t, err)
+
+	holds, err = db.Holds(HoldFilter{Active: true})
+	require.NoError(t, err)
+	require.Len(t, holds, 1)
+	assert.Equal(t, charlesHold.ID, holds[0].ID)
+	assert.Equal(t, HoldStatusReady, holds[0].Status)
+}
+
+func TestHoldsOnNewAndRestoredCopies(t *testing.T) {
+	book := openlibrary.Book{OLID: "olid-booka", Title: "Book A"}
+
+	db := openTestDatabase(t)
+	defer db.Close()
+
+	require.NoError(t, db.InsertRecord(book))
+	ada, err := db.AddPatron(Patron{Name: "Ada"})
+	require.NoError(t, err)
+	charles, err := db.AddPatron(Patron{Name: "Charles"})
+	require.NoError(t, err)
+
+	// a new copy is kept for the first in the queue
+	adaHold, err := db.PlaceHold(book, *ada)
+	require.NoError(t, err)
+	assert.Equal(t, HoldStatusWaiting, adaHold.Status)
+	bookCopy, err := db.AddCopy(book, Copy{})
+	require.NoError(t, err)
+
+	holds, err := db.Holds(HoldFilter{Active: true})
+	require.NoError(t, err)
+	require.Len(t, holds, 1)
+	assert.Equal(t, HoldStatusReady, holds[0].Status)
+	assert.Equal(t, bookCopy.Accession, holds[0].Copy.Accession)
+
+	// a copy recorded as missing and made available again is kept for the next in the queue
+	missing, err := db.AddCopy(book, Copy{})
+	require.NoError(t, err)
+	_, err = db.updateColumn(EntityCopy, missing.Accession, "status", CopyStatusMissing)
+	require.NoError(t, err)
+	charlesHold, err := db.PlaceHold(book, *charles)
+	require.NoError(t, err)
+	assert.Equal(t, HoldStatusWaiting, charlesHold.Status)
+
+	changes, err := db.History(missing.Accession)
+	require.NoError(t, err)
+	require.NoError(t, db.Undo(changes[len(changes)-1].ID))
+
+	holds, err = db.Holds(HoldFilter{PatronID: &charles.ID})
+	require.NoError(t, err)
+	require.Len(t, holds, 1)
+	assert.Equal(t, HoldStatusReady, holds[0].Status)
+	assert.Equal(t, missing.Accession, holds[0].Copy.Accession)
+
+	// removing a kept copy passes its hold another copy on the shelf, or puts it back to waiting
+	spare, err := db.AddCopy(book, Copy{})
+	require.NoError(t, err)
+	_, err = db.RemoveCopy(bookCopy.Accession)
+	require.NoError(t, err)
+	holds, err = db.Holds(HoldFilter{PatronID: &ada.ID})
+	require.NoError(t, err)
+	require.Len(t, holds, 1)
+	assert.Equal(t, HoldStatusReady, holds[0].Status)
+	assert.Equal(t, spare.Accession, holds[0].Copy.Accession)
+
+	_, err = db.RemoveCopy(spare.Accession)
+	require.NoError(t, err)
+	holds, err = db.Holds(HoldFilter{PatronID: &ada.ID})
+	require.NoError(t, err)
+	require.Len(t, holds, 1)
+	assert.Equal(t, HoldStatusWaiting, holds[0].Status)
+	assert.Nil(t, holds[0].CopyID)
+	assert.Nil(t, holds[0].PickupBy)
+
+	// holds on a book leaving the collection are cancelled
+	_, err = db.DeaccessionBook(book, Deaccession{Reason: DeaccessionWithdrawn})
+	require.NoError(t, err)
+	holds, err = db.Holds(HoldFilter{})
+	require.NoError(t, err)
+	require.Len(t, holds, 2)
+	for _, hold := range holds {
+		assert.Equal(t, HoldStatusCancelled, hold.Status)
+		assert.Nil(t, hold.CopyID)
+	}
+}
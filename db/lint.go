@@ -0,0 +1,396 @@
+package db
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/arudzitis/addlib/isbn"
+
+	"gorm.io/gorm"
+)
+
+// Severities of lint problems.
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// LintRule checks the catalogue for one kind of problem, and can correct the problems it finds if it
+// is Fixable.
+type LintRule struct {
+	ID          string
+	Severity    string
+	Description string
+	Fixable     bool
+
+	check func(d DB) ([]LintProblem, error)
+}
+
+// LintProblem is a problem found by a LintRule with a book, author or credit, identified by Entity
+// and Key.
+type LintProblem struct {
+	Rule     string
+	Severity string
+	Entity   string
+	Key      string
+	Message  string
+
+	// Fixed is set once Lint has corrected the problem.
+	Fixed bool
+
+	fix func(tx *gorm.DB) error
+}
+
+// LintRules are the checks Lint makes, in the order it makes them. Credits are checked before
+// authors, since removing an orphaned credit can leave an author with no books.
+var LintRules = []LintRule{
+	{ID: "isbn-checksum", Severity: SeverityError, Description: "isbns with the wrong length or check digit", check: lintISBNChecksums},
+	{ID: "isbn-mismatch", Severity: SeverityWarning, Description: "books whose isbn-10s and isbn-13s are of different editions", check: lintISBNMismatches},
+	{ID: "missing-authors", Severity: SeverityWarning, Description: "books credited to no one", check: lintMissingAuthors},
+	{ID: "empty-author-name", Severity: SeverityError, Description: "authors with no name, such as those saved from unresolved openlibrary redirects", check: lintEmptyAuthorNames},
+	{ID: "title-caps", Severity: SeverityWarning, Description: "titles written entirely in capitals", Fixable: true, check: lintTitleCaps},
+	{ID: "title-punctuation", Severity: SeverityWarning, Description: "titles ending in stray punctuation left over from catalogue records; full stops, which may end abbreviations, are reported but not fixed", Fixable: true, check: lintTitlePunctuation},
+	{ID: "orphan-credit", Severity: SeverityError, Description: "credits of books or authors which no longer exist", Fixable: true, check: lintOrphanCredits},
+	{ID: "unused-author", Severity: SeverityWarning, Description: "authors credited with no books", Fixable: true, check: lintUnusedAuthors},
+}
+
+// Lint checks the catalogue, including deaccessioned books, against the rules with the given ids, or
+// every rule if none are given. If fix is set, the problems found by fixable rules are corrected
+// as they are found, and marked Fixed.
+func (d DB) Lint(ruleIDs []string, fix bool) ([]LintProblem, error) {
+	rules := LintRules
+	if len(ruleIDs) > 0 {
+		rules = []LintRule{}
+		for _, id := range ruleIDs {
+			rule, ok := findLintRule(id)
+			if !ok {
+				return nil, fmt.Errorf("db: no lint rule %q: %w", id, ErrNotFound)
+			}
+			rules = append(rules, rule)
+		}
+	}
+
+	problems := []LintProblem{}
+	for _, rule := range rules {
+		found, err := rule.check(d)
+		if err != nil {
+			return nil, fmt.Errorf("db: error checking %s: %w", rule.ID, err)
+		}
+
+		for i := range found {
+			found[i].Rule = rule.ID
+			found[i].Severity = rule.Severity
+			if !fix || found[i].fix == nil {
+				continue
+			}
+
+			err = d.db.Transaction(found[i].fix)
+			if err != nil {
+				return nil, fmt.Errorf("db: error fixing %s of %s %s: %w", rule.ID, found[i].Entity, found[i].Key, err)
+			}
+			found[i].Fixed = true
+		}
+		problems = append(problems, found...)
+	}
+	return problems, nil
+}
+
+func findLintRule(id string) (LintRule, bool) {
+	for _, rule := range LintRules {
+		if rule.ID == id {
+			return rule, true
+		}
+	}
+	return LintRule{}, false
+}
+
+func lintBooks(tx *gorm.DB) ([]Book, error) {
+	books := []Book{}
+	err := tx.Preload("Credits").Order("id").Find(&books).Error
+	return books, err
+}
+
+func lintISBNChecksums(d DB) ([]LintProblem, error) {
+	books, err := lintBooks(d.db)
+	if err != nil {
+		return nil, err
+	}
+
+	problems := []LintProblem{}
+	for _, book := range books {
+		for _, column := range []struct {
+			name   string
+			values *string
+			length int
+		}{
+			{"isbn13", book.ISBN13, 13},
+			{"isbn10", book.ISBN10, 10},
+		} {
+			if column.values == nil {
+				continue
+			}
+			for _, value := range strings.Split(*column.values, isbnSeparator) {
+				if len(isbn.Clean(value)) != column.length || !isbn.Valid(value) {
+					problems = append(problems, LintProblem{
+						Entity:  EntityBook,
+						Key:     book.OLID,
+						Message: fmt.Sprintf("%s %q is not a valid isbn", column.name, value),
+					})
+				}
+			}
+		}
+	}
+	return problems, nil
+}
+
+func lintISBNMismatches(d DB) ([]LintProblem, error) {
+	books, err := lintBooks(d.db)
+	if err != nil {
+		return nil, err
+	}
+
+	problems := []LintProblem{}
+	for _, book := range books {
+		if book.ISBN10 == nil || book.ISBN13 == nil {
+			continue
+		}
+
+		isbn13s := map[string]bool{}
+		for _, value := range strings.Split(*book.ISBN13, isbnSeparator) {
+			if isbn.Valid(value) {
+				isbn13s[isbn.Key(value)] = true
+			}
+		}
+
+		// a book may carry the isbns of several bindings, so only a book none of whose isbn-10s
+		// correspond to one of its isbn-13s is a mismatch
+		checked, matched := 0, false
+		for _, value := range strings.Split(*book.ISBN10, isbnSeparator) {
+			if isbn.Valid(value) {
+				checked++
+				matched = matched || isbn13s[isbn.Key(value)]
+			}
+		}
+		if checked > 0 && len(isbn13s) > 0 && !matched {
+			problems = append(problems, LintProblem{
+				Entity:  EntityBook,
+				Key:     book.OLID,
+				Message: fmt.Sprintf("isbn10 %s does not correspond to isbn13 %s", *book.ISBN10, *book.ISBN13),
+			})
+		}
+	}
+	return problems, nil
+}
+
+func lintMissingAuthors(d DB) ([]LintProblem, error) {
+	books, err := lintBooks(d.db)
+	if err != nil {
+		return nil, err
+	}
+
+	problems := []LintProblem{}
+	for _, book := range books {
+		if len(book.Credits) == 0 {
+			problems = append(problems, LintProblem{
+				Entity:  EntityBook,
+				Key:     book.OLID,
+				Message: fmt.Sprintf("%q has no authors", book.Title),
+			})
+		}
+	}
+	return problems, nil
+}
+
+func lintEmptyAuthorNames(d DB) ([]LintProblem, error) {
+	authors := []Author{}
+	err := d.db.Where("TRIM(name) = ''").Order("id").Find(&authors).Error
+	if err != nil {
+		return nil, err
+	}
+
+	problems := []LintProblem{}
+	for _, author := range authors {
+		problems = append(problems, LintProblem{
+			Entity:  EntityAuthor,
+			Key:     author.OLID,
+			Message: "author has no name; refresh fetches names from openlibrary",
+		})
+	}
+	return problems, nil
+}
+
+// romanNumeralPattern matches the numerals of volumes and parts, such as "II" or "XIV", which stay
+// in capitals when a title is recased.
+var romanNumeralPattern = regexp.MustCompile(`^(X{0,3})(IX|IV|V?I{0,3})$`)
+
+// minorTitleWords are left in lower case within a title.
+var minorTitleWords = map[string]bool{
+	"a": true, "an": true, "and": true, "as": true, "at": true, "but": true, "by": true, "for": true,
+	"from": true, "in": true, "into": true, "nor": true, "of": true, "on": true, "or": true,
+	"the": true, "to": true, "with": true,
+}
+
+func lintTitleCaps(d DB) ([]LintProblem, error) {
+	books, err := lintBooks(d.db)
+	if err != nil {
+		return nil, err
+	}
+
+	problems := []LintProblem{}
+	for _, book := range books {
+		if !allCaps(book.Title) {
+			continue
+		}
+
+		olid, title := book.OLID, titleCase(book.Title)
+		problems = append(problems, LintProblem{
+			Entity:  EntityBook,
+			Key:     olid,
+			Message: fmt.Sprintf("%q is in capitals; should be %q", book.Title, title),
+			fix: func(tx *gorm.DB) error {
+				_, err := d.updateColumnTx(tx, EntityBook, olid, "title", title)
+				return err
+			},
+		})
+	}
+	return problems, nil
+}
+
+// allCaps reports whether a title of more than one letter has no lower case letters.
+func allCaps(title string) bool {
+	letters := 0
+	for _, r := range title {
+		if unicode.IsLower(r) {
+			return false
+		}
+		if unicode.IsUpper(r) {
+			letters++
+		}
+	}
+	return letters > 1
+}
+
+// titleCase recases a title written in capitals, such as "THE RETURN OF THE KING" to "The Return of
+// the King". Roman numerals stay in capitals.
+func titleCase(title string) string {
+	words := strings.Fields(title)
+	for i, word := range words {
+		if len(word) > 1 && romanNumeralPattern.MatchString(word) {
+			continue
+		}
+
+		lower := []rune(strings.ToLower(word))
+		if i > 0 && minorTitleWords[string(lower)] && !strings.HasSuffix(words[i-1], ":") {
+			words[i] = string(lower)
+			continue
+		}
+		for j, r := range lower {
+			if unicode.IsLetter(r) {
+				lower[j] = unicode.ToUpper(r)
+				break
+			}
+		}
+		words[i] = string(lower)
+	}
+	return strings.Join(words, " ")
+}
+
+// strayPunctuationPattern matches the punctuation left at the end of titles copied from catalogue
+// records, such as "The hobbit /".
+var strayPunctuationPattern = regexp.MustCompile(`\s*[,;:/=][\s,;:/=.]*$|\s+$`)
+
+// trailingPeriodPattern matches a title ending in a full stop, but not an ellipsis. The full stop may
+// be stray, or may end an abbreviation such as "Inc." or "Jr.", so it is only reported.
+var trailingPeriodPattern = regexp.MustCompile(`[^.]\.$`)
+
+func lintTitlePunctuation(d DB) ([]LintProblem, error) {
+	books, err := lintBooks(d.db)
+	if err != nil {
+		return nil, err
+	}
+
+	problems := []LintProblem{}
+	for _, book := range books {
+		if !strayPunctuationPattern.MatchString(book.Title) {
+			if trailingPeriodPattern.MatchString(book.Title) {
+				problems = append(problems, LintProblem{
+					Entity:  EntityBook,
+					Key:     book.OLID,
+					Message: fmt.Sprintf("%q ends in a full stop; remove it unless it ends an abbreviation", book.Title),
+				})
+			}
+			continue
+		}
+
+		olid, title := book.OLID, strayPunctuationPattern.ReplaceAllString(book.Title, "")
+		problems = append(problems, LintProblem{
+			Entity:  EntityBook,
+			Key:     olid,
+			Message: fmt.Sprintf("%q ends in punctuation; should be %q", book.Title, title),
+			fix: func(tx *gorm.DB) error {
+				_, err := d.updateColumnTx(tx, EntityBook, olid, "title", title)
+				return err
+			},
+		})
+	}
+	return problems, nil
+}
+
+func lintOrphanCredits(d DB) ([]LintProblem, error) {
+	credits := []BookAuthor{}
+	err := d.db.Where("book_id NOT IN (SELECT id FROM books) OR author_id NOT IN (SELECT id FROM authors)").
+		Order("book_id, position").
+		Find(&credits).Error
+	if err != nil {
+		return nil, err
+	}
+
+	problems := []LintProblem{}
+	for _, credit := range credits {
+		credit := credit
+		key := creditEntityKey(credit.BookID, credit.AuthorID)
+		problems = append(problems, LintProblem{
+			Entity:  EntityCredit,
+			Key:     key,
+			Message: fmt.Sprintf("credit of author %d with book %d, one of which does not exist", credit.AuthorID, credit.BookID),
+			fix: func(tx *gorm.DB) error {
+				err := tx.Where("book_id = ? AND author_id = ?", credit.BookID, credit.AuthorID).Delete(&BookAuthor{}).Error
+				if err != nil {
+					return err
+				}
+				// the position and role are kept, so that Undo can restore the credit as it was
+				old := fmt.Sprintf("%d %s", credit.Position, credit.Role)
+				return d.recordChangeTx(tx, Change{Action: ActionDelete, Entity: EntityCredit, EntityKey: key, OldValue: &old})
+			},
+		})
+	}
+	return problems, nil
+}
+
+func lintUnusedAuthors(d DB) ([]LintProblem, error) {
+	authors := []Author{}
+	err := d.db.Where("id NOT IN (SELECT author_id FROM book_authors)").Order("id").Find(&authors).Error
+	if err != nil {
+		return nil, err
+	}
+
+	problems := []LintProblem{}
+	for _, author := range authors {
+		author := author
+		problems = append(problems, LintProblem{
+			Entity:  EntityAuthor,
+			Key:     author.OLID,
+			Message: fmt.Sprintf("%q is credited with no books", author.Name),
+			fix: func(tx *gorm.DB) error {
+				err := tx.Delete(&Author{}, author.ID).Error
+				if err != nil {
+					return err
+				}
+				return d.recordChangeTx(tx, Change{Action: ActionDelete, Entity: EntityAuthor, EntityKey: author.OLID, OldValue: &author.Name})
+			},
+		})
+	}
+	return problems, nil
+}
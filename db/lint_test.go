@@ -0,0 +1,87 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/arudzitis/addlib/openlibrary"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLint(t *testing.T) {
+	author := openlibrary.Author{OLID: "olid-authora", Name: "Author A"}
+
+	db := openTestDatabase(t)
+	defer db.Close()
+
+	for _, book := range []openlibrary.Book{
+		{OLID: "olid-booka", Title: "Book A", Isbn10: []string{"0261102362"}, Isbn13: []string{"9780261102361"}, Authors: []openlibrary.Author{author}},
+		{OLID: "olid-bookb", Title: "Book B", Isbn10: []string{"0261102362"}, Isbn13: []string{"9780441172719"}, Authors: []openlibrary.Author{author}},
+		{OLID: "olid-bookc", Title: "THE RETURN OF THE KING: PART II", Isbn13: []string{"9780261102362"}, Authors: []openlibrary.Author{author}},
+		{OLID: "olid-bookd", Title: "The hobbit, or, There and back again /"},
+		{OLID: "olid-booke", Title: "And then...", Authors: []openlibrary.Author{{OLID: "olid-authorb"}}},
+		{OLID: "olid-bookf", Title: "Everything you need to know about A.I.", Authors: []openlibrary.Author{author}},
+	} {
+		require.NoError(t, db.InsertRecord(book))
+	}
+	require.NoError(t, db.db.Create(&Author{OLID: "olid-unused", Name: "Unused"}).Error)
+	require.NoError(t, db.db.Create(&BookAuthor{BookID: 99, AuthorID: 1}).Error)
+
+	problems, err := db.Lint(nil, false)
+	require.NoError(t, err)
+
+	found := map[string][]string{}
+	for _, problem := range problems {
+		found[problem.Rule] = append(found[problem.Rule], problem.Key)
+		assert.False(t, problem.Fixed)
+	}
+	assert.Equal(t, map[string][]string{
+		"isbn-checksum":     {"olid-bookc"},
+		"isbn-mismatch":     {"olid-bookb"},
+		"missing-authors":   {"olid-bookd"},
+		"empty-author-name": {"olid-authorb"},
+		"title-caps":        {"olid-bookc"},
+		"title-punctuation": {"olid-bookd", "olid-bookf"},
+		"orphan-credit":     {"99/1"},
+		"unused-author":     {"olid-unused"},
+	}, found)
+
+	problems, err = db.Lint([]string{"title-caps", "title-punctuation", "orphan-credit", "unused-author", "isbn-checksum"}, true)
+	require.NoError(t, err)
+	require.Len(t, problems, 6)
+	for _, problem := range problems {
+		// full stops may end abbreviations, so are only reported
+		fixable := problem.Rule != "isbn-checksum" && problem.Key != "olid-bookf"
+		assert.Equal(t, fixable, problem.Fixed, problem.Rule)
+	}
+
+	books, err := db.FindBooks(BookFilter{})
+	require.NoError(t, err)
+	assert.Equal(t, "The Return of the King: Part II", books[2].Title)
+	assert.Equal(t, "The hobbit, or, There and back again", books[3].Title)
+	assert.Equal(t, "And then...", books[4].Title)
+	assert.Equal(t, "Everything you need to know about A.I.", books[5].Title)
+
+	problems, err = db.Lint([]string{"title-caps", "title-punctuation", "orphan-credit", "unused-author"}, false)
+	require.NoError(t, err)
+	require.Len(t, problems, 1)
+	assert.Equal(t, "olid-bookf", problems[0].Key)
+
+	changes, err := db.History("olid-unused")
+	require.NoError(t, err)
+	require.Len(t, changes, 1)
+	assert.Equal(t, ActionDelete, changes[0].Action)
+
+	// removed credits can be restored
+	changes, err = db.History("99/1")
+	require.NoError(t, err)
+	require.Len(t, changes, 1)
+	assert.Equal(t, EntityCredit, changes[0].Entity)
+	require.NoError(t, db.Undo(changes[0].ID))
+	var credits int64
+	require.NoError(t, db.db.Model(&BookAuthor{}).Where("book_id = 99 AND author_id = 1").Count(&credits).Error)
+	assert.Equal(t, int64(1), credits)
+
+	_, err = db.Lint([]string{"no-such-rule"}, false)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
@@ -0,0 +1,426 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/arudzitis/addlib/openlibrary"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// FieldReturned records a loan being closed, with the time the copy came back as its new value.
+	FieldReturned = "returned_at"
+
+	// FieldDue records a loan being renewed, with the old and new due dates.
+	FieldDue = "due_on"
+
+	// FieldAnonymized records a loan being anonymized, with the time as its new value.
+	FieldAnonymized = "anonymized_at"
+)
+
+// ErrUnavailable is returned when a copy cannot be lent.
+var ErrUnavailable = errors.New("db: copy is not available")
+
+// FindCopy looks up a copy by its accession number.
+func (d DB) FindCopy(accession string) (*Copy, error) {
+	bookCopy, err := readCopyTx(d.db, accession)
+	if err != nil {
+		return nil, fmt.Errorf("db: %w", err)
+	}
+	return bookCopy, nil
+}
+
+// LendableCopy returns the copy of a book to lend to a patron: the copy kept for them if their hold
+// is ready, otherwise the first copy which is available, not on loan and not kept for anybody else.
+func (d DB) LendableCopy(book openlibrary.Book, patron Patron) (*Copy, error) {
+	bookCopy := Copy{}
+	tx := d.db.Joins("JOIN books ON books.id = copies.book_id").
+		Where("books.olid = ? AND copies.status = ?", book.OLID, CopyStatusAvailable).
+		Where("copies.id NOT IN ("+openLoansQuery+")").
+		Where("copies.id NOT IN ("+reservedCopiesQuery+" AND patron_id != ?)", patron.ID).
+		Order(clause.OrderBy{Expression: clause.Expr{SQL: "copies.id IN (" + reservedCopiesQuery + ") DESC, copies.id"}}).
+		Limit(1).
+		Find(&bookCopy)
+	if tx.Error != nil {
+		return nil, fmt.Errorf("db: error reading copies: %w", tx.Error)
+	}
+	if tx.RowsAffected == 0 {
+		return nil, fmt.Errorf("no copy of %s to lend: %w", book.OLID, ErrUnavailable)
+	}
+	return &bookCopy, nil
+}
+
+// openLoansQuery selects the ids of the copies which are out on loan.
+const openLoansQuery = "SELECT copy_id FROM loans WHERE returned_at IS NULL"
+
+// Checkout lends the copy with the given accession number to a patron until the due date, or for the
+// loan period if due is nil. Only available copies of books still held may be lent, and only to
+// active patrons within the limits of the copy's loan policy.
+func (d DB) Checkout(accession string, patron Patron, due *time.Time) (*Loan, error) {
+	loan := &Loan{}
+	err := d.db.Transaction(func(tx *gorm.DB) error {
+		if patron.Status != PatronStatusActive {
+			return fmt.Errorf("patron %s is %s", patron.Barcode, patron.Status)
+		}
+
+		bookCopy, err := readCopyTx(tx, accession)
+		if err != nil {
+			return err
+		}
+		if bookCopy.Status != CopyStatusAvailable {
+			return fmt.Errorf("copy %s is %s: %w", accession, bookCopy.Status, ErrUnavailable)
+		}
+
+		open, err := openLoanTx(tx, bookCopy.ID)
+		if err != nil {
+			return err
+		}
+		if open != nil {
+			return fmt.Errorf("copy %s is already out, due %s: %w", accession, open.DueOn.Format("2006-01-02"), ErrUnavailable)
+		}
+
+		book := Book{}
+		err = tx.First(&book, bookCopy.BookID).Error
+		if err != nil {
+			return err
+		}
+		if book.DeaccessionedOn != nil {
+			return fmt.Errorf("%s has been deaccessioned: %w", book.Title, ErrUnavailable)
+		}
+
+		policy, err := d.policyTx(tx, bookCopy.BookID, bookCopy.ID)
+		if err != nil {
+			return err
+		}
+		if policy.NonCirculating {
+			return fmt.Errorf("%s is not lent under the %s policy: %w", book.Title, policy.Name, ErrUnavailable)
+		}
+		err = d.checkItemLimitTx(tx, patron, *policy)
+		if err != nil {
+			return err
+		}
+
+		err = d.fulfilHoldsTx(tx, *bookCopy, patron)
+		if err != nil {
+			return err
+		}
+
+		now := tx.NowFunc()
+		dueOn, err := dueDate(now, due, *policy)
+		if err != nil {
+			return err
+		}
+
+		loan = &Loan{
+			CopyID:       bookCopy.ID,
+			PatronID:     patron.ID,
+			CheckedOutAt: now,
+			DueOn:        dueOn,
+		}
+		err = tx.Create(loan).Error
+		if err != nil {
+			return err
+		}
+
+		return d.recordChangeTx(tx, Change{Action: ActionCreate, Entity: EntityLoan, EntityKey: accession, NewValue: &patron.Barcode})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("db: error checking out copy: %w", err)
+	}
+	return loan, nil
+}
+
+// Checkin closes the open loan of the copy with the given accession number. If a patron is waiting
+// for the book, the copy is kept for the first in the queue and their hold is returned.
+func (d DB) Checkin(accession string) (*Loan, *Hold, error) {
+	var loan *Loan
+	var hold *Hold
+	err := d.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		loan, err = readOpenLoanTx(tx, accession)
+		if err != nil {
+			return err
+		}
+
+		returned := tx.NowFunc()
+		err = tx.Model(loan).Update("returned_at", returned).Error
+		if err != nil {
+			return err
+		}
+
+		returnedValue := returned.Format(time.RFC3339)
+		err = d.recordChangeTx(tx, Change{Action: ActionUpdate, Entity: EntityLoan, EntityKey: accession, Field: FieldReturned, NewValue: &returnedValue})
+		if err != nil {
+			return err
+		}
+
+		bookCopy := Copy{}
+		err = tx.First(&bookCopy, loan.CopyID).Error
+		if err != nil {
+			return err
+		}
+		hold, err = d.reserveCopyTx(tx, bookCopy)
+		return err
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("db: error checking in copy: %w", err)
+	}
+	return loan, hold, nil
+}
+
+// Renew extends the open loan of the copy with the given accession number until the due date, or for
+// another loan period from today if due is nil. Loans of books other patrons are waiting for cannot
+// be renewed, nor can loans more often than their loan policy allows.
+func (d DB) Renew(accession string, due *time.Time) (*Loan, error) {
+	var loan *Loan
+	err := d.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		loan, err = readOpenLoanTx(tx, accession)
+		if err != nil {
+			return err
+		}
+
+		patron := Patron{}
+		err = tx.First(&patron, loan.PatronID).Error
+		if err != nil {
+			return err
+		}
+		if patron.Status != PatronStatusActive {
+			return fmt.Errorf("patron %s is %s", patron.Barcode, patron.Status)
+		}
+
+		var waiting int64
+		err = tx.Model(&Hold{}).
+			Where("status = ? AND book_id IN (SELECT book_id FROM copies WHERE id = ?)", HoldStatusWaiting, loan.CopyID).
+			Count(&waiting).Error
+		if err != nil {
+			return err
+		}
+		if waiting > 0 {
+			return fmt.Errorf("%d patrons are waiting for copy %s", waiting, accession)
+		}
+
+		bookCopy := Copy{}
+		err = tx.First(&bookCopy, loan.CopyID).Error
+		if err != nil {
+			return err
+		}
+		policy, err := d.policyTx(tx, bookCopy.BookID, bookCopy.ID)
+		if err != nil {
+			return err
+		}
+		if policy.NonCirculating {
+			return fmt.Errorf("copy %s is not lent under the %s policy", accession, policy.Name)
+		}
+		if policy.MaxRenewals != nil && int64(loan.Renewals) >= *policy.MaxRenewals {
+			return fmt.Errorf("copy %s has been renewed %d times, the most the %s policy allows", accession, loan.Renewals, policy.Name)
+		}
+
+		dueOn, err := dueDate(tx.NowFunc(), due, *policy)
+		if err != nil {
+			return err
+		}
+
+		oldDue, newDue := loan.DueOn.Format("2006-01-02"), dueOn.Format("2006-01-02")
+		err = tx.Model(loan).Updates(map[string]interface{}{"due_on": dueOn, "renewals": loan.Renewals + 1}).Error
+		if err != nil {
+			return err
+		}
+
+		return d.recordChangeTx(tx, Change{Action: ActionUpdate, Entity: EntityLoan, EntityKey: accession, Field: FieldDue, OldValue: &oldDue, NewValue: &newDue})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("db: error renewing loan: %w", err)
+	}
+	return loan, nil
+}
+
+// OpenLoan returns the open loan of the copy with the given accession number, or nil if it is not on
+// loan.
+func (d DB) OpenLoan(accession string) (*Loan, error) {
+	bookCopy, err := d.FindCopy(accession)
+	if err != nil {
+		return nil, err
+	}
+
+	loan, err := openLoanTx(d.db, bookCopy.ID)
+	if err != nil {
+		return nil, fmt.Errorf("db: error reading loan: %w", err)
+	}
+	return loan, nil
+}
+
+// dueDate is the day a loan made at now falls due: the given date if there is one, otherwise after
+// the loan period of the policy. A date given for a copy under a loan policy other than the default
+// may not be later than the policy allows.
+func dueDate(now time.Time, due *time.Time, policy EffectivePolicy) (time.Time, error) {
+	latest := day(now).AddDate(0, 0, int(*policy.LoanDays))
+	if due == nil {
+		return latest, nil
+	}
+
+	if due.Before(day(now)) {
+		return time.Time{}, fmt.Errorf("due date %s has passed", due.Format("2006-01-02"))
+	}
+	if policy.ID != 0 && day(*due).After(latest) {
+		return time.Time{}, fmt.Errorf("the %s policy lends for at most %d days, until %s", policy.Name, *policy.LoanDays, latest.Format("2006-01-02"))
+	}
+	return day(*due), nil
+}
+
+func readOpenLoanTx(tx *gorm.DB, accession string) (*Loan, error) {
+	bookCopy, err := readCopyTx(tx, accession)
+	if err != nil {
+		return nil, err
+	}
+
+	loan, err := openLoanTx(tx, bookCopy.ID)
+	if err != nil {
+		return nil, err
+	}
+	if loan == nil {
+		return nil, fmt.Errorf("copy %s is not on loan: %w", accession, ErrNotFound)
+	}
+	return loan, nil
+}
+
+func openLoanTx(tx *gorm.DB, copyID int64) (*Loan, error) {
+	loan := Loan{}
+	result := tx.Preload("Patron").Where("copy_id = ? AND returned_at IS NULL", copyID).Limit(1).Find(&loan)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, nil
+	}
+	return &loan, nil
+}
+
+// AnonymizeLoans removes the patron from the loans returned more than the anonymize_loans_after_days
+// setting before asOf, along with their notices and the barcode recorded in the change log when they
+// were made. Holds closed before then are anonymized the same way. It returns the number of loans
+// anonymized; if the setting is unset none are.
+func (d DB) AnonymizeLoans(asOf time.Time) (int64, error) {
+	value, err := d.Setting(SettingAnonymizeAfterDays)
+	if err != nil || value == "" {
+		return 0, err
+	}
+	days, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("db: invalid %s setting: %w", SettingAnonymizeAfterDays, err)
+	}
+	cutoff := day(asOf).AddDate(0, 0, -days)
+
+	var anonymized int64
+	err = d.db.Transaction(func(tx *gorm.DB) error {
+		// times are compared through julianday, as the stored ones carry the offset of their zone
+		loans := []Loan{}
+		err := tx.Preload("Copy").Where("julianday(returned_at) < julianday(?) AND anonymized_at IS NULL", cutoff).Order("id").Find(&loans).Error
+		if err != nil {
+			return err
+		}
+
+		now := tx.NowFunc()
+		for _, loan := range loans {
+			err = tx.Model(&Loan{ID: loan.ID}).Updates(map[string]interface{}{"patron_id": 0, "anonymized_at": now}).Error
+			if err != nil {
+				return err
+			}
+			err = tx.Where("loan_id = ?", loan.ID).Delete(&NoticeLoan{}).Error
+			if err != nil {
+				return err
+			}
+			err = tx.Model(&Change{}).
+				Where("action = ? AND entity = ? AND entity_key = ? AND julianday(created_at) <= julianday(?)", ActionCreate, EntityLoan, loan.Copy.Accession, loan.ReturnedAt.UTC()).
+				Update("new_value", nil).Error
+			if err != nil {
+				return err
+			}
+
+			anonymizedValue := now.Format(time.RFC3339)
+			err = d.recordChangeTx(tx, Change{Action: ActionUpdate, Entity: EntityLoan, EntityKey: loan.Copy.Accession, Field: FieldAnonymized, NewValue: &anonymizedValue})
+			if err != nil {
+				return err
+			}
+			anonymized++
+		}
+
+		holds := []Hold{}
+		err = tx.Where("status NOT IN ? AND julianday(closed_at) < julianday(?) AND patron_id <> 0", activeHoldStatuses, cutoff).Order("id").Find(&holds).Error
+		if err != nil {
+			return err
+		}
+		for _, hold := range holds {
+			err = tx.Model(&Hold{ID: hold.ID}).Update("patron_id", 0).Error
+			if err != nil {
+				return err
+			}
+			err = tx.Model(&Change{}).
+				Where("action = ? AND entity = ? AND entity_key = ?", ActionCreate, EntityHold, strconv.FormatInt(hold.ID, 10)).
+				Update("new_value", nil).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		// notices name their recipient, and are only kept while they are about a loan which still
+		// names its patron
+		return tx.Where("julianday(sent_at) < julianday(?) AND id NOT IN (SELECT notice_id FROM notice_loans)", cutoff).Delete(&Notice{}).Error
+	})
+	if err != nil {
+		return 0, fmt.Errorf("db: error anonymizing loans: %w", err)
+	}
+	return anonymized, nil
+}
+
+// OverdueLoan is an open loan past its due date, with the title of the book lent.
+type OverdueLoan struct {
+	Loan
+	Title       string
+	DaysOverdue int
+}
+
+// OverdueLoans returns the loans which were due before the given day and are still out, ordered by
+// patron and then due date.
+func (d DB) OverdueLoans(asOf time.Time) ([]OverdueLoan, error) {
+	today := day(asOf)
+
+	loans := []Loan{}
+	tx := d.db.Preload("Patron").Preload("Copy").
+		Joins("JOIN patrons ON patrons.id = loans.patron_id").
+		Where("loans.returned_at IS NULL AND loans.due_on < ?", today).
+		Order("patrons.name").Order("patrons.id").Order("loans.due_on").Order("loans.id").
+		Find(&loans)
+	if tx.Error != nil {
+		return nil, fmt.Errorf("db: error reading overdue loans: %w", tx.Error)
+	}
+
+	bookIDs := []int64{}
+	for _, loan := range loans {
+		bookIDs = append(bookIDs, loan.Copy.BookID)
+	}
+	books := []Book{}
+	tx = d.db.Where("id IN ?", bookIDs).Find(&books)
+	if tx.Error != nil {
+		return nil, fmt.Errorf("db: error reading overdue books: %w", tx.Error)
+	}
+	titles := map[int64]string{}
+	for _, book := range books {
+		titles[book.ID] = book.Title
+	}
+
+	overdue := make([]OverdueLoan, len(loans))
+	for i, loan := range loans {
+		overdue[i] = OverdueLoan{
+			Loan:        loan,
+			Title:       titles[loan.Copy.BookID],
+			DaysOverdue: int(today.Sub(day(loan.DueOn)).Hours() / 24),
+		}
+	}
+	return overdue, nil
+}
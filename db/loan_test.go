@@ -0,0 +1,319 @@
+package db
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/arudzitis/addlib/openlibrary"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPatrons(t *testing.T) {
+	db := openTestDatabase(t)
+	defer db.Close()
+
+	first, err := db.AddPatron(Patron{Name: "Ada Lovelace", Contact: "ada@example.com"})
+	require.NoError(t, err)
+	assert.Equal(t, "P000001", first.Barcode)
+	assert.Equal(t, PatronStatusActive, first.Status)
+
+	_, err = db.AddPatron(Patron{Name: "Charles Babbage", Barcode: "LIB-2"})
+	require.NoError(t, err)
+
+	_, err = db.AddPatron(Patron{Name: "Someone", Barcode: "LIB-2"})
+	assert.Error(t, err, "barcodes must be unique")
+	_, err = db.AddPatron(Patron{Name: "Someone", Status: "banished"})
+	assert.Error(t, err)
+
+	found, err := db.FindPatron("LIB-2")
+	require.NoError(t, err)
+	assert.Equal(t, "Charles Babbage", found.Name)
+	found, err = db.FindPatron("1")
+	require.NoError(t, err)
+	assert.Equal(t, "Ada Lovelace", found.Name)
+	_, err = db.FindPatron("LIB-3")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	_, err = db.UpdatePatron(*found, map[string]string{"status": PatronStatusSuspended, "contact": "ada@example.org"})
+	require.NoError(t, err)
+
+	patrons, err := db.Patrons()
+	require.NoError(t, err)
+	require.Len(t, patrons, 2)
+	assert.Equal(t, PatronStatusSuspended, patrons[0].Status)
+	assert.Equal(t, "ada@example.org", patrons[0].Contact)
+
+	// barcodes given by hand in the generated form are skipped
+	_, err = db.AddPatron(Patron{Name: "Grace Hopper", Barcode: "P000004"})
+	require.NoError(t, err)
+	generated, err := db.AddPatron(Patron{Name: "Alan Turing"})
+	require.NoError(t, err)
+	assert.Equal(t, "P000005", generated.Barcode)
+}
+
+func TestSettings(t *testing.T) {
+	db := openTestDatabase(t)
+	defer db.Close()
+
+	period, err := db.LoanPeriod()
+	require.NoError(t, err)
+	assert.Equal(t, 21, period)
+
+	require.NoError(t, db.SetSetting(SettingLoanPeriod, "14"))
+	period, err = db.LoanPeriod()
+	require.NoError(t, err)
+	assert.Equal(t, 14, period)
+
+	assert.Error(t, db.SetSetting(SettingLoanPeriod, "-3"))
+	assert.ErrorIs(t, db.SetSetting("colour", "blue"), ErrNotFound)
+
+	changes, err := db.History(SettingLoanPeriod)
+	require.NoError(t, err)
+	require.Len(t, changes, 1)
+	require.NoError(t, db.Undo(changes[0].ID))
+
+	assert.Error(t, db.SetSetting(SettingSMTPServer, "localhost"), "the server needs a port")
+	assert.Error(t, db.SetSetting(SettingSMTPFrom, "library"))
+	require.NoError(t, db.SetSetting(SettingSMTPFrom, "Library <library@example.com>"))
+
+	values, err := db.Settings()
+	require.NoError(t, err)
+	byKey := map[string]SettingValue{}
+	for _, value := range values {
+		byKey[value.Key] = value
+	}
+	assert.Equal(t, "21", byKey[SettingLoanPeriod].Value)
+	assert.True(t, byKey[SettingLoanPeriod].IsDefault)
+	assert.Equal(t, "Library <library@example.com>", byKey[SettingSMTPFrom].Value)
+	assert.False(t, byKey[SettingSMTPFrom].IsDefault)
+}
+
+func TestLoans(t *testing.T) {
+	book := openlibrary.Book{OLID: "olid-booka", Title: "Book A"}
+
+	db := openTestDatabase(t)
+	defer db.Close()
+
+	clock := time.Date(2023, time.May, 1, 15, 0, 0, 0, time.UTC)
+	db.db.Config.NowFunc = func() time.Time { return clock }
+
+	require.NoError(t, db.InsertRecord(book))
+	ada, err := db.AddPatron(Patron{Name: "Ada Lovelace"})
+	require.NoError(t, err)
+
+	_, err = db.LendableCopy(book, *ada)
+	assert.ErrorIs(t, err, ErrUnavailable, "a book without copies cannot be lent")
+
+	first, err := db.AddCopy(book, Copy{})
+	require.NoError(t, err)
+	second, err := db.AddCopy(book, Copy{})
+	require.NoError(t, err)
+
+	charles, err := db.AddPatron(Patron{Name: "Charles Babbage", Status: PatronStatusSuspended})
+	require.NoError(t, err)
+
+	_, err = db.Checkout(first.Accession, *charles, nil)
+	assert.Error(t, err, "suspended patrons cannot borrow")
+
+	loan, err := db.Checkout(first.Accession, *ada, nil)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2023, time.May, 22, 0, 0, 0, 0, time.UTC), loan.DueOn)
+
+	_, err = db.Checkout(first.Accession, *ada, nil)
+	assert.ErrorIs(t, err, ErrUnavailable, "a copy already out cannot be lent again")
+
+	lendable, err := db.LendableCopy(book, *ada)
+	require.NoError(t, err)
+	assert.Equal(t, second.Accession, lendable.Accession)
+
+	_, err = db.RemoveCopy(first.Accession)
+	assert.Error(t, err, "copies on loan cannot be removed")
+	_, err = db.DeaccessionBook(book, Deaccession{Reason: DeaccessionSold})
+	assert.Error(t, err, "books with copies on loan cannot be deaccessioned")
+
+	clock = clock.AddDate(0, 0, 20)
+	loan, err = db.Renew(first.Accession, nil)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2023, time.June, 11, 0, 0, 0, 0, time.UTC), loan.DueOn)
+	assert.Equal(t, 1, loan.Renewals)
+
+	open, err := db.OpenLoan(first.Accession)
+	require.NoError(t, err)
+	require.NotNil(t, open)
+	assert.Equal(t, "Ada Lovelace", open.Patron.Name)
+
+	loan, hold, err := db.Checkin(first.Accession)
+	require.NoError(t, err)
+	require.NotNil(t, loan.ReturnedAt)
+	assert.Nil(t, hold, "nobody is waiting for the book")
+
+	_, _, err = db.Checkin(first.Accession)
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = db.Renew(first.Accession, nil)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	open, err = db.OpenLoan(first.Accession)
+	require.NoError(t, err)
+	assert.Nil(t, open)
+
+	due := time.Date(2023, time.July, 1, 0, 0, 0, 0, time.UTC)
+	loan, err = db.Checkout(first.Accession, *ada, &due)
+	require.NoError(t, err)
+	assert.Equal(t, due, loan.DueOn)
+
+	changes, err := db.History(first.Accession)
+	require.NoError(t, err)
+	descriptions := []string{}
+	for _, change := range changes {
+		descriptions = append(descriptions, change.Action+" "+change.Describe())
+	}
+	assert.Equal(t, []string{
+		"create olid-booka",
+		"create P000001",
+		`update due_on: "2023-05-22" -> "2023-06-11"`,
+		`update returned_at: (none) -> "2023-05-21T15:00:00Z"`,
+		"create P000001",
+	}, descriptions)
+}
+
+func TestOverdueLoans(t *testing.T) {
+	bookA := openlibrary.Book{OLID: "olid-booka", Title: "Book A"}
+	bookB := openlibrary.Book{OLID: "olid-bookb", Title: "Book B"}
+
+	db := openTestDatabase(t)
+	defer db.Close()
+
+	clock := time.Date(2023, time.May, 1, 15, 0, 0, 0, time.UTC)
+	db.db.Config.NowFunc = func() time.Time { return clock }
+
+	require.NoError(t, db.InsertRecord(bookA))
+	require.NoError(t, db.InsertRecord(bookB))
+	copyA, err := db.AddCopy(bookA, Copy{})
+	require.NoError(t, err)
+	copyB, err := db.AddCopy(bookB, Copy{})
+	require.NoError(t, err)
+	copyC, err := db.AddCopy(bookB, Copy{})
+	require.NoError(t, err)
+
+	ada, err := db.AddPatron(Patron{Name: "Ada Lovelace"})
+	require.NoError(t, err)
+	charles, err := db.AddPatron(Patron{Name: "Charles Babbage"})
+	require.NoError(t, err)
+
+	due := func(d int) *time.Time {
+		date := time.Date(2023, time.May, d, 0, 0, 0, 0, time.UTC)
+		return &date
+	}
+	_, err = db.Checkout(copyA.Accession, *charles, due(3))
+	require.NoError(t, err)
+	_, err = db.Checkout(copyB.Accession, *ada, due(10))
+	require.NoError(t, err)
+	_, err = db.Checkout(copyC.Accession, *ada, due(5))
+	require.NoError(t, err)
+
+	clock = *due(10)
+	overdue, err := db.OverdueLoans(clock)
+	require.NoError(t, err)
+	require.Len(t, overdue, 2, "loans due today are not yet overdue")
+	assert.Equal(t, "Ada Lovelace", overdue[0].Patron.Name)
+	assert.Equal(t, copyC.Accession, overdue[0].Copy.Accession)
+	assert.Equal(t, "Book B", overdue[0].Title)
+	assert.Equal(t, 5, overdue[0].DaysOverdue)
+	assert.Equal(t, "Charles Babbage", overdue[1].Patron.Name)
+	assert.Equal(t, 7, overdue[1].DaysOverdue)
+
+	_, _, err = db.Checkin(copyA.Accession)
+	require.NoError(t, err)
+	overdue, err = db.OverdueLoans(clock)
+	require.NoError(t, err)
+	require.Len(t, overdue, 1)
+
+	_, err = db.RecordNotice(*ada, NoticeMethodEmail, "ada@example.com", []Loan{overdue[0].Loan})
+	require.NoError(t, err)
+
+	notices, err := db.Notices()
+	require.NoError(t, err)
+	require.Len(t, notices, 1)
+	assert.Equal(t, "Ada Lovelace", notices[0].Patron.Name)
+	assert.Equal(t, "ada@example.com", notices[0].Recipient)
+	assert.True(t, notices[0].SentAt.Equal(clock))
+	require.Len(t, notices[0].Loans, 1)
+	assert.Equal(t, copyC.Accession, notices[0].Loans[0].Copy.Accession)
+}
+
+func TestAnonymizeLoans(t *testing.T) {
+	book := openlibrary.Book{OLID: "olid-booka", Title: "Book A"}
+
+	db := openTestDatabase(t)
+	defer db.Close()
+
+	clock := time.Date(2023, time.May, 1, 12, 0, 0, 0, time.UTC)
+	db.db.Config.NowFunc = func() time.Time { return clock }
+
+	require.NoError(t, db.InsertRecord(book))
+	bookCopy, err := db.AddCopy(book, Copy{})
+	require.NoError(t, err)
+	ada, err := db.AddPatron(Patron{Name: "Ada", Contact: "ada@example.com"})
+	require.NoError(t, err)
+	charles, err := db.AddPatron(Patron{Name: "Charles", Contact: "charles@example.com"})
+	require.NoError(t, err)
+
+	loan, err := db.Checkout(bookCopy.Accession, *charles, nil)
+	require.NoError(t, err)
+	_, err = db.PlaceHold(book, *ada)
+	require.NoError(t, err)
+	_, err = db.RecordNotice(*charles, "email", charles.Contact, []Loan{*loan})
+	require.NoError(t, err)
+
+	clock = clock.AddDate(0, 0, 3)
+	_, _, err = db.Checkin(bookCopy.Accession)
+	require.NoError(t, err)
+	_, err = db.Checkout(bookCopy.Accession, *ada, nil)
+	require.NoError(t, err)
+	clock = clock.AddDate(0, 0, 2)
+	_, _, err = db.Checkin(bookCopy.Accession)
+	require.NoError(t, err)
+
+	// ada still has the book and charles is still waiting for it, so neither is anonymized
+	lentAt := clock
+	_, err = db.Checkout(bookCopy.Accession, *ada, nil)
+	require.NoError(t, err)
+	active, err := db.PlaceHold(book, *charles)
+	require.NoError(t, err)
+
+	require.NoError(t, db.SetSetting(SettingAnonymizeAfterDays, "30"))
+	clock = clock.AddDate(0, 0, 60)
+	anonymized, err := db.AnonymizeLoans(clock)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), anonymized)
+
+	notices, err := db.Notices()
+	require.NoError(t, err)
+	assert.Empty(t, notices)
+
+	holds, err := db.Holds(HoldFilter{})
+	require.NoError(t, err)
+	require.Len(t, holds, 2)
+	assert.Equal(t, int64(0), holds[0].PatronID)
+	assert.Equal(t, charles.ID, holds[1].PatronID)
+
+	identities := []string{ada.Barcode, ada.Name, ada.Contact, charles.Barcode, charles.Name, charles.Contact}
+	changes, err := db.History("")
+	require.NoError(t, err)
+	for _, change := range changes {
+		if change.Entity == EntityPatron || (change.Entity == EntityHold && change.EntityKey == strconv.FormatInt(active.ID, 10)) {
+			continue
+		}
+		if change.Entity == EntityLoan && change.Action == ActionCreate && change.CreatedAt.Equal(lentAt) {
+			continue
+		}
+		for _, value := range []*string{change.OldValue, change.NewValue} {
+			assert.NotContains(t, identities, strValue(value), "%s of %s %s", change.Action, change.Entity, change.EntityKey)
+		}
+	}
+
+	_, err = db.RemoveCopy(bookCopy.Accession)
+	assert.Error(t, err, "copies which have been lent are kept for their loan history")
+}
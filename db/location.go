@@ -0,0 +1,268 @@
+package db
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/arudzitis/addlib/openlibrary"
+
+	"gorm.io/gorm"
+)
+
+// LocationSeparator separates the levels of a location path, e.g. "Main/Office/Case 1/Shelf 2".
+const LocationSeparator = "/"
+
+// AddLocation creates the location described by path, along with any of its parents which do not
+// already exist, and returns the innermost location.
+func (d DB) AddLocation(path []string) (*Location, error) {
+	if len(path) == 0 {
+		return nil, fmt.Errorf("db: empty location path")
+	}
+	if len(path) > len(LocationKinds) {
+		return nil, fmt.Errorf("db: location path %q is deeper than %s", strings.Join(path, LocationSeparator), strings.Join(LocationKinds, ", "))
+	}
+
+	var location *Location
+	err := d.db.Transaction(func(tx *gorm.DB) error {
+		for depth, name := range path {
+			var parentID *int64
+			if location != nil {
+				parentID = &location.ID
+			}
+
+			existing, err := readChildLocation(tx, parentID, name)
+			if err != nil {
+				return err
+			}
+			if existing != nil {
+				location = existing
+				continue
+			}
+
+			location = &Location{
+				ParentID: parentID,
+				Name:     name,
+				Kind:     LocationKinds[depth],
+			}
+			err = tx.Create(location).Error
+			if err != nil {
+				return err
+			}
+
+			err = d.recordChangeTx(tx, Change{
+				Action:    ActionCreate,
+				Entity:    EntityLocation,
+				EntityKey: strconv.FormatInt(location.ID, 10),
+				NewValue:  &location.Name,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("db: error creating location: %w", err)
+	}
+
+	return location, nil
+}
+
+// Locations returns every location, ordered by name.
+func (d DB) Locations() ([]Location, error) {
+	locations := []Location{}
+	tx := d.db.Order("name").Find(&locations)
+	if tx.Error != nil {
+		return nil, fmt.Errorf("db: error reading locations: %w", tx.Error)
+	}
+	return locations, nil
+}
+
+// LocationIDPrefix marks a location reference as a numeric id rather than a path, e.g. "#12".
+const LocationIDPrefix = "#"
+
+// FindLocation looks up a location by its full path or, if no location has that path, by its numeric
+// id. An id given with LocationIDPrefix is never taken as a path, so "#12" finds location 12 even if
+// there is a location named "12".
+func (d DB) FindLocation(ref string) (*Location, error) {
+	if strings.HasPrefix(ref, LocationIDPrefix) {
+		id, err := strconv.ParseInt(strings.TrimPrefix(ref, LocationIDPrefix), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("db: invalid location id %q: %w", ref, err)
+		}
+		return d.findLocationByID(ref, id)
+	}
+
+	var location *Location
+	for _, name := range strings.Split(strings.Trim(ref, LocationSeparator), LocationSeparator) {
+		var parentID *int64
+		if location != nil {
+			parentID = &location.ID
+		}
+
+		var err error
+		location, err = readChildLocation(d.db, parentID, name)
+		if err != nil {
+			return nil, fmt.Errorf("db: error reading location: %w", err)
+		}
+		if location == nil {
+			break
+		}
+	}
+	if location != nil {
+		return location, nil
+	}
+
+	if id, err := strconv.ParseInt(ref, 10, 64); err == nil {
+		return d.findLocationByID(ref, id)
+	}
+	return nil, fmt.Errorf("db: no location %q: %w", ref, ErrNotFound)
+}
+
+func (d DB) findLocationByID(ref string, id int64) (*Location, error) {
+	location := Location{}
+	tx := d.db.Limit(1).Find(&location, id)
+	if tx.Error != nil {
+		return nil, fmt.Errorf("db: error reading location: %w", tx.Error)
+	}
+	if tx.RowsAffected != 1 {
+		return nil, fmt.Errorf("db: no location %q: %w", ref, ErrNotFound)
+	}
+	return &location, nil
+}
+
+// LocationPaths returns the full path of every location, keyed by location id.
+func (d DB) LocationPaths() (map[int64]string, error) {
+	locations, err := d.Locations()
+	if err != nil {
+		return nil, err
+	}
+
+	byID := map[int64]Location{}
+	for _, location := range locations {
+		byID[location.ID] = location
+	}
+
+	paths := map[int64]string{}
+	for _, location := range locations {
+		names := []string{location.Name}
+		for parentID := location.ParentID; parentID != nil; {
+			parent, ok := byID[*parentID]
+			if !ok {
+				break
+			}
+			names = append([]string{parent.Name}, names...)
+			parentID = parent.ParentID
+		}
+		paths[location.ID] = strings.Join(names, LocationSeparator)
+	}
+
+	return paths, nil
+}
+
+// RemoveLocation deletes an empty location. Locations which contain other locations, books or
+// copies cannot be removed.
+func (d DB) RemoveLocation(location Location) error {
+	return d.db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		err := tx.Model(&Location{}).Where("parent_id = ?", location.ID).Count(&count).Error
+		if err != nil {
+			return fmt.Errorf("db: error reading locations: %w", err)
+		}
+		if count > 0 {
+			return fmt.Errorf("db: location %q contains %d other locations", location.Name, count)
+		}
+
+		err = tx.Model(&Book{}).Where("location_id = ?", location.ID).Count(&count).Error
+		if err != nil {
+			return fmt.Errorf("db: error reading books: %w", err)
+		}
+		if count > 0 {
+			return fmt.Errorf("db: location %q still holds %d books", location.Name, count)
+		}
+
+		err = tx.Model(&Copy{}).Where("location_id = ?", location.ID).Count(&count).Error
+		if err != nil {
+			return fmt.Errorf("db: error reading copies: %w", err)
+		}
+		if count > 0 {
+			return fmt.Errorf("db: location %q still holds %d copies", location.Name, count)
+		}
+
+		err = tx.Delete(&Location{}, location.ID).Error
+		if err != nil {
+			return fmt.Errorf("db: error deleting location: %w", err)
+		}
+
+		return d.recordChangeTx(tx, Change{
+			Action:    ActionDelete,
+			Entity:    EntityLocation,
+			EntityKey: strconv.FormatInt(location.ID, 10),
+			OldValue:  &location.Name,
+		})
+	})
+}
+
+// MoveBook shelves a book at a location.
+func (d DB) MoveBook(book openlibrary.Book, location Location) (int64, error) {
+	rows, err := d.updateColumn(EntityBook, book.OLID, "location_id", location.ID)
+	if err != nil {
+		return 0, fmt.Errorf("db: error moving book: %w", err)
+	}
+	return rows, nil
+}
+
+// MoveCopy shelves the copy with the given accession number at a location.
+func (d DB) MoveCopy(accession string, location Location) (int64, error) {
+	rows, err := d.updateColumn(EntityCopy, accession, "location_id", location.ID)
+	if err != nil {
+		return 0, fmt.Errorf("db: error moving copy: %w", err)
+	}
+	return rows, nil
+}
+
+// locationSubtree returns the id of a location along with the ids of every location within it.
+func (d DB) locationSubtree(id int64) ([]int64, error) {
+	return locationSubtreeTx(d.db, id)
+}
+
+func locationSubtreeTx(tx *gorm.DB, id int64) ([]int64, error) {
+	locations := []Location{}
+	err := tx.Find(&locations).Error
+	if err != nil {
+		return nil, fmt.Errorf("db: error reading locations: %w", err)
+	}
+
+	children := map[int64][]int64{}
+	for _, location := range locations {
+		if location.ParentID != nil {
+			children[*location.ParentID] = append(children[*location.ParentID], location.ID)
+		}
+	}
+
+	subtree := []int64{id}
+	for i := 0; i < len(subtree); i++ {
+		subtree = append(subtree, children[subtree[i]]...)
+	}
+	return subtree, nil
+}
+
+func readChildLocation(tx *gorm.DB, parentID *int64, name string) (*Location, error) {
+	query := tx.Where("name = ?", name)
+	if parentID == nil {
+		query = query.Where("parent_id IS NULL")
+	} else {
+		query = query.Where("parent_id = ?", *parentID)
+	}
+
+	location := Location{}
+	result := query.Limit(1).Find(&location)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected != 1 {
+		return nil, nil
+	}
+	return &location, nil
+}
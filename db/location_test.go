@@ -0,0 +1,88 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/arudzitis/addlib/openlibrary"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocations(t *testing.T) {
+	bookA := openlibrary.Book{OLID: "olid-booka", Title: "Book A"}
+	bookB := openlibrary.Book{OLID: "olid-bookb", Title: "Book B"}
+	bookC := openlibrary.Book{OLID: "olid-bookc", Title: "Book C"}
+
+	db := openTestDatabase(t)
+	defer db.Close()
+
+	for _, book := range []openlibrary.Book{bookA, bookB, bookC} {
+		require.NoError(t, db.InsertRecord(book))
+	}
+
+	shelf, err := db.AddLocation([]string{"Main", "Office", "Case 1", "Shelf 1"})
+	require.NoError(t, err)
+	assert.Equal(t, "shelf", shelf.Kind)
+
+	otherShelf, err := db.AddLocation([]string{"Main", "Office", "Case 1", "Shelf 2"})
+	require.NoError(t, err)
+
+	_, err = db.AddLocation([]string{"Main", "Office", "Case 1", "Shelf 2", "Box"})
+	assert.Error(t, err, "locations are at most four levels deep")
+
+	room, err := db.FindLocation("Main/Office")
+	require.NoError(t, err)
+	assert.Equal(t, "room", room.Kind)
+
+	byID, err := db.FindLocation("1")
+	require.NoError(t, err)
+	assert.Equal(t, "Main", byID.Name)
+
+	// a location named like an id is preferred to the id, unless the id is marked as one
+	numbered, err := db.AddLocation([]string{"2"})
+	require.NoError(t, err)
+	found, err := db.FindLocation("2")
+	require.NoError(t, err)
+	assert.Equal(t, numbered.ID, found.ID)
+	found, err = db.FindLocation("#2")
+	require.NoError(t, err)
+	assert.Equal(t, "Office", found.Name)
+	require.NoError(t, db.RemoveLocation(*numbered))
+	_, err = db.FindLocation("#x")
+	assert.Error(t, err)
+
+	_, err = db.FindLocation("Main/Attic")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	paths, err := db.LocationPaths()
+	require.NoError(t, err)
+	assert.Equal(t, "Main/Office/Case 1/Shelf 2", paths[otherShelf.ID])
+
+	rows, err := db.MoveBook(bookA, *shelf)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), rows)
+
+	bookCopy, err := db.AddCopy(bookB, Copy{})
+	require.NoError(t, err)
+	rows, err = db.MoveCopy(bookCopy.Accession, *otherShelf)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), rows)
+
+	books, err := db.FindBooks(BookFilter{LocationID: &room.ID})
+	require.NoError(t, err)
+	require.Len(t, books, 2)
+	assert.Equal(t, "Book A", books[0].Title)
+	assert.Equal(t, "Book B", books[1].Title)
+
+	books, err = db.FindBooks(BookFilter{LocationID: &shelf.ID})
+	require.NoError(t, err)
+	require.Len(t, books, 1)
+	assert.Equal(t, "Book A", books[0].Title)
+
+	assert.Error(t, db.RemoveLocation(*room), "locations with children cannot be removed")
+	assert.Error(t, db.RemoveLocation(*shelf), "locations holding books cannot be removed")
+
+	emptyShelf, err := db.AddLocation([]string{"Main", "Office", "Case 1", "Shelf 3"})
+	require.NoError(t, err)
+	require.NoError(t, db.RemoveLocation(*emptyShelf))
+}
@@ -0,0 +1,231 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// MergeBooks folds duplicate book records into a surviving record. The survivor gains the
+// duplicates' copies, authors, tags, collections, subjects, works, isbns and loan policy, along with
+// their location, classification and publication details where it has none of its own, and the
+// duplicates are removed.
+func (d DB) MergeBooks(survivorOLID string, duplicateOLIDs []string) error {
+	err := d.db.Transaction(func(tx *gorm.DB) error {
+		return d.mergeBooksTx(tx, survivorOLID, duplicateOLIDs)
+	})
+	if err != nil {
+		return fmt.Errorf("db: error merging books: %w", err)
+	}
+	return nil
+}
+
+// RekeyAuthor moves an author to a new openlibrary id, such as the survivor of a merge on
+// openlibrary. If an author already has the new id the two are merged.
+func (d DB) RekeyAuthor(oldOLID string, newOLID string) error {
+	err := d.db.Transaction(func(tx *gorm.DB) error {
+		existing, err := readAuthorTx(tx, newOLID)
+		if err != nil {
+			return err
+		}
+		if existing != nil {
+			_, err = d.mergeAuthorsTx(tx, newOLID, []string{oldOLID})
+			return err
+		}
+		_, err = d.updateColumnTx(tx, EntityAuthor, oldOLID, "olid", newOLID)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("db: error rekeying author: %w", err)
+	}
+	return nil
+}
+
+// RekeyBook moves a book to a new openlibrary id. If a book already has the new id the two are
+// merged.
+func (d DB) RekeyBook(oldOLID string, newOLID string) error {
+	err := d.db.Transaction(func(tx *gorm.DB) error {
+		existing, err := readBookTx(tx, newOLID)
+		if err != nil {
+			return err
+		}
+		if existing != nil {
+			return d.mergeBooksTx(tx, newOLID, []string{oldOLID})
+		}
+		_, err = d.updateColumnTx(tx, EntityBook, oldOLID, "olid", newOLID)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("db: error rekeying book: %w", err)
+	}
+	return nil
+}
+
+func (d DB) mergeBooksTx(tx *gorm.DB, survivorOLID string, duplicateOLIDs []string) error {
+	survivor, err := readBookTx(tx, survivorOLID)
+	if err != nil {
+		return err
+	}
+	if survivor == nil {
+		return fmt.Errorf("no book with olid %s: %w", survivorOLID, ErrNotFound)
+	}
+
+	for _, duplicateOLID := range duplicateOLIDs {
+		if duplicateOLID == survivorOLID {
+			continue
+		}
+
+		duplicate, err := readBookTx(tx, duplicateOLID)
+		if err != nil {
+			return err
+		}
+		if duplicate == nil {
+			return fmt.Errorf("no book with olid %s: %w", duplicateOLID, ErrNotFound)
+		}
+
+		err = tx.Model(&Copy{}).Where("book_id = ?", duplicate.ID).Update("book_id", survivor.ID).Error
+		if err != nil {
+			return err
+		}
+
+		err = mergeCreditsTx(tx, *survivor, *duplicate)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Exec("INSERT OR IGNORE INTO book_tags (book_id, tag_id) SELECT ?, tag_id FROM book_tags WHERE book_id = ?", survivor.ID, duplicate.ID).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Where("book_id = ?", duplicate.ID).Delete(&BookTag{}).Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.Exec("INSERT OR IGNORE INTO book_collections (book_id, collection_id) SELECT ?, collection_id FROM book_collections WHERE book_id = ?", survivor.ID, duplicate.ID).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Where("book_id = ?", duplicate.ID).Delete(&BookCollection{}).Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.Exec("UPDATE OR IGNORE policy_assignments SET target_id = ? WHERE scope = ? AND target_id = ?", survivor.ID, PolicyScopeBook, duplicate.ID).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Where("scope = ? AND target_id = ?", PolicyScopeBook, duplicate.ID).Delete(&PolicyAssignment{}).Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.Exec("INSERT OR IGNORE INTO book_subjects (book_id, subject_id) SELECT ?, subject_id FROM book_subjects WHERE book_id = ?", survivor.ID, duplicate.ID).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Where("book_id = ?", duplicate.ID).Delete(&BookSubject{}).Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.Exec("INSERT OR IGNORE INTO book_works (book_id, work_id) SELECT ?, work_id FROM book_works WHERE book_id = ?", survivor.ID, duplicate.ID).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Where("book_id = ?", duplicate.ID).Delete(&BookWork{}).Error
+		if err != nil {
+			return err
+		}
+
+		survivor.ISBN10 = mergeISBNs(survivor.ISBN10, duplicate.ISBN10)
+		survivor.ISBN13 = mergeISBNs(survivor.ISBN13, duplicate.ISBN13)
+		if survivor.LocationID == nil {
+			survivor.LocationID = duplicate.LocationID
+		}
+		if survivor.CallNumber == nil {
+			survivor.CallNumber = duplicate.CallNumber
+		}
+		if survivor.Dewey == nil {
+			survivor.Dewey = duplicate.Dewey
+		}
+		if survivor.LCC == nil {
+			survivor.LCC = duplicate.LCC
+		}
+		if survivor.PublishDate == nil {
+			survivor.PublishDate = duplicate.PublishDate
+		}
+		if survivor.Languages == nil {
+			survivor.Languages = duplicate.Languages
+		}
+
+		err = tx.Delete(&Book{}, duplicate.ID).Error
+		if err != nil {
+			return err
+		}
+
+		err = d.recordChangeTx(tx, Change{Action: ActionMerge, Entity: EntityBook, EntityKey: duplicateOLID, NewValue: &survivorOLID})
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Model(survivor).Select("isbn10", "isbn13", "location_id", "call_number", "dewey", "lcc", "publish_date", "languages", "updated_at").Updates(survivor).Error
+}
+
+// mergeCreditsTx credits the survivor with any authors of the duplicate it lacks, after its own
+// authors, and removes the duplicate's credits.
+func mergeCreditsTx(tx *gorm.DB, survivor Book, duplicate Book) error {
+	var nextPosition int
+	err := tx.Model(&BookAuthor{}).Select("COALESCE(MAX(position) + 1, 0)").Where("book_id = ?", survivor.ID).Scan(&nextPosition).Error
+	if err != nil {
+		return err
+	}
+
+	credits := []BookAuthor{}
+	err = tx.Where("book_id = ?", duplicate.ID).Order("position").Find(&credits).Error
+	if err != nil {
+		return err
+	}
+
+	for _, credit := range credits {
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&BookAuthor{
+			BookID:   survivor.ID,
+			AuthorID: credit.AuthorID,
+			Role:     credit.Role,
+			Position: nextPosition,
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+		nextPosition += int(result.RowsAffected)
+	}
+
+	return tx.Where("book_id = ?", duplicate.ID).Delete(&BookAuthor{}).Error
+}
+
+// mergeISBNs returns the union of two comma separated isbn lists.
+func mergeISBNs(a *string, b *string) *string {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+
+	merged := strings.Split(*a, isbnSeparator)
+	for _, isbn := range strings.Split(*b, isbnSeparator) {
+		found := false
+		for _, existing := range merged {
+			found = found || existing == isbn
+		}
+		if !found {
+			merged = append(merged, isbn)
+		}
+	}
+
+	result := strings.Join(merged, isbnSeparator)
+	return &result
+}
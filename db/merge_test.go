@@ -0,0 +1,84 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/arudzitis/addlib/openlibrary"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRekeyBookMerges(t *testing.T) {
+	authorA := openlibrary.Author{OLID: "olid-authora", Name: "Author A"}
+	authorB := openlibrary.Author{OLID: "olid-authorb", Name: "Author B", Role: openlibrary.RoleEditor}
+
+	survivor := openlibrary.Book{OLID: "olid-booka", Title: "Book A", Isbn13: []string{"9780000000001"}, Authors: []openlibrary.Author{authorA}}
+	duplicate := openlibrary.Book{OLID: "olid-bookb", Title: "Book A", Isbn13: []string{"9780000000002"}, Authors: []openlibrary.Author{authorB, authorA}}
+	renamed := openlibrary.Book{OLID: "olid-bookc", Title: "Book C", Authors: []openlibrary.Author{authorA}}
+
+	db := openTestDatabase(t)
+	defer db.Close()
+
+	for _, book := range []openlibrary.Book{survivor, duplicate, renamed} {
+		require.NoError(t, db.InsertRecord(book))
+	}
+
+	_, err := db.AddCopy(duplicate, Copy{Accession: "A1"})
+	require.NoError(t, err)
+	_, err = db.TagBooks("reference", []openlibrary.Book{duplicate})
+	require.NoError(t, err)
+	shelf, err := db.AddLocation([]string{"Main"})
+	require.NoError(t, err)
+	_, err = db.MoveBook(duplicate, *shelf)
+	require.NoError(t, err)
+
+	err = db.RekeyBook(duplicate.OLID, survivor.OLID)
+	require.NoError(t, err)
+
+	err = db.RekeyBook(renamed.OLID, "olid-bookd")
+	require.NoError(t, err)
+
+	books, err := db.FindBooks(BookFilter{})
+	require.NoError(t, err)
+	require.Len(t, books, 2)
+
+	merged := books[0]
+	assert.Equal(t, "olid-booka", merged.OLID)
+	assert.Equal(t, "9780000000001,9780000000002", *merged.ISBN13)
+	assert.Equal(t, shelf.ID, *merged.LocationID)
+	require.Len(t, merged.Copies, 1)
+	assert.Equal(t, "A1", merged.Copies[0].Accession)
+	require.Len(t, merged.Tags, 1)
+	require.Len(t, merged.Credits, 2)
+	assert.Equal(t, "Author A", merged.Credits[0].Author.Name)
+	assert.Equal(t, "Author B", merged.Credits[1].Author.Name)
+	assert.Equal(t, openlibrary.RoleEditor, merged.Credits[1].Role)
+
+	assert.Equal(t, "olid-bookd", books[1].OLID)
+
+	err = db.MergeBooks(survivor.OLID, []string{"olid-missing"})
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestRekeyAuthorMerges(t *testing.T) {
+	authorA := openlibrary.Author{OLID: "olid-authora", Name: ""}
+	authorB := openlibrary.Author{OLID: "olid-authorb", Name: "Author B"}
+
+	db := openTestDatabase(t)
+	defer db.Close()
+
+	require.NoError(t, db.InsertRecord(openlibrary.Book{OLID: "olid-booka", Title: "Book A", Authors: []openlibrary.Author{authorA}}))
+	require.NoError(t, db.InsertRecord(openlibrary.Book{OLID: "olid-bookb", Title: "Book B", Authors: []openlibrary.Author{authorB}}))
+
+	err := db.RekeyAuthor(authorA.OLID, authorB.OLID)
+	require.NoError(t, err)
+
+	err = db.RekeyAuthor(authorB.OLID, "olid-authorc")
+	require.NoError(t, err)
+
+	authors, err := db.Authors()
+	require.NoError(t, err)
+	require.Len(t, authors, 1)
+	assert.Equal(t, "olid-authorc", authors[0].OLID)
+	assert.Equal(t, int64(2), authors[0].Books)
+}
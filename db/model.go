@@ -1,25 +1,338 @@
 package db
 
 import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/arudzitis/addlib/openlibrary"
+
 	_ "gorm.io/gorm"
 )
 
 type Book struct {
-	ID      int64    `gorm:"primaryKey;column:id"`
-	OLID    string   `gorm:"index;unique;column:olid;not null"`
-	ISBN13  *string  `gorm:"column:isbn13"`
-	ISBN10  *string  `gorm:"column:isbn10"`
-	Title   string   `gorm:"column:title;not null"`
-	Authors []Author `gorm:"many2many:book_authors;"`
+	ID           int64    `gorm:"primaryKey;column:id"`
+	OLID         string   `gorm:"index;unique;column:olid;not null"`
+	ISBN13       *string  `gorm:"column:isbn13"`
+	ISBN10       *string  `gorm:"column:isbn10"`
+	Title        string   `gorm:"column:title;not null"`
+	Dewey        *string  `gorm:"column:dewey"`
+	LCC          *string  `gorm:"column:lcc"`
+	CallNumber   *string  `gorm:"column:call_number"`
+	AuthorSource string   `gorm:"column:author_source;not null;default:''"`
+	LocationID   *int64   `gorm:"index;column:location_id"`
+	Authors      []Author `gorm:"many2many:book_authors;"`
+	Credits      []BookAuthor
+	Copies       []Copy
+	Tags         []Tag  `gorm:"many2many:book_tags;"`
+	Works        []Work `gorm:"many2many:book_works;"`
+
+	CreatedAt time.Time `gorm:"index;column:created_at"`
+	UpdatedAt time.Time `gorm:"index;column:updated_at"`
+
+	// Publication details from openlibrary. PublishDate is free text such as "March 1997", and
+	// Languages are comma separated MARC codes such as "eng".
+	PublishDate *string   `gorm:"column:publish_date"`
+	Languages   *string   `gorm:"column:languages"`
+	Subjects    []Subject `gorm:"many2many:book_subjects;"`
+
+	// DeaccessionedOn is set once the book has left the collection, for one of the
+	// DeaccessionReasons.
+	DeaccessionedOn   *time.Time `gorm:"index;column:deaccessioned_on"`
+	DeaccessionReason string     `gorm:"column:deaccession_reason;not null;default:''"`
+	DeaccessionNote   string     `gorm:"column:deaccession_note;not null;default:''"`
+}
+
+// ShelfMark is the call number the book is shelved under: the local call number if one has been
+// set, otherwise its first Dewey number, otherwise its first Library of Congress number.
+func (b Book) ShelfMark() string {
+	for _, value := range []*string{b.CallNumber, b.Dewey, b.LCC} {
+		if value != nil && *value != "" {
+			first, _, _ := strings.Cut(*value, openlibrary.ClassificationSeparator)
+			return first
+		}
+	}
+	return ""
+}
+
+// publishYearPattern matches a year in the free text of a publication date, such as "March 1997".
+var publishYearPattern = regexp.MustCompile(`\b1[0-9]{3}\b|\b20[0-9]{2}\b`)
+
+// PublishYear returns the year the book was published, if its publication date gives one.
+func (b Book) PublishYear() (int, bool) {
+	if b.PublishDate == nil {
+		return 0, false
+	}
+	match := publishYearPattern.FindString(*b.PublishDate)
+	if match == "" {
+		return 0, false
+	}
+	year, err := strconv.Atoi(match)
+	return year, err == nil
 }
 
+// Reasons a book may be deaccessioned.
+const (
+	DeaccessionDonated   = "donated"
+	DeaccessionLost      = "lost"
+	DeaccessionDamaged   = "damaged"
+	DeaccessionSold      = "sold"
+	DeaccessionWithdrawn = "withdrawn"
+)
+
+var DeaccessionReasons = []string{DeaccessionDonated, DeaccessionLost, DeaccessionDamaged, DeaccessionSold, DeaccessionWithdrawn}
+
 type Author struct {
-	ID   int64  `gorm:"primaryKey;column:id"`
-	OLID string `gorm:"unique;column:olid;not null"`
-	Name string `gorm:"column:name;not null"`
+	ID             int64   `gorm:"primaryKey;column:id"`
+	OLID           string  `gorm:"unique;column:olid;not null"`
+	Name           string  `gorm:"column:name;not null"`
+	PersonalName   *string `gorm:"column:personal_name"`
+	AlternateNames *string `gorm:"column:alternate_names"`
+	BirthDate      *string `gorm:"column:birth_date"`
+	DeathDate      *string `gorm:"column:death_date"`
+	Bio            *string `gorm:"column:bio"`
+	WikidataID     *string `gorm:"column:wikidata_id"`
+	VIAFID         *string `gorm:"column:viaf_id"`
+	// SortNameOverride replaces the sort name derived from Name, for names the derivation gets wrong.
+	SortNameOverride *string `gorm:"column:sort_name"`
+
+	CreatedAt time.Time `gorm:"column:created_at"`
+	UpdatedAt time.Time `gorm:"index;column:updated_at"`
 }
 
+// BookAuthor credits an author with a book. Role is one of the openlibrary.Role constants, and
+// Position orders the authors of a book as openlibrary lists them.
 type BookAuthor struct {
-	BookID   int64 `gorm:"primaryKey;column:book_id"`
-	AuthorID int64 `gorm:"primaryKey;column:author_id"`
+	BookID   int64  `gorm:"primaryKey;column:book_id"`
+	AuthorID int64  `gorm:"primaryKey;column:author_id"`
+	Role     string `gorm:"column:role;not null;default:''"`
+	Position int    `gorm:"column:position;not null;default:0"`
+	Author   Author
+
+	CreatedAt time.Time `gorm:"column:created_at"`
+	UpdatedAt time.Time `gorm:"index;column:updated_at"`
+}
+
+const (
+	CopyStatusAvailable = "available"
+	CopyStatusMissing   = "missing"
+	CopyStatusWithdrawn = "withdrawn"
+)
+
+type Copy struct {
+	ID         int64      `gorm:"primaryKey;column:id"`
+	BookID     int64      `gorm:"index;column:book_id;not null"`
+	Accession  string     `gorm:"unique;column:accession;not null"`
+	Condition  string     `gorm:"column:condition"`
+	AcquiredOn *time.Time `gorm:"column:acquired_on"`
+	Source     string     `gorm:"column:source"`
+	PriceCents *int64     `gorm:"column:price_cents"`
+	Status     string     `gorm:"column:status;not null"`
+	LocationID *int64     `gorm:"index;column:location_id"`
+}
+
+type Work struct {
+	ID    int64  `gorm:"primaryKey;column:id"`
+	OLID  string `gorm:"unique;column:olid;not null"`
+	Title string `gorm:"column:title;not null"`
+}
+
+type BookWork struct {
+	BookID int64 `gorm:"primaryKey;column:book_id"`
+	WorkID int64 `gorm:"primaryKey;column:work_id"`
+}
+
+type Tag struct {
+	ID   int64  `gorm:"primaryKey;column:id"`
+	Name string `gorm:"unique;column:name;not null"`
+}
+
+type BookTag struct {
+	BookID int64 `gorm:"primaryKey;column:book_id"`
+	TagID  int64 `gorm:"primaryKey;column:tag_id"`
+}
+
+// Subject is a subject heading openlibrary gives a book or its work, such as "Epic poetry".
+type Subject struct {
+	ID   int64  `gorm:"primaryKey;column:id"`
+	Name string `gorm:"unique;column:name;not null"`
+}
+
+type BookSubject struct {
+	BookID    int64 `gorm:"primaryKey;column:book_id"`
+	SubjectID int64 `gorm:"primaryKey;index;column:subject_id"`
+}
+
+// Collection is a named set of books, such as an office library, sharing the catalogue with others.
+// A book belongs to a collection itself, or through any of its copies.
+type Collection struct {
+	ID   int64  `gorm:"primaryKey;column:id"`
+	Name string `gorm:"unique;column:name;not null"`
+}
+
+type BookCollection struct {
+	BookID       int64 `gorm:"primaryKey;column:book_id"`
+	CollectionID int64 `gorm:"primaryKey;index;column:collection_id"`
+}
+
+type CopyCollection struct {
+	CopyID       int64 `gorm:"primaryKey;column:copy_id"`
+	CollectionID int64 `gorm:"primaryKey;index;column:collection_id"`
+}
+
+// LoanPolicy sets the terms copies are lent on. A policy attaches to collections, tags or single
+// books; a nil limit falls back to the library-wide setting.
+type LoanPolicy struct {
+	ID             int64  `gorm:"primaryKey;column:id"`
+	Name           string `gorm:"unique;column:name;not null"`
+	LoanDays       *int64 `gorm:"column:loan_days"`
+	MaxRenewals    *int64 `gorm:"column:max_renewals"`
+	MaxItems       *int64 `gorm:"column:max_items"`
+	NonCirculating bool   `gorm:"column:non_circulating;not null;default:false"`
+}
+
+const (
+	PolicyScopeBook       = "book"
+	PolicyScopeTag        = "tag"
+	PolicyScopeCollection = "collection"
+)
+
+// PolicyAssignment attaches a loan policy to the book, tag or collection with TargetID, as named by
+// Scope. Each target has at most one policy.
+type PolicyAssignment struct {
+	Scope    string `gorm:"primaryKey;column:scope"`
+	TargetID int64  `gorm:"primaryKey;column:target_id"`
+	PolicyID int64  `gorm:"index;column:policy_id;not null"`
+}
+
+// LocationKinds names each level of the location hierarchy, from the outermost inwards.
+var LocationKinds = []string{"building", "room", "bookcase", "shelf"}
+
+type Location struct {
+	ID       int64  `gorm:"primaryKey;column:id"`
+	ParentID *int64 `gorm:"index;column:parent_id"`
+	Name     string `gorm:"column:name;not null"`
+	Kind     string `gorm:"column:kind;not null"`
+}
+
+const (
+	PatronStatusActive    = "active"
+	PatronStatusSuspended = "suspended"
+)
+
+// Patron is someone who may borrow copies. Only active patrons may borrow.
+type Patron struct {
+	ID        int64     `gorm:"primaryKey;column:id"`
+	Barcode   string    `gorm:"unique;column:barcode;not null"`
+	Name      string    `gorm:"column:name;not null"`
+	Contact   string    `gorm:"column:contact;not null;default:''"`
+	Status    string    `gorm:"column:status;not null"`
+	CreatedAt time.Time `gorm:"column:created_at"`
+}
+
+// Loan records a copy being lent to a patron. A loan is open until the copy is returned. Once
+// anonymized, a loan no longer records its patron and PatronID is 0.
+type Loan struct {
+	ID           int64      `gorm:"primaryKey;column:id"`
+	CopyID       int64      `gorm:"index;column:copy_id;not null"`
+	PatronID     int64      `gorm:"index;column:patron_id;not null"`
+	CheckedOutAt time.Time  `gorm:"index;column:checked_out_at;not null"`
+	DueOn        time.Time  `gorm:"index;column:due_on;not null"`
+	ReturnedAt   *time.Time `gorm:"index;column:returned_at"`
+	Renewals     int        `gorm:"column:renewals;not null;default:0"`
+	AnonymizedAt *time.Time `gorm:"column:anonymized_at"`
+	Copy         Copy
+	Patron       Patron
+}
+
+const (
+	HoldStatusWaiting   = "waiting"
+	HoldStatusReady     = "ready"
+	HoldStatusFulfilled = "fulfilled"
+	HoldStatusCancelled = "cancelled"
+	HoldStatusExpired   = "expired"
+)
+
+// Hold queues a patron for a book. Holds are waiting until a copy comes back, when the oldest is
+// made ready with that copy reserved for the patron until PickupBy. A hold closes when the patron
+// borrows the book, cancels it or fails to collect it in time. Once anonymized, a closed hold no
+// longer records its patron and PatronID is 0.
+type Hold struct {
+	ID       int64      `gorm:"primaryKey;column:id"`
+	BookID   int64      `gorm:"index;column:book_id;not null"`
+	PatronID int64      `gorm:"index;column:patron_id;not null"`
+	PlacedAt time.Time  `gorm:"column:placed_at;not null"`
+	Status   string     `gorm:"index;column:status;not null"`
+	CopyID   *int64     `gorm:"index;column:copy_id"`
+	PickupBy *time.Time `gorm:"column:pickup_by"`
+	ClosedAt *time.Time `gorm:"column:closed_at"`
+	Book     Book
+	Patron   Patron
+	Copy     *Copy
+}
+
+// Stocktake is a check of the items on the shelves of a location, and the locations within it,
+// against where the catalogue records them. Items are scanned as they are found until the stocktake
+// is finished.
+type Stocktake struct {
+	ID         int64      `gorm:"primaryKey;column:id"`
+	LocationID int64      `gorm:"index;column:location_id;not null"`
+	StartedAt  time.Time  `gorm:"column:started_at;not null"`
+	FinishedAt *time.Time `gorm:"index;column:finished_at"`
+	Location   Location
+	Scans      []StocktakeScan
+}
+
+// StocktakeScan is a barcode, isbn or openlibrary id scanned during a stocktake, along with the copy
+// or book it was found to refer to, if any.
+type StocktakeScan struct {
+	ID          int64     `gorm:"primaryKey;column:id"`
+	StocktakeID int64     `gorm:"index;column:stocktake_id;not null"`
+	Ref         string    `gorm:"column:ref;not null"`
+	CopyID      *int64    `gorm:"column:copy_id"`
+	BookID      *int64    `gorm:"column:book_id"`
+	ScannedAt   time.Time `gorm:"column:scanned_at;not null"`
+	Book        *Book
+}
+
+const (
+	NoticeMethodFile  = "file"
+	NoticeMethodEmail = "email"
+)
+
+// Notice records a reminder about overdue loans being sent to a patron, by email or by writing it to
+// a file for delivery some other way.
+type Notice struct {
+	ID        int64     `gorm:"primaryKey;column:id"`
+	PatronID  int64     `gorm:"index;column:patron_id;not null"`
+	SentAt    time.Time `gorm:"index;column:sent_at;not null"`
+	Method    string    `gorm:"column:method;not null"`
+	Recipient string    `gorm:"column:recipient;not null"`
+	Patron    Patron
+	Loans     []Loan `gorm:"many2many:notice_loans;"`
+}
+
+type NoticeLoan struct {
+	NoticeID int64 `gorm:"primaryKey;column:notice_id"`
+	LoanID   int64 `gorm:"primaryKey;index;column:loan_id"`
+}
+
+// Setting is a configuration value stored in the database; a nil Value means the default applies.
+type Setting struct {
+	Key   string  `gorm:"primaryKey;column:key"`
+	Value *string `gorm:"column:value"`
+}
+
+// Change is an entry in the append-only log of every change made to the catalogue.
+type Change struct {
+	ID        int64     `gorm:"primaryKey;column:id"`
+	CreatedAt time.Time `gorm:"index;column:created_at;not null"`
+	Actor     string    `gorm:"column:actor;not null"`
+	Action    string    `gorm:"column:action;not null"`
+	Entity    string    `gorm:"column:entity;not null"`
+	EntityKey string    `gorm:"index;column:entity_key;not null"`
+	Field     string    `gorm:"column:field;not null;default:''"`
+	OldValue  *string   `gorm:"column:old_value"`
+	NewValue  *string   `gorm:"column:new_value"`
 }
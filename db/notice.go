@@ -0,0 +1,48 @@
+package db
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// RecordNotice records that a notice about some of a patron's loans was sent by the given method to
+// the given recipient, an email address or file name.
+func (d DB) RecordNotice(patron Patron, method string, recipient string, loans []Loan) (*Notice, error) {
+	notice := &Notice{
+		PatronID:  patron.ID,
+		Method:    method,
+		Recipient: recipient,
+	}
+	err := d.db.Transaction(func(tx *gorm.DB) error {
+		notice.SentAt = tx.NowFunc()
+		err := tx.Omit("Patron", "Loans").Create(notice).Error
+		if err != nil {
+			return err
+		}
+
+		for _, loan := range loans {
+			err = tx.Create(&NoticeLoan{NoticeID: notice.ID, LoanID: loan.ID}).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("db: error recording notice: %w", err)
+	}
+	return notice, nil
+}
+
+// Notices returns the notices sent, most recent first, with their patrons and loans.
+func (d DB) Notices() ([]Notice, error) {
+	notices := []Notice{}
+	tx := d.db.Preload("Patron").Preload("Loans").Preload("Loans.Copy").
+		Order("sent_at DESC").Order("id DESC").
+		Find(&notices)
+	if tx.Error != nil {
+		return nil, fmt.Errorf("db: error reading notices: %w", tx.Error)
+	}
+	return notices, nil
+}
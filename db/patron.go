@@ -0,0 +1,128 @@
+package db
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"gorm.io/gorm"
+)
+
+const patronBarcodeFormat = "P%06d"
+
+var PatronStatuses = []string{PatronStatusActive, PatronStatusSuspended}
+
+// AddPatron registers a new patron. If the patron has no barcode, one is generated from the next
+// patron id, skipping any already given to a patron by hand, and patrons are active unless given
+// another status.
+func (d DB) AddPatron(patron Patron) (*Patron, error) {
+	if patron.Name == "" {
+		return nil, fmt.Errorf("db: a patron needs a name")
+	}
+	if patron.Status == "" {
+		patron.Status = PatronStatusActive
+	}
+	if !validPatronStatus(patron.Status) {
+		return nil, fmt.Errorf("db: unknown patron status %q", patron.Status)
+	}
+	patron.ID = 0
+
+	err := d.db.Transaction(func(tx *gorm.DB) error {
+		if patron.Barcode == "" {
+			var err error
+			patron.Barcode, err = generateKeyTx(tx, &Patron{}, "barcode", patronBarcodeFormat)
+			if err != nil {
+				return err
+			}
+		}
+		err := tx.Create(&patron).Error
+		if err != nil {
+			return err
+		}
+
+		return d.recordChangeTx(tx, Change{Action: ActionCreate, Entity: EntityPatron, EntityKey: patron.Barcode, NewValue: &patron.Name})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("db: error creating patron: %w", err)
+	}
+
+	return &patron, nil
+}
+
+// Patrons returns every patron, ordered by name.
+func (d DB) Patrons() ([]Patron, error) {
+	patrons := []Patron{}
+	tx := d.db.Order("name").Order("id").Find(&patrons)
+	if tx.Error != nil {
+		return nil, fmt.Errorf("db: error reading patrons: %w", tx.Error)
+	}
+	return patrons, nil
+}
+
+// FindPatron looks up a patron by barcode or numeric id.
+func (d DB) FindPatron(ref string) (*Patron, error) {
+	patron := Patron{}
+	tx := d.db.Where("barcode = ?", ref).Limit(1).Find(&patron)
+	if tx.Error != nil {
+		return nil, fmt.Errorf("db: error reading patron: %w", tx.Error)
+	}
+	if tx.RowsAffected == 1 {
+		return &patron, nil
+	}
+
+	if id, err := strconv.ParseInt(ref, 10, 64); err == nil {
+		tx = d.db.Limit(1).Find(&patron, id)
+		if tx.Error != nil {
+			return nil, fmt.Errorf("db: error reading patron: %w", tx.Error)
+		}
+		if tx.RowsAffected == 1 {
+			return &patron, nil
+		}
+	}
+
+	return nil, fmt.Errorf("db: no patron %q: %w", ref, ErrNotFound)
+}
+
+// UpdatePatron sets the name, contact details or status of a patron; only the named columns are
+// changed.
+func (d DB) UpdatePatron(patron Patron, columns map[string]string) (int64, error) {
+	if status, ok := columns["status"]; ok && !validPatronStatus(status) {
+		return 0, fmt.Errorf("db: unknown patron status %q", status)
+	}
+	if name, ok := columns["name"]; ok && name == "" {
+		return 0, fmt.Errorf("db: a patron needs a name")
+	}
+
+	var rows int64
+	err := d.db.Transaction(func(tx *gorm.DB) error {
+		names := []string{}
+		for column := range columns {
+			names = append(names, column)
+		}
+		sort.Strings(names)
+
+		for _, column := range names {
+			updated, err := d.updateColumnTx(tx, EntityPatron, patron.Barcode, column, columns[column])
+			if err != nil {
+				return err
+			}
+			if updated > rows {
+				rows = updated
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("db: error updating patron: %w", err)
+	}
+	return rows, nil
+}
+
+func validPatronStatus(status string) bool {
+	for _, valid := range PatronStatuses {
+		if status == valid {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,622 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/arudzitis/addlib/openlibrary"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// FieldPolicy records a loan policy being attached to a book, tag or collection, as its new value,
+// or detached from one, as its old value.
+const FieldPolicy = "policy"
+
+// DefaultPolicyName names the policy made up of the library-wide settings, which governs copies with
+// no other policy attached.
+const DefaultPolicyName = "default"
+
+var PolicyScopes = []string{PolicyScopeBook, PolicyScopeTag, PolicyScopeCollection}
+
+// EffectivePolicy is the loan policy governing a copy, with the limits it leaves unset filled in from
+// the library-wide settings. A nil MaxRenewals or MaxItems means there is no limit.
+type EffectivePolicy struct {
+	LoanPolicy
+	// Source describes what the policy is attached to, such as `tag "reference"`; it is empty for the
+	// default policy.
+	Source string
+}
+
+// PolicyAttachment is a policy attached to a book, tag or collection, along with their names.
+type PolicyAttachment struct {
+	PolicyAssignment
+	Policy string
+	Target string
+}
+
+// CreatePolicy creates a new loan policy, attached to nothing.
+func (d DB) CreatePolicy(policy LoanPolicy) (*LoanPolicy, error) {
+	if _, err := policyColumn("name", policy.Name); err != nil {
+		return nil, fmt.Errorf("db: %w", err)
+	}
+	limits := map[string]*int64{"loan_days": policy.LoanDays, "max_renewals": policy.MaxRenewals, "max_items": policy.MaxItems}
+	for column, limit := range limits {
+		if limit == nil {
+			continue
+		}
+		if _, err := policyColumn(column, strconv.FormatInt(*limit, 10)); err != nil {
+			return nil, fmt.Errorf("db: %w", err)
+		}
+	}
+	policy.ID = 0
+
+	err := d.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Create(&policy).Error
+		if err != nil {
+			return err
+		}
+
+		return d.recordChangeTx(tx, Change{
+			Action:    ActionCreate,
+			Entity:    EntityPolicy,
+			EntityKey: strconv.FormatInt(policy.ID, 10),
+			NewValue:  &policy.Name,
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("db: error creating loan policy: %w", err)
+	}
+	return &policy, nil
+}
+
+// Policies returns every loan policy, ordered by name.
+func (d DB) Policies() ([]LoanPolicy, error) {
+	policies := []LoanPolicy{}
+	tx := d.db.Order("name").Find(&policies)
+	if tx.Error != nil {
+		return nil, fmt.Errorf("db: error reading loan policies: %w", tx.Error)
+	}
+	return policies, nil
+}
+
+// FindPolicy looks up a loan policy by its name or numeric id.
+func (d DB) FindPolicy(ref string) (*LoanPolicy, error) {
+	policy := LoanPolicy{}
+	tx := d.db.Where("name = ?", ref).Limit(1).Find(&policy)
+	if tx.Error != nil {
+		return nil, fmt.Errorf("db: error reading loan policy: %w", tx.Error)
+	}
+	if tx.RowsAffected == 1 {
+		return &policy, nil
+	}
+
+	if id, err := strconv.ParseInt(ref, 10, 64); err == nil {
+		tx = d.db.Limit(1).Find(&policy, id)
+		if tx.Error != nil {
+			return nil, fmt.Errorf("db: error reading loan policy: %w", tx.Error)
+		}
+		if tx.RowsAffected == 1 {
+			return &policy, nil
+		}
+	}
+
+	return nil, fmt.Errorf("db: no loan policy %q: %w", ref, ErrNotFound)
+}
+
+// UpdatePolicy sets the name, limits or circulation of a loan policy; only the named columns are
+// changed. An empty limit is cleared, so the library-wide setting applies.
+func (d DB) UpdatePolicy(policy LoanPolicy, columns map[string]string) (int64, error) {
+	values := map[string]interface{}{}
+	names := []string{}
+	for column, value := range columns {
+		converted, err := policyColumn(column, value)
+		if err != nil {
+			return 0, fmt.Errorf("db: %w", err)
+		}
+		values[column] = converted
+		names = append(names, column)
+	}
+	sort.Strings(names)
+
+	var rows int64
+	err := d.db.Transaction(func(tx *gorm.DB) error {
+		for _, column := range names {
+			updated, err := d.updateColumnTx(tx, EntityPolicy, strconv.FormatInt(policy.ID, 10), column, values[column])
+			if err != nil {
+				return err
+			}
+			if updated > rows {
+				rows = updated
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("db: error updating loan policy: %w", err)
+	}
+	return rows, nil
+}
+
+// DeletePolicy removes a loan policy which is no longer attached to anything.
+func (d DB) DeletePolicy(policy LoanPolicy) error {
+	err := d.db.Transaction(func(tx *gorm.DB) error {
+		var attached int64
+		err := tx.Model(&PolicyAssignment{}).Where("policy_id = ?", policy.ID).Count(&attached).Error
+		if err != nil {
+			return err
+		}
+		if attached > 0 {
+			return fmt.Errorf("policy %s is attached to %d books, tags or collections; detach it first", policy.Name, attached)
+		}
+
+		result := tx.Delete(&LoanPolicy{}, policy.ID)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return fmt.Errorf("no loan policy %q: %w", policy.Name, ErrNotFound)
+		}
+
+		return d.recordChangeTx(tx, Change{
+			Action:    ActionDelete,
+			Entity:    EntityPolicy,
+			EntityKey: strconv.FormatInt(policy.ID, 10),
+			OldValue:  &policy.Name,
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("db: error deleting loan policy: %w", err)
+	}
+	return nil
+}
+
+// AttachPolicy attaches a loan policy to a book, tag or collection, as named by scope, replacing any
+// policy already attached to it. Books are given by openlibrary id, tags by name and collections by
+// name or id.
+func (d DB) AttachPolicy(policy LoanPolicy, scope string, key string) error {
+	err := d.db.Transaction(func(tx *gorm.DB) error {
+		targetID, entityKey, err := policyTargetTx(tx, scope, key)
+		if err != nil {
+			return err
+		}
+
+		old, err := attachedPolicyTx(tx, scope, targetID)
+		if err != nil {
+			return err
+		}
+		if old != nil && old.ID == policy.ID {
+			return nil
+		}
+
+		err = tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(&PolicyAssignment{Scope: scope, TargetID: targetID, PolicyID: policy.ID}).Error
+		if err != nil {
+			return err
+		}
+
+		change := Change{Action: ActionUpdate, Entity: scope, EntityKey: entityKey, Field: FieldPolicy, NewValue: &policy.Name}
+		if old != nil {
+			change.OldValue = &old.Name
+		}
+		return d.recordChangeTx(tx, change)
+	})
+	if err != nil {
+		return fmt.Errorf("db: error attaching loan policy: %w", err)
+	}
+	return nil
+}
+
+// DetachPolicy removes the loan policy attached to a book, tag or collection, named as for
+// AttachPolicy.
+func (d DB) DetachPolicy(scope string, key string) error {
+	err := d.db.Transaction(func(tx *gorm.DB) error {
+		targetID, entityKey, err := policyTargetTx(tx, scope, key)
+		if err != nil {
+			return err
+		}
+
+		old, err := attachedPolicyTx(tx, scope, targetID)
+		if err != nil {
+			return err
+		}
+		if old == nil {
+			return fmt.Errorf("no loan policy is attached to %s %s: %w", scope, key, ErrNotFound)
+		}
+
+		err = tx.Where("scope = ? AND target_id = ?", scope, targetID).Delete(&PolicyAssignment{}).Error
+		if err != nil {
+			return err
+		}
+
+		return d.recordChangeTx(tx, Change{Action: ActionUpdate, Entity: scope, EntityKey: entityKey, Field: FieldPolicy, OldValue: &old.Name})
+	})
+	if err != nil {
+		return fmt.Errorf("db: error detaching loan policy: %w", err)
+	}
+	return nil
+}
+
+// PolicyAttachments returns everything a loan policy is attached to, ordered by policy name.
+func (d DB) PolicyAttachments() ([]PolicyAttachment, error) {
+	attachments := []PolicyAttachment{}
+	tx := d.db.Model(&PolicyAssignment{}).
+		Select("policy_assignments.*, loan_policies.name AS policy").
+		Joins("JOIN loan_policies ON loan_policies.id = policy_assignments.policy_id").
+		Order("loan_policies.name").Order("policy_assignments.scope").Order("policy_assignments.target_id").
+		Scan(&attachments)
+	if tx.Error != nil {
+		return nil, fmt.Errorf("db: error reading loan policies: %w", tx.Error)
+	}
+
+	for i := range attachments {
+		target, err := policyTargetNameTx(d.db, attachments[i].PolicyAssignment)
+		if err != nil {
+			return nil, fmt.Errorf("db: error reading loan policies: %w", err)
+		}
+		attachments[i].Target = target
+	}
+	return attachments, nil
+}
+
+// CopyPolicy returns the loan policy governing the copy with the given accession number.
+func (d DB) CopyPolicy(accession string) (*EffectivePolicy, error) {
+	bookCopy, err := readCopyTx(d.db, accession)
+	if err != nil {
+		return nil, fmt.Errorf("db: %w", err)
+	}
+
+	policy, err := d.policyTx(d.db, bookCopy.BookID, bookCopy.ID)
+	if err != nil {
+		return nil, fmt.Errorf("db: error resolving loan policy: %w", err)
+	}
+	return policy, nil
+}
+
+// BookPolicy returns the loan policy governing the copies of a book, leaving aside any collections
+// single copies belong to.
+func (d DB) BookPolicy(book openlibrary.Book) (*EffectivePolicy, error) {
+	ormBook, err := d.readBook(book.OLID)
+	if err != nil {
+		return nil, err
+	}
+	if ormBook == nil {
+		return nil, fmt.Errorf("db: no book with olid %s: %w", book.OLID, ErrNotFound)
+	}
+
+	policy, err := d.policyTx(d.db, ormBook.ID, 0)
+	if err != nil {
+		return nil, fmt.Errorf("db: error resolving loan policy: %w", err)
+	}
+	return policy, nil
+}
+
+// policyTx resolves the loan policy governing a copy, or a book if copyID is 0. A policy attached to
+// the book itself wins over one attached to any of its tags, which wins over one attached to any of
+// the collections the book or copy is in; among several tags or collections the strictest policy
+// wins. Without any of these the default policy applies.
+func (d DB) policyTx(tx *gorm.DB, bookID int64, copyID int64) (*EffectivePolicy, error) {
+	levels := []struct {
+		scope   string
+		targets string
+		args    []interface{}
+	}{
+		{PolicyScopeBook, "?", []interface{}{bookID}},
+		{PolicyScopeTag, "SELECT tag_id FROM book_tags WHERE book_id = ?", []interface{}{bookID}},
+		{PolicyScopeCollection, "SELECT collection_id FROM book_collections WHERE book_id = ? " +
+			"UNION SELECT collection_id FROM copy_collections WHERE copy_id = ?", []interface{}{bookID, copyID}},
+	}
+
+	defaults, err := d.defaultPolicy()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, level := range levels {
+		assignments := []PolicyAssignment{}
+		args := append([]interface{}{level.scope}, level.args...)
+		err := tx.Where("scope = ? AND target_id IN ("+level.targets+")", args...).Find(&assignments).Error
+		if err != nil {
+			return nil, err
+		}
+		if len(assignments) == 0 {
+			continue
+		}
+
+		policyIDs := []int64{}
+		for _, assignment := range assignments {
+			policyIDs = append(policyIDs, assignment.PolicyID)
+		}
+		policies := []LoanPolicy{}
+		err = tx.Where("id IN ?", policyIDs).Find(&policies).Error
+		if err != nil {
+			return nil, err
+		}
+		sort.Slice(policies, func(i, j int) bool { return stricter(policies[i], policies[j]) })
+		policy := policies[0]
+
+		source := ""
+		for _, assignment := range assignments {
+			if assignment.PolicyID == policy.ID {
+				name, err := policyTargetNameTx(tx, assignment)
+				if err != nil {
+					return nil, err
+				}
+				source = fmt.Sprintf("%s %q", assignment.Scope, name)
+				break
+			}
+		}
+
+		if policy.LoanDays == nil {
+			policy.LoanDays = defaults.LoanDays
+		}
+		if policy.MaxRenewals == nil {
+			policy.MaxRenewals = defaults.MaxRenewals
+		}
+		if policy.MaxItems == nil {
+			policy.MaxItems = defaults.MaxItems
+		}
+		return &EffectivePolicy{LoanPolicy: policy, Source: source}, nil
+	}
+
+	return &EffectivePolicy{LoanPolicy: *defaults}, nil
+}
+
+// defaultPolicy returns the policy made up of the library-wide settings.
+func (d DB) defaultPolicy() (*LoanPolicy, error) {
+	period, err := d.LoanPeriod()
+	if err != nil {
+		return nil, err
+	}
+	loanDays := int64(period)
+	policy := &LoanPolicy{Name: DefaultPolicyName, LoanDays: &loanDays}
+
+	for key, limit := range map[string]**int64{SettingMaxRenewals: &policy.MaxRenewals, SettingMaxLoans: &policy.MaxItems} {
+		value, err := d.Setting(key)
+		if err != nil {
+			return nil, err
+		}
+		if value == "" {
+			continue
+		}
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s setting: %w", key, err)
+		}
+		*limit = &n
+	}
+	return policy, nil
+}
+
+// checkItemLimitTx fails if a patron already has as many copies out as the library allows in all, or
+// as many out under a policy as the policy itself allows. A policy without its own limit only
+// inherits the library-wide one, which counts every loan rather than those under the policy.
+func (d DB) checkItemLimitTx(tx *gorm.DB, patron Patron, policy EffectivePolicy) error {
+	defaults, err := d.defaultPolicy()
+	if err != nil {
+		return err
+	}
+
+	loans := []Loan{}
+	err = tx.Preload("Copy").Where("patron_id = ? AND returned_at IS NULL", patron.ID).Find(&loans).Error
+	if err != nil {
+		return err
+	}
+	if defaults.MaxItems != nil && int64(len(loans)) >= *defaults.MaxItems {
+		return fmt.Errorf("patron %s already has %d items out, the most the library allows", patron.Barcode, len(loans))
+	}
+
+	if policy.ID == 0 {
+		return nil
+	}
+	limits := []sql.NullInt64{}
+	err = tx.Model(&LoanPolicy{}).Where("id = ?", policy.ID).Pluck("max_items", &limits).Error
+	if err != nil {
+		return err
+	}
+	if len(limits) != 1 || !limits[0].Valid {
+		return nil
+	}
+
+	var out int64
+	for _, loan := range loans {
+		governing, err := d.policyTx(tx, loan.Copy.BookID, loan.CopyID)
+		if err != nil {
+			return err
+		}
+		if governing.ID == policy.ID {
+			out++
+		}
+	}
+	if out >= limits[0].Int64 {
+		return fmt.Errorf("patron %s already has %d items out under the %s policy, the most it allows", patron.Barcode, out, policy.Name)
+	}
+	return nil
+}
+
+// stricter orders loan policies from the strictest: non-circulating policies first, then by the
+// shortest loan, the fewest renewals and the fewest items.
+func stricter(a LoanPolicy, b LoanPolicy) bool {
+	if a.NonCirculating != b.NonCirculating {
+		return a.NonCirculating
+	}
+	for _, limits := range [][2]*int64{{a.LoanDays, b.LoanDays}, {a.MaxRenewals, b.MaxRenewals}, {a.MaxItems, b.MaxItems}} {
+		x, y := limits[0], limits[1]
+		switch {
+		case x == nil && y == nil:
+		case x == nil:
+			return false
+		case y == nil:
+			return true
+		case *x != *y:
+			return *x < *y
+		}
+	}
+	return a.ID < b.ID
+}
+
+// policyColumn converts a value given for a column of a loan policy, checking it is in range. An empty
+// limit converts to nil.
+func policyColumn(column string, value string) (interface{}, error) {
+	switch column {
+	case "name":
+		if value == "" {
+			return nil, fmt.Errorf("a loan policy needs a name")
+		}
+		if value == DefaultPolicyName {
+			return nil, fmt.Errorf("the name %q is kept for the library-wide settings", DefaultPolicyName)
+		}
+		return value, nil
+
+	case "non_circulating":
+		nonCirculating, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for %s: %w", column, err)
+		}
+		if nonCirculating {
+			return int64(1), nil
+		}
+		return int64(0), nil
+
+	case "loan_days", "max_renewals", "max_items":
+		if value == "" {
+			return nil, nil
+		}
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for %s: %w", column, err)
+		}
+		least := int64(1)
+		if column == "max_renewals" {
+			least = 0
+		}
+		if n < least {
+			return nil, fmt.Errorf("%s must be at least %d", column, least)
+		}
+		return n, nil
+	}
+
+	return nil, fmt.Errorf("loan policies have no %s", column)
+}
+
+// policyTargetTx finds the id of the book, tag or collection a policy is attached to, and the key it
+// is recorded under in the change log.
+func policyTargetTx(tx *gorm.DB, scope string, key string) (int64, string, error) {
+	switch scope {
+	case PolicyScopeBook:
+		book, err := readBookTx(tx, key)
+		if err != nil {
+			return 0, "", err
+		}
+		if book == nil {
+			return 0, "", fmt.Errorf("no book with olid %s: %w", key, ErrNotFound)
+		}
+		return book.ID, book.OLID, nil
+
+	case PolicyScopeTag:
+		tag := Tag{}
+		result := tx.Where("name = ?", key).Limit(1).Find(&tag)
+		if result.Error != nil {
+			return 0, "", result.Error
+		}
+		if result.RowsAffected == 0 {
+			return 0, "", fmt.Errorf("no tag %q: %w", key, ErrNotFound)
+		}
+		return tag.ID, tag.Name, nil
+
+	case PolicyScopeCollection:
+		collection := Collection{}
+		result := tx.Where("name = ?", key).Limit(1).Find(&collection)
+		if result.Error != nil {
+			return 0, "", result.Error
+		}
+		if result.RowsAffected == 0 {
+			id, err := strconv.ParseInt(key, 10, 64)
+			if err != nil {
+				return 0, "", fmt.Errorf("no collection %q: %w", key, ErrNotFound)
+			}
+			result = tx.Limit(1).Find(&collection, id)
+			if result.Error != nil {
+				return 0, "", result.Error
+			}
+			if result.RowsAffected == 0 {
+				return 0, "", fmt.Errorf("no collection %q: %w", key, ErrNotFound)
+			}
+		}
+		return collection.ID, strconv.FormatInt(collection.ID, 10), nil
+	}
+
+	return 0, "", fmt.Errorf("loan policies attach to a %s, %s or %s, not a %s", PolicyScopeBook, PolicyScopeTag, PolicyScopeCollection, scope)
+}
+
+// policyTargetNameTx returns the title of the book, or the name of the tag or collection, a policy is
+// attached to.
+func policyTargetNameTx(tx *gorm.DB, assignment PolicyAssignment) (string, error) {
+	var names []string
+	var err error
+	switch assignment.Scope {
+	case PolicyScopeBook:
+		err = tx.Model(&Book{}).Where("id = ?", assignment.TargetID).Pluck("title", &names).Error
+	case PolicyScopeTag:
+		err = tx.Model(&Tag{}).Where("id = ?", assignment.TargetID).Pluck("name", &names).Error
+	case PolicyScopeCollection:
+		err = tx.Model(&Collection{}).Where("id = ?", assignment.TargetID).Pluck("name", &names).Error
+	}
+	if err != nil || len(names) == 0 {
+		return "", err
+	}
+	return names[0], nil
+}
+
+// attachedPolicyTx returns the policy attached to a book, tag or collection, or nil if there is none.
+func attachedPolicyTx(tx *gorm.DB, scope string, targetID int64) (*LoanPolicy, error) {
+	policy := LoanPolicy{}
+	result := tx.Joins("JOIN policy_assignments ON policy_assignments.policy_id = loan_policies.id").
+		Where("policy_assignments.scope = ? AND policy_assignments.target_id = ?", scope, targetID).
+		Limit(1).
+		Find(&policy)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return nil, result.Error
+	}
+	return &policy, nil
+}
+
+// undoAttachment reverses a loan policy being attached to or detached from a book, tag or collection,
+// while the policy it left attached is still in place.
+func (d DB) undoAttachment(change Change) error {
+	var current *LoanPolicy
+	err := d.db.Transaction(func(tx *gorm.DB) error {
+		targetID, _, err := policyTargetTx(tx, change.Entity, change.EntityKey)
+		if err != nil {
+			return err
+		}
+		current, err = attachedPolicyTx(tx, change.Entity, targetID)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("db: error reading loan policy: %w", err)
+	}
+
+	currentName := ""
+	if current != nil {
+		currentName = current.Name
+	}
+	if currentName != strValue(change.NewValue) {
+		return fmt.Errorf("db: policy of %s %s has changed since change %d", change.Entity, change.EntityKey, change.ID)
+	}
+
+	if change.OldValue == nil {
+		return d.DetachPolicy(change.Entity, change.EntityKey)
+	}
+	policy, err := d.FindPolicy(*change.OldValue)
+	if errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("db: loan policy %q no longer exists: %w", *change.OldValue, err)
+	}
+	if err != nil {
+		return err
+	}
+	return d.AttachPolicy(*policy, change.Entity, change.EntityKey)
+}
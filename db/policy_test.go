@@ -0,0 +1,271 @@
+package db
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/arudzitis/addlib/openlibrary"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func int64Ptr(n int64) *int64 {
+	return &n
+}
+
+func TestPolicyResolution(t *testing.T) {
+	book := openlibrary.Book{OLID: "olid-booka", Title: "Book A"}
+	other := openlibrary.Book{OLID: "olid-bookb", Title: "Book B"}
+
+	db := openTestDatabase(t)
+	defer db.Close()
+
+	require.NoError(t, db.InsertRecord(book))
+	require.NoError(t, db.InsertRecord(other))
+	bookCopy, err := db.AddCopy(book, Copy{})
+	require.NoError(t, err)
+	otherCopy, err := db.AddCopy(book, Copy{})
+	require.NoError(t, err)
+
+	require.NoError(t, db.SetSetting(SettingMaxRenewals, "3"))
+
+	policy, err := db.CopyPolicy(bookCopy.Accession)
+	require.NoError(t, err)
+	assert.Equal(t, DefaultPolicyName, policy.Name)
+	assert.Equal(t, int64(21), *policy.LoanDays)
+	assert.Equal(t, int64(3), *policy.MaxRenewals)
+	assert.Nil(t, policy.MaxItems)
+
+	collectionPolicy, err := db.CreatePolicy(LoanPolicy{Name: "office", LoanDays: int64Ptr(28)})
+	require.NoError(t, err)
+	shortLoan, err := db.CreatePolicy(LoanPolicy{Name: "short loan", LoanDays: int64Ptr(7), MaxItems: int64Ptr(2)})
+	require.NoError(t, err)
+	reference, err := db.CreatePolicy(LoanPolicy{Name: "reference", NonCirculating: true})
+	require.NoError(t, err)
+	bookPolicy, err := db.CreatePolicy(LoanPolicy{Name: "special", LoanDays: int64Ptr(60), MaxRenewals: int64Ptr(0)})
+	require.NoError(t, err)
+
+	_, err = db.CreatePolicy(LoanPolicy{Name: DefaultPolicyName})
+	assert.Error(t, err)
+	_, err = db.CreatePolicy(LoanPolicy{Name: "bad", LoanDays: int64Ptr(0)})
+	assert.Error(t, err)
+
+	// collections of a single copy apply to that copy only
+	office, err := db.CreateCollection("office")
+	require.NoError(t, err)
+	require.NoError(t, db.AttachPolicy(*collectionPolicy, PolicyScopeCollection, "office"))
+	_, err = db.AddCopyToCollection(*office, bookCopy.Accession)
+	require.NoError(t, err)
+
+	policy, err = db.CopyPolicy(bookCopy.Accession)
+	require.NoError(t, err)
+	assert.Equal(t, "office", policy.Name)
+	assert.Equal(t, `collection "office"`, policy.Source)
+	assert.Equal(t, int64(28), *policy.LoanDays)
+	assert.Equal(t, int64(3), *policy.MaxRenewals, "limits a policy leaves unset come from the settings")
+
+	policy, err = db.CopyPolicy(otherCopy.Accession)
+	require.NoError(t, err)
+	assert.Equal(t, DefaultPolicyName, policy.Name)
+	policy, err = db.BookPolicy(book)
+	require.NoError(t, err)
+	assert.Equal(t, DefaultPolicyName, policy.Name)
+
+	// tags win over collections, and the strictest of several tags wins
+	_, err = db.TagBooks("new", []openlibrary.Book{book})
+	require.NoError(t, err)
+	_, err = db.TagBooks("reference", []openlibrary.Book{book})
+	require.NoError(t, err)
+	require.NoError(t, db.AttachPolicy(*shortLoan, PolicyScopeTag, "new"))
+
+	policy, err = db.CopyPolicy(bookCopy.Accession)
+	require.NoError(t, err)
+	assert.Equal(t, "short loan", policy.Name)
+	assert.Equal(t, `tag "new"`, policy.Source)
+
+	require.NoError(t, db.AttachPolicy(*reference, PolicyScopeTag, "reference"))
+	policy, err = db.CopyPolicy(bookCopy.Accession)
+	require.NoError(t, err)
+	assert.Equal(t, "reference", policy.Name)
+	assert.True(t, policy.NonCirculating)
+
+	// the book's own policy wins over everything
+	require.NoError(t, db.AttachPolicy(*bookPolicy, PolicyScopeBook, book.OLID))
+	policy, err = db.CopyPolicy(bookCopy.Accession)
+	require.NoError(t, err)
+	assert.Equal(t, "special", policy.Name)
+	assert.Equal(t, `book "Book A"`, policy.Source)
+	assert.Equal(t, int64(0), *policy.MaxRenewals)
+
+	policy, err = db.BookPolicy(other)
+	require.NoError(t, err)
+	assert.Equal(t, DefaultPolicyName, policy.Name)
+
+	// attaching another policy replaces the first, and detaching falls back to the tags
+	require.NoError(t, db.AttachPolicy(*shortLoan, PolicyScopeBook, book.OLID))
+	policy, err = db.BookPolicy(book)
+	require.NoError(t, err)
+	assert.Equal(t, "short loan", policy.Name)
+
+	require.NoError(t, db.DetachPolicy(PolicyScopeBook, book.OLID))
+	policy, err = db.BookPolicy(book)
+	require.NoError(t, err)
+	assert.Equal(t, "reference", policy.Name)
+	assert.ErrorIs(t, db.DetachPolicy(PolicyScopeBook, book.OLID), ErrNotFound)
+
+	attachments, err := db.PolicyAttachments()
+	require.NoError(t, err)
+	require.Len(t, attachments, 3)
+	assert.Equal(t, "office", attachments[0].Policy)
+	assert.Equal(t, "office", attachments[0].Target)
+	assert.Equal(t, "reference", attachments[1].Target)
+	assert.Equal(t, "new", attachments[2].Target)
+
+	assert.Error(t, db.DeletePolicy(*reference), "attached policies cannot be deleted")
+	require.NoError(t, db.DeletePolicy(*bookPolicy))
+
+	// changes to policies and attachments can be undone
+	_, err = db.UpdatePolicy(*reference, map[string]string{"non_circulating": "false", "loan_days": "2"})
+	require.NoError(t, err)
+	policy, err = db.BookPolicy(book)
+	require.NoError(t, err)
+	assert.Equal(t, "reference", policy.Name, "a two day loan is stricter than a seven day one")
+	assert.False(t, policy.NonCirculating)
+	assert.Equal(t, int64(2), *policy.LoanDays)
+
+	history, err := db.History("")
+	require.NoError(t, err)
+	require.NoError(t, db.Undo(history[len(history)-1].ID))
+	require.NoError(t, db.Undo(history[len(history)-2].ID))
+	policy, err = db.BookPolicy(book)
+	require.NoError(t, err)
+	assert.Equal(t, "reference", policy.Name)
+	assert.Equal(t, int64(21), *policy.LoanDays)
+
+	detach := Change{}
+	for _, change := range history {
+		if change.Field == FieldPolicy && change.NewValue == nil {
+			detach = change
+		}
+	}
+	require.NoError(t, db.Undo(detach.ID))
+	policy, err = db.BookPolicy(book)
+	require.NoError(t, err)
+	assert.Equal(t, "short loan", policy.Name)
+}
+
+func TestPolicyEnforcement(t *testing.T) {
+	book := openlibrary.Book{OLID: "olid-booka", Title: "Book A"}
+	other := openlibrary.Book{OLID: "olid-bookb", Title: "Book B"}
+
+	db := openTestDatabase(t)
+	defer db.Close()
+
+	clock := time.Date(2023, time.May, 1, 15, 0, 0, 0, time.UTC)
+	db.db.Config.NowFunc = func() time.Time { return clock }
+
+	require.NoError(t, db.InsertRecord(book))
+	require.NoError(t, db.InsertRecord(other))
+	copies := []*Copy{}
+	for _, b := range []openlibrary.Book{book, book, book, other} {
+		bookCopy, err := db.AddCopy(b, Copy{})
+		require.NoError(t, err)
+		copies = append(copies, bookCopy)
+	}
+	ada, err := db.AddPatron(Patron{Name: "Ada"})
+	require.NoError(t, err)
+
+	shortLoan, err := db.CreatePolicy(LoanPolicy{Name: "short loan", LoanDays: int64Ptr(7), MaxRenewals: int64Ptr(1), MaxItems: int64Ptr(2)})
+	require.NoError(t, err)
+	require.NoError(t, db.AttachPolicy(*shortLoan, PolicyScopeBook, book.OLID))
+
+	loan, err := db.Checkout(copies[0].Accession, *ada, nil)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2023, time.May, 8, 0, 0, 0, 0, time.UTC), loan.DueOn.UTC())
+
+	late := time.Date(2023, time.June, 1, 0, 0, 0, 0, time.UTC)
+	_, err = db.Checkout(copies[1].Accession, *ada, &late)
+	assert.Error(t, err, "due dates may not be later than the policy allows")
+
+	_, err = db.Checkout(copies[1].Accession, *ada, nil)
+	require.NoError(t, err)
+	_, err = db.Checkout(copies[2].Accession, *ada, nil)
+	assert.Error(t, err, "only two short loans at once")
+
+	_, err = db.Checkout(copies[3].Accession, *ada, &late)
+	require.NoError(t, err, "other books are lent under the default policy")
+
+	_, err = db.Renew(copies[0].Accession, nil)
+	require.NoError(t, err)
+	_, err = db.Renew(copies[0].Accession, nil)
+	assert.Error(t, err, "only one renewal")
+
+	reference, err := db.CreatePolicy(LoanPolicy{Name: "reference", NonCirculating: true})
+	require.NoError(t, err)
+	require.NoError(t, db.AttachPolicy(*reference, PolicyScopeBook, other.OLID))
+	_, _, err = db.Checkin(copies[3].Accession)
+	require.NoError(t, err)
+	_, err = db.Checkout(copies[3].Accession, *ada, nil)
+	assert.ErrorIs(t, err, ErrUnavailable)
+}
+
+func TestLibraryLoanLimit(t *testing.T) {
+	book := openlibrary.Book{OLID: "olid-booka", Title: "Book A"}
+	other := openlibrary.Book{OLID: "olid-bookb", Title: "Book B"}
+
+	db := openTestDatabase(t)
+	defer db.Close()
+
+	require.NoError(t, db.InsertRecord(book))
+	require.NoError(t, db.InsertRecord(other))
+	copies := []*Copy{}
+	for _, b := range []openlibrary.Book{book, book, other} {
+		bookCopy, err := db.AddCopy(b, Copy{})
+		require.NoError(t, err)
+		copies = append(copies, bookCopy)
+	}
+	ada, err := db.AddPatron(Patron{Name: "Ada"})
+	require.NoError(t, err)
+
+	// a policy without its own limit does not count only its own loans against the library's
+	shortLoan, err := db.CreatePolicy(LoanPolicy{Name: "short loan", LoanDays: int64Ptr(7)})
+	require.NoError(t, err)
+	require.NoError(t, db.AttachPolicy(*shortLoan, PolicyScopeBook, book.OLID))
+	require.NoError(t, db.SetSetting(SettingMaxLoans, "2"))
+
+	_, err = db.Checkout(copies[2].Accession, *ada, nil)
+	require.NoError(t, err)
+	_, err = db.Checkout(copies[0].Accession, *ada, nil)
+	require.NoError(t, err)
+	_, err = db.Checkout(copies[1].Accession, *ada, nil)
+	assert.Error(t, err, "two loans in all, whatever their policies")
+
+	_, _, err = db.Checkin(copies[2].Accession)
+	require.NoError(t, err)
+	_, err = db.Checkout(copies[1].Accession, *ada, nil)
+	assert.NoError(t, err)
+}
+
+func TestUndoPolicyCreation(t *testing.T) {
+	db := openTestDatabase(t)
+	defer db.Close()
+
+	first, err := db.CreatePolicy(LoanPolicy{Name: "short loan", LoanDays: int64Ptr(7)})
+	require.NoError(t, err)
+	_, err = db.UpdatePolicy(*first, map[string]string{"name": "week loan"})
+	require.NoError(t, err)
+	second, err := db.CreatePolicy(LoanPolicy{Name: "short loan", LoanDays: int64Ptr(3)})
+	require.NoError(t, err)
+
+	changes, err := db.History(strconv.FormatInt(first.ID, 10))
+	require.NoError(t, err)
+	require.Equal(t, ActionCreate, changes[0].Action)
+	require.NoError(t, db.Undo(changes[0].ID))
+
+	_, err = db.FindPolicy("week loan")
+	assert.ErrorIs(t, err, ErrNotFound)
+	found, err := db.FindPolicy("short loan")
+	require.NoError(t, err)
+	assert.Equal(t, second.ID, found.ID)
+}
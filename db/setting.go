@@ -0,0 +1,209 @@
+package db
+
+import (
+	"fmt"
+	"net"
+	"net/mail"
+	"sort"
+	"strconv"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// SettingLoanPeriod is the number of days a copy is lent for unless a due date is given or a loan
+	// policy says otherwise.
+	SettingLoanPeriod = "loan_period_days"
+
+	// SettingMaxRenewals is the number of times a loan may be renewed; unset, there is no limit.
+	SettingMaxRenewals = "max_renewals"
+	// SettingMaxLoans is the number of copies a patron may have out at once; unset, there is no limit.
+	SettingMaxLoans = "max_loans"
+
+	// SettingAnonymizeAfterDays is the number of days after a copy is returned that the loan stops
+	// recording who borrowed it; unset, loans are kept in full.
+	SettingAnonymizeAfterDays = "anonymize_loans_after_days"
+
+	// SettingHoldPickupDays is the number of days a copy is kept for a patron whose hold is ready.
+	SettingHoldPickupDays = "hold_pickup_days"
+
+	// SettingSMTPServer is the host and port of the server notices are emailed through.
+	SettingSMTPServer = "smtp_server"
+	// SettingSMTPFrom is the address notices are emailed from.
+	SettingSMTPFrom = "smtp_from"
+	// SettingSMTPUsername authenticates with the SMTP server if it is set.
+	SettingSMTPUsername = "smtp_username"
+)
+
+// setting describes a configuration value: its default and how to check a new value.
+type setting struct {
+	description  string
+	defaultValue string
+	validate     func(string) error
+}
+
+var settings = map[string]setting{
+	SettingLoanPeriod: {
+		description:  "days a copy is lent for",
+		defaultValue: "21",
+		validate:     positiveInt,
+	},
+	SettingMaxRenewals: {
+		description: "times a loan may be renewed, unless a loan policy says otherwise; unlimited if unset",
+		validate:    nonNegativeInt,
+	},
+	SettingMaxLoans: {
+		description: "copies a patron may have out at once, unless a loan policy says otherwise; unlimited if unset",
+		validate:    positiveInt,
+	},
+	SettingAnonymizeAfterDays: {
+		description: "days after their return that loans forget who borrowed them; never if unset",
+		validate:    positiveInt,
+	},
+	SettingHoldPickupDays: {
+		description:  "days a returned copy is kept for the next patron in the hold queue",
+		defaultValue: "7",
+		validate:     positiveInt,
+	},
+	SettingSMTPServer: {
+		description:  "host:port of the SMTP server notices are sent through",
+		defaultValue: "localhost:25",
+		validate:     hostPort,
+	},
+	SettingSMTPFrom: {
+		description: "address notices are sent from",
+		validate:    emailAddress,
+	},
+	SettingSMTPUsername: {
+		description: "username for the SMTP server, if it needs one",
+	},
+}
+
+// SettingValue is the current value of a setting.
+type SettingValue struct {
+	Key         string
+	Value       string
+	Description string
+	// IsDefault is set when the value has not been configured.
+	IsDefault bool
+}
+
+// Settings returns the value of every setting, ordered by key.
+func (d DB) Settings() ([]SettingValue, error) {
+	keys := []string{}
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	values := []SettingValue{}
+	for _, key := range keys {
+		value, err := d.settingValue(key)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, *value)
+	}
+	return values, nil
+}
+
+// Setting returns the value of a setting, or its default if it has not been configured.
+func (d DB) Setting(key string) (string, error) {
+	value, err := d.settingValue(key)
+	if err != nil {
+		return "", err
+	}
+	return value.Value, nil
+}
+
+// SetSetting configures a setting. An empty value restores the default.
+func (d DB) SetSetting(key string, value string) error {
+	s, ok := settings[key]
+	if !ok {
+		return fmt.Errorf("db: no setting %q: %w", key, ErrNotFound)
+	}
+	if value != "" && s.validate != nil {
+		if err := s.validate(value); err != nil {
+			return fmt.Errorf("db: invalid value for %s: %w", key, err)
+		}
+	}
+
+	err := d.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where(Setting{Key: key}).FirstOrCreate(&Setting{Key: key}).Error
+		if err != nil {
+			return err
+		}
+		_, err = d.updateColumnTx(tx, EntitySetting, key, "value", optional(value))
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("db: error saving setting: %w", err)
+	}
+	return nil
+}
+
+// LoanPeriod returns the number of days copies are lent for under the default loan policy.
+func (d DB) LoanPeriod() (int, error) {
+	value, err := d.Setting(SettingLoanPeriod)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(value)
+}
+
+// HoldPickupDays returns the number of days a copy is kept for a patron whose hold is ready.
+func (d DB) HoldPickupDays() (int, error) {
+	value, err := d.Setting(SettingHoldPickupDays)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(value)
+}
+
+func (d DB) settingValue(key string) (*SettingValue, error) {
+	s, ok := settings[key]
+	if !ok {
+		return nil, fmt.Errorf("db: no setting %q: %w", key, ErrNotFound)
+	}
+
+	value, _, err := readColumnTx(d.db, EntitySetting, key, "value")
+	if err != nil {
+		return nil, fmt.Errorf("db: error reading setting: %w", err)
+	}
+	if value == nil {
+		return &SettingValue{Key: key, Value: s.defaultValue, Description: s.description, IsDefault: true}, nil
+	}
+	return &SettingValue{Key: key, Value: *value, Description: s.description}, nil
+}
+
+func positiveInt(value string) error {
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return err
+	}
+	if n <= 0 {
+		return fmt.Errorf("%d is not positive", n)
+	}
+	return nil
+}
+
+func nonNegativeInt(value string) error {
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return err
+	}
+	if n < 0 {
+		return fmt.Errorf("%d is negative", n)
+	}
+	return nil
+}
+
+func hostPort(value string) error {
+	_, _, err := net.SplitHostPort(value)
+	return err
+}
+
+func emailAddress(value string) error {
+	_, err := mail.ParseAddress(value)
+	return err
+}
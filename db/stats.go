@@ -0,0 +1,290 @@
+package db
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/arudzitis/addlib/openlibrary"
+)
+
+// CirculationFilter selects the loans counted by CirculationStats.
+type CirculationFilter struct {
+	// Period matches loans made within the range.
+	Period DateRange
+
+	// CollectionID, if set, counts only loans of books in the collection.
+	CollectionID *int64
+}
+
+// LoanCount is the number of loans of a book, or of books by an author, under a subject or with a
+// tag, or made to a patron. Key is the openlibrary id, subject, tag name or patron barcode.
+type LoanCount struct {
+	Key   string
+	Name  string
+	Loans int
+}
+
+// CirculationStats summarises the loans made over a period.
+type CirculationStats struct {
+	Loans    int
+	Returned int
+	// AverageLoanDays is the mean length of the returned loans.
+	AverageLoanDays float64
+
+	// Books, Authors, Subjects, Tags and Patrons count loans, the most borrowed first. Loans which have been
+	// anonymized count towards no patron.
+	Books    []LoanCount
+	Authors  []LoanCount
+	Subjects []LoanCount
+	Tags     []LoanCount
+	Patrons  []LoanCount
+
+	// NeverBorrowed are the books still held, with at least one copy, of which no copy was lent in the
+	// period.
+	NeverBorrowed []Book
+}
+
+// CirculationStats counts the loans matching a filter by book, author, subject, tag and patron.
+func (d DB) CirculationStats(filter CirculationFilter) (*CirculationStats, error) {
+	query := d.db.Preload("Copy").Preload("Patron").Order("id")
+	if filter.Period.From != nil {
+		query = query.Where("julianday(checked_out_at) >= julianday(?)", *filter.Period.From)
+	}
+	if filter.Period.To != nil {
+		query = query.Where("julianday(checked_out_at) < julianday(?)", filter.Period.To.AddDate(0, 0, 1))
+	}
+	if filter.CollectionID != nil {
+		query = query.Where("copy_id IN (SELECT id FROM copies WHERE book_id IN ("+collectionBooksQuery+"))", *filter.CollectionID, *filter.CollectionID)
+	}
+
+	loans := []Loan{}
+	tx := query.Find(&loans)
+	if tx.Error != nil {
+		return nil, fmt.Errorf("db: error reading loans: %w", tx.Error)
+	}
+
+	books, err := d.FindBooks(BookFilter{CollectionID: filter.CollectionID, IncludeDeaccessioned: true})
+	if err != nil {
+		return nil, err
+	}
+	booksByID := map[int64]Book{}
+	for _, book := range books {
+		booksByID[book.ID] = book
+	}
+
+	stats := &CirculationStats{Loans: len(loans)}
+	bookCounts, authorCounts, subjectCounts, tagCounts, patronCounts := counter{}, counter{}, counter{}, counter{}, counter{}
+	var loanDays float64
+	for _, loan := range loans {
+		if loan.ReturnedAt != nil {
+			stats.Returned++
+			loanDays += loan.ReturnedAt.Sub(loan.CheckedOutAt).Hours() / 24
+		}
+		if loan.AnonymizedAt == nil {
+			patronCounts.add(loan.Patron.Barcode, loan.Patron.Name)
+		}
+
+		book, ok := booksByID[loan.Copy.BookID]
+		if !ok {
+			continue
+		}
+		bookCounts.add(book.OLID, book.Title)
+		for _, credit := range book.Credits {
+			authorCounts.add(credit.Author.OLID, credit.Author.Name)
+		}
+		for _, subject := range book.Subjects {
+			subjectCounts.add(subject.Name, subject.Name)
+		}
+		for _, tag := range book.Tags {
+			tagCounts.add(tag.Name, tag.Name)
+		}
+	}
+	if stats.Returned > 0 {
+		stats.AverageLoanDays = loanDays / float64(stats.Returned)
+	}
+	stats.Books = bookCounts.loanCounts()
+	stats.Authors = authorCounts.loanCounts()
+	stats.Subjects = subjectCounts.loanCounts()
+	stats.Tags = tagCounts.loanCounts()
+	stats.Patrons = patronCounts.loanCounts()
+
+	stats.NeverBorrowed = []Book{}
+	for _, book := range books {
+		if book.DeaccessionedOn == nil && len(book.Copies) > 0 && bookCounts[book.OLID] == nil {
+			stats.NeverBorrowed = append(stats.NeverBorrowed, book)
+		}
+	}
+
+	return stats, nil
+}
+
+// CatalogueStats summarises the books in the catalogue.
+type CatalogueStats struct {
+	Books   int `json:"books"`
+	Copies  int `json:"copies"`
+	Authors int `json:"authors"`
+	Works   int `json:"works"`
+
+	// EditionsPerWork is the mean number of books of each work the books are editions of, and
+	// MultiEditionWorks are the works with more than one.
+	EditionsPerWork   float64     `json:"editions_per_work"`
+	MultiEditionWorks []BookCount `json:"multi_edition_works"`
+
+	// TopAuthors, Languages and Subjects count books, the most first. Decades are in order, with the
+	// books whose publication year is not known last.
+	TopAuthors []BookCount `json:"top_authors"`
+	Decades    []BookCount `json:"decades"`
+	Languages  []BookCount `json:"languages"`
+	Subjects   []BookCount `json:"subjects"`
+
+	MissingISBN    []BookSummary `json:"missing_isbn"`
+	MissingAuthors []BookSummary `json:"missing_authors"`
+}
+
+// BookCount is the number of books by an author, of a work, or published in a decade, a language or
+// under a subject. Key is the openlibrary id, decade, language code or subject; it is empty for
+// books where that is not known.
+type BookCount struct {
+	Key   string `json:"key"`
+	Name  string `json:"name"`
+	Books int    `json:"books"`
+}
+
+// BookSummary identifies a book in a report.
+type BookSummary struct {
+	OLID  string `json:"olid"`
+	Title string `json:"title"`
+}
+
+// unknownName names the count of books whose decade or language is not known.
+const unknownName = "unknown"
+
+// CatalogueStats summarises the books matching a filter.
+func (d DB) CatalogueStats(filter BookFilter) (*CatalogueStats, error) {
+	books, err := d.FindBooks(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &CatalogueStats{Books: len(books), MissingISBN: []BookSummary{}, MissingAuthors: []BookSummary{}}
+	authors, works, decades, languages, subjects := counter{}, counter{}, counter{}, counter{}, counter{}
+	for _, book := range books {
+		stats.Copies += len(book.Copies)
+		summary := BookSummary{OLID: book.OLID, Title: book.Title}
+
+		if len(book.Credits) == 0 {
+			stats.MissingAuthors = append(stats.MissingAuthors, summary)
+		}
+		for _, credit := range book.Credits {
+			authors.add(credit.Author.OLID, credit.Author.Name)
+		}
+		for _, work := range book.Works {
+			works.add(work.OLID, work.Title)
+		}
+
+		if book.ISBN10 == nil && book.ISBN13 == nil {
+			stats.MissingISBN = append(stats.MissingISBN, summary)
+		}
+
+		if year, ok := book.PublishYear(); ok {
+			decade := fmt.Sprintf("%ds", year/10*10)
+			decades.add(decade, decade)
+		} else {
+			decades.add("", unknownName)
+		}
+
+		if book.Languages == nil {
+			languages.add("", unknownName)
+		} else {
+			for _, code := range strings.Split(*book.Languages, openlibrary.LanguageSeparator) {
+				languages.add(code, code)
+			}
+		}
+
+		for _, subject := range book.Subjects {
+			subjects.add(subject.Name, subject.Name)
+		}
+	}
+
+	stats.Authors = len(authors)
+	stats.Works = len(works)
+	if len(works) > 0 {
+		editions := 0
+		for _, work := range works {
+			editions += work.n
+		}
+		stats.EditionsPerWork = float64(editions) / float64(len(works))
+	}
+	stats.MultiEditionWorks = []BookCount{}
+	for _, work := range works.bookCounts() {
+		if work.Books > 1 {
+			stats.MultiEditionWorks = append(stats.MultiEditionWorks, work)
+		}
+	}
+
+	stats.TopAuthors = authors.bookCounts()
+	stats.Languages = languages.bookCounts()
+	stats.Subjects = subjects.bookCounts()
+
+	stats.Decades = decades.bookCounts()
+	sort.SliceStable(stats.Decades, func(i, j int) bool {
+		if (stats.Decades[i].Key == "") != (stats.Decades[j].Key == "") {
+			return stats.Decades[j].Key == ""
+		}
+		return stats.Decades[i].Key < stats.Decades[j].Key
+	})
+
+	return stats, nil
+}
+
+// counter tallies loans or books by key.
+type counter map[string]*tally
+
+type tally struct {
+	key  string
+	name string
+	n    int
+}
+
+func (c counter) add(key string, name string) {
+	if t, ok := c[key]; ok {
+		t.n++
+		return
+	}
+	c[key] = &tally{key: key, name: name, n: 1}
+}
+
+// sorted returns the tallies, the highest first and then by name.
+func (c counter) sorted() []tally {
+	tallies := []tally{}
+	for _, t := range c {
+		tallies = append(tallies, *t)
+	}
+	sort.Slice(tallies, func(i, j int) bool {
+		if tallies[i].n != tallies[j].n {
+			return tallies[i].n > tallies[j].n
+		}
+		if tallies[i].name != tallies[j].name {
+			return tallies[i].name < tallies[j].name
+		}
+		return tallies[i].key < tallies[j].key
+	})
+	return tallies
+}
+
+func (c counter) loanCounts() []LoanCount {
+	counts := []LoanCount{}
+	for _, t := range c.sorted() {
+		counts = append(counts, LoanCount{Key: t.key, Name: t.name, Loans: t.n})
+	}
+	return counts
+}
+
+func (c counter) bookCounts() []BookCount {
+	counts := []BookCount{}
+	for _, t := range c.sorted() {
+		counts = append(counts, BookCount{Key: t.key, Name: t.name, Books: t.n})
+	}
+	return counts
+}
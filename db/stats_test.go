@@ -0,0 +1,182 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/arudzitis/addlib/openlibrary"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCirculationStats(t *testing.T) {
+	bookA := openlibrary.Book{OLID: "olid-booka", Title: "Book A", Authors: []openlibrary.Author{{OLID: "olid-authora", Name: "Author A"}}, Subjects: []string{"Fantasy", "Dragons"}}
+	bookB := openlibrary.Book{OLID: "olid-bookb", Title: "Book B", Authors: []openlibrary.Author{{OLID: "olid-authora", Name: "Author A"}}, Subjects: []string{"Fantasy"}}
+	bookC := openlibrary.Book{OLID: "olid-bookc", Title: "Book C"}
+	bookD := openlibrary.Book{OLID: "olid-bookd", Title: "Book D"}
+
+	db := openTestDatabase(t)
+	defer db.Close()
+
+	clock := time.Date(2023, time.May, 1, 12, 0, 0, 0, time.UTC)
+	db.db.Config.NowFunc = func() time.Time { return clock }
+
+	copies := map[string]*Copy{}
+	for _, book := range []openlibrary.Book{bookA, bookB, bookC, bookD} {
+		require.NoError(t, db.InsertRecord(book))
+	}
+	for _, book := range []openlibrary.Book{bookA, bookB, bookC} {
+		bookCopy, err := db.AddCopy(book, Copy{})
+		require.NoError(t, err)
+		copies[book.OLID] = bookCopy
+	}
+	_, err := db.TagBooks("fiction", []openlibrary.Book{bookA})
+	require.NoError(t, err)
+
+	ada, err := db.AddPatron(Patron{Name: "Ada"})
+	require.NoError(t, err)
+	charles, err := db.AddPatron(Patron{Name: "Charles"})
+	require.NoError(t, err)
+
+	lend := func(book openlibrary.Book, patron *Patron, days int) {
+		_, err := db.Checkout(copies[book.OLID].Accession, *patron, nil)
+		require.NoError(t, err)
+		clock = clock.AddDate(0, 0, days)
+		_, _, err = db.Checkin(copies[book.OLID].Accession)
+		require.NoError(t, err)
+	}
+	lend(bookA, ada, 4)
+	lend(bookA, charles, 2)
+	lend(bookB, ada, 6)
+
+	stats, err := db.CirculationStats(CirculationFilter{})
+	require.NoError(t, err)
+	assert.Equal(t, 3, stats.Loans)
+	assert.Equal(t, 3, stats.Returned)
+	assert.InDelta(t, 4.0, stats.AverageLoanDays, 0.001)
+	assert.Equal(t, []LoanCount{{Key: "olid-booka", Name: "Book A", Loans: 2}, {Key: "olid-bookb", Name: "Book B", Loans: 1}}, stats.Books)
+	assert.Equal(t, []LoanCount{{Key: "olid-authora", Name: "Author A", Loans: 3}}, stats.Authors)
+	assert.Equal(t, []LoanCount{{Key: "Fantasy", Name: "Fantasy", Loans: 3}, {Key: "Dragons", Name: "Dragons", Loans: 2}}, stats.Subjects)
+	assert.Equal(t, []LoanCount{{Key: "fiction", Name: "fiction", Loans: 2}}, stats.Tags)
+	assert.Equal(t, []LoanCount{{Key: ada.Barcode, Name: "Ada", Loans: 2}, {Key: charles.Barcode, Name: "Charles", Loans: 1}}, stats.Patrons)
+	require.Len(t, stats.NeverBorrowed, 1, "books without copies cannot be borrowed")
+	assert.Equal(t, "olid-bookc", stats.NeverBorrowed[0].OLID)
+
+	from := time.Date(2023, time.May, 5, 0, 0, 0, 0, time.UTC)
+	stats, err = db.CirculationStats(CirculationFilter{Period: DateRange{From: &from}})
+	require.NoError(t, err)
+	assert.Equal(t, 2, stats.Loans)
+	require.Len(t, stats.Books, 2)
+	assert.Equal(t, 1, stats.Books[0].Loans)
+
+	// anonymized loans still count, but towards no patron
+	clock = clock.AddDate(0, 0, 30)
+	anonymized, err := db.AnonymizeLoans(clock)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), anonymized, "loans are kept in full unless the setting is given")
+
+	require.NoError(t, db.SetSetting(SettingAnonymizeAfterDays, "31"))
+	anonymized, err = db.AnonymizeLoans(clock)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), anonymized, "only loans returned more than 31 days ago")
+
+	stats, err = db.CirculationStats(CirculationFilter{})
+	require.NoError(t, err)
+	assert.Equal(t, 3, stats.Loans)
+	assert.Equal(t, []LoanCount{{Key: ada.Barcode, Name: "Ada", Loans: 1}}, stats.Patrons)
+
+	history, err := db.History(copies[bookA.OLID].Accession)
+	require.NoError(t, err)
+	for _, change := range history {
+		if change.Entity == EntityLoan && change.Action == ActionCreate {
+			assert.Nil(t, change.NewValue, "the change log no longer names the borrower")
+		}
+	}
+
+	// loans are counted on the UTC day they were made, whatever zone they were recorded in
+	clock = time.Date(2023, time.July, 1, 8, 0, 0, 0, time.FixedZone("AEST", 10*60*60))
+	lend(bookC, ada, 1)
+	from = time.Date(2023, time.July, 1, 0, 0, 0, 0, time.UTC)
+	stats, err = db.CirculationStats(CirculationFilter{Period: DateRange{From: &from}})
+	require.NoError(t, err)
+	assert.Equal(t, 0, stats.Loans)
+	june30 := time.Date(2023, time.June, 30, 0, 0, 0, 0, time.UTC)
+	stats, err = db.CirculationStats(CirculationFilter{Period: DateRange{From: &june30, To: &june30}})
+	require.NoError(t, err)
+	assert.Equal(t, 1, stats.Loans)
+}
+
+func TestCatalogueStats(t *testing.T) {
+	authorA := openlibrary.Author{OLID: "olid-authora", Name: "Author A"}
+	authorB := openlibrary.Author{OLID: "olid-authorb", Name: "Author B"}
+	work := openlibrary.Work{Key: "olid-worka", Title: "Work A"}
+	books := []openlibrary.Book{
+		{
+			OLID: "olid-booka", Title: "Book A", Authors: []openlibrary.Author{authorA, authorB}, Works: []openlibrary.Work{work},
+			Isbn13: []string{"9780140268867"}, PublishDate: "March 1997", Languages: []openlibrary.Language{{Key: "/languages/eng"}},
+			Subjects: []string{"Epic poetry", "Homer"},
+		},
+		{
+			OLID: "olid-bookb", Title: "Book B", Authors: []openlibrary.Author{authorA}, Works: []openlibrary.Work{work},
+			Isbn10: []string{"0140268863"}, PublishDate: "1991", Languages: []openlibrary.Language{{Key: "/languages/eng"}, {Key: "/languages/grc"}},
+			Subjects: []string{"Epic poetry"},
+		},
+		{
+			OLID: "olid-bookc", Title: "Book C", Works: []openlibrary.Work{{Key: "olid-workc", Title: "Work C"}},
+			PublishDate: "1887",
+		},
+		{OLID: "olid-bookd", Title: "Book D", Authors: []openlibrary.Author{authorB}, PublishDate: "n.d."},
+	}
+
+	db := openTestDatabase(t)
+	defer db.Close()
+
+	for _, book := range books {
+		require.NoError(t, db.InsertRecord(book))
+	}
+	_, err := db.AddCopy(books[0], Copy{})
+	require.NoError(t, err)
+
+	stats, err := db.CatalogueStats(BookFilter{})
+	require.NoError(t, err)
+	assert.Equal(t, 4, stats.Books)
+	assert.Equal(t, 1, stats.Copies)
+	assert.Equal(t, 2, stats.Authors)
+	assert.Equal(t, 2, stats.Works)
+	assert.InDelta(t, 1.5, stats.EditionsPerWork, 0.001)
+	assert.Equal(t, []BookCount{{Key: "olid-worka", Name: "Work A", Books: 2}}, stats.MultiEditionWorks)
+	assert.Equal(t, []BookCount{{Key: "olid-authora", Name: "Author A", Books: 2}, {Key: "olid-authorb", Name: "Author B", Books: 2}}, stats.TopAuthors)
+	assert.Equal(t, []BookCount{
+		{Key: "1880s", Name: "1880s", Books: 1},
+		{Key: "1990s", Name: "1990s", Books: 2},
+		{Key: "", Name: "unknown", Books: 1},
+	}, stats.Decades)
+	assert.Equal(t, []BookCount{
+		{Key: "eng", Name: "eng", Books: 2},
+		{Key: "", Name: "unknown", Books: 2},
+		{Key: "grc", Name: "grc", Books: 1},
+	}, stats.Languages)
+	assert.Equal(t, []BookCount{{Key: "Epic poetry", Name: "Epic poetry", Books: 2}, {Key: "Homer", Name: "Homer", Books: 1}}, stats.Subjects)
+	assert.Equal(t, []BookSummary{{OLID: "olid-bookc", Title: "Book C"}, {OLID: "olid-bookd", Title: "Book D"}}, stats.MissingISBN)
+	assert.Equal(t, []BookSummary{{OLID: "olid-bookc", Title: "Book C"}}, stats.MissingAuthors)
+
+	// details missing from books saved earlier are filled in, and existing ones kept
+	rows, err := db.UpdatePublication(openlibrary.Book{
+		OLID:        "olid-bookd",
+		PublishDate: "2001",
+		Languages:   []openlibrary.Language{{Key: "/languages/fre"}},
+		Subjects:    []string{"Homer", "Epic poetry"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), rows, "the date is kept, the languages and subjects added")
+
+	book, err := db.FindBook("olid-bookd")
+	require.NoError(t, err)
+	assert.Equal(t, "n.d.", *book.PublishDate)
+	assert.Equal(t, "fre", *book.Languages)
+
+	history, err := db.History("olid-bookd")
+	require.NoError(t, err)
+	assert.Equal(t, FieldSubjects, history[len(history)-1].Field)
+	assert.Equal(t, "Homer; Epic poetry", *history[len(history)-1].NewValue)
+}
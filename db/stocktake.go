@@ -0,0 +1,406 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/arudzitis/addlib/isbn"
+
+	"gorm.io/gorm"
+)
+
+// StocktakeItem is a copy, or a book with no copies, reported by a stocktake.
+type StocktakeItem struct {
+	Book Book
+	Copy *Copy
+
+	// LocationID is where the catalogue records the item, if anywhere: the location of the copy,
+	// otherwise that of its book.
+	LocationID *int64
+
+	// Elsewhere is set for items recorded outside the location of the stocktake, or nowhere.
+	Elsewhere bool
+
+	// OnLoan is set for copies the catalogue records as lent out.
+	OnLoan bool
+}
+
+// StocktakeReport compares the items scanned during a stocktake with those the catalogue expects
+// to find: the available copies, not out on loan or kept on the hold shelf for a patron, recorded at
+// the location or any location within it, along with the books with no copies shelved there.
+type StocktakeReport struct {
+	Stocktake Stocktake
+
+	// Seen counts the expected items which were scanned.
+	Seen int
+
+	// Missing are the items expected but not scanned.
+	Missing []StocktakeItem
+
+	// Misplaced are the items scanned which the catalogue records somewhere else, lent out or
+	// missing.
+	Misplaced []StocktakeItem
+
+	// Unknown are the scans which matched no copy or book, or a book none of whose copies here were
+	// left to match.
+	Unknown []string
+
+	// Updated counts the misplaced items moved or made available when the stocktake was finished.
+	Updated int
+}
+
+// StocktakeOptions are the corrections made to the catalogue when a stocktake is finished.
+type StocktakeOptions struct {
+	// UpdateLocations moves the misplaced items to the location of the stocktake, and makes the
+	// copies found which were recorded as missing available again. Copies recorded as lent out are
+	// left for check in.
+	UpdateLocations bool
+
+	// MarkMissing records the copies expected but not scanned as missing.
+	MarkMissing bool
+}
+
+// StartStocktake begins a stocktake of a location. Only one stocktake of a location may be open at
+// a time.
+func (d DB) StartStocktake(location Location) (*Stocktake, error) {
+	stocktake := Stocktake{LocationID: location.ID, StartedAt: d.db.NowFunc(), Location: location}
+	err := d.db.Transaction(func(tx *gorm.DB) error {
+		var open int64
+		err := tx.Model(&Stocktake{}).Where("location_id = ? AND finished_at IS NULL", location.ID).Count(&open).Error
+		if err != nil {
+			return err
+		}
+		if open > 0 {
+			return fmt.Errorf("a stocktake of %q is already open", location.Name)
+		}
+
+		err = tx.Omit("Location").Create(&stocktake).Error
+		if err != nil {
+			return err
+		}
+
+		return d.recordChangeTx(tx, Change{Action: ActionCreate, Entity: EntityStocktake, EntityKey: strconv.FormatInt(stocktake.ID, 10), NewValue: &location.Name})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("db: error starting stocktake: %w", err)
+	}
+	return &stocktake, nil
+}
+
+// OpenStocktake finds an unfinished stocktake by id, or if ref is empty the only one open.
+func (d DB) OpenStocktake(ref string) (*Stocktake, error) {
+	query := d.db.Preload("Location").Where("finished_at IS NULL")
+	if ref != "" {
+		id, err := strconv.ParseInt(ref, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("db: invalid stocktake id %q: %w", ref, err)
+		}
+		query = query.Where("id = ?", id)
+	}
+
+	stocktakes := []Stocktake{}
+	tx := query.Order("id").Find(&stocktakes)
+	if tx.Error != nil {
+		return nil, fmt.Errorf("db: error reading stocktakes: %w", tx.Error)
+	}
+	switch {
+	case len(stocktakes) == 0 && ref != "":
+		return nil, fmt.Errorf("db: no open stocktake %s: %w", ref, ErrNotFound)
+	case len(stocktakes) == 0:
+		return nil, fmt.Errorf("db: no stocktake is open: %w", ErrNotFound)
+	case len(stocktakes) > 1:
+		return nil, fmt.Errorf("db: %d stocktakes are open; choose one by id", len(stocktakes))
+	}
+	return &stocktakes[0], nil
+}
+
+// ScanStocktake records an item found during a stocktake, by the accession number of a copy or the
+// openlibrary id or isbn of a book. Scans which match nothing are kept, to be reported as unknown.
+func (d DB) ScanStocktake(stocktake Stocktake, ref string) (*StocktakeScan, error) {
+	scan := StocktakeScan{StocktakeID: stocktake.ID, Ref: ref, ScannedAt: d.db.NowFunc()}
+
+	bookCopy, err := readCopyTx(d.db, ref)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, fmt.Errorf("db: error reading copy: %w", err)
+	}
+	if bookCopy != nil {
+		book := Book{}
+		err = d.db.First(&book, bookCopy.BookID).Error
+		if err != nil {
+			return nil, fmt.Errorf("db: error reading book: %w", err)
+		}
+		scan.CopyID, scan.BookID, scan.Book = &bookCopy.ID, &book.ID, &book
+	} else {
+		book, err := d.findScannedBook(ref)
+		if err != nil {
+			return nil, err
+		}
+		if book != nil {
+			scan.BookID, scan.Book = &book.ID, book
+		}
+	}
+
+	tx := d.db.Omit("Book").Create(&scan)
+	if tx.Error != nil {
+		return nil, fmt.Errorf("db: error recording scan: %w", tx.Error)
+	}
+	return &scan, nil
+}
+
+// findScannedBook finds a book by openlibrary id or by isbn, in whichever form it was scanned or
+// catalogued.
+func (d DB) findScannedBook(ref string) (*Book, error) {
+	refs := []string{ref}
+	if isbn.Valid(ref) {
+		isbn13, _ := isbn.To13(ref)
+		refs = append(refs, isbn13)
+		if isbn10, ok := isbn.To10(ref); ok {
+			refs = append(refs, isbn10)
+		}
+	}
+
+	for _, candidate := range refs {
+		book, err := d.FindBook(candidate)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return book, nil
+	}
+	return nil, nil
+}
+
+// StocktakeReport compares what has been scanned so far with what the catalogue expects.
+func (d DB) StocktakeReport(stocktake Stocktake) (*StocktakeReport, error) {
+	report, err := d.stocktakeReportTx(d.db, stocktake)
+	if err != nil {
+		return nil, fmt.Errorf("db: error reporting stocktake: %w", err)
+	}
+	return report, nil
+}
+
+// FinishStocktake closes a stocktake, making the corrections chosen, and reports on it.
+func (d DB) FinishStocktake(stocktake Stocktake, options StocktakeOptions) (*StocktakeReport, error) {
+	var report *StocktakeReport
+	err := d.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		report, err = d.stocktakeReportTx(tx, stocktake)
+		if err != nil {
+			return err
+		}
+
+		if options.UpdateLocations {
+			for _, item := range report.Misplaced {
+				if item.OnLoan {
+					continue
+				}
+
+				var rows int64
+				if item.Copy == nil {
+					rows, err = d.updateColumnTx(tx, EntityBook, item.Book.OLID, "location_id", stocktake.LocationID)
+				} else {
+					rows, err = d.shelveCopyTx(tx, item, stocktake.LocationID)
+				}
+				if err != nil {
+					return err
+				}
+				if rows > 0 {
+					report.Updated++
+				}
+			}
+		}
+
+		if options.MarkMissing {
+			for _, item := range report.Missing {
+				if item.Copy == nil {
+					continue
+				}
+				_, err = d.updateColumnTx(tx, EntityCopy, item.Copy.Accession, "status", CopyStatusMissing)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		finishedAt := d.db.NowFunc()
+		err = tx.Model(&Stocktake{ID: stocktake.ID}).Update("finished_at", finishedAt).Error
+		if err != nil {
+			return err
+		}
+		report.Stocktake.FinishedAt = &finishedAt
+
+		summary := fmt.Sprintf("%d seen, %d missing, %d misplaced, %d unknown", report.Seen, len(report.Missing), len(report.Misplaced), len(report.Unknown))
+		return d.recordChangeTx(tx, Change{Action: ActionUpdate, Entity: EntityStocktake, EntityKey: strconv.FormatInt(stocktake.ID, 10), NewValue: &summary})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("db: error finishing stocktake: %w", err)
+	}
+	return report, nil
+}
+
+// shelveCopyTx moves a copy found during a stocktake to where it was found, and makes it available
+// again if it had been recorded as missing, keeping it for the oldest hold waiting on its book. It
+// returns the number of copy rows updated.
+func (d DB) shelveCopyTx(tx *gorm.DB, item StocktakeItem, locationID int64) (int64, error) {
+	bookCopy := *item.Copy
+	var rows int64
+	if item.Elsewhere {
+		updated, err := d.updateColumnTx(tx, EntityCopy, bookCopy.Accession, "location_id", locationID)
+		if err != nil {
+			return 0, err
+		}
+		rows += updated
+	}
+	if bookCopy.Status == CopyStatusMissing {
+		updated, err := d.updateColumnTx(tx, EntityCopy, bookCopy.Accession, "status", CopyStatusAvailable)
+		if err != nil {
+			return 0, err
+		}
+		rows += updated
+
+		bookCopy.Status = CopyStatusAvailable
+		_, err = d.reserveCopyTx(tx, bookCopy)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return rows, nil
+}
+
+func (d DB) stocktakeReportTx(tx *gorm.DB, stocktake Stocktake) (*StocktakeReport, error) {
+	err := tx.Preload("Location").Preload("Scans", func(db *gorm.DB) *gorm.DB {
+		return db.Order("id")
+	}).First(&stocktake, stocktake.ID).Error
+	if err != nil {
+		return nil, err
+	}
+
+	subtree, err := locationSubtreeTx(tx, stocktake.LocationID)
+	if err != nil {
+		return nil, err
+	}
+	within := map[int64]bool{}
+	for _, id := range subtree {
+		within[id] = true
+	}
+
+	onLoan := []int64{}
+	err = tx.Model(&Loan{}).Where("returned_at IS NULL").Pluck("copy_id", &onLoan).Error
+	if err != nil {
+		return nil, err
+	}
+	lent := map[int64]bool{}
+	for _, id := range onLoan {
+		lent[id] = true
+	}
+
+	// copies kept for ready holds are usually on the hold shelf rather than where they belong
+	reserved := []int64{}
+	err = tx.Model(&Hold{}).Where("status = ? AND copy_id IS NOT NULL", HoldStatusReady).Pluck("copy_id", &reserved).Error
+	if err != nil {
+		return nil, err
+	}
+	kept := map[int64]bool{}
+	for _, id := range reserved {
+		kept[id] = true
+	}
+
+	books := []Book{}
+	err = tx.Preload("Copies", func(db *gorm.DB) *gorm.DB {
+		return db.Order("accession")
+	}).Where("deaccessioned_on IS NULL").Order("title, id").Find(&books).Error
+	if err != nil {
+		return nil, err
+	}
+
+	// items are keyed by copy id, or by the negated book id for books with no copies
+	items := map[int64]StocktakeItem{}
+	expected := map[int64]bool{}
+	order := []int64{}
+	copiesOfBook := map[int64][]int64{}
+	for _, book := range books {
+		if len(book.Copies) == 0 {
+			key := -book.ID
+			item := StocktakeItem{Book: book, LocationID: book.LocationID}
+			item.Elsewhere = item.LocationID == nil || !within[*item.LocationID]
+			items[key] = item
+			expected[key] = !item.Elsewhere
+			order = append(order, key)
+			continue
+		}
+
+		for i := range book.Copies {
+			bookCopy := book.Copies[i]
+			item := StocktakeItem{Book: book, Copy: &bookCopy, LocationID: bookCopy.LocationID, OnLoan: lent[bookCopy.ID]}
+			if item.LocationID == nil {
+				item.LocationID = book.LocationID
+			}
+			item.Elsewhere = item.LocationID == nil || !within[*item.LocationID]
+			items[bookCopy.ID] = item
+			expected[bookCopy.ID] = bookCopy.Status == CopyStatusAvailable && !item.OnLoan && !kept[bookCopy.ID] && !item.Elsewhere
+			order = append(order, bookCopy.ID)
+			copiesOfBook[book.ID] = append(copiesOfBook[book.ID], bookCopy.ID)
+		}
+	}
+
+	report := &StocktakeReport{Stocktake: stocktake, Missing: []StocktakeItem{}, Misplaced: []StocktakeItem{}, Unknown: []string{}}
+	seen := map[int64]bool{}
+	for _, scan := range stocktake.Scans {
+		key, ok := scannedItem(scan, copiesOfBook, items, expected, seen)
+		item, known := items[key]
+		if !ok || !known {
+			// copies of deaccessioned books are as unknown as anything else
+			report.Unknown = append(report.Unknown, scan.Ref)
+			continue
+		}
+		if seen[key] {
+			continue
+		}
+
+		seen[key] = true
+		if expected[key] {
+			report.Seen++
+		} else {
+			report.Misplaced = append(report.Misplaced, item)
+		}
+	}
+
+	for _, key := range order {
+		if expected[key] && !seen[key] {
+			report.Missing = append(report.Missing, items[key])
+		}
+	}
+	return report, nil
+}
+
+// scannedItem returns the key of the item a scan refers to, or false if it matched nothing. A scan of
+// a book with copies counts as the first of its copies which is expected and not yet seen, or
+// failing that the first recorded at the location and not yet seen. Copies recorded elsewhere are
+// only matched by accession, so a scan of a book once all its copies here have been seen is extra.
+func scannedItem(scan StocktakeScan, copiesOfBook map[int64][]int64, items map[int64]StocktakeItem, expected map[int64]bool, seen map[int64]bool) (int64, bool) {
+	switch {
+	case scan.CopyID != nil:
+		return *scan.CopyID, true
+	case scan.BookID == nil:
+		return 0, false
+	}
+
+	copies := copiesOfBook[*scan.BookID]
+	if len(copies) == 0 {
+		return -*scan.BookID, true
+	}
+	for _, id := range copies {
+		if expected[id] && !seen[id] {
+			return id, true
+		}
+	}
+	for _, id := range copies {
+		if !seen[id] && !items[id].Elsewhere {
+			return id, true
+		}
+	}
+	return 0, false
+}
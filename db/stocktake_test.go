@@ -0,0 +1,115 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/arudzitis/addlib/openlibrary"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStocktake(t *testing.T) {
+	bookA := openlibrary.Book{OLID: "olid-booka", Title: "Book A"}
+	bookB := openlibrary.Book{OLID: "olid-bookb", Title: "Book B", Isbn13: []string{"9780261102217"}}
+	bookC := openlibrary.Book{OLID: "olid-bookc", Title: "Book C", Isbn13: []string{"9780261102361"}}
+	bookD := openlibrary.Book{OLID: "olid-bookd", Title: "Book D"}
+	bookE := openlibrary.Book{OLID: "olid-booke", Title: "Book E"}
+	bookF := openlibrary.Book{OLID: "olid-bookf", Title: "Book F"}
+
+	db := openTestDatabase(t)
+	defer db.Close()
+
+	case1, err := db.AddLocation([]string{"Main", "Office", "Case 1"})
+	require.NoError(t, err)
+	shelf, err := db.AddLocation([]string{"Main", "Office", "Case 1", "Shelf 1"})
+	require.NoError(t, err)
+	elsewhere, err := db.AddLocation([]string{"Main", "Office", "Case 2"})
+	require.NoError(t, err)
+
+	for _, book := range []openlibrary.Book{bookA, bookB, bookC, bookD, bookE, bookF} {
+		require.NoError(t, db.InsertRecord(book))
+	}
+	for _, bookCopy := range []struct {
+		book      openlibrary.Book
+		accession string
+		status    string
+		location  *Location
+	}{
+		{bookA, "A1", CopyStatusAvailable, shelf},
+		{bookA, "A2", CopyStatusAvailable, case1},
+		{bookB, "B1", CopyStatusAvailable, elsewhere},
+		{bookD, "D1", CopyStatusMissing, shelf},
+		{bookE, "E1", CopyStatusAvailable, elsewhere},
+		{bookF, "F1", CopyStatusAvailable, shelf},
+	} {
+		_, err := db.AddCopy(bookCopy.book, Copy{Accession: bookCopy.accession, Status: bookCopy.status})
+		require.NoError(t, err)
+		_, err = db.MoveCopy(bookCopy.accession, *bookCopy.location)
+		require.NoError(t, err)
+	}
+	_, err = db.MoveBook(bookC, *shelf)
+	require.NoError(t, err)
+	patron, err := db.AddPatron(Patron{Name: "Ada"})
+	require.NoError(t, err)
+	_, err = db.Checkout("E1", *patron, nil)
+	require.NoError(t, err)
+	// F1 is kept on the hold shelf for a patron, so is not looked for
+	hold, err := db.PlaceHold(bookF, *patron)
+	require.NoError(t, err)
+	require.Equal(t, HoldStatusReady, hold.Status)
+
+	stocktake, err := db.StartStocktake(*case1)
+	require.NoError(t, err)
+	_, err = db.StartStocktake(*case1)
+	assert.Error(t, err)
+
+	open, err := db.OpenStocktake("")
+	require.NoError(t, err)
+	assert.Equal(t, stocktake.ID, open.ID)
+	assert.Equal(t, "Case 1", open.Location.Name)
+
+	// the isbn of book B is extra: its only copy is recorded elsewhere, and is scanned by accession
+	for _, ref := range []string{"A1", "A1", "B1", "D1", "E1", "0-261-10236-2", "978-0-261-10221-7", "X999"} {
+		_, err := db.ScanStocktake(*open, ref)
+		require.NoError(t, err)
+	}
+
+	report, err := db.StocktakeReport(*open)
+	require.NoError(t, err)
+	assert.Equal(t, 2, report.Seen)
+	require.Len(t, report.Missing, 1)
+	assert.Equal(t, "A2", report.Missing[0].Copy.Accession)
+	require.Len(t, report.Misplaced, 3)
+	assert.Equal(t, "B1", report.Misplaced[0].Copy.Accession)
+	assert.True(t, report.Misplaced[0].Elsewhere)
+	assert.Equal(t, "D1", report.Misplaced[1].Copy.Accession)
+	assert.False(t, report.Misplaced[1].Elsewhere)
+	assert.Equal(t, "E1", report.Misplaced[2].Copy.Accession)
+	assert.True(t, report.Misplaced[2].OnLoan)
+	assert.Equal(t, []string{"978-0-261-10221-7", "X999"}, report.Unknown)
+
+	report, err = db.FinishStocktake(*open, StocktakeOptions{UpdateLocations: true, MarkMissing: true})
+	require.NoError(t, err)
+	assert.Equal(t, 2, report.Updated, "copies on loan are left for check in")
+
+	copies := map[string]Copy{}
+	for _, book := range []openlibrary.Book{bookA, bookB, bookD, bookE} {
+		bookCopies, err := db.Copies(book)
+		require.NoError(t, err)
+		for _, bookCopy := range bookCopies {
+			copies[bookCopy.Accession] = bookCopy
+		}
+	}
+	assert.Equal(t, CopyStatusMissing, copies["A2"].Status)
+	assert.Equal(t, case1.ID, *copies["B1"].LocationID)
+	assert.Equal(t, CopyStatusAvailable, copies["D1"].Status)
+	assert.Equal(t, shelf.ID, *copies["D1"].LocationID)
+	assert.Equal(t, elsewhere.ID, *copies["E1"].LocationID)
+
+	fCopies, err := db.Copies(bookF)
+	require.NoError(t, err)
+	assert.Equal(t, CopyStatusAvailable, fCopies[0].Status, "copies kept for holds are not marked missing")
+
+	_, err = db.OpenStocktake("")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
@@ -0,0 +1,129 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/arudzitis/addlib/openlibrary"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// FieldSubjects records subjects being added to a book, as its new value, joined by subjectSeparator.
+const FieldSubjects = "subjects"
+
+// subjectSeparator joins subject names, which may contain commas, in the change log.
+const subjectSeparator = "; "
+
+// subjectBooksQuery selects the ids of the books with the subject named by its argument.
+const subjectBooksQuery = "SELECT book_subjects.book_id FROM book_subjects JOIN subjects ON subjects.id = book_subjects.subject_id WHERE subjects.name = ?"
+
+// SubjectCount is a subject along with the number of books still held which have it.
+type SubjectCount struct {
+	Name  string
+	Books int64
+}
+
+// Subjects returns every subject of a book still held, with the number of such books, ordered by
+// name.
+func (d DB) Subjects() ([]SubjectCount, error) {
+	counts := []SubjectCount{}
+	tx := d.db.Model(&Subject{}).
+		Select("subjects.name AS name, COUNT(book_subjects.book_id) AS books").
+		Joins("JOIN book_subjects ON book_subjects.subject_id = subjects.id AND book_subjects.book_id IN (" + heldBooksQuery + ")").
+		Group("subjects.id").
+		Order("subjects.name").
+		Scan(&counts)
+	if tx.Error != nil {
+		return nil, fmt.Errorf("db: error reading subjects: %w", tx.Error)
+	}
+	return counts, nil
+}
+
+// UpdatePublication fills in the publication date, languages and subjects of a saved book from
+// openlibrary. Details the book already has are kept, and subjects are only ever added.
+func (d DB) UpdatePublication(book openlibrary.Book) (int64, error) {
+	var rows int64
+	err := d.db.Transaction(func(tx *gorm.DB) error {
+		ormBook, err := readBookTx(tx, book.OLID)
+		if err != nil {
+			return err
+		}
+		if ormBook == nil {
+			return fmt.Errorf("no book with olid %s: %w", book.OLID, ErrNotFound)
+		}
+
+		for _, detail := range []struct {
+			column   string
+			existing *string
+			value    *string
+		}{
+			{"publish_date", ormBook.PublishDate, optional(book.PublishDate)},
+			{"languages", ormBook.Languages, book.GetLanguages()},
+		} {
+			if detail.existing != nil || detail.value == nil {
+				continue
+			}
+			updated, err := d.updateColumnTx(tx, EntityBook, book.OLID, detail.column, *detail.value)
+			if err != nil {
+				return err
+			}
+			rows += updated
+		}
+
+		added := []string{}
+		for _, name := range book.Subjects {
+			linked, err := addSubjectTx(tx, *ormBook, name)
+			if err != nil {
+				return err
+			}
+			if linked {
+				added = append(added, name)
+			}
+		}
+		if len(added) == 0 {
+			return nil
+		}
+		rows++
+
+		subjects := strings.Join(added, subjectSeparator)
+		return d.recordChangeTx(tx, Change{Action: ActionUpdate, Entity: EntityBook, EntityKey: book.OLID, Field: FieldSubjects, NewValue: &subjects})
+	})
+	if err != nil {
+		return 0, fmt.Errorf("db: error updating publication details: %w", err)
+	}
+	return rows, nil
+}
+
+// addSubjectsTx links a book to each of the subjects, creating those which do not exist yet.
+func addSubjectsTx(tx *gorm.DB, book Book, names []string) error {
+	for _, name := range names {
+		_, err := addSubjectTx(tx, book, name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addSubjectTx links a book to a subject, creating it if it does not exist yet. It returns whether the
+// book was not already linked.
+func addSubjectTx(tx *gorm.DB, book Book, name string) (bool, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return false, nil
+	}
+
+	subject := Subject{Name: name}
+	err := tx.Where(Subject{Name: name}).FirstOrCreate(&subject).Error
+	if err != nil {
+		return false, fmt.Errorf("db: error creating subject: %w", err)
+	}
+
+	result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&BookSubject{BookID: book.ID, SubjectID: subject.ID})
+	if result.Error != nil {
+		return false, fmt.Errorf("db: error linking subject: %w", result.Error)
+	}
+	return result.RowsAffected == 1, nil
+}
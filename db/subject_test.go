@@ -0,0 +1,34 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/arudzitis/addlib/openlibrary"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubjects(t *testing.T) {
+	bookA := openlibrary.Book{OLID: "olid-booka", Title: "Book A", Subjects: []string{"Fantasy", "Dragons"}}
+	bookB := openlibrary.Book{OLID: "olid-bookb", Title: "Book B", Subjects: []string{"Fantasy"}}
+	bookC := openlibrary.Book{OLID: "olid-bookc", Title: "Book C", Subjects: []string{"Fantasy", "Maps"}}
+
+	db := openTestDatabase(t)
+	defer db.Close()
+
+	for _, book := range []openlibrary.Book{bookA, bookB, bookC} {
+		require.NoError(t, db.InsertRecord(book))
+	}
+	_, err := db.DeaccessionBook(bookC, Deaccession{Reason: DeaccessionReasons[0]})
+	require.NoError(t, err)
+
+	subjects, err := db.Subjects()
+	require.NoError(t, err)
+	assert.Equal(t, []SubjectCount{{"Dragons", 1}, {"Fantasy", 2}}, subjects)
+
+	books, err := db.FindBooks(BookFilter{Subject: "Fantasy"})
+	require.NoError(t, err)
+	require.Len(t, books, 2)
+	assert.Equal(t, "Book A", books[0].Title)
+	assert.Equal(t, "Book B", books[1].Title)
+}
@@ -0,0 +1,119 @@
+package db
+
+import (
+	"fmt"
+
+	"github.com/arudzitis/addlib/openlibrary"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// taggedBooksQuery selects the ids of tagged books; it must be completed with a condition on the tag
+// name.
+const taggedBooksQuery = "SELECT book_tags.book_id FROM book_tags JOIN tags ON tags.id = book_tags.tag_id WHERE tags.name"
+
+// TagCount is a tag along with the number of books it has been applied to.
+type TagCount struct {
+	Name  string
+	Books int64
+}
+
+// TagBooks applies a tag to each of the books, creating the tag if it does not exist yet. It returns
+// the number of books which were not already tagged.
+func (d DB) TagBooks(name string, books []openlibrary.Book) (int64, error) {
+	var tagged int64
+	err := d.db.Transaction(func(tx *gorm.DB) error {
+		tag := Tag{Name: name}
+		err := tx.Where(Tag{Name: name}).FirstOrCreate(&tag).Error
+		if err != nil {
+			return err
+		}
+
+		for _, book := range books {
+			ormBook, err := readBookTx(tx, book.OLID)
+			if err != nil {
+				return err
+			}
+			if ormBook == nil {
+				return fmt.Errorf("no book with olid %s: %w", book.OLID, ErrNotFound)
+			}
+
+			result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&BookTag{BookID: ormBook.ID, TagID: tag.ID})
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				continue
+			}
+			tagged++
+
+			err = d.recordChangeTx(tx, Change{Action: ActionUpdate, Entity: EntityBook, EntityKey: book.OLID, Field: FieldTag, NewValue: &name})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("db: error tagging books: %w", err)
+	}
+	return tagged, nil
+}
+
+// UntagBooks removes a tag from each of the books, returning the number of books which had the tag.
+func (d DB) UntagBooks(name string, books []openlibrary.Book) (int64, error) {
+	var untagged int64
+	err := d.db.Transaction(func(tx *gorm.DB) error {
+		for _, book := range books {
+			result := tx.Where("tag_id IN (SELECT id FROM tags WHERE name = ?) AND book_id IN (SELECT id FROM books WHERE olid = ?)", name, book.OLID).
+				Delete(&BookTag{})
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				continue
+			}
+			untagged++
+
+			err := d.recordChangeTx(tx, Change{Action: ActionUpdate, Entity: EntityBook, EntityKey: book.OLID, Field: FieldTag, OldValue: &name})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("db: error untagging books: %w", err)
+	}
+	return untagged, nil
+}
+
+// Tags returns every tag in use, with the number of books still held carrying it.
+func (d DB) Tags() ([]TagCount, error) {
+	counts := []TagCount{}
+	tx := d.db.Model(&Tag{}).
+		Select("tags.name AS name, COUNT(book_tags.book_id) AS books").
+		Joins("LEFT JOIN book_tags ON book_tags.tag_id = tags.id AND book_tags.book_id IN (" + heldBooksQuery + ")").
+		Group("tags.id").
+		Order("tags.name").
+		Scan(&counts)
+	if tx.Error != nil {
+		return nil, fmt.Errorf("db: error reading tags: %w", tx.Error)
+	}
+	return counts, nil
+}
+
+// BookTags returns the tags applied to a book, ordered by name.
+func (d DB) BookTags(book openlibrary.Book) ([]Tag, error) {
+	tags := []Tag{}
+	tx := d.db.Joins("JOIN book_tags ON book_tags.tag_id = tags.id").
+		Joins("JOIN books ON books.id = book_tags.book_id").
+		Where("books.olid = ?", book.OLID).
+		Order("tags.name").
+		Find(&tags)
+	if tx.Error != nil {
+		return nil, fmt.Errorf("db: error reading tags for book: %w", tx.Error)
+	}
+	return tags, nil
+}
@@ -0,0 +1,75 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/arudzitis/addlib/openlibrary"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTagFilters(t *testing.T) {
+	authorA := openlibrary.Author{OLID: "olid-authora", Name: "Author A"}
+
+	bookA := openlibrary.Book{OLID: "olid-booka", Title: "Book A", Authors: []openlibrary.Author{authorA}}
+	bookB := openlibrary.Book{OLID: "olid-bookb", Title: "Book B"}
+	bookC := openlibrary.Book{OLID: "olid-bookc", Title: "Book C"}
+
+	db := openTestDatabase(t)
+	defer db.Close()
+
+	for _, book := range []openlibrary.Book{bookA, bookB, bookC} {
+		require.NoError(t, db.InsertRecord(book))
+	}
+
+	rows, err := db.TagBooks("reference", []openlibrary.Book{bookA, bookB})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), rows)
+
+	rows, err = db.TagBooks("reference", []openlibrary.Book{bookA})
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), rows, "tagging twice is a no-op")
+
+	_, err = db.TagBooks("team-room", []openlibrary.Book{bookB, bookC})
+	require.NoError(t, err)
+
+	_, err = db.TagBooks("team-room", []openlibrary.Book{{OLID: "olid-missing"}})
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	testCases := []struct {
+		name     string
+		filter   BookFilter
+		expected []string
+	}{
+		{"all", BookFilter{Tags: TagFilter{All: []string{"reference", "team-room"}}}, []string{"Book B"}},
+		{"any", BookFilter{Tags: TagFilter{Any: []string{"reference", "team-room"}}}, []string{"Book A", "Book B", "Book C"}},
+		{"none", BookFilter{Tags: TagFilter{None: []string{"reference"}}}, []string{"Book C"}},
+		{"any and none", BookFilter{Tags: TagFilter{Any: []string{"team-room"}, None: []string{"reference"}}}, []string{"Book C"}},
+		{"query by author", BookFilter{Query: "author a"}, []string{"Book A"}},
+		{"query and tag", BookFilter{Query: "Book", Tags: TagFilter{All: []string{"reference"}}}, []string{"Book A", "Book B"}},
+	}
+
+	for _, testCase := range testCases {
+		books, err := db.FindBooks(testCase.filter)
+		require.NoError(t, err)
+
+		titles := []string{}
+		for _, book := range books {
+			titles = append(titles, book.Title)
+		}
+		assert.Equal(t, testCase.expected, titles, testCase.name)
+	}
+
+	rows, err = db.UntagBooks("reference", []openlibrary.Book{bookA})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), rows)
+
+	tags, err := db.Tags()
+	require.NoError(t, err)
+	assert.Equal(t, []TagCount{{"reference", 1}, {"team-room", 2}}, tags)
+
+	bookTags, err := db.BookTags(bookB)
+	require.NoError(t, err)
+	require.Len(t, bookTags, 2)
+	assert.Equal(t, "reference", bookTags[0].Name)
+}
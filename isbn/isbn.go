@@ -0,0 +1,104 @@
+// Package isbn validates ISBNs and converts between the ten and thirteen digit forms, so that the
+// same book recorded under either form can be recognised.
+package isbn
+
+import (
+	"strconv"
+	"strings"
+)
+
+// bookland is the prefix of the thirteen digit ISBNs which have a ten digit form.
+const bookland = "978"
+
+// Clean strips the hyphens and spaces from an ISBN and uppercases a trailing check character.
+func Clean(isbn string) string {
+	return strings.ToUpper(strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, isbn))
+}
+
+// Valid reports whether an ISBN, in either form, has the right length and check digit.
+func Valid(isbn string) bool {
+	isbn = Clean(isbn)
+	switch len(isbn) {
+	case 10:
+		return digits(isbn[:9]) && checkDigit10(isbn[:9]) == isbn[9]
+	case 13:
+		return digits(isbn) && checkDigit13(isbn[:12]) == isbn[12]
+	default:
+		return false
+	}
+}
+
+// To13 returns the thirteen digit form of a valid ISBN.
+func To13(isbn string) (string, bool) {
+	isbn = Clean(isbn)
+	if !Valid(isbn) {
+		return "", false
+	}
+	if len(isbn) == 13 {
+		return isbn, true
+	}
+	body := bookland + isbn[:9]
+	return body + string(checkDigit13(body)), true
+}
+
+// To10 returns the ten digit form of a valid ISBN. Thirteen digit ISBNs outside the 978 prefix have
+// no ten digit form.
+func To10(isbn string) (string, bool) {
+	isbn = Clean(isbn)
+	if !Valid(isbn) {
+		return "", false
+	}
+	if len(isbn) == 10 {
+		return isbn, true
+	}
+	if !strings.HasPrefix(isbn, bookland) {
+		return "", false
+	}
+	body := isbn[3:12]
+	return body + string(checkDigit10(body)), true
+}
+
+// Key returns the form two ISBNs share if they identify the same book: the thirteen digit form of a
+// valid ISBN, otherwise the cleaned input.
+func Key(isbn string) string {
+	if isbn13, ok := To13(isbn); ok {
+		return isbn13
+	}
+	return Clean(isbn)
+}
+
+func digits(s string) bool {
+	_, err := strconv.ParseUint(s, 10, 64)
+	return err == nil
+}
+
+// checkDigit10 computes the check character of the first nine digits of a ten digit ISBN.
+func checkDigit10(body string) byte {
+	sum := 0
+	for i := 0; i < 9; i++ {
+		sum += (10 - i) * int(body[i]-'0')
+	}
+	check := (11 - sum%11) % 11
+	if check == 10 {
+		return 'X'
+	}
+	return byte('0' + check)
+}
+
+// checkDigit13 computes the check digit of the first twelve digits of a thirteen digit ISBN.
+func checkDigit13(body string) byte {
+	sum := 0
+	for i := 0; i < 12; i++ {
+		weight := 1
+		if i%2 == 1 {
+			weight = 3
+		}
+		sum += weight * int(body[i]-'0')
+	}
+	return byte('0' + (10-sum%10)%10)
+}
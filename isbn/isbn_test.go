@@ -0,0 +1,37 @@
+package isbn
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValid(t *testing.T) {
+	assert.True(t, Valid("0-261-10236-2"))
+	assert.True(t, Valid("9780261102361"))
+	assert.True(t, Valid("080442957x"))
+	assert.False(t, Valid("0261102363"))
+	assert.False(t, Valid("9780261102362"))
+	assert.False(t, Valid("026110236"))
+	assert.False(t, Valid("97802611023a1"))
+}
+
+func TestConvert(t *testing.T) {
+	isbn13, ok := To13("0-261-10236-2")
+	assert.True(t, ok)
+	assert.Equal(t, "9780261102361", isbn13)
+
+	isbn10, ok := To10("978-0-8044-2957-3")
+	assert.True(t, ok)
+	assert.Equal(t, "080442957X", isbn10)
+
+	_, ok = To10("9791032300824")
+	assert.False(t, ok)
+	_, ok = To13("0261102363")
+	assert.False(t, ok)
+}
+
+func TestKey(t *testing.T) {
+	assert.Equal(t, Key("0261102362"), Key("978-0-261-10236-1"))
+	assert.Equal(t, "NOTANISBN", Key("not an isbn"))
+}
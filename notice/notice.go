@@ -0,0 +1,140 @@
+// Package notice renders reminder messages for patrons with overdue loans from a user editable
+// template, and delivers them by email.
+package notice
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// DefaultTemplate is used when no template of the library's own is given. A template may define a
+// "subject" template for the subject line; the rest of it renders the body of the message.
+const DefaultTemplate = `{{define "subject"}}Overdue library {{if eq (len .Items) 1}}item{{else}}items{{end}}{{end -}}
+Dear {{.Name}},
+
+Our records show that the following {{if eq (len .Items) 1}}item is{{else}}items are{{end}} overdue:
+{{range .Items}}
+  {{.Title}} ({{.Accession}}), due {{.DueOn.Format "2 January 2006"}}, {{.DaysOverdue}} {{if eq .DaysOverdue 1}}day{{else}}days{{end}} overdue
+{{- end}}
+
+Please return or renew {{if eq (len .Items) 1}}it{{else}}them{{end}} as soon as you can.
+
+Thank you.
+`
+
+const defaultSubject = "Overdue library items"
+
+// Item is a single overdue loan.
+type Item struct {
+	Title       string
+	Accession   string
+	DueOn       time.Time
+	DaysOverdue int
+}
+
+// Data is what a template is rendered with: the patron and their overdue items.
+type Data struct {
+	Name    string
+	Barcode string
+	Contact string
+	Date    time.Time
+	Items   []Item
+}
+
+// Message is a rendered notice.
+type Message struct {
+	Subject string
+	Body    string
+}
+
+// Parse parses the text of a notice template.
+func Parse(text string) (*template.Template, error) {
+	t, err := template.New("notice").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("notice: error parsing template: %w", err)
+	}
+	return t, nil
+}
+
+// Render renders the notice for a patron.
+func Render(t *template.Template, data Data) (*Message, error) {
+	message := &Message{Subject: defaultSubject}
+
+	if subject := t.Lookup("subject"); subject != nil {
+		buffer := &bytes.Buffer{}
+		err := subject.Execute(buffer, data)
+		if err != nil {
+			return nil, fmt.Errorf("notice: error rendering subject: %w", err)
+		}
+		message.Subject = strings.TrimSpace(buffer.String())
+	}
+
+	buffer := &bytes.Buffer{}
+	err := t.Execute(buffer, data)
+	if err != nil {
+		return nil, fmt.Errorf("notice: error rendering notice: %w", err)
+	}
+	message.Body = buffer.String()
+
+	return message, nil
+}
+
+// Mailer sends notices through an SMTP server.
+type Mailer struct {
+	// Server is the host and port of the SMTP server, e.g. "localhost:25".
+	Server string
+	From   string
+	// Username and Password authenticate with the server if Username is set.
+	Username string
+	Password string
+}
+
+// Send emails a message to the given address.
+func (m Mailer) Send(to string, message Message) error {
+	host, _, err := net.SplitHostPort(m.Server)
+	if err != nil {
+		return fmt.Errorf("notice: invalid smtp server %q: %w", m.Server, err)
+	}
+	from, err := mail.ParseAddress(m.From)
+	if err != nil {
+		return fmt.Errorf("notice: invalid sender address %q: %w", m.From, err)
+	}
+	recipient, err := mail.ParseAddress(to)
+	if err != nil {
+		return fmt.Errorf("notice: invalid recipient address %q: %w", to, err)
+	}
+
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, host)
+	}
+
+	err = smtp.SendMail(m.Server, auth, from.Address, []string{recipient.Address}, email(from, recipient, message))
+	if err != nil {
+		return fmt.Errorf("notice: error sending to %s: %w", recipient.Address, err)
+	}
+	return nil
+}
+
+// email formats a message with its headers, with CRLF line endings as SMTP expects.
+func email(from *mail.Address, to *mail.Address, message Message) []byte {
+	buffer := &bytes.Buffer{}
+	fmt.Fprintf(buffer, "From: %s\r\n", from.String())
+	fmt.Fprintf(buffer, "To: %s\r\n", to.String())
+	fmt.Fprintf(buffer, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", message.Subject))
+	fmt.Fprintf(buffer, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buffer.WriteString("MIME-Version: 1.0\r\n")
+	buffer.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buffer.WriteString("\r\n")
+
+	body := strings.ReplaceAll(message.Body, "\r\n", "\n")
+	buffer.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	return buffer.Bytes()
+}
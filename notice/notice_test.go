@@ -0,0 +1,148 @@
+package notice
+
+import (
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderDefaultTemplate(t *testing.T) {
+	tmpl, err := Parse(DefaultTemplate)
+	require.NoError(t, err)
+
+	data := Data{
+		Name: "Ada Lovelace",
+		Items: []Item{
+			{Title: "Dune", Accession: "C000001", DueOn: time.Date(2023, time.May, 1, 0, 0, 0, 0, time.UTC), DaysOverdue: 1},
+			{Title: "Emma", Accession: "C000002", DueOn: time.Date(2023, time.April, 20, 0, 0, 0, 0, time.UTC), DaysOverdue: 12},
+		},
+	}
+
+	message, err := Render(tmpl, data)
+	require.NoError(t, err)
+	assert.Equal(t, "Overdue library items", message.Subject)
+	assert.Equal(t, `Dear Ada Lovelace,
+
+Our records show that the following items are overdue:
+
+  Dune (C000001), due 1 May 2023, 1 day overdue
+  Emma (C000002), due 20 April 2023, 12 days overdue
+
+Please return or renew them as soon as you can.
+
+Thank you.
+`, message.Body)
+
+	data.Items = data.Items[:1]
+	message, err = Render(tmpl, data)
+	require.NoError(t, err)
+	assert.Equal(t, "Overdue library item", message.Subject)
+	assert.Contains(t, message.Body, "the following item is overdue")
+}
+
+func TestRenderCustomTemplate(t *testing.T) {
+	tmpl, err := Parse("Hi {{.Name}}, {{len .Items}} overdue.")
+	require.NoError(t, err)
+
+	message, err := Render(tmpl, Data{Name: "Ada", Items: []Item{{}}})
+	require.NoError(t, err)
+	assert.Equal(t, defaultSubject, message.Subject, "templates need not define a subject")
+	assert.Equal(t, "Hi Ada, 1 overdue.", message.Body)
+
+	_, err = Parse("{{.Name")
+	assert.Error(t, err)
+
+	tmpl, err = Parse("{{.Nickname}}")
+	require.NoError(t, err)
+	_, err = Render(tmpl, Data{})
+	assert.Error(t, err)
+}
+
+func TestSend(t *testing.T) {
+	server, received := serveSMTP(t)
+
+	mailer := Mailer{Server: server, From: "Library <library@example.com>"}
+	err := mailer.Send("ada@example.com", Message{Subject: "Überfällig", Body: "Line one\nLine two\n"})
+	require.NoError(t, err)
+
+	mail := <-received
+	assert.Equal(t, "<library@example.com>", mail.from)
+	assert.Equal(t, []string{"<ada@example.com>"}, mail.to)
+	assert.Contains(t, mail.data, "To: <ada@example.com>\r\n")
+	assert.Contains(t, mail.data, "Subject: =?utf-8?q?=C3=9Cberf=C3=A4llig?=\r\n")
+	assert.True(t, strings.HasSuffix(mail.data, "\r\n\r\nLine one\r\nLine two\r\n"))
+
+	err = mailer.Send("not an address", Message{})
+	assert.Error(t, err)
+
+	mailer.Server = "localhost"
+	err = mailer.Send("ada@example.com", Message{})
+	assert.Error(t, err, "the server needs a port")
+}
+
+type received struct {
+	from string
+	to   []string
+	data string
+}
+
+// serveSMTP accepts a single SMTP session on a local port, returning its address and a channel
+// receiving the mail delivered in it.
+func serveSMTP(t *testing.T) (string, <-chan received) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+
+	mails := make(chan received, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+
+		text := textproto.NewConn(conn)
+		mail := received{}
+		_ = text.PrintfLine("220 localhost ready")
+		for {
+			line, err := text.ReadLine()
+			if err != nil {
+				return
+			}
+			command, argument, _ := strings.Cut(line, " ")
+			switch strings.ToUpper(command) {
+			case "EHLO", "HELO":
+				_ = text.PrintfLine("250 localhost")
+			case "MAIL":
+				mail.from = strings.TrimPrefix(argument, "FROM:")
+				_ = text.PrintfLine("250 OK")
+			case "RCPT":
+				mail.to = append(mail.to, strings.TrimPrefix(argument, "TO:"))
+				_ = text.PrintfLine("250 OK")
+			case "DATA":
+				_ = text.PrintfLine("354 go ahead")
+				lines, err := text.ReadDotLines()
+				if err != nil {
+					return
+				}
+				mail.data = strings.Join(lines, "\r\n") + "\r\n"
+				_ = text.PrintfLine("250 OK")
+				mails <- mail
+			case "QUIT":
+				_ = text.PrintfLine("221 bye")
+				return
+			default:
+				_ = text.PrintfLine("502 unsupported")
+			}
+		}
+	}()
+
+	return listener.Addr().String(), mails
+}
@@ -0,0 +1,133 @@
+package openlibrary
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Contributor roles. Primary authors have no role.
+const (
+	RoleAuthor      = ""
+	RoleEditor      = "editor"
+	RoleTranslator  = "translator"
+	RoleIllustrator = "illustrator"
+	RoleContributor = "contributor"
+)
+
+// LocalAuthorKeyPrefix marks authors which were named by an edition but have no openlibrary record
+// of their own.
+const LocalAuthorKeyPrefix = "local:"
+
+type Contributor struct {
+	Role string `json:"role"`
+	Name string `json:"name"`
+}
+
+var (
+	roleClausePattern = regexp.MustCompile(`(?i)^(.*?)\bby\s+(.+)$`)
+	nameListPattern   = regexp.MustCompile(`(?i)\s*(?:,\s*and\s+|,|\s+and\s+|&)\s*`)
+)
+
+// LocalAuthorKey builds a stable key for an author known only by name.
+func LocalAuthorKey(name string) string {
+	return LocalAuthorKeyPrefix + strings.Join(strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}), "-")
+}
+
+// IsLocalAuthorKey reports whether a key was made by LocalAuthorKey.
+func IsLocalAuthorKey(key string) bool {
+	return strings.HasPrefix(key, LocalAuthorKeyPrefix)
+}
+
+// NormalizeRole maps the free text roles used in openlibrary records, such as "Translator" or
+// "edited", onto one of the Role constants.
+func NormalizeRole(role string) string {
+	role = strings.ToLower(role)
+	switch {
+	case role == "", role == "author", strings.HasPrefix(role, "written"), strings.HasPrefix(role, "/type/author_role"):
+		return RoleAuthor
+	case strings.Contains(role, "edit"), strings.HasPrefix(role, "ed."), strings.Contains(role, "compil"):
+		return RoleEditor
+	case strings.Contains(role, "transl"), strings.HasPrefix(role, "trans"):
+		return RoleTranslator
+	case strings.Contains(role, "illustr"), strings.Contains(role, "drawings"), strings.Contains(role, "pictures"):
+		return RoleIllustrator
+	default:
+		return RoleContributor
+	}
+}
+
+// ParseByStatement extracts the people named in an edition's by_statement, such as "by J.R.R.
+// Tolkien ; illustrated by Alan Lee", along with their roles. The authors returned have local keys.
+func ParseByStatement(statement string) []Author {
+	authors := []Author{}
+	for _, clause := range strings.Split(statement, ";") {
+		clause = strings.TrimSpace(strings.TrimRight(strings.TrimSpace(clause), "."))
+
+		role := RoleAuthor
+		names := clause
+		if match := roleClausePattern.FindStringSubmatch(clause); match != nil {
+			role = NormalizeRole(strings.TrimSpace(match[1]))
+			names = match[2]
+		}
+
+		for _, name := range nameListPattern.Split(names, -1) {
+			name = strings.TrimSpace(strings.Trim(name, "[]"))
+			if name == "" {
+				continue
+			}
+			authors = append(authors, Author{OLID: LocalAuthorKey(name), Name: name, Role: role})
+		}
+	}
+	return authors
+}
+
+// applyContributors assigns roles to the authors of a book using the edition's contributor list and
+// by_statement, adding any named contributors who are not already among the book's authors. Primary
+// authors named only by the by_statement are not added.
+func applyContributors(book *Book) {
+	credited := append([]Author{}, ParseByStatement(book.ByStatement)...)
+	for _, contributor := range book.Contributors {
+		credited = append(credited, Author{
+			OLID: LocalAuthorKey(contributor.Name),
+			Name: contributor.Name,
+			Role: NormalizeRole(contributor.Role),
+		})
+	}
+
+	for _, credit := range credited {
+		if credit.Role == RoleAuthor {
+			continue
+		}
+
+		matched := false
+		for i := range book.Authors {
+			if SameName(book.Authors[i].Name, credit.Name) {
+				if book.Authors[i].Role == RoleAuthor {
+					book.Authors[i].Role = credit.Role
+				}
+				matched = true
+			}
+		}
+		if !matched {
+			book.Authors = append(book.Authors, credit)
+		}
+	}
+}
+
+// SameName reports whether two names are the same ignoring case, spacing and punctuation, so that
+// "J.R.R. Tolkien" and "J. R. R. Tolkien" match.
+func SameName(a, b string) bool {
+	return nameKey(a) == nameKey(b)
+}
+
+func nameKey(name string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, name)
+}
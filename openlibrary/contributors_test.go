@@ -0,0 +1,67 @@
+package openlibrary
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseByStatement(t *testing.T) {
+	testCases := []struct {
+		statement string
+		expected  []Author
+	}{
+		{
+			"by J.R.R. Tolkien.",
+			[]Author{{OLID: "local:j-r-r-tolkien", Name: "J.R.R. Tolkien"}},
+		},
+		{
+			"edited by John Smith and Jane Doe ; translated from the French by Bob Roe.",
+			[]Author{
+				{OLID: "local:john-smith", Name: "John Smith", Role: RoleEditor},
+				{OLID: "local:jane-doe", Name: "Jane Doe", Role: RoleEditor},
+				{OLID: "local:bob-roe", Name: "Bob Roe", Role: RoleTranslator},
+			},
+		},
+		{
+			"Ann Author, Ben Author, and Cat Author ; illustrations by Dee Artist",
+			[]Author{
+				{OLID: "local:ann-author", Name: "Ann Author"},
+				{OLID: "local:ben-author", Name: "Ben Author"},
+				{OLID: "local:cat-author", Name: "Cat Author"},
+				{OLID: "local:dee-artist", Name: "Dee Artist", Role: RoleIllustrator},
+			},
+		},
+		{
+			"",
+			[]Author{},
+		},
+	}
+
+	for _, testCase := range testCases {
+		assert.Equal(t, testCase.expected, ParseByStatement(testCase.statement), testCase.statement)
+	}
+}
+
+func TestApplyContributors(t *testing.T) {
+	book := &Book{
+		Authors: []Author{
+			{OLID: "/authors/OL1A", Name: "Homer"},
+			{OLID: "/authors/OL2A", Name: "E. V. Rieu"},
+		},
+		ByStatement: "Homer ; translated by E.V. Rieu",
+		Contributors: []Contributor{
+			{Role: "Introduction", Name: "Peter Jones"},
+			{Role: "Editor", Name: "D.C.H. Rieu"},
+		},
+	}
+
+	applyContributors(book)
+
+	assert.Equal(t, []Author{
+		{OLID: "/authors/OL1A", Name: "Homer"},
+		{OLID: "/authors/OL2A", Name: "E. V. Rieu", Role: RoleTranslator},
+		{OLID: "local:peter-jones", Name: "Peter Jones", Role: RoleContributor},
+		{OLID: "local:d-c-h-rieu", Name: "D.C.H. Rieu", Role: RoleEditor},
+	}, book.Authors)
+}
@@ -0,0 +1,50 @@
+package openlibrary
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Cover sizes.
+const (
+	CoverSmall  = "S"
+	CoverMedium = "M"
+	CoverLarge  = "L"
+)
+
+// coversURL is where cover images are fetched from; it is replaced in tests.
+var coversURL = "https://covers.openlibrary.org"
+
+// coverClient fetches cover images, giving up on a slow server rather than stalling a site build.
+var coverClient = &http.Client{Timeout: 30 * time.Second}
+
+// CoverURL is the address of the cover image of an edition, such as "/books/OL1M", in one of the
+// Cover sizes. Openlibrary serves a blank image for editions without a cover.
+func CoverURL(olid string, size string) string {
+	return fmt.Sprintf("%s/b/olid/%s-%s.jpg", coversURL, strings.TrimPrefix(olid, "/books/"), size)
+}
+
+// FetchCover downloads the medium sized cover image of an edition, returning nil if it has none.
+func FetchCover(olid string) ([]byte, error) {
+	response, err := coverClient.Get(CoverURL(olid, CoverMedium) + "?default=false")
+	if err != nil {
+		return nil, fmt.Errorf("openlibrary: error making request: %w", err)
+	}
+	defer func() { _ = response.Body.Close() }()
+
+	if response.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("openlibrary: non 200 status while fetching cover: %s", olid)
+	}
+
+	content, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, fmt.Errorf("openlibrary: error reading response: %w", err)
+	}
+	return content, nil
+}
@@ -0,0 +1,35 @@
+package openlibrary
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFetchCover(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/b/olid/OL1M-M.jpg" || r.URL.Query().Get("default") != "false" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte("jpeg"))
+	}))
+	t.Cleanup(server.Close)
+
+	previousURL := coversURL
+	coversURL = server.URL
+	t.Cleanup(func() { coversURL = previousURL })
+
+	assert.Equal(t, server.URL+"/b/olid/OL1M-S.jpg", CoverURL("/books/OL1M", CoverSmall))
+
+	cover, err := FetchCover("/books/OL1M")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("jpeg"), cover)
+
+	cover, err = FetchCover("/books/OL2M")
+	require.NoError(t, err)
+	assert.Nil(t, cover)
+}
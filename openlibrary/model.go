@@ -1,8 +1,50 @@
 package openlibrary
 
+import (
+	"encoding/json"
+	"strings"
+)
+
 type Author struct {
 	OLID string `json:"key"`
 	Name string `json:"name"`
+
+	// Role is the part the author played in a particular book; it is empty for primary authors.
+	Role string `json:"-"`
+
+	PersonalName   string    `json:"personal_name"`
+	AlternateNames []string  `json:"alternate_names"`
+	BirthDate      string    `json:"birth_date"`
+	DeathDate      string    `json:"death_date"`
+	Bio            Text      `json:"bio"`
+	RemoteIDs      RemoteIDs `json:"remote_ids"`
+}
+
+// RemoteIDs are an author's identifiers in other authority files.
+type RemoteIDs struct {
+	Wikidata string `json:"wikidata"`
+	VIAF     string `json:"viaf"`
+}
+
+// Text is a string which openlibrary sometimes wraps in a typed object, such as
+// {"type": "/type/text", "value": "..."}.
+type Text string
+
+func (t *Text) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err == nil {
+		*t = Text(value)
+		return nil
+	}
+
+	typed := struct {
+		Value string `json:"value"`
+	}{}
+	if err := json.Unmarshal(data, &typed); err != nil {
+		return err
+	}
+	*t = Text(typed.Value)
+	return nil
 }
 
 type Book struct {
@@ -11,7 +53,47 @@ type Book struct {
 	Isbn10  []string `json:"isbn_10"`
 	Isbn13  []string `json:"isbn_13"`
 	Authors []Author `json:"authors"`
-	Works   []struct {
-		Key string `json:"key"`
-	} `json:"works"`
+
+	DeweyDecimalClass []string `json:"dewey_decimal_class"`
+	LCClassifications []string `json:"lc_classifications"`
+
+	ByStatement  string        `json:"by_statement"`
+	Contributors []Contributor `json:"contributors"`
+
+	Works []Work `json:"works"`
+
+	PublishDate string     `json:"publish_date"`
+	Languages   []Language `json:"languages"`
+	// Subjects are those of the edition followed by any more of its works.
+	Subjects []string `json:"subjects"`
+
+	// AuthorSource records where the authors of the book were taken from, as one of the
+	// AuthorSource constants.
+	AuthorSource string `json:"-"`
 }
+
+// Language is a language an edition is written in, keyed like "/languages/eng".
+type Language struct {
+	Key string `json:"key"`
+}
+
+// Code returns the MARC code of the language, such as "eng".
+func (l Language) Code() string {
+	return strings.TrimPrefix(l.Key, languageKeyPrefix)
+}
+
+const languageKeyPrefix = "/languages/"
+
+// Work is a work an edition contains. Editions only carry the key; the title is filled in when the
+// work is looked up.
+type Work struct {
+	Key   string `json:"key"`
+	Title string `json:"-"`
+}
+
+const (
+	AuthorSourceEdition     = "edition"
+	AuthorSourceWork        = "work"
+	AuthorSourceByStatement = "by-statement"
+	AuthorSourceNone        = "none"
+)
@@ -0,0 +1,35 @@
+package openlibrary
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthorUnmarshal(t *testing.T) {
+	testCases := []struct {
+		name     string
+		json     string
+		expected Author
+	}{
+		{
+			"plain bio",
+			`{"key": "/authors/OL1A", "name": "A", "bio": "Plain.", "remote_ids": {"viaf": "123"}}`,
+			Author{OLID: "/authors/OL1A", Name: "A", Bio: "Plain.", RemoteIDs: RemoteIDs{VIAF: "123"}},
+		},
+		{
+			"typed bio",
+			`{"key": "/authors/OL1A", "name": "A", "bio": {"type": "/type/text", "value": "Typed."}, "alternate_names": ["B"]}`,
+			Author{OLID: "/authors/OL1A", Name: "A", Bio: "Typed.", AlternateNames: []string{"B"}},
+		},
+	}
+
+	for _, testCase := range testCases {
+		author := Author{}
+		err := json.Unmarshal([]byte(testCase.json), &author)
+		require.NoError(t, err, testCase.name)
+		assert.Equal(t, testCase.expected, author, testCase.name)
+	}
+}
@@ -2,147 +2,294 @@ package openlibrary
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"strings"
 )
 
-const isbnSeparator = ","
+// ISBNSeparator joins multiple isbns of one form.
+const ISBNSeparator = ","
+
+// ClassificationSeparator joins multiple classification numbers; unlike isbns these may contain
+// commas.
+const ClassificationSeparator = "; "
+
+// LanguageSeparator joins the codes of the languages of a book, such as "eng,fre".
+const LanguageSeparator = ","
 
 func (b *Book) GetIsbn13() *string {
-	if len(b.Isbn13) == 0 {
-		return nil
-	}
-	result := strings.Join(b.Isbn13, isbnSeparator)
-	return &result
+	return joinValues(b.Isbn13, ISBNSeparator)
 }
 
 func (b *Book) GetIsbn10() *string {
-	if len(b.Isbn10) == 0 {
-		return nil
-	}
-	result := strings.Join(b.Isbn10, isbnSeparator)
-	return &result
+	return joinValues(b.Isbn10, ISBNSeparator)
 }
 
 func (b *Book) SetIsbn13(input string) {
-	b.Isbn13 = strings.Split(input, isbnSeparator)
+	b.Isbn13 = strings.Split(input, ISBNSeparator)
 }
 
 func (b *Book) SetIsbn10(input string) {
-	b.Isbn10 = strings.Split(input, isbnSeparator)
+	b.Isbn10 = strings.Split(input, ISBNSeparator)
 }
 
-func LookupByISBN(isbn string) (*Book, error) {
-	response, err := http.Get(fmt.Sprintf("https://openlibrary.org/isbn/%s.json", isbn))
-	if err != nil {
-		return nil, fmt.Errorf("openlibrary: error making request: %w", err)
+func (b *Book) GetDeweyDecimalClass() *string {
+	return joinValues(b.DeweyDecimalClass, ClassificationSeparator)
+}
+
+func (b *Book) GetLCClassifications() *string {
+	return joinValues(b.LCClassifications, ClassificationSeparator)
+}
+
+func (b *Book) SetDeweyDecimalClass(input string) {
+	b.DeweyDecimalClass = strings.Split(input, ClassificationSeparator)
+}
+
+func (b *Book) SetLCClassifications(input string) {
+	b.LCClassifications = strings.Split(input, ClassificationSeparator)
+}
+
+// GetLanguages returns the codes of the languages of the book, such as "eng,fre".
+func (b *Book) GetLanguages() *string {
+	codes := []string{}
+	for _, language := range b.Languages {
+		codes = append(codes, language.Code())
 	}
+	return joinValues(codes, LanguageSeparator)
+}
 
-	if response.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("openlibrary: non 200 stats while looking up isbn: %s", isbn)
+func (b *Book) SetLanguages(input string) {
+	b.Languages = nil
+	for _, code := range strings.Split(input, LanguageSeparator) {
+		b.Languages = append(b.Languages, Language{Key: languageKeyPrefix + code})
 	}
+}
 
-	result := &Book{}
+func joinValues(values []string, separator string) *string {
+	if len(values) == 0 {
+		return nil
+	}
+	result := strings.Join(values, separator)
+	return &result
+}
 
-	responseBody, err := ioutil.ReadAll(response.Body)
+// LookupByISBN fetches the edition with the given isbn, enriched with the title and authors of its
+// work and the details of each author.
+func LookupByISBN(isbn string) (*Book, error) {
+	responseBody, err := fetchRecord(fmt.Sprintf("/isbn/%s", isbn), "isbn")
 	if err != nil {
-		return nil, fmt.Errorf("openlibrary: error reading response: %w", err)
+		return nil, err
 	}
 
+	result := &Book{}
+
 	err = json.Unmarshal(responseBody, result)
 	if err != nil {
 		return nil, fmt.Errorf("openlibrary: error unmarshaling isbn response: %w", err)
 	}
 
-	// The quality of title string and authors in the parent work object seems to be better, so use
-	// that if it is present and unambiguous
-	if len(result.Works) == 1 {
-		work, err := lookupWorkByKey(result.Works[0].Key)
+	err = resolveWorks(result)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range result.Authors {
+		resolvedAuthor, err := LookupAuthor(result.Authors[i].OLID)
 		if err != nil {
 			return nil, err
 		}
+		resolvedAuthor.Role = result.Authors[i].Role
+		result.Authors[i] = *resolvedAuthor
+	}
+
+	// with no linked authors at all, the by_statement is the only record of who wrote the book
+	if len(result.Authors) == 0 {
+		result.Authors = ParseByStatement(result.ByStatement)
+		result.AuthorSource = AuthorSourceByStatement
+		if len(result.Authors) == 0 {
+			result.AuthorSource = AuthorSourceNone
+		}
+	}
+
+	applyContributors(result)
+
+	return result, nil
+}
 
-		var tentativeTitle string
-		if work.Subtitle != "" {
-			tentativeTitle = fmt.Sprintf("%s: %s", work.Title, work.Subtitle)
-		} else {
-			tentativeTitle = work.Title
+// resolveWorks looks up the works of an edition, filling in their titles, and settles on the title
+// and authors of the book.
+//
+// The quality of title string and authors in the parent work object seems to be better, so use that
+// if it is present and unambiguous. An edition holding several works, such as an anthology or an
+// omnibus, keeps its own title since no single work title describes it; its authors are its own if
+// it lists any, otherwise every author of every work in order.
+func resolveWorks(result *Book) error {
+	result.AuthorSource = AuthorSourceEdition
+
+	works := []*work{}
+	for i := range result.Works {
+		work, err := lookupWorkByKey(result.Works[i].Key)
+		if err != nil {
+			return err
 		}
+		result.Works[i].Title = work.fullTitle()
+		result.Subjects = appendSubjects(result.Subjects, work.Subjects)
+		works = append(works, work)
+	}
 
-		if len(tentativeTitle) > len(result.Title) {
+	if len(works) == 1 {
+		if tentativeTitle := works[0].fullTitle(); len(tentativeTitle) > len(result.Title) {
 			result.Title = tentativeTitle
 		}
+	}
 
-		if len(work.Authors) > 0 {
-			authors := []Author{}
-			for _, a := range work.Authors {
-				authors = append(authors, a.Author)
+	if len(works) == 1 || (len(works) > 1 && len(result.Authors) == 0) {
+		authors := []Author{}
+		seen := map[string]bool{}
+		for _, work := range works {
+			for _, author := range work.credits() {
+				if !seen[author.OLID] {
+					seen[author.OLID] = true
+					authors = append(authors, author)
+				}
 			}
+		}
+
+		if len(authors) > 0 {
 			result.Authors = authors
+			result.AuthorSource = AuthorSourceWork
 		}
 	}
 
-	for i := range result.Authors {
-		resolvedAuthor, err := lookupAuthorByKey(result.Authors[i].OLID)
+	return nil
+}
+
+// LookupAuthor fetches the author with the given key. If the author has been merged into another
+// record, that record is returned instead, with its own key.
+func LookupAuthor(key string) (*Author, error) {
+	responseBody, err := fetchRecord(key, "author")
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Author{}
+
+	err = json.Unmarshal(responseBody, result)
+	if err != nil {
+		return nil, fmt.Errorf("openlibrary: error unmarshaling author response: %w", err)
+	}
+
+	return result, nil
+}
+
+// LookupEdition fetches the edition with the given key along with the subjects of its works, leaving
+// its authors and works unresolved. It is for filling in the details of books already saved.
+func LookupEdition(key string) (*Book, error) {
+	responseBody, err := fetchRecord(key, "edition")
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Book{}
+
+	err = json.Unmarshal(responseBody, result)
+	if err != nil {
+		return nil, fmt.Errorf("openlibrary: error unmarshaling edition response: %w", err)
+	}
+
+	for _, edition := range result.Works {
+		work, err := lookupWorkByKey(edition.Key)
 		if err != nil {
 			return nil, err
 		}
-		result.Authors[i] = *resolvedAuthor
+		result.Subjects = appendSubjects(result.Subjects, work.Subjects)
 	}
 
 	return result, nil
 }
 
-func lookupAuthorByKey(key string) (*Author, error) {
-	response, err := http.Get(fmt.Sprintf("https://openlibrary.org/%s.json", key))
-	if err != nil {
-		return nil, fmt.Errorf("openlibrary: error making request: %w", err)
+// appendSubjects adds the subjects not already in subjects, ignoring case and surrounding space.
+func appendSubjects(subjects []string, more []string) []string {
+	seen := map[string]bool{}
+	for _, subject := range subjects {
+		seen[strings.ToLower(strings.TrimSpace(subject))] = true
 	}
-	if response.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("openlibrary: non 200 stats while looking up author: %s", key)
+	for _, subject := range more {
+		key := strings.ToLower(strings.TrimSpace(subject))
+		if key == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		subjects = append(subjects, strings.TrimSpace(subject))
 	}
+	return subjects
+}
 
-	result := &Author{}
-
-	responseBody, err := ioutil.ReadAll(response.Body)
+// ResolveKey returns the key of the record which now holds the data for key, which is key itself
+// unless the record has been merged into another. ErrDeleted is returned for deleted records.
+func ResolveKey(key string) (string, error) {
+	responseBody, err := fetchRecord(key, "record")
 	if err != nil {
-		return nil, fmt.Errorf("openlibrary: error reading response: %w", err)
+		return "", err
 	}
 
+	result := &record{}
+
 	err = json.Unmarshal(responseBody, result)
 	if err != nil {
-		return nil, fmt.Errorf("openlibrary: error unmarshaling author response: %w", err)
+		return "", fmt.Errorf("openlibrary: error unmarshaling record response: %w", err)
 	}
 
-	return result, nil
+	if result.Key == "" {
+		return key, nil
+	}
+	return result.Key, nil
 }
 
 type work struct {
-	Title    string `json:"title"`
-	Subtitle string `json:"subtitle"`
+	Title    string   `json:"title"`
+	Subtitle string   `json:"subtitle"`
+	Subjects []string `json:"subjects"`
 	Authors  []struct {
 		Author `json:"author"`
+		Role   string `json:"role"`
+		Type   struct {
+			Key string `json:"key"`
+		} `json:"type"`
 	} `json:"authors"`
 }
 
-func lookupWorkByKey(key string) (*work, error) {
-	response, err := http.Get(fmt.Sprintf("https://openlibrary.org/%s.json", key))
-	if err != nil {
-		return nil, fmt.Errorf("openlibrary: error making request: %w", err)
-	}
-	if response.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("openlibrary: non 200 stats while looking up work: %s", key)
+func (w *work) fullTitle() string {
+	if w.Subtitle != "" {
+		return fmt.Sprintf("%s: %s", w.Title, w.Subtitle)
 	}
+	return w.Title
+}
 
-	result := &work{}
+// credits returns the authors of the work with their roles.
+func (w *work) credits() []Author {
+	authors := []Author{}
+	for _, a := range w.Authors {
+		author := a.Author
+		author.Role = NormalizeRole(a.Role)
+		if author.Role == RoleAuthor {
+			author.Role = NormalizeRole(a.Type.Key)
+		}
+		authors = append(authors, author)
+	}
+	return authors
+}
 
-	responseBody, err := ioutil.ReadAll(response.Body)
+func lookupWorkByKey(key string) (*work, error) {
+	responseBody, err := fetchRecord(key, "work")
 	if err != nil {
-		return nil, fmt.Errorf("openlibrary: error reading response: %w", err)
+		return nil, err
 	}
 
+	result := &work{}
+
 	err = json.Unmarshal(responseBody, result)
 	if err != nil {
 		return nil, fmt.Errorf("openlibrary: error unmarshaling works response: %w", err)
@@ -150,3 +297,89 @@ func lookupWorkByKey(key string) (*work, error) {
 
 	return result, nil
 }
+
+const (
+	typeRedirect = "/type/redirect"
+	typeDelete   = "/type/delete"
+
+	maxRedirects = 10
+)
+
+var (
+	// ErrDeleted is returned when a record has been deleted from openlibrary.
+	ErrDeleted = errors.New("openlibrary: record has been deleted")
+
+	// ErrRedirectLoop is returned when redirect records do not lead to a real record.
+	ErrRedirectLoop = errors.New("openlibrary: redirect loop")
+
+	// ErrNotFound is returned when openlibrary has no record at all, such as for an isbn it does not
+	// know.
+	ErrNotFound = errors.New("openlibrary: no such record")
+)
+
+// baseURL is where records are fetched from; it is replaced in tests.
+var baseURL = "https://openlibrary.org"
+
+// record holds the fields common to every openlibrary record.
+type record struct {
+	Key  string `json:"key"`
+	Type struct {
+		Key string `json:"key"`
+	} `json:"type"`
+	Location string `json:"location"`
+}
+
+// fetchRecord fetches the json for the record at path, such as "/authors/OL1A". When openlibrary
+// merges duplicate records the old key holds a redirect record pointing at the survivor, so these
+// are followed to the real record.
+func fetchRecord(path string, kind string) ([]byte, error) {
+	visited := map[string]bool{}
+	for {
+		if visited[path] || len(visited) > maxRedirects {
+			return nil, fmt.Errorf("%w while looking up %s: %s", ErrRedirectLoop, kind, path)
+		}
+		visited[path] = true
+
+		responseBody, err := fetch(path, kind)
+		if err != nil {
+			return nil, err
+		}
+
+		result := &record{}
+		err = json.Unmarshal(responseBody, result)
+		if err != nil {
+			return nil, fmt.Errorf("openlibrary: error unmarshaling %s response: %w", kind, err)
+		}
+
+		switch result.Type.Key {
+		case typeRedirect:
+			path = result.Location
+		case typeDelete:
+			return nil, fmt.Errorf("%w: %s %s", ErrDeleted, kind, path)
+		default:
+			return responseBody, nil
+		}
+	}
+}
+
+func fetch(path string, kind string) ([]byte, error) {
+	response, err := http.Get(fmt.Sprintf("%s%s.json", baseURL, path))
+	if err != nil {
+		return nil, fmt.Errorf("openlibrary: error making request: %w", err)
+	}
+	defer func() { _ = response.Body.Close() }()
+
+	if response.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%w while looking up %s: %s", ErrNotFound, kind, path)
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("openlibrary: non 200 stats while looking up %s: %s", kind, path)
+	}
+
+	responseBody, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, fmt.Errorf("openlibrary: error reading response: %w", err)
+	}
+
+	return responseBody, nil
+}
@@ -0,0 +1,155 @@
+package openlibrary
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serveRecords points the client at a server holding the given records, keyed by path.
+func serveRecords(t *testing.T, records map[string]string) {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		record, ok := records[strings.TrimSuffix(r.URL.Path, ".json")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(record))
+	}))
+	t.Cleanup(server.Close)
+
+	previousURL := baseURL
+	baseURL = server.URL
+	t.Cleanup(func() { baseURL = previousURL })
+}
+
+func TestLookupAuthorFollowsRedirects(t *testing.T) {
+	serveRecords(t, map[string]string{
+		"/authors/OL1A": `{"key": "/authors/OL1A", "type": {"key": "/type/redirect"}, "location": "/authors/OL2A"}`,
+		"/authors/OL2A": `{"key": "/authors/OL2A", "type": {"key": "/type/redirect"}, "location": "/authors/OL3A"}`,
+		"/authors/OL3A": `{"key": "/authors/OL3A", "type": {"key": "/type/author"}, "name": "Survivor"}`,
+		"/authors/OL4A": `{"key": "/authors/OL4A", "type": {"key": "/type/delete"}}`,
+		"/authors/OL5A": `{"key": "/authors/OL5A", "type": {"key": "/type/redirect"}, "location": "/authors/OL6A"}`,
+		"/authors/OL6A": `{"key": "/authors/OL6A", "type": {"key": "/type/redirect"}, "location": "/authors/OL5A"}`,
+	})
+
+	author, err := LookupAuthor("/authors/OL1A")
+	require.NoError(t, err)
+	assert.Equal(t, "/authors/OL3A", author.OLID)
+	assert.Equal(t, "Survivor", author.Name)
+
+	key, err := ResolveKey("/authors/OL2A")
+	require.NoError(t, err)
+	assert.Equal(t, "/authors/OL3A", key)
+
+	_, err = LookupAuthor("/authors/OL4A")
+	assert.ErrorIs(t, err, ErrDeleted)
+
+	_, err = ResolveKey("/authors/OL5A")
+	assert.ErrorIs(t, err, ErrRedirectLoop)
+
+	_, err = ResolveKey("/authors/OL7A")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestLookupByISBN(t *testing.T) {
+	serveRecords(t, map[string]string{
+		"/isbn/9780140268867": `{
+			"key": "/books/OL1M",
+			"title": "Odyssey",
+			"isbn_13": ["9780140268867"],
+			"dewey_decimal_class": ["883/.01"],
+			"by_statement": "Homer ; translated by Robert Fagles",
+			"works": [{"key": "/works/OL1W"}]
+		}`,
+		"/works/OL1W": `{
+			"title": "The Odyssey",
+			"authors": [
+				{"type": {"key": "/type/author_role"}, "author": {"key": "/authors/OL1A"}},
+				{"type": {"key": "/type/author_role"}, "author": {"key": "/authors/OL2A"}}
+			]
+		}`,
+		"/authors/OL1A": `{"key": "/authors/OL1A", "type": {"key": "/type/redirect"}, "location": "/authors/OL9A"}`,
+		"/authors/OL9A": `{"key": "/authors/OL9A", "name": "Homer"}`,
+		"/authors/OL2A": `{"key": "/authors/OL2A", "name": "Robert Fagles"}`,
+	})
+
+	book, err := LookupByISBN("9780140268867")
+	require.NoError(t, err)
+	assert.Equal(t, "/books/OL1M", book.OLID)
+	assert.Equal(t, "The Odyssey", book.Title)
+	assert.Equal(t, []string{"883/.01"}, book.DeweyDecimalClass)
+	assert.Equal(t, []Author{
+		{OLID: "/authors/OL9A", Name: "Homer"},
+		{OLID: "/authors/OL2A", Name: "Robert Fagles", Role: RoleTranslator},
+	}, book.Authors)
+}
+
+func TestLookupByISBNWorkResolution(t *testing.T) {
+	serveRecords(t, map[string]string{
+		"/isbn/1":       `{"key": "/books/OL1M", "title": "Omnibus", "works": [{"key": "/works/OL1W"}, {"key": "/works/OL2W"}]}`,
+		"/isbn/2":       `{"key": "/books/OL2M", "title": "Omnibus", "authors": [{"key": "/authors/OL3A"}], "works": [{"key": "/works/OL1W"}, {"key": "/works/OL2W"}]}`,
+		"/isbn/3":       `{"key": "/books/OL3M", "title": "Pamphlet", "by_statement": "by Ann Author and Ben Author ; edited by Cat Editor"}`,
+		"/isbn/4":       `{"key": "/books/OL4M", "title": "Anonymous"}`,
+		"/works/OL1W":   `{"title": "First Novel", "authors": [{"author": {"key": "/authors/OL1A"}}]}`,
+		"/works/OL2W":   `{"title": "Second Novel", "authors": [{"author": {"key": "/authors/OL2A"}}, {"author": {"key": "/authors/OL1A"}}]}`,
+		"/authors/OL1A": `{"key": "/authors/OL1A", "name": "Author One"}`,
+		"/authors/OL2A": `{"key": "/authors/OL2A", "name": "Author Two"}`,
+		"/authors/OL3A": `{"key": "/authors/OL3A", "name": "Anthologist"}`,
+	})
+
+	book, err := LookupByISBN("1")
+	require.NoError(t, err)
+	assert.Equal(t, "Omnibus", book.Title, "an edition of several works keeps its own title")
+	assert.Equal(t, []Work{{Key: "/works/OL1W", Title: "First Novel"}, {Key: "/works/OL2W", Title: "Second Novel"}}, book.Works)
+	assert.Equal(t, []Author{{OLID: "/authors/OL1A", Name: "Author One"}, {OLID: "/authors/OL2A", Name: "Author Two"}}, book.Authors)
+	assert.Equal(t, AuthorSourceWork, book.AuthorSource)
+
+	book, err = LookupByISBN("2")
+	require.NoError(t, err)
+	assert.Equal(t, []Author{{OLID: "/authors/OL3A", Name: "Anthologist"}}, book.Authors)
+	assert.Equal(t, AuthorSourceEdition, book.AuthorSource)
+
+	book, err = LookupByISBN("3")
+	require.NoError(t, err)
+	assert.Equal(t, []Author{
+		{OLID: "local:ann-author", Name: "Ann Author"},
+		{OLID: "local:ben-author", Name: "Ben Author"},
+		{OLID: "local:cat-editor", Name: "Cat Editor", Role: RoleEditor},
+	}, book.Authors)
+	assert.Equal(t, AuthorSourceByStatement, book.AuthorSource)
+
+	book, err = LookupByISBN("4")
+	require.NoError(t, err)
+	assert.Empty(t, book.Authors)
+	assert.Equal(t, AuthorSourceNone, book.AuthorSource)
+}
+
+func TestLookupEdition(t *testing.T) {
+	serveRecords(t, map[string]string{
+		"/books/OL1M": `{"key": "/books/OL1M", "type": {"key": "/type/redirect"}, "location": "/books/OL2M"}`,
+		"/books/OL2M": `{
+			"key": "/books/OL2M",
+			"title": "Odyssey",
+			"publish_date": "March 1997",
+			"languages": [{"key": "/languages/eng"}, {"key": "/languages/grc"}],
+			"subjects": ["Epic poetry", "Odysseus (Greek mythology)"],
+			"works": [{"key": "/works/OL1W"}]
+		}`,
+		"/works/OL1W": `{"title": "The Odyssey", "subjects": ["epic poetry ", "Homer", ""]}`,
+	})
+
+	book, err := LookupEdition("/books/OL1M")
+	require.NoError(t, err)
+	assert.Equal(t, "/books/OL2M", book.OLID)
+	assert.Equal(t, "Odyssey", book.Title, "the work does not retitle the edition")
+	assert.Equal(t, "March 1997", book.PublishDate)
+	assert.Equal(t, "eng,grc", *book.GetLanguages())
+	assert.Equal(t, []string{"Epic poetry", "Odysseus (Greek mythology)", "Homer"}, book.Subjects)
+}
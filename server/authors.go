@@ -0,0 +1,139 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/arudzitis/addlib/db"
+)
+
+// AuthorResource is an author as the API returns it.
+type AuthorResource struct {
+	OLID      string `json:"olid"`
+	Name      string `json:"name"`
+	SortName  string `json:"sort_name"`
+	BirthDate string `json:"birth_date"`
+	DeathDate string `json:"death_date"`
+}
+
+// AuthorSummaryResource is an author in a listing, with the number of books still held which
+// credit them.
+type AuthorSummaryResource struct {
+	AuthorResource
+	Books int64 `json:"books"`
+}
+
+// AuthorDetailResource is a single author with their books.
+type AuthorDetailResource struct {
+	AuthorResource
+	Books []BookResource `json:"books"`
+}
+
+// authorUpdate is the body of an author update. Fields left out are not changed; an empty sort name
+// restores the one derived from the name.
+type authorUpdate struct {
+	Name     *string `json:"name"`
+	SortName *string `json:"sort_name"`
+}
+
+func (s *Server) handleAuthors(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+
+	page, err := readPagination(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	filter := db.AuthorFilter{Query: strings.TrimSpace(r.URL.Query().Get("q"))}
+	total, err := s.database.CountAuthors(filter)
+	if err != nil {
+		writeDatabaseError(w, err)
+		return
+	}
+
+	filter.Limit, filter.Offset = page.PerPage, page.offset()
+	summaries, err := s.database.FindAuthors(filter)
+	if err != nil {
+		writeDatabaseError(w, err)
+		return
+	}
+
+	items := []AuthorSummaryResource{}
+	for _, summary := range summaries {
+		items = append(items, AuthorSummaryResource{AuthorResource: authorResource(summary.Author), Books: summary.Books})
+	}
+	writeJSON(w, http.StatusOK, pageResponse{Items: items, Page: page.Page, PerPage: page.PerPage, Total: int(total)})
+}
+
+func (s *Server) handleAuthor(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet, http.MethodPatch) {
+		return
+	}
+
+	author, err := s.database.FindAuthor(resourceRef(r, APIPrefix+"authors/"))
+	if err != nil {
+		writeDatabaseError(w, err)
+		return
+	}
+
+	if r.Method == http.MethodPatch {
+		update := authorUpdate{}
+		if !readJSON(w, r, &update) {
+			return
+		}
+		changes := db.AuthorChanges{Name: trimmed(update.Name), SortName: trimmed(update.SortName)}
+		if changes.Name != nil && *changes.Name == "" {
+			writeError(w, http.StatusBadRequest, errors.New("name cannot be empty"))
+			return
+		}
+
+		err = s.database.UpdateAuthor(author.OLID, changes)
+		if err != nil {
+			writeDatabaseError(w, err)
+			return
+		}
+
+		author, err = s.database.FindAuthor(author.OLID)
+		if err != nil {
+			writeDatabaseError(w, err)
+			return
+		}
+	}
+
+	books, err := s.database.FindBooks(db.BookFilter{AuthorID: &author.ID})
+	if err != nil {
+		writeDatabaseError(w, err)
+		return
+	}
+
+	locationPaths, err := s.database.LocationPaths()
+	if err != nil {
+		writeDatabaseError(w, err)
+		return
+	}
+
+	resource := AuthorDetailResource{AuthorResource: authorResource(*author), Books: []BookResource{}}
+	for _, book := range books {
+		resource.Books = append(resource.Books, bookResource(book, locationPaths))
+	}
+	writeJSON(w, http.StatusOK, resource)
+}
+
+func authorResource(author db.Author) AuthorResource {
+	resource := AuthorResource{
+		OLID:     author.OLID,
+		Name:     author.Name,
+		SortName: author.SortName(),
+	}
+	if author.BirthDate != nil {
+		resource.BirthDate = *author.BirthDate
+	}
+	if author.DeathDate != nil {
+		resource.DeathDate = *author.DeathDate
+	}
+	return resource
+}
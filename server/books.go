@@ -0,0 +1,362 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/arudzitis/addlib/db"
+	"github.com/arudzitis/addlib/isbn"
+	"github.com/arudzitis/addlib/openlibrary"
+)
+
+// BookResource is a book as the API returns it.
+type BookResource struct {
+	OLID            string           `json:"olid"`
+	Title           string           `json:"title"`
+	Authors         []CreditResource `json:"authors"`
+	ISBN13          []string         `json:"isbn13"`
+	ISBN10          []string         `json:"isbn10"`
+	CallNumber      string           `json:"call_number"`
+	PublishDate     string           `json:"publish_date"`
+	Languages       []string         `json:"languages"`
+	Subjects        []string         `json:"subjects"`
+	Tags            []string         `json:"tags"`
+	Location        string           `json:"location"`
+	Copies          []CopyResource   `json:"copies"`
+	DeaccessionedOn *time.Time       `json:"deaccessioned_on"`
+	CreatedAt       time.Time        `json:"created_at"`
+	UpdatedAt       time.Time        `json:"updated_at"`
+}
+
+// CreditResource names an author of a book, and their role if they are not its author.
+type CreditResource struct {
+	OLID string `json:"olid"`
+	Name string `json:"name"`
+	Role string `json:"role"`
+}
+
+// CopyResource is a copy of a book.
+type CopyResource struct {
+	Accession string `json:"accession"`
+	Status    string `json:"status"`
+	Location  string `json:"location"`
+}
+
+// bookUpdate is the body of a book update. Fields left out are not changed.
+type bookUpdate struct {
+	Title      *string `json:"title"`
+	CallNumber *string `json:"call_number"`
+}
+
+// bookImport is the body of a book import.
+type bookImport struct {
+	ISBN string `json:"isbn"`
+}
+
+func (s *Server) handleBooks(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet, http.MethodPost) {
+		return
+	}
+	if r.Method == http.MethodPost {
+		s.importBook(w, r)
+		return
+	}
+
+	filter, err := s.bookFilter(r.URL.Query())
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+	s.listBooks(w, r, filter)
+}
+
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+
+	filter, err := s.bookFilter(r.URL.Query())
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+	if filter.Query == "" {
+		writeError(w, http.StatusBadRequest, errors.New("missing search text: q"))
+		return
+	}
+	s.listBooks(w, r, filter)
+}
+
+// bookFilter reads the q, tag, location and author parameters shared by the book listings.
+func (s *Server) bookFilter(query url.Values) (db.BookFilter, error) {
+	filter := db.BookFilter{
+		Query: strings.TrimSpace(query.Get("q")),
+		Tags:  db.TagFilter{All: query["tag"]},
+	}
+
+	if ref := query.Get("location"); ref != "" {
+		location, err := s.database.FindLocation(ref)
+		if err != nil {
+			return filter, err
+		}
+		filter.LocationID = &location.ID
+	}
+
+	if ref := query.Get("author"); ref != "" {
+		author, err := s.database.FindAuthor(ref)
+		if err != nil {
+			return filter, err
+		}
+		filter.AuthorID = &author.ID
+	}
+
+	return filter, nil
+}
+
+// writeQueryError answers a listing naming a location or author which does not exist as a bad
+// request, rather than as a missing listing.
+func writeQueryError(w http.ResponseWriter, err error) {
+	if errors.Is(err, db.ErrNotFound) {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeDatabaseError(w, err)
+}
+
+func (s *Server) listBooks(w http.ResponseWriter, r *http.Request, filter db.BookFilter) {
+	page, err := readPagination(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	total, err := s.database.CountBooks(filter)
+	if err != nil {
+		writeDatabaseError(w, err)
+		return
+	}
+
+	filter.Limit, filter.Offset = page.PerPage, page.offset()
+	books, err := s.database.FindBooks(filter)
+	if err != nil {
+		writeDatabaseError(w, err)
+		return
+	}
+
+	locationPaths, err := s.database.LocationPaths()
+	if err != nil {
+		writeDatabaseError(w, err)
+		return
+	}
+
+	items := []BookResource{}
+	for _, book := range books {
+		items = append(items, bookResource(book, locationPaths))
+	}
+	writeJSON(w, http.StatusOK, pageResponse{Items: items, Page: page.Page, PerPage: page.PerPage, Total: int(total)})
+}
+
+func (s *Server) handleBook(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet, http.MethodPatch) {
+		return
+	}
+
+	book, err := s.database.FindBook(resourceRef(r, APIPrefix+"books/"))
+	if err != nil {
+		writeDatabaseError(w, err)
+		return
+	}
+
+	if r.Method == http.MethodPatch {
+		update := bookUpdate{}
+		if !readJSON(w, r, &update) {
+			return
+		}
+		changes := db.BookChanges{Title: trimmed(update.Title), CallNumber: trimmed(update.CallNumber)}
+		if changes.Title != nil && *changes.Title == "" {
+			writeError(w, http.StatusBadRequest, errors.New("title cannot be empty"))
+			return
+		}
+
+		err = s.database.UpdateBook(openlibrary.Book{OLID: book.OLID}, changes)
+		if err != nil {
+			writeDatabaseError(w, err)
+			return
+		}
+	}
+
+	s.writeBook(w, http.StatusOK, book.OLID)
+}
+
+// importBook looks up a book by isbn and adds it to the catalogue. Books already catalogued are a
+// conflict, so that a tool can tell whether it added anything.
+func (s *Server) importBook(w http.ResponseWriter, r *http.Request) {
+	request := bookImport{}
+	if !readJSON(w, r, &request) {
+		return
+	}
+
+	cleaned := isbn.Clean(request.ISBN)
+	if !isbn.Valid(cleaned) {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid isbn: %q", request.ISBN))
+		return
+	}
+
+	existing, err := s.findBookByISBN(cleaned)
+	if err != nil {
+		writeDatabaseError(w, err)
+		return
+	}
+	if existing != nil {
+		writeError(w, http.StatusConflict, catalogueConflict("isbn "+cleaned, existing))
+		return
+	}
+
+	book, err := s.lookupISBN(cleaned)
+	if err != nil {
+		writeError(w, lookupStatus(err), err)
+		return
+	}
+
+	// openlibrary may file the isbn under an edition catalogued without it
+	existing, err = s.findBook(book.OLID)
+	if err != nil {
+		writeDatabaseError(w, err)
+		return
+	}
+	if existing != nil {
+		writeError(w, http.StatusConflict, catalogueConflict("isbn "+cleaned, existing))
+		return
+	}
+
+	err = s.database.InsertRecord(*book)
+	if err != nil {
+		writeDatabaseError(w, err)
+		return
+	}
+
+	w.Header().Set("Location", APIPrefix+"books/"+strings.TrimPrefix(book.OLID, "/books/"))
+	s.writeBook(w, http.StatusCreated, book.OLID)
+}
+
+// lookupStatus answers an isbn openlibrary does not know as unprocessable, and any other failure to
+// look it up as a bad gateway.
+func lookupStatus(err error) int {
+	if errors.Is(err, openlibrary.ErrNotFound) {
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusBadGateway
+}
+
+// findBookByISBN looks for a catalogued book under either form of an isbn, returning nil if there
+// is none.
+func (s *Server) findBookByISBN(cleaned string) (*db.Book, error) {
+	forms := []string{cleaned}
+	if isbn13, ok := isbn.To13(cleaned); ok && isbn13 != cleaned {
+		forms = append(forms, isbn13)
+	}
+	if isbn10, ok := isbn.To10(cleaned); ok && isbn10 != cleaned {
+		forms = append(forms, isbn10)
+	}
+
+	return s.findBook(forms...)
+}
+
+// findBook looks for a catalogued book, deaccessioned or not, by any of the refs, returning nil if
+// there is none.
+func (s *Server) findBook(refs ...string) (*db.Book, error) {
+	for _, ref := range refs {
+		book, err := s.database.FindBook(ref)
+		if err == nil {
+			return book, nil
+		}
+		if !errors.Is(err, db.ErrNotFound) {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+// catalogueConflict explains why what was asked to be imported is not, pointing at the book it is
+// already catalogued as.
+func catalogueConflict(what string, existing *db.Book) error {
+	if existing.DeaccessionedOn != nil {
+		return fmt.Errorf("%s was catalogued as %s, which was deaccessioned on %s; restore it instead", what, existing.OLID, existing.DeaccessionedOn.Format("2006-01-02"))
+	}
+	return fmt.Errorf("%s is already catalogued as %s", what, existing.OLID)
+}
+
+// writeBook answers with a book and everything about it.
+func (s *Server) writeBook(w http.ResponseWriter, status int, olid string) {
+	resource, err := s.readBookResource(olid)
+	if err != nil {
+		writeDatabaseError(w, err)
+		return
+	}
+	writeJSON(w, status, resource)
+}
+
+// readBookResource reads a book and everything about it.
+func (s *Server) readBookResource(olid string) (*BookResource, error) {
+	books, err := s.database.FindBooks(db.BookFilter{OLID: olid, IncludeDeaccessioned: true})
+	if err != nil {
+		return nil, err
+	}
+	if len(books) != 1 {
+		return nil, fmt.Errorf("db: no book matching %q: %w", olid, db.ErrNotFound)
+	}
+
+	locationPaths, err := s.database.LocationPaths()
+	if err != nil {
+		return nil, err
+	}
+
+	resource := bookResource(books[0], locationPaths)
+	return &resource, nil
+}
+
+func bookResource(book db.Book, locationPaths map[int64]string) BookResource {
+	resource := BookResource{
+		OLID:            book.OLID,
+		Title:           book.Title,
+		Authors:         []CreditResource{},
+		ISBN13:          splitList(book.ISBN13, openlibrary.ISBNSeparator),
+		ISBN10:          splitList(book.ISBN10, openlibrary.ISBNSeparator),
+		CallNumber:      book.ShelfMark(),
+		Languages:       splitList(book.Languages, openlibrary.LanguageSeparator),
+		Subjects:        []string{},
+		Tags:            []string{},
+		Copies:          []CopyResource{},
+		DeaccessionedOn: book.DeaccessionedOn,
+		CreatedAt:       book.CreatedAt,
+		UpdatedAt:       book.UpdatedAt,
+	}
+
+	if book.PublishDate != nil {
+		resource.PublishDate = *book.PublishDate
+	}
+	if book.LocationID != nil {
+		resource.Location = locationPaths[*book.LocationID]
+	}
+	for _, credit := range book.Credits {
+		resource.Authors = append(resource.Authors, CreditResource{OLID: credit.Author.OLID, Name: credit.Author.Name, Role: credit.Role})
+	}
+	for _, subject := range book.Subjects {
+		resource.Subjects = append(resource.Subjects, subject.Name)
+	}
+	for _, tag := range book.Tags {
+		resource.Tags = append(resource.Tags, tag.Name)
+	}
+	for _, bookCopy := range book.Copies {
+		copyResource := CopyResource{Accession: bookCopy.Accession, Status: bookCopy.Status}
+		if bookCopy.LocationID != nil {
+			copyResource.Location = locationPaths[*bookCopy.LocationID]
+		}
+		resource.Copies = append(resource.Copies, copyResource)
+	}
+	return resource
+}
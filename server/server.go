@@ -0,0 +1,221 @@
+// Package server exposes the catalogue over HTTP as a JSON API, for tools which would otherwise
+// shell out to the command line, and optionally as web pages for people who would rather not use it.
+package server
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/arudzitis/addlib/db"
+	"github.com/arudzitis/addlib/openlibrary"
+)
+
+// APIPrefix is where the JSON API is served.
+const APIPrefix = "/api/"
+
+const (
+	defaultPerPage = 50
+	maxPerPage     = 500
+)
+
+//go:embed openapi.json
+var openAPIDocument []byte
+
+// Options configure a server.
+type Options struct {
+	// LookupISBN finds the openlibrary record of a book being imported. It defaults to
+	// openlibrary.LookupByISBN, and is replaced in tests.
+	LookupISBN func(isbn string) (*openlibrary.Book, error)
+
+	// UI serves a user interface for browsing and editing the catalogue alongside the API.
+	UI bool
+}
+
+// Server serves the catalogue in a database.
+type Server struct {
+	database   *db.DB
+	lookupISBN func(isbn string) (*openlibrary.Book, error)
+	mux        *http.ServeMux
+}
+
+// New creates a server for a database.
+func New(database *db.DB, options Options) *Server {
+	s := &Server{
+		database:   database,
+		lookupISBN: options.LookupISBN,
+		mux:        http.NewServeMux(),
+	}
+	if s.lookupISBN == nil {
+		s.lookupISBN = openlibrary.LookupByISBN
+	}
+
+	s.mux.HandleFunc(APIPrefix+"books", s.handleBooks)
+	s.mux.HandleFunc(APIPrefix+"books/", s.handleBook)
+	s.mux.HandleFunc(APIPrefix+"authors", s.handleAuthors)
+	s.mux.HandleFunc(APIPrefix+"authors/", s.handleAuthor)
+	s.mux.HandleFunc(APIPrefix+"search", s.handleSearch)
+	s.mux.HandleFunc(APIPrefix+"openapi.json", s.handleOpenAPI)
+	s.mux.HandleFunc(APIPrefix, func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no such endpoint: %s", r.URL.Path))
+	})
+
+	if options.UI {
+		s.registerUI()
+	}
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(openAPIDocument)
+}
+
+// errorResponse is the body of every unsuccessful response.
+type errorResponse struct {
+	Status int    `json:"status"`
+	Error  string `json:"error"`
+}
+
+// pageResponse is the body of every listing.
+type pageResponse struct {
+	Items   interface{} `json:"items"`
+	Page    int         `json:"page"`
+	PerPage int         `json:"per_page"`
+	Total   int         `json:"total"`
+}
+
+// pagination is the part of a listing asked for with the page and per_page parameters.
+type pagination struct {
+	Page    int
+	PerPage int
+}
+
+// readPagination reads the page and per_page parameters. Pages are numbered from 1.
+func readPagination(query url.Values) (pagination, error) {
+	p := pagination{Page: 1, PerPage: defaultPerPage}
+	for _, param := range []struct {
+		name  string
+		value *int
+		max   int
+	}{
+		{"page", &p.Page, 0},
+		{"per_page", &p.PerPage, maxPerPage},
+	} {
+		text := query.Get(param.name)
+		if text == "" {
+			continue
+		}
+		value, err := strconv.Atoi(text)
+		if err != nil || value < 1 || (param.max > 0 && value > param.max) {
+			return p, fmt.Errorf("invalid %s: %q", param.name, text)
+		}
+		*param.value = value
+	}
+	return p, nil
+}
+
+// offset is the number of items on the pages before this one.
+func (p pagination) offset() int {
+	return (p.Page - 1) * p.PerPage
+}
+
+// allowMethods reports whether the request uses one of the methods, answering it with an error if
+// not.
+func allowMethods(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, method := range methods {
+		if r.Method == method {
+			return true
+		}
+	}
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+	return false
+}
+
+// readJSON decodes a json request body, reporting whether it could, and answering the request with
+// an error if not. Fields the endpoint does not know are rejected so that typos are not silently
+// ignored.
+func readJSON(w http.ResponseWriter, r *http.Request, value interface{}) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/json" {
+		writeError(w, http.StatusUnsupportedMediaType, fmt.Errorf("request body must be application/json, not %q", r.Header.Get("Content-Type")))
+		return false
+	}
+
+	decoder := json.NewDecoder(io.LimitReader(r.Body, 1<<20))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(value)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(value)
+	if err != nil {
+		log.Printf("error writing response: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorResponse{Status: status, Error: err.Error()})
+}
+
+// writeDatabaseError answers with a 404 for records which do not exist, and a 500 otherwise.
+func writeDatabaseError(w http.ResponseWriter, err error) {
+	if errors.Is(err, db.ErrNotFound) {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	log.Printf("error serving request: %v", err)
+	writeError(w, http.StatusInternalServerError, err)
+}
+
+// resourceRef is the part of the path after an endpoint's prefix, such as "OL1M" in
+// "/api/books/OL1M".
+func resourceRef(r *http.Request, prefix string) string {
+	return strings.Trim(strings.TrimPrefix(r.URL.Path, prefix), "/")
+}
+
+// trimmed trims the spaces around an optional field of a request body.
+func trimmed(value *string) *string {
+	if value == nil {
+		return nil
+	}
+	text := strings.TrimSpace(*value)
+	return &text
+}
+
+// splitList splits one of the columns holding several values, such as isbn13, into its values.
+func splitList(value *string, separator string) []string {
+	values := []string{}
+	if value == nil || *value == "" {
+		return values
+	}
+	for _, item := range strings.Split(*value, separator) {
+		if item = strings.TrimSpace(item); item != "" {
+			values = append(values, item)
+		}
+	}
+	return values
+}
@@ -0,0 +1,295 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/arudzitis/addlib/db"
+	"github.com/arudzitis/addlib/openlibrary"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const unavailableISBN = "9780000000002"
+
+var (
+	tolkien = openlibrary.Author{OLID: "/authors/OL1A", Name: "J. R. R. Tolkien", BirthDate: "3 January 1892"}
+	lewis   = openlibrary.Author{OLID: "/authors/OL2A", Name: "C. S. Lewis"}
+
+	hobbit = openlibrary.Book{OLID: "/books/OL1M", Title: "The Hobbit", Isbn13: []string{"9780261102217"}, Authors: []openlibrary.Author{tolkien}, Subjects: []string{"Fantasy"}}
+	rings  = openlibrary.Book{OLID: "/books/OL2M", Title: "The Fellowship of the Ring", Authors: []openlibrary.Author{tolkien}}
+	narnia = openlibrary.Book{OLID: "/books/OL3M", Title: "The Lion, the Witch and the Wardrobe", Authors: []openlibrary.Author{lewis}}
+)
+
+// newTestServer serves a catalogue holding three books, with its user interface, importing isbns
+// from records. Openlibrary is unavailable for the isbn unavailableISBN.
+func newTestServer(t *testing.T, records map[string]openlibrary.Book) (*httptest.Server, *db.DB) {
+	t.Helper()
+
+	database, err := db.OpenDatabase(filepath.Join(t.TempDir(), "test.sqlite3"), false)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = database.Close() })
+	require.NoError(t, database.Migrate())
+
+	for _, book := range []openlibrary.Book{hobbit, rings, narnia} {
+		require.NoError(t, database.InsertRecord(book))
+	}
+	_, err = database.TagBooks("favourite", []openlibrary.Book{hobbit, narnia})
+	require.NoError(t, err)
+	_, err = database.AddCopy(hobbit, db.Copy{Accession: "A1"})
+	require.NoError(t, err)
+
+	lookup := func(isbn string) (*openlibrary.Book, error) {
+		if isbn == unavailableISBN {
+			return nil, errors.New("openlibrary: non 200 stats while looking up isbn")
+		}
+		book, ok := records[isbn]
+		if !ok {
+			return nil, fmt.Errorf("%w while looking up isbn: /isbn/%s", openlibrary.ErrNotFound, isbn)
+		}
+		return &book, nil
+	}
+
+	server := httptest.NewServer(New(database, Options{LookupISBN: lookup, UI: true}))
+	t.Cleanup(server.Close)
+	return server, database
+}
+
+// request makes a request, with a json body if there is one, decoding the response into result and
+// returning its status.
+func request(t *testing.T, method string, url string, body string, result interface{}) int {
+	t.Helper()
+
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	require.NoError(t, err)
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	response, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer func() { _ = response.Body.Close() }()
+
+	assert.Equal(t, "application/json", response.Header.Get("Content-Type"))
+	if result != nil {
+		require.NoError(t, json.NewDecoder(response.Body).Decode(result))
+	}
+	return response.StatusCode
+}
+
+type bookPage struct {
+	Items   []BookResource `json:"items"`
+	Page    int            `json:"page"`
+	PerPage int            `json:"per_page"`
+	Total   int            `json:"total"`
+}
+
+func titles(books []BookResource) []string {
+	titles := []string{}
+	for _, book := range books {
+		titles = append(titles, book.Title)
+	}
+	return titles
+}
+
+func TestListBooks(t *testing.T) {
+	server, _ := newTestServer(t, nil)
+
+	page := bookPage{}
+	require.Equal(t, http.StatusOK, request(t, http.MethodGet, server.URL+"/api/books", "", &page))
+	assert.Equal(t, []string{hobbit.Title, rings.Title, narnia.Title}, titles(page.Items))
+	assert.Equal(t, 3, page.Total)
+	assert.Equal(t, 1, page.Page)
+	assert.Equal(t, defaultPerPage, page.PerPage)
+
+	book := page.Items[0]
+	assert.Equal(t, []CreditResource{{OLID: tolkien.OLID, Name: tolkien.Name}}, book.Authors)
+	assert.Equal(t, []string{"9780261102217"}, book.ISBN13)
+	assert.Equal(t, []string{}, book.ISBN10)
+	assert.Equal(t, []string{"Fantasy"}, book.Subjects)
+	assert.Equal(t, []string{"favourite"}, book.Tags)
+	require.Len(t, book.Copies, 1)
+	assert.Equal(t, "A1", book.Copies[0].Accession)
+
+	page = bookPage{}
+	require.Equal(t, http.StatusOK, request(t, http.MethodGet, server.URL+"/api/books?page=2&per_page=2", "", &page))
+	assert.Equal(t, []string{narnia.Title}, titles(page.Items))
+	assert.Equal(t, 3, page.Total)
+
+	page = bookPage{}
+	require.Equal(t, http.StatusOK, request(t, http.MethodGet, server.URL+"/api/books?page=5", "", &page))
+	assert.Empty(t, page.Items)
+
+	page = bookPage{}
+	require.Equal(t, http.StatusOK, request(t, http.MethodGet, server.URL+"/api/books?tag=favourite&author=OL1A", "", &page))
+	assert.Equal(t, []string{hobbit.Title}, titles(page.Items))
+
+	for _, query := range []string{"page=0", "per_page=501", "page=two", "author=OL9A", "location=Nowhere"} {
+		t.Run(query, func(t *testing.T) {
+			result := errorResponse{}
+			assert.Equal(t, http.StatusBadRequest, request(t, http.MethodGet, server.URL+"/api/books?"+query, "", &result))
+			assert.Equal(t, http.StatusBadRequest, result.Status)
+			assert.NotEmpty(t, result.Error)
+		})
+	}
+}
+
+func TestSearch(t *testing.T) {
+	server, _ := newTestServer(t, nil)
+
+	page := bookPage{}
+	require.Equal(t, http.StatusOK, request(t, http.MethodGet, server.URL+"/api/search?q=lewis", "", &page))
+	assert.Equal(t, []string{narnia.Title}, titles(page.Items))
+
+	page = bookPage{}
+	require.Equal(t, http.StatusOK, request(t, http.MethodGet, server.URL+"/api/search?q=ring", "", &page))
+	assert.Equal(t, []string{rings.Title}, titles(page.Items))
+
+	result := errorResponse{}
+	assert.Equal(t, http.StatusBadRequest, request(t, http.MethodGet, server.URL+"/api/search", "", &result))
+}
+
+func TestGetBook(t *testing.T) {
+	server, _ := newTestServer(t, nil)
+
+	for _, ref := range []string{"OL1M", "9780261102217"} {
+		book := BookResource{}
+		require.Equal(t, http.StatusOK, request(t, http.MethodGet, server.URL+"/api/books/"+ref, "", &book))
+		assert.Equal(t, hobbit.Title, book.Title)
+	}
+
+	result := errorResponse{}
+	assert.Equal(t, http.StatusNotFound, request(t, http.MethodGet, server.URL+"/api/books/OL9M", "", &result))
+	assert.Equal(t, errorResponse{Status: http.StatusNotFound, Error: `db: no book matching "OL9M": db: record not found`}, result)
+	assert.Equal(t, http.StatusNotFound, request(t, http.MethodGet, server.URL+"/api/books/%25", "", &result), "wildcards only match themselves")
+
+	result = errorResponse{}
+	assert.Equal(t, http.StatusMethodNotAllowed, request(t, http.MethodDelete, server.URL+"/api/books/OL1M", "", &result))
+
+	result = errorResponse{}
+	assert.Equal(t, http.StatusNotFound, request(t, http.MethodGet, server.URL+"/api/shelves", "", &result))
+}
+
+func TestUpdateBook(t *testing.T) {
+	server, database := newTestServer(t, nil)
+
+	book := BookResource{}
+	require.Equal(t, http.StatusOK, request(t, http.MethodPatch, server.URL+"/api/books/OL2M", `{"title": "The Fellowship of the Ring: Being the First Part", "call_number": "823.912 TOL"}`, &book))
+	assert.Equal(t, "The Fellowship of the Ring: Being the First Part", book.Title)
+	assert.Equal(t, "823.912 TOL", book.CallNumber)
+
+	changes, err := database.History(rings.OLID)
+	require.NoError(t, err)
+	assert.NotEmpty(t, changes)
+
+	for _, body := range []string{`{"title": " "}`, `{"titel": "Typo"}`, `not json`} {
+		result := errorResponse{}
+		assert.Equal(t, http.StatusBadRequest, request(t, http.MethodPatch, server.URL+"/api/books/OL2M", body, &result), body)
+	}
+
+	for _, contentType := range []string{"", "text/plain", "application/x-www-form-urlencoded"} {
+		req, err := http.NewRequest(http.MethodPatch, server.URL+"/api/books/OL2M", strings.NewReader(`{"title": "Form"}`))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", contentType)
+		response, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		_ = response.Body.Close()
+		assert.Equal(t, http.StatusUnsupportedMediaType, response.StatusCode, contentType)
+	}
+}
+
+func TestImportBook(t *testing.T) {
+	silmarillion := openlibrary.Book{OLID: "/books/OL4M", Title: "The Silmarillion", Isbn13: []string{"9780261102736"}, Authors: []openlibrary.Author{tolkien}}
+	// openlibrary files these isbns under books catalogued without them
+	server, database := newTestServer(t, map[string]openlibrary.Book{"9780261102736": silmarillion, "9780261102354": rings, "9780006716631": narnia})
+	_, err := database.DeaccessionBook(narnia, db.Deaccession{Reason: db.DeaccessionLost})
+	require.NoError(t, err)
+
+	req, err := http.NewRequest(http.MethodPost, server.URL+"/api/books", strings.NewReader(`{"isbn": "978-0-261-10273-6"}`))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	response, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer func() { _ = response.Body.Close() }()
+	assert.Equal(t, http.StatusCreated, response.StatusCode)
+	assert.Equal(t, "/api/books/OL4M", response.Header.Get("Location"))
+	book := BookResource{}
+	require.NoError(t, json.NewDecoder(response.Body).Decode(&book))
+	assert.Equal(t, silmarillion.Title, book.Title)
+
+	for _, test := range []struct {
+		isbn   string
+		status int
+	}{
+		{"9780261102736", http.StatusConflict},
+		// the ten digit form of the hobbit's isbn
+		{"0261102214", http.StatusConflict},
+		{"9780261102354", http.StatusConflict},
+		{"9780261102737", http.StatusBadRequest},
+		{"9780000000019", http.StatusUnprocessableEntity},
+		{unavailableISBN, http.StatusBadGateway},
+	} {
+		result := errorResponse{}
+		assert.Equal(t, test.status, request(t, http.MethodPost, server.URL+"/api/books", fmt.Sprintf(`{"isbn": %q}`, test.isbn), &result), test.isbn)
+		assert.Equal(t, test.status, result.Status)
+	}
+
+	result := errorResponse{}
+	assert.Equal(t, http.StatusConflict, request(t, http.MethodPost, server.URL+"/api/books", `{"isbn": "9780006716631"}`, &result))
+	assert.Contains(t, result.Error, "deaccessioned")
+}
+
+func TestAuthors(t *testing.T) {
+	server, _ := newTestServer(t, nil)
+
+	page := struct {
+		Items []AuthorSummaryResource `json:"items"`
+		Total int                     `json:"total"`
+	}{}
+	require.Equal(t, http.StatusOK, request(t, http.MethodGet, server.URL+"/api/authors", "", &page))
+	require.Len(t, page.Items, 2)
+	assert.Equal(t, "C. S. Lewis", page.Items[0].Name)
+	assert.Equal(t, int64(1), page.Items[0].Books)
+	assert.Equal(t, "Tolkien, J. R. R.", page.Items[1].SortName)
+	assert.Equal(t, int64(2), page.Items[1].Books)
+
+	require.Equal(t, http.StatusOK, request(t, http.MethodGet, server.URL+"/api/authors?q=tolk", "", &page))
+	assert.Equal(t, 1, page.Total)
+
+	author := AuthorDetailResource{}
+	require.Equal(t, http.StatusOK, request(t, http.MethodGet, server.URL+"/api/authors/OL1A", "", &author))
+	assert.Equal(t, "3 January 1892", author.BirthDate)
+	assert.Equal(t, []string{hobbit.Title, rings.Title}, titles(author.Books))
+
+	author = AuthorDetailResource{}
+	require.Equal(t, http.StatusOK, request(t, http.MethodPatch, server.URL+"/api/authors/OL1A", `{"name": "John Ronald Reuel Tolkien", "sort_name": "Tolkien, J. R. R."}`, &author))
+	assert.Equal(t, "John Ronald Reuel Tolkien", author.Name)
+	assert.Equal(t, "Tolkien, J. R. R.", author.SortName)
+
+	result := errorResponse{}
+	assert.Equal(t, http.StatusNotFound, request(t, http.MethodGet, server.URL+"/api/authors/OL9A", "", &result))
+}
+
+func TestOpenAPIDocument(t *testing.T) {
+	server, _ := newTestServer(t, nil)
+
+	document := struct {
+		OpenAPI string                     `json:"openapi"`
+		Paths   map[string]json.RawMessage `json:"paths"`
+	}{}
+	require.Equal(t, http.StatusOK, request(t, http.MethodGet, server.URL+"/api/openapi.json", "", &document))
+	assert.Equal(t, "3.0.3", document.OpenAPI)
+
+	paths := []string{}
+	for path := range document.Paths {
+		paths = append(paths, path)
+	}
+	assert.ElementsMatch(t, []string{
+		"/api/books", "/api/books/{ref}", "/api/search", "/api/authors", "/api/authors/{ref}", "/api/openapi.json",
+	}, paths)
+}
@@ -0,0 +1,503 @@
+package server
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/arudzitis/addlib/db"
+	"github.com/arudzitis/addlib/isbn"
+	"github.com/arudzitis/addlib/openlibrary"
+)
+
+//go:embed ui
+var uiFiles embed.FS
+
+const uiLayout = "layout.html"
+
+var uiPages = []string{"books.html", "book.html", "authors.html", "author.html", "subjects.html", "add.html", "error.html"}
+
+var uiFuncs = template.FuncMap{
+	"join":        strings.Join,
+	"bookPath":    bookPagePath,
+	"authorPath":  authorPagePath,
+	"subjectPath": subjectPagePath,
+}
+
+func bookPagePath(olid string) string {
+	return "/books/" + strings.TrimPrefix(olid, "/books/")
+}
+
+// authorPagePath escapes the key, as authors known only by name have keys such as "local:le-guin".
+func authorPagePath(olid string) string {
+	return "/authors/" + url.PathEscape(strings.TrimPrefix(olid, "/authors/"))
+}
+
+func subjectPagePath(name string) string {
+	return "/subjects?" + url.Values{"name": {name}}.Encode()
+}
+
+// uiTemplates holds each page parsed along with the layout it is rendered in.
+var uiTemplates = parseUITemplates()
+
+func parseUITemplates() map[string]*template.Template {
+	templates := map[string]*template.Template{}
+	for _, name := range uiPages {
+		templates[name] = template.Must(template.New(uiLayout).Funcs(uiFuncs).Option("missingkey=error").
+			ParseFS(uiFiles, "ui/"+uiLayout, "ui/"+name))
+	}
+	return templates
+}
+
+// uiPage is what the user interface templates are rendered with. Each page uses the fields it needs.
+type uiPage struct {
+	Title  string
+	Notice string
+	Error  string
+
+	Query string
+	Pager *uiPager
+
+	Books   []BookResource
+	Book    *BookResource
+	Authors []AuthorSummaryResource
+	Author  *AuthorDetailResource
+	// SortNameOverride is the sort name set for the author, or empty if it is derived from their name.
+	SortNameOverride string
+	Subjects         []db.SubjectCount
+
+	// ISBN, Preview and Existing are for adding books: the isbn entered, its openlibrary record, and
+	// the book it is already catalogued as.
+	ISBN     string
+	Preview  *openlibrary.Book
+	Existing string
+}
+
+// uiPager links to the pages either side of a listing's page.
+type uiPager struct {
+	Page     int
+	Pages    int
+	Total    int
+	Previous string
+	Next     string
+}
+
+// newPager describes the page of a listing of total items, linking to its neighbours by changing
+// the page parameter of the request.
+func newPager(r *http.Request, page pagination, total int) *uiPager {
+	pager := &uiPager{Page: page.Page, Pages: (total + page.PerPage - 1) / page.PerPage, Total: total}
+	link := func(number int) string {
+		query := r.URL.Query()
+		query.Set("page", fmt.Sprint(number))
+		return r.URL.Path + "?" + query.Encode()
+	}
+	if page.Page > 1 {
+		pager.Previous = link(page.Page - 1)
+	}
+	if page.Page < pager.Pages {
+		pager.Next = link(page.Page + 1)
+	}
+	return pager
+}
+
+// registerUI serves the pages of the user interface alongside the API.
+func (s *Server) registerUI() {
+	static, err := fs.Sub(uiFiles, "ui")
+	if err != nil {
+		panic(err)
+	}
+	s.mux.Handle("/static/", http.FileServer(http.FS(static)))
+
+	s.mux.HandleFunc("/", s.handleHome)
+	s.mux.HandleFunc("/books/", s.handleBookPage)
+	s.mux.HandleFunc("/authors", s.handleAuthorsPage)
+	s.mux.HandleFunc("/authors/", s.handleAuthorPage)
+	s.mux.HandleFunc("/subjects", s.handleSubjectsPage)
+	s.mux.HandleFunc("/add", s.handleAddPage)
+}
+
+func renderPage(w http.ResponseWriter, status int, name string, page uiPage) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	err := uiTemplates[name].ExecuteTemplate(w, uiLayout, page)
+	if err != nil {
+		log.Printf("error rendering %s: %v", name, err)
+	}
+}
+
+func renderError(w http.ResponseWriter, status int, err error) {
+	if status == http.StatusInternalServerError {
+		log.Printf("error serving page: %v", err)
+	}
+	renderPage(w, status, "error.html", uiPage{Title: http.StatusText(status), Error: err.Error()})
+}
+
+// renderDatabaseError shows a not found page for records which do not exist, and a server error
+// otherwise.
+func renderDatabaseError(w http.ResponseWriter, err error) {
+	if errors.Is(err, db.ErrNotFound) {
+		renderError(w, http.StatusNotFound, err)
+		return
+	}
+	renderError(w, http.StatusInternalServerError, err)
+}
+
+// acceptForm reports whether a request may be served, answering it if not. Forms may only be posted
+// from the interface itself, so that other sites cannot make changes on a visitor's behalf.
+func acceptForm(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead && r.Method != http.MethodPost {
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		renderError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return false
+	}
+	if r.Method != http.MethodPost {
+		return true
+	}
+
+	if origin := r.Header.Get("Origin"); origin != "" {
+		parsed, err := url.Parse(origin)
+		if err != nil || parsed.Host != r.Host {
+			renderError(w, http.StatusForbidden, fmt.Errorf("form posted from another site: %s", origin))
+			return false
+		}
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		renderError(w, http.StatusBadRequest, err)
+		return false
+	}
+	return true
+}
+
+// listBooksPage renders a page of the books matching a filter.
+func (s *Server) listBooksPage(w http.ResponseWriter, r *http.Request, filter db.BookFilter, page uiPage) {
+	pagination, err := readPagination(r.URL.Query())
+	if err != nil {
+		renderError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	total, err := s.database.CountBooks(filter)
+	if err != nil {
+		renderDatabaseError(w, err)
+		return
+	}
+
+	filter.Limit, filter.Offset = pagination.PerPage, pagination.offset()
+	books, err := s.database.FindBooks(filter)
+	if err != nil {
+		renderDatabaseError(w, err)
+		return
+	}
+
+	locationPaths, err := s.database.LocationPaths()
+	if err != nil {
+		renderDatabaseError(w, err)
+		return
+	}
+
+	for _, book := range books {
+		page.Books = append(page.Books, bookResource(book, locationPaths))
+	}
+	page.Pager = newPager(r, pagination, int(total))
+	renderPage(w, http.StatusOK, "books.html", page)
+}
+
+func (s *Server) handleHome(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		renderError(w, http.StatusNotFound, fmt.Errorf("no such page: %s", r.URL.Path))
+		return
+	}
+	if !acceptForm(w, r) {
+		return
+	}
+
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	page := uiPage{Title: "Books", Query: query}
+	if query != "" {
+		page.Title = fmt.Sprintf("Books matching %q", query)
+	}
+	s.listBooksPage(w, r, db.BookFilter{Query: query}, page)
+}
+
+func (s *Server) handleBookPage(w http.ResponseWriter, r *http.Request) {
+	if !acceptForm(w, r) {
+		return
+	}
+
+	book, err := s.database.FindBook(resourceRef(r, "/books/"))
+	if err != nil {
+		renderDatabaseError(w, err)
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		s.updateBookFromForm(w, r, book.OLID)
+		return
+	}
+
+	resource, err := s.readBookResource(book.OLID)
+	if err != nil {
+		renderDatabaseError(w, err)
+		return
+	}
+
+	page := uiPage{Title: resource.Title, Book: resource}
+	switch {
+	case r.URL.Query().Get("added") != "":
+		page.Notice = "Added to the catalogue."
+	case r.URL.Query().Get("saved") != "":
+		page.Notice = "Saved."
+	}
+	renderPage(w, http.StatusOK, "book.html", page)
+}
+
+// updateBookFromForm saves the fields of the book form which were changed, so that saving a new
+// title does not also turn the classification shown into a local call number.
+func (s *Server) updateBookFromForm(w http.ResponseWriter, r *http.Request, olid string) {
+	current, err := s.readBookResource(olid)
+	if err != nil {
+		renderDatabaseError(w, err)
+		return
+	}
+
+	title := strings.TrimSpace(r.PostForm.Get("title"))
+	callNumber := strings.TrimSpace(r.PostForm.Get("call_number"))
+	if title == "" {
+		renderPage(w, http.StatusBadRequest, "book.html", uiPage{Title: current.Title, Book: current, Error: "The title cannot be empty."})
+		return
+	}
+
+	changes := db.BookChanges{}
+	if title != current.Title {
+		changes.Title = &title
+	}
+	if callNumber != current.CallNumber {
+		changes.CallNumber = &callNumber
+	}
+	err = s.database.UpdateBook(openlibrary.Book{OLID: olid}, changes)
+	if err != nil {
+		renderDatabaseError(w, err)
+		return
+	}
+
+	http.Redirect(w, r, bookPagePath(olid)+"?saved=1", http.StatusSeeOther)
+}
+
+func (s *Server) handleAuthorsPage(w http.ResponseWriter, r *http.Request) {
+	if !acceptForm(w, r) {
+		return
+	}
+
+	pagination, err := readPagination(r.URL.Query())
+	if err != nil {
+		renderError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	filter := db.AuthorFilter{Query: query, Held: true}
+	total, err := s.database.CountAuthors(filter)
+	if err != nil {
+		renderDatabaseError(w, err)
+		return
+	}
+
+	filter.Limit, filter.Offset = pagination.PerPage, pagination.offset()
+	summaries, err := s.database.FindAuthors(filter)
+	if err != nil {
+		renderDatabaseError(w, err)
+		return
+	}
+
+	page := uiPage{Title: "Authors", Query: query}
+	for _, summary := range summaries {
+		page.Authors = append(page.Authors, AuthorSummaryResource{AuthorResource: authorResource(summary.Author), Books: summary.Books})
+	}
+	page.Pager = newPager(r, pagination, int(total))
+	renderPage(w, http.StatusOK, "authors.html", page)
+}
+
+func (s *Server) handleAuthorPage(w http.ResponseWriter, r *http.Request) {
+	if !acceptForm(w, r) {
+		return
+	}
+
+	author, err := s.database.FindAuthor(resourceRef(r, "/authors/"))
+	if err != nil {
+		renderDatabaseError(w, err)
+		return
+	}
+
+	page := uiPage{}
+	if r.Method == http.MethodPost {
+		page.Error = s.updateAuthorFromForm(w, r, *author)
+		if page.Error == "" {
+			return
+		}
+	}
+	if r.URL.Query().Get("saved") != "" {
+		page.Notice = "Saved."
+	}
+
+	books, err := s.database.FindBooks(db.BookFilter{AuthorID: &author.ID})
+	if err != nil {
+		renderDatabaseError(w, err)
+		return
+	}
+
+	locationPaths, err := s.database.LocationPaths()
+	if err != nil {
+		renderDatabaseError(w, err)
+		return
+	}
+
+	page.Title = author.Name
+	page.SortNameOverride = sortNameOverride(*author)
+	page.Author = &AuthorDetailResource{AuthorResource: authorResource(*author), Books: []BookResource{}}
+	for _, book := range books {
+		page.Author.Books = append(page.Author.Books, bookResource(book, locationPaths))
+	}
+
+	status := http.StatusOK
+	if page.Error != "" {
+		status = http.StatusBadRequest
+	}
+	renderPage(w, status, "author.html", page)
+}
+
+// updateAuthorFromForm saves the fields of the author form which were changed, redirecting back to
+// the author. If the form is invalid it returns why, without responding, so that the page can be
+// shown again with the reason; otherwise it returns "" once it has responded.
+func (s *Server) updateAuthorFromForm(w http.ResponseWriter, r *http.Request, author db.Author) string {
+	name := strings.TrimSpace(r.PostForm.Get("name"))
+	if name == "" {
+		return "The name cannot be empty."
+	}
+
+	changes := db.AuthorChanges{}
+	if name != author.Name {
+		changes.Name = &name
+	}
+	if sortName := strings.TrimSpace(r.PostForm.Get("sort_name")); sortName != sortNameOverride(author) {
+		changes.SortName = &sortName
+	}
+	err := s.database.UpdateAuthor(author.OLID, changes)
+	if err != nil {
+		renderDatabaseError(w, err)
+		return ""
+	}
+
+	http.Redirect(w, r, authorPagePath(author.OLID)+"?saved=1", http.StatusSeeOther)
+	return ""
+}
+
+func sortNameOverride(author db.Author) string {
+	if author.SortNameOverride == nil {
+		return ""
+	}
+	return *author.SortNameOverride
+}
+
+// handleSubjectsPage lists the subjects, or with a name the books with that subject. Subjects are
+// named in the query rather than the path as many, such as "Fiction / Fantasy", contain slashes.
+func (s *Server) handleSubjectsPage(w http.ResponseWriter, r *http.Request) {
+	if !acceptForm(w, r) {
+		return
+	}
+
+	if name := r.URL.Query().Get("name"); name != "" {
+		s.listBooksPage(w, r, db.BookFilter{Subject: name}, uiPage{Title: name})
+		return
+	}
+
+	subjects, err := s.database.Subjects()
+	if err != nil {
+		renderDatabaseError(w, err)
+		return
+	}
+	renderPage(w, http.StatusOK, "subjects.html", uiPage{Title: "Subjects", Subjects: subjects})
+}
+
+// handleAddPage adds a book by isbn in two steps: posting an isbn with the "preview" action shows
+// what openlibrary has for it, and posting it again with the "add" action saves it.
+func (s *Server) handleAddPage(w http.ResponseWriter, r *http.Request) {
+	if !acceptForm(w, r) {
+		return
+	}
+
+	page := uiPage{Title: "Add a book"}
+	if r.Method != http.MethodPost {
+		renderPage(w, http.StatusOK, "add.html", page)
+		return
+	}
+
+	page.ISBN = strings.TrimSpace(r.PostForm.Get("isbn"))
+	cleaned := isbn.Clean(page.ISBN)
+	if !isbn.Valid(cleaned) {
+		page.Error = fmt.Sprintf("%q is not a valid isbn.", page.ISBN)
+		renderPage(w, http.StatusBadRequest, "add.html", page)
+		return
+	}
+
+	existing, err := s.findBookByISBN(cleaned)
+	if err != nil {
+		renderDatabaseError(w, err)
+		return
+	}
+	if existing != nil {
+		renderConflict(w, page, cleaned, existing)
+		return
+	}
+
+	book, err := s.lookupISBN(cleaned)
+	if err != nil {
+		page.Error = fmt.Sprintf("Could not look up %s on openlibrary: %v", cleaned, err)
+		if errors.Is(err, openlibrary.ErrNotFound) {
+			page.Error = fmt.Sprintf("openlibrary has no record of %s.", cleaned)
+		}
+		renderPage(w, lookupStatus(err), "add.html", page)
+		return
+	}
+
+	existing, err = s.findBook(book.OLID)
+	if err != nil {
+		renderDatabaseError(w, err)
+		return
+	}
+	if existing != nil {
+		renderConflict(w, page, cleaned, existing)
+		return
+	}
+
+	if r.PostForm.Get("action") != "add" {
+		page.ISBN = cleaned
+		page.Preview = book
+		renderPage(w, http.StatusOK, "add.html", page)
+		return
+	}
+
+	err = s.database.InsertRecord(*book)
+	if err != nil {
+		renderDatabaseError(w, err)
+		return
+	}
+	http.Redirect(w, r, bookPagePath(book.OLID)+"?added=1", http.StatusSeeOther)
+}
+
+// renderConflict answers an attempt to add a book which is already catalogued, pointing at it.
+func renderConflict(w http.ResponseWriter, page uiPage, cleaned string, existing *db.Book) {
+	page.Error = fmt.Sprintf("%s is already catalogued.", cleaned)
+	if existing.DeaccessionedOn != nil {
+		page.Error = fmt.Sprintf("%s was catalogued, but deaccessioned on %s; restore it rather than adding it again.", cleaned, existing.DeaccessionedOn.Format("2006-01-02"))
+	}
+	page.Existing = existing.OLID
+	renderPage(w, http.StatusConflict, "add.html", page)
+}
@@ -0,0 +1,177 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/arudzitis/addlib/openlibrary"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// noRedirects is a client which returns redirects rather than following them, so they can be
+// checked.
+var noRedirects = &http.Client{
+	CheckRedirect: func(req *http.Request, via []*http.Request) error { return http.ErrUseLastResponse },
+}
+
+// getPage fetches a page, returning its status and body.
+func getPage(t *testing.T, url string) (int, string) {
+	t.Helper()
+
+	response, err := noRedirects.Get(url)
+	require.NoError(t, err)
+	defer func() { _ = response.Body.Close() }()
+
+	body, err := io.ReadAll(response.Body)
+	require.NoError(t, err)
+	return response.StatusCode, string(body)
+}
+
+// postForm posts a form, returning the status, the page redirected to and the body.
+func postForm(t *testing.T, url string, form url.Values) (int, string, string) {
+	t.Helper()
+
+	response, err := noRedirects.PostForm(url, form)
+	require.NoError(t, err)
+	defer func() { _ = response.Body.Close() }()
+
+	body, err := io.ReadAll(response.Body)
+	require.NoError(t, err)
+	return response.StatusCode, response.Header.Get("Location"), string(body)
+}
+
+func TestBrowsePages(t *testing.T) {
+	server, _ := newTestServer(t, nil)
+
+	status, body := getPage(t, server.URL+"/")
+	require.Equal(t, http.StatusOK, status)
+	assert.Contains(t, body, `<a href="/books/OL1M">The Hobbit</a>`)
+	assert.Contains(t, body, `<a href="/authors/OL2A">C. S. Lewis</a>`)
+
+	status, body = getPage(t, server.URL+"/?q=lewis")
+	require.Equal(t, http.StatusOK, status)
+	assert.Contains(t, body, "The Lion, the Witch and the Wardrobe")
+	assert.NotContains(t, body, "The Hobbit")
+
+	status, body = getPage(t, server.URL+"/?per_page=2")
+	require.Equal(t, http.StatusOK, status)
+	assert.Contains(t, body, "Page 1 of 2")
+	assert.Contains(t, body, `href="/?page=2&amp;per_page=2"`)
+
+	status, body = getPage(t, server.URL+"/authors/OL1A")
+	require.Equal(t, http.StatusOK, status)
+	assert.Contains(t, body, "The Fellowship of the Ring")
+
+	status, body = getPage(t, server.URL+"/subjects")
+	require.Equal(t, http.StatusOK, status)
+	assert.Contains(t, body, `<a href="/subjects?name=Fantasy">Fantasy</a>`)
+
+	status, body = getPage(t, server.URL+"/subjects?name=Fantasy")
+	require.Equal(t, http.StatusOK, status)
+	assert.Contains(t, body, "The Hobbit")
+	assert.NotContains(t, body, "The Fellowship of the Ring")
+
+	status, body = getPage(t, server.URL+"/books/OL1M")
+	require.Equal(t, http.StatusOK, status)
+	assert.Contains(t, body, "<h1>The Hobbit</h1>")
+	assert.Contains(t, body, "9780261102217")
+
+	status, _ = getPage(t, server.URL+"/books/OL9M")
+	assert.Equal(t, http.StatusNotFound, status)
+	status, _ = getPage(t, server.URL+"/shelves")
+	assert.Equal(t, http.StatusNotFound, status)
+
+	status, body = getPage(t, server.URL+"/static/style.css")
+	require.Equal(t, http.StatusOK, status)
+	assert.Contains(t, body, "font-family")
+}
+
+func TestEditPages(t *testing.T) {
+	server, database := newTestServer(t, nil)
+
+	status, location, _ := postForm(t, server.URL+"/books/OL2M", url.Values{"title": {"The Fellowship of the Ring "}, "call_number": {"823.912"}})
+	require.Equal(t, http.StatusSeeOther, status)
+	assert.Equal(t, "/books/OL2M?saved=1", location)
+
+	book, err := database.FindBook("OL2M")
+	require.NoError(t, err)
+	assert.Equal(t, "823.912", *book.CallNumber)
+
+	// the unchanged title is left alone
+	changes, err := database.History(rings.OLID)
+	require.NoError(t, err)
+	for _, change := range changes {
+		assert.NotEqual(t, "title", change.Field)
+	}
+
+	status, _, body := postForm(t, server.URL+"/books/OL2M", url.Values{"title": {""}})
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Contains(t, body, "The title cannot be empty.")
+
+	status, location, _ = postForm(t, server.URL+"/authors/OL2A", url.Values{"name": {"Clive Staples Lewis"}, "sort_name": {"Lewis, C. S."}})
+	require.Equal(t, http.StatusSeeOther, status)
+	assert.Equal(t, "/authors/OL2A?saved=1", location)
+
+	author, err := database.FindAuthor("OL2A")
+	require.NoError(t, err)
+	assert.Equal(t, "Clive Staples Lewis", author.Name)
+	assert.Equal(t, "Lewis, C. S.", author.SortName())
+
+	status, _, body = postForm(t, server.URL+"/authors/OL2A", url.Values{"name": {" "}})
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Contains(t, body, "The name cannot be empty.")
+
+	req, err := http.NewRequest(http.MethodPost, server.URL+"/authors/OL2A", strings.NewReader("name=Jack"))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Origin", "https://elsewhere.example.com")
+	response, err := noRedirects.Do(req)
+	require.NoError(t, err)
+	_ = response.Body.Close()
+	assert.Equal(t, http.StatusForbidden, response.StatusCode)
+}
+
+func TestAddPage(t *testing.T) {
+	silmarillion := openlibrary.Book{OLID: "/books/OL4M", Title: "The Silmarillion", Isbn13: []string{"9780261102736"}, Authors: []openlibrary.Author{tolkien}}
+	server, database := newTestServer(t, map[string]openlibrary.Book{"9780261102736": silmarillion, "9780261102354": rings})
+
+	status, _, body := postForm(t, server.URL+"/add", url.Values{"isbn": {"978-0-261-10273-6"}, "action": {"preview"}})
+	require.Equal(t, http.StatusOK, status)
+	assert.Contains(t, body, "<h2>The Silmarillion</h2>")
+	assert.Contains(t, body, `<input type="hidden" name="isbn" value="9780261102736">`)
+
+	// previewing does not add the book
+	_, err := database.FindBook("OL4M")
+	assert.Error(t, err)
+
+	status, location, _ := postForm(t, server.URL+"/add", url.Values{"isbn": {"9780261102736"}, "action": {"add"}})
+	require.Equal(t, http.StatusSeeOther, status)
+	assert.Equal(t, "/books/OL4M?added=1", location)
+
+	status, body = getPage(t, server.URL+location)
+	require.Equal(t, http.StatusOK, status)
+	assert.Contains(t, body, "Added to the catalogue.")
+
+	status, _, body = postForm(t, server.URL+"/add", url.Values{"isbn": {"9780261102736"}, "action": {"preview"}})
+	assert.Equal(t, http.StatusConflict, status)
+	assert.Contains(t, body, `<a href="/books/OL4M">`)
+
+	status, _, body = postForm(t, server.URL+"/add", url.Values{"isbn": {"9780261102354"}, "action": {"add"}})
+	assert.Equal(t, http.StatusConflict, status, "openlibrary files the isbn under a book already catalogued")
+	assert.Contains(t, body, `<a href="/books/OL2M">`)
+
+	status, _, body = postForm(t, server.URL+"/add", url.Values{"isbn": {"12345"}, "action": {"preview"}})
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Contains(t, body, "is not a valid isbn")
+
+	status, _, body = postForm(t, server.URL+"/add", url.Values{"isbn": {"9780000000019"}, "action": {"preview"}})
+	assert.Equal(t, http.StatusUnprocessableEntity, status)
+	assert.Contains(t, body, "openlibrary has no record of 9780000000019")
+
+	status, _, _ = postForm(t, server.URL+"/add", url.Values{"isbn": {unavailableISBN}, "action": {"preview"}})
+	assert.Equal(t, http.StatusBadGateway, status)
+}
@@ -0,0 +1,554 @@
+// Package site renders a catalogue as a static html site: an index with a search box backed by a
+// json index, pages for each book, author and subject, and an A to Z of titles. The pages are
+// rendered from html/template files, any of which may be replaced by the library's own.
+package site
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+//go:embed templates
+var defaultTemplates embed.FS
+
+const templatesDir = "templates"
+
+// layoutTemplate wraps every page; the page templates define its "title" and "content".
+const layoutTemplate = "layout.html"
+
+// Assets are copied into the site as they are.
+var assets = []string{"style.css", "search.js"}
+
+// Catalogue is what a site is built from.
+type Catalogue struct {
+	Title     string
+	Generated time.Time
+	Books     []Book
+}
+
+// Book is a book as it appears on the site.
+type Book struct {
+	OLID        string
+	Title       string
+	Authors     []Credit
+	Subjects    []string
+	PublishDate string
+	Languages   []string
+	ISBNs       []string
+	CallNumber  string
+	Location    string
+	Copies      int
+
+	// Cover is the address of an image of the cover, if one is known. It is replaced with the path of
+	// the downloaded image when covers are fetched.
+	Cover string
+}
+
+// Credit names an author of a book and their role, such as "editor", if they are not the author.
+type Credit struct {
+	OLID string
+	Name string
+	Role string
+}
+
+// Options control how a site is built.
+type Options struct {
+	// Out is the directory the site is written to. It is created if it does not exist.
+	Out string
+
+	// Templates is a directory of templates replacing the default ones of the same name.
+	Templates string
+
+	// FetchCover, if set, is called for each book to download its cover into the site. It returns
+	// nil for books with no cover.
+	FetchCover func(olid string) ([]byte, error)
+}
+
+// Author is an author with the books they are credited with, for the author pages.
+type Author struct {
+	Slug  string
+	OLID  string
+	Name  string
+	Books []*Book
+}
+
+// Subject is a subject with its books, for the subject pages.
+type Subject struct {
+	Slug  string
+	Name  string
+	Books []*Book
+}
+
+// Letter is the books whose titles start with a letter, for the A to Z pages. Titles starting with
+// anything else are filed under "#".
+type Letter struct {
+	Slug   string
+	Letter string
+	Books  []*Book
+}
+
+// Page is what a template is rendered with. Root leads from the page back to the top of the site,
+// such as "../", so that the site can be moved anywhere.
+type Page struct {
+	Root      string
+	Catalogue *Catalogue
+	Authors   []*Author
+	Subjects  []*Subject
+	Letters   []*Letter
+
+	Book    *Book
+	Author  *Author
+	Subject *Subject
+	Letter  *Letter
+
+	authorSlugs  map[string]string
+	subjectSlugs map[string]string
+}
+
+// AuthorPath leads from the page to the page of the author with an openlibrary id.
+func (p Page) AuthorPath(olid string) string {
+	return p.Root + "authors/" + p.authorSlugs[olid] + ".html"
+}
+
+// SubjectPath leads from the page to the page of a subject.
+func (p Page) SubjectPath(name string) string {
+	return p.Root + "subjects/" + p.subjectSlugs[name] + ".html"
+}
+
+// BookPath leads from the page to the page of a book.
+func (p Page) BookPath(book *Book) string {
+	return p.Root + bookPath(book.OLID)
+}
+
+// CoverURL is the address of the cover of a book, either on the site or elsewhere.
+func (p Page) CoverURL(book *Book) string {
+	if strings.Contains(book.Cover, "://") {
+		return book.Cover
+	}
+	return p.Root + book.Cover
+}
+
+// List pairs books with the page listing them, for the "books" template.
+func (p Page) List(books []*Book) BookList {
+	return BookList{Page: p, Books: books}
+}
+
+// BookList is a list of books on a page.
+type BookList struct {
+	Page
+	Books []*Book
+}
+
+// searchEntry is a book in the json search index.
+type searchEntry struct {
+	Title    string   `json:"title"`
+	Authors  []string `json:"authors"`
+	Subjects []string `json:"subjects"`
+	ISBNs    []string `json:"isbns"`
+	URL      string   `json:"url"`
+}
+
+// Build writes the site for a catalogue, returning the number of pages written.
+func Build(catalogue Catalogue, options Options) (int, error) {
+	templates, err := loadTemplates(options.Templates)
+	if err != nil {
+		return 0, err
+	}
+
+	err = os.MkdirAll(options.Out, 0o755)
+	if err != nil {
+		return 0, fmt.Errorf("site: error creating %s: %w", options.Out, err)
+	}
+
+	books := make([]*Book, len(catalogue.Books))
+	for i := range catalogue.Books {
+		books[i] = &catalogue.Books[i]
+	}
+	sort.SliceStable(books, func(i, j int) bool {
+		return strings.ToLower(sortTitle(books[i].Title)) < strings.ToLower(sortTitle(books[j].Title))
+	})
+
+	if options.FetchCover != nil {
+		err = fetchCovers(books, options)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	site := Page{Catalogue: &catalogue, Authors: authorsOf(books), Subjects: subjectsOf(books), Letters: lettersOf(books),
+		authorSlugs: map[string]string{}, subjectSlugs: map[string]string{}}
+	for _, author := range site.Authors {
+		site.authorSlugs[author.OLID] = author.Slug
+	}
+	for _, subject := range site.Subjects {
+		site.subjectSlugs[subject.Name] = subject.Slug
+	}
+	pages := 0
+	write := func(name string, path string, page Page) error {
+		page.Root = strings.Repeat("../", strings.Count(path, "/"))
+		err := writePage(templates, name, filepath.Join(options.Out, filepath.FromSlash(path)), page)
+		if err != nil {
+			return err
+		}
+		pages++
+		return nil
+	}
+
+	err = write("index.html", "index.html", site)
+	if err != nil {
+		return pages, err
+	}
+	for _, book := range books {
+		page := site
+		page.Book = book
+		err = write("book.html", bookPath(book.OLID), page)
+		if err != nil {
+			return pages, err
+		}
+	}
+	err = write("authors.html", "authors/index.html", site)
+	if err != nil {
+		return pages, err
+	}
+	for _, author := range site.Authors {
+		page := site
+		page.Author = author
+		err = write("author.html", "authors/"+author.Slug+".html", page)
+		if err != nil {
+			return pages, err
+		}
+	}
+	err = write("subjects.html", "subjects/index.html", site)
+	if err != nil {
+		return pages, err
+	}
+	for _, subject := range site.Subjects {
+		page := site
+		page.Subject = subject
+		err = write("subject.html", "subjects/"+subject.Slug+".html", page)
+		if err != nil {
+			return pages, err
+		}
+	}
+	for _, letter := range site.Letters {
+		page := site
+		page.Letter = letter
+		err = write("browse.html", "browse/"+letter.Slug+".html", page)
+		if err != nil {
+			return pages, err
+		}
+	}
+
+	err = writeSearchIndex(books, filepath.Join(options.Out, "search.json"))
+	if err != nil {
+		return pages, err
+	}
+	for _, asset := range assets {
+		content, err := readTemplate(options.Templates, asset)
+		if err != nil {
+			return pages, err
+		}
+		err = os.WriteFile(filepath.Join(options.Out, asset), content, 0o644)
+		if err != nil {
+			return pages, fmt.Errorf("site: error writing %s: %w", asset, err)
+		}
+	}
+
+	return pages, nil
+}
+
+// WriteTemplates copies the default templates and assets into a directory, as a starting point for
+// the library's own.
+func WriteTemplates(dir string) error {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return fmt.Errorf("site: error creating %s: %w", dir, err)
+	}
+
+	entries, err := defaultTemplates.ReadDir(templatesDir)
+	if err != nil {
+		return fmt.Errorf("site: error reading templates: %w", err)
+	}
+	for _, entry := range entries {
+		content, err := defaultTemplates.ReadFile(templatesDir + "/" + entry.Name())
+		if err != nil {
+			return fmt.Errorf("site: error reading %s: %w", entry.Name(), err)
+		}
+		err = os.WriteFile(filepath.Join(dir, entry.Name()), content, 0o644)
+		if err != nil {
+			return fmt.Errorf("site: error writing %s: %w", entry.Name(), err)
+		}
+	}
+	return nil
+}
+
+// pageTemplates are rendered within the layout, one per kind of page.
+var pageTemplates = []string{"index.html", "book.html", "authors.html", "author.html", "subjects.html", "subject.html", "browse.html"}
+
+var templateFuncs = template.FuncMap{
+	"join": strings.Join,
+}
+
+// loadTemplates parses each page template along with the layout, preferring the files in dir.
+func loadTemplates(dir string) (map[string]*template.Template, error) {
+	layout, err := readTemplate(dir, layoutTemplate)
+	if err != nil {
+		return nil, err
+	}
+
+	templates := map[string]*template.Template{}
+	for _, name := range pageTemplates {
+		content, err := readTemplate(dir, name)
+		if err != nil {
+			return nil, err
+		}
+
+		t, err := template.New(layoutTemplate).Funcs(templateFuncs).Option("missingkey=error").Parse(string(layout))
+		if err == nil {
+			_, err = t.New(name).Parse(string(content))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("site: error parsing %s: %w", name, err)
+		}
+		templates[name] = t
+	}
+	return templates, nil
+}
+
+// readTemplate reads a template or asset from dir if it is there, otherwise the default.
+func readTemplate(dir string, name string) ([]byte, error) {
+	if dir != "" {
+		content, err := os.ReadFile(filepath.Join(dir, name))
+		if err == nil {
+			return content, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("site: error reading %s: %w", name, err)
+		}
+	}
+
+	content, err := defaultTemplates.ReadFile(templatesDir + "/" + name)
+	if err != nil {
+		return nil, fmt.Errorf("site: error reading %s: %w", name, err)
+	}
+	return content, nil
+}
+
+func writePage(templates map[string]*template.Template, name string, path string, page Page) error {
+	err := os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return fmt.Errorf("site: error creating %s: %w", filepath.Dir(path), err)
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("site: error creating %s: %w", path, err)
+	}
+	defer func() { _ = file.Close() }()
+
+	err = templates[name].ExecuteTemplate(file, layoutTemplate, page)
+	if err != nil {
+		return fmt.Errorf("site: error rendering %s: %w", path, err)
+	}
+	return file.Close()
+}
+
+func writeSearchIndex(books []*Book, path string) error {
+	entries := []searchEntry{}
+	for _, book := range books {
+		entry := searchEntry{Title: book.Title, Authors: []string{}, Subjects: book.Subjects, ISBNs: book.ISBNs, URL: bookPath(book.OLID)}
+		for _, author := range book.Authors {
+			entry.Authors = append(entry.Authors, author.Name)
+		}
+		if entry.Subjects == nil {
+			entry.Subjects = []string{}
+		}
+		if entry.ISBNs == nil {
+			entry.ISBNs = []string{}
+		}
+		entries = append(entries, entry)
+	}
+
+	content, err := json.Marshal(entries)
+	if err != nil {
+		return fmt.Errorf("site: error encoding search index: %w", err)
+	}
+	err = os.WriteFile(path, content, 0o644)
+	if err != nil {
+		return fmt.Errorf("site: error writing search index: %w", err)
+	}
+	return nil
+}
+
+func fetchCovers(books []*Book, options Options) error {
+	dir := filepath.Join(options.Out, "covers")
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return fmt.Errorf("site: error creating %s: %w", dir, err)
+	}
+
+	for _, book := range books {
+		name := slug(book.OLID) + ".jpg"
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); err == nil {
+			// covers already downloaded by an earlier build are kept
+			book.Cover = "covers/" + name
+			continue
+		}
+
+		// a cover which cannot be fetched is left off, rather than holding up the rest of the site
+		content, err := options.FetchCover(book.OLID)
+		if err != nil {
+			log.Printf("error fetching cover of %s; %v, skipping...", book.OLID, err)
+		}
+		if err != nil || content == nil {
+			book.Cover = ""
+			continue
+		}
+
+		err = os.WriteFile(path, content, 0o644)
+		if err != nil {
+			return fmt.Errorf("site: error writing cover of %s: %w", book.OLID, err)
+		}
+		book.Cover = "covers/" + name
+	}
+	return nil
+}
+
+func authorsOf(books []*Book) []*Author {
+	byOLID := map[string]*Author{}
+	authors := []*Author{}
+	for _, book := range books {
+		for _, credit := range book.Authors {
+			author, ok := byOLID[credit.OLID]
+			if !ok {
+				author = &Author{OLID: credit.OLID, Name: credit.Name}
+				byOLID[credit.OLID] = author
+				authors = append(authors, author)
+			}
+			author.Books = append(author.Books, book)
+		}
+	}
+
+	sort.SliceStable(authors, func(i, j int) bool {
+		return strings.ToLower(authors[i].Name) < strings.ToLower(authors[j].Name)
+	})
+	slugs := map[string]bool{}
+	for _, author := range authors {
+		author.Slug = uniqueSlug(slug(author.OLID), slugs)
+	}
+	return authors
+}
+
+func subjectsOf(books []*Book) []*Subject {
+	byName := map[string]*Subject{}
+	subjects := []*Subject{}
+	for _, book := range books {
+		for _, name := range book.Subjects {
+			subject, ok := byName[name]
+			if !ok {
+				subject = &Subject{Name: name}
+				byName[name] = subject
+				subjects = append(subjects, subject)
+			}
+			subject.Books = append(subject.Books, book)
+		}
+	}
+
+	sort.SliceStable(subjects, func(i, j int) bool {
+		return strings.ToLower(subjects[i].Name) < strings.ToLower(subjects[j].Name)
+	})
+	slugs := map[string]bool{}
+	for _, subject := range subjects {
+		subject.Slug = uniqueSlug(slug(subject.Name), slugs)
+	}
+	return subjects
+}
+
+// lettersOf files the books under the first letter of their titles, ignoring a leading article.
+func lettersOf(books []*Book) []*Letter {
+	byLetter := map[string]*Letter{}
+	letters := []*Letter{}
+	for _, book := range books {
+		letter := "#"
+		for _, r := range sortTitle(book.Title) {
+			if unicode.IsLetter(r) && r < unicode.MaxASCII {
+				letter = strings.ToUpper(string(r))
+			}
+			break
+		}
+
+		group, ok := byLetter[letter]
+		if !ok {
+			group = &Letter{Letter: letter, Slug: strings.ToLower(letter)}
+			if letter == "#" {
+				group.Slug = "other"
+			}
+			byLetter[letter] = group
+			letters = append(letters, group)
+		}
+		group.Books = append(group.Books, book)
+	}
+
+	sort.SliceStable(letters, func(i, j int) bool {
+		if (letters[i].Letter == "#") != (letters[j].Letter == "#") {
+			return letters[j].Letter == "#"
+		}
+		return letters[i].Letter < letters[j].Letter
+	})
+	return letters
+}
+
+// sortTitle drops a leading article, so "The Hobbit" is filed under H.
+func sortTitle(title string) string {
+	for _, article := range []string{"the ", "a ", "an "} {
+		if len(title) > len(article) && strings.EqualFold(title[:len(article)], article) {
+			return title[len(article):]
+		}
+	}
+	return title
+}
+
+func bookPath(olid string) string {
+	return "books/" + slug(olid) + ".html"
+}
+
+// slug turns an openlibrary id or a name into a file name: "/books/OL1M" becomes "OL1M", and
+// "Science fiction" becomes "science-fiction".
+func slug(key string) string {
+	for _, prefix := range []string{"/books/", "/authors/"} {
+		if strings.HasPrefix(key, prefix) {
+			return strings.TrimPrefix(key, prefix)
+		}
+	}
+
+	words := strings.FieldsFunc(strings.ToLower(key), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if len(words) == 0 {
+		return "-"
+	}
+	return strings.Join(words, "-")
+}
+
+// uniqueSlug numbers slugs which have already been used, such as for subjects differing only by
+// punctuation.
+func uniqueSlug(candidate string, used map[string]bool) string {
+	unique := candidate
+	for n := 2; used[unique]; n++ {
+		unique = fmt.Sprintf("%s-%d", candidate, n)
+	}
+	used[unique] = true
+	return unique
+}
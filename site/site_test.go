@@ -0,0 +1,139 @@
+package site
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testCatalogue() Catalogue {
+	return Catalogue{
+		Title:     "Test Library",
+		Generated: time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC),
+		Books: []Book{
+			{
+				OLID:     "/books/OL1M",
+				Title:    "The Hobbit",
+				Authors:  []Credit{{OLID: "/authors/OL1A", Name: "J. R. R. Tolkien"}},
+				Subjects: []string{"Fantasy", "Dragons"},
+				ISBNs:    []string{"9780261102217"},
+				Copies:   1,
+			},
+			{
+				OLID:     "/books/OL2M",
+				Title:    "1984",
+				Authors:  []Credit{{OLID: "/authors/OL2A", Name: "George Orwell"}, {OLID: "/authors/OL3A", Name: "A. Editor", Role: "editor"}},
+				Subjects: []string{"Dystopias"},
+				Cover:    "https://example.com/cover.jpg",
+			},
+		},
+	}
+}
+
+func TestBuild(t *testing.T) {
+	out := t.TempDir()
+
+	pages, err := Build(testCatalogue(), Options{Out: out})
+	require.NoError(t, err)
+	assert.Equal(t, 13, pages)
+
+	for _, path := range []string{
+		"index.html", "books/OL1M.html", "books/OL2M.html",
+		"authors/index.html", "authors/OL1A.html", "authors/OL2A.html", "authors/OL3A.html",
+		"subjects/index.html", "subjects/fantasy.html", "subjects/dragons.html", "subjects/dystopias.html",
+		"browse/h.html", "browse/other.html", "style.css", "search.js",
+	} {
+		assert.FileExists(t, filepath.Join(out, path))
+	}
+
+	page, err := os.ReadFile(filepath.Join(out, "books", "OL1M.html"))
+	require.NoError(t, err)
+	assert.Contains(t, string(page), `href="../authors/OL1A.html">J. R. R. Tolkien</a>`)
+	assert.Contains(t, string(page), `href="../subjects/fantasy.html">Fantasy</a>`)
+	assert.Contains(t, string(page), "9780261102217")
+
+	page, err = os.ReadFile(filepath.Join(out, "books", "OL2M.html"))
+	require.NoError(t, err)
+	assert.Contains(t, string(page), `src="https://example.com/cover.jpg"`)
+	assert.Contains(t, string(page), "A. Editor</a> (editor)")
+
+	content, err := os.ReadFile(filepath.Join(out, "search.json"))
+	require.NoError(t, err)
+	index := []searchEntry{}
+	require.NoError(t, json.Unmarshal(content, &index))
+	require.Len(t, index, 2)
+	assert.Equal(t, searchEntry{
+		Title:    "1984",
+		Authors:  []string{"George Orwell", "A. Editor"},
+		Subjects: []string{"Dystopias"},
+		ISBNs:    []string{},
+		URL:      "books/OL2M.html",
+	}, index[0])
+}
+
+func TestBuildOverriddenTemplates(t *testing.T) {
+	templates := t.TempDir()
+	require.NoError(t, WriteTemplates(templates))
+	require.NoError(t, os.WriteFile(filepath.Join(templates, "book.html"),
+		[]byte(`{{define "title"}}{{.Book.Title}}{{end}}{{define "content"}}<p class="custom">{{.Book.Title}}</p>{{end}}`), 0o644))
+	require.NoError(t, os.Remove(filepath.Join(templates, "index.html")))
+
+	out := t.TempDir()
+	_, err := Build(testCatalogue(), Options{Out: out, Templates: templates})
+	require.NoError(t, err)
+
+	page, err := os.ReadFile(filepath.Join(out, "books", "OL1M.html"))
+	require.NoError(t, err)
+	assert.Contains(t, string(page), `<p class="custom">The Hobbit</p>`)
+	assert.Contains(t, string(page), "<title>The Hobbit - Test Library</title>")
+
+	// templates missing from the directory fall back to the defaults
+	assert.FileExists(t, filepath.Join(out, "index.html"))
+
+	require.NoError(t, os.WriteFile(filepath.Join(templates, "book.html"), []byte(`{{.Missing}}`), 0o644))
+	_, err = Build(testCatalogue(), Options{Out: t.TempDir(), Templates: templates})
+	assert.Error(t, err)
+}
+
+func TestBuildFetchesCovers(t *testing.T) {
+	out := t.TempDir()
+	fetched := []string{}
+	offline := false
+	fetchCover := func(olid string) ([]byte, error) {
+		fetched = append(fetched, olid)
+		if olid == "/books/OL1M" {
+			return []byte("jpeg"), nil
+		}
+		if offline {
+			return nil, errors.New("timed out")
+		}
+		return nil, nil
+	}
+
+	_, err := Build(testCatalogue(), Options{Out: out, FetchCover: fetchCover})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/books/OL2M", "/books/OL1M"}, fetched)
+
+	page, err := os.ReadFile(filepath.Join(out, "books", "OL1M.html"))
+	require.NoError(t, err)
+	assert.Contains(t, string(page), `src="../covers/OL1M.jpg"`)
+
+	page, err = os.ReadFile(filepath.Join(out, "books", "OL2M.html"))
+	require.NoError(t, err)
+	assert.NotContains(t, string(page), "<img")
+
+	// covers already downloaded are kept, and covers which cannot be fetched are left off
+	offline = true
+	_, err = Build(testCatalogue(), Options{Out: out, FetchCover: fetchCover})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/books/OL2M", "/books/OL1M", "/books/OL2M"}, fetched)
+	page, err = os.ReadFile(filepath.Join(out, "books", "OL2M.html"))
+	require.NoError(t, err)
+	assert.NotContains(t, string(page), "<img")
+}
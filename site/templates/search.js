@@ -0,0 +1,52 @@
+// Searches search.json as the user types, listing the books matching every word typed.
+(function () {
+  var input = document.getElementById("search");
+  var results = document.getElementById("results");
+  if (!input || !results) {
+    return;
+  }
+
+  var root = input.getAttribute("data-root") || "";
+  var books = [];
+  fetch(input.getAttribute("data-index"))
+    .then(function (response) { return response.json(); })
+    .then(function (index) {
+      books = index.map(function (book) {
+        book.text = [book.title].concat(book.authors, book.subjects, book.isbns).join(" ").toLowerCase();
+        return book;
+      });
+      search();
+    });
+
+  function search() {
+    var words = input.value.toLowerCase().split(/\s+/).filter(Boolean);
+    results.innerHTML = "";
+    if (words.length === 0) {
+      return;
+    }
+
+    var shown = 0;
+    for (var i = 0; i < books.length && shown < 50; i++) {
+      var book = books[i];
+      if (!words.every(function (word) { return book.text.indexOf(word) >= 0; })) {
+        continue;
+      }
+
+      var link = document.createElement("a");
+      link.href = root + book.url;
+      link.textContent = book.title;
+      var item = document.createElement("li");
+      item.appendChild(link);
+      if (book.authors.length > 0) {
+        var authors = document.createElement("span");
+        authors.className = "authors";
+        authors.textContent = " by " + book.authors.join(", ");
+        item.appendChild(authors);
+      }
+      results.appendChild(item);
+      shown++;
+    }
+  }
+
+  input.addEventListener("input", search);
+})();